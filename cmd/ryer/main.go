@@ -28,14 +28,28 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
 	"github.com/mdhender/conduit/internal/config"
 	"github.com/mdhender/conduit/internal/jwt"
+	"github.com/mdhender/conduit/internal/password"
 	"github.com/mdhender/conduit/internal/servers/ryer"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/dump"
+	"github.com/mdhender/conduit/internal/store/file"
 	"github.com/mdhender/conduit/internal/store/memory"
+	"github.com/mdhender/conduit/internal/store/postgres"
 	"github.com/mdhender/conduit/internal/way"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -48,18 +62,116 @@ func main() {
 		os.Exit(2)
 	}
 
+	if len(cfg.Args) != 0 {
+		if err := runCommand(cfg); err != nil {
+			log.Printf("[main] %+v\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+
 	if err := run(cfg); err != nil {
 		log.Printf("[main] %+v\n", err)
 		os.Exit(2)
 	}
 }
 
+// runCommand runs an admin command against the configured store instead of serving:
+//
+//	backup [file]   writes a dump of the store to the file (or stdout)
+//	restore [file]  loads a dump from the file (or stdin) into an empty store
+//
+// A file name of "-" also means stdout or stdin.
+func runCommand(cfg *config.Config) (err error) {
+	command, name := cfg.Args[0], "-"
+	switch {
+	case command != "backup" && command != "restore":
+		return fmt.Errorf("unknown command %q", command)
+	case len(cfg.Args) > 2:
+		return fmt.Errorf("%s: too many arguments", command)
+	case len(cfg.Args) == 2:
+		name = cfg.Args[1]
+	}
+	if cfg.Data.Driver == "" && !cfg.Data.Persist {
+		return fmt.Errorf("%s: the memory store doesn't keep data; set -data-driver or -data-persist", command)
+	}
+
+	db, err := newStore(cfg)
+	if err != nil {
+		return err
+	}
+	if c, ok := db.(io.Closer); ok {
+		defer func() {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	if command == "restore" {
+		r := io.Reader(os.Stdin)
+		if name != "-" {
+			fp, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer fp.Close()
+			r = fp
+		}
+		if err := dump.Import(r, db); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		log.Printf("[main] restore: loaded %s\n", name)
+		return nil
+	}
+
+	if name == "-" {
+		if err := dump.Export(os.Stdout, db); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		return nil
+	}
+	fp, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = dump.Export(fp, db); err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(name) // don't leave a partial backup behind
+		return fmt.Errorf("backup: %w", err)
+	}
+	log.Printf("[main] backup: wrote %s\n", name)
+	return nil
+}
+
 func run(cfg *config.Config) error {
+	db, err := newStore(cfg)
+	if err != nil {
+		return err
+	}
+	tf, err := newTokenFactory(cfg)
+	if err != nil {
+		return err
+	}
+	go pruneKeys(tf.Keyring(), time.Minute)
+	cookies, err := newCookies(cfg)
+	if err != nil {
+		return err
+	}
+
 	s := &ryer.Server{
-		DB:           memory.New(),
-		DtFmt:        cfg.App.TimestampFormat,
-		Router:       way.NewRouter(),
-		TokenFactory: jwt.NewFactory(cfg.Server.Salt + cfg.Server.Key),
+		AccessTokenTTL:  cfg.Server.JWT.AccessTTL,
+		Cookies:         cookies,
+		DB:              db,
+		DtFmt:           cfg.App.TimestampFormat,
+		RefreshTokenTTL: cfg.Server.JWT.RefreshTTL,
+		Router:          way.NewRouter(),
+		TokenFactory:    tf,
 	}
 	s.Addr = net.JoinHostPort(cfg.Server.Host, cfg.Server.Port)
 	s.IdleTimeout = cfg.Server.Timeout.Idle
@@ -77,3 +189,110 @@ func run(cfg *config.Config) error {
 	log.Printf("[main] listening on %s\n", s.Addr)
 	return s.ListenAndServe()
 }
+
+// pruneKeys removes retired keys from the keyring every interval,
+// so they are no longer published in the JWKS or kept in memory.
+func pruneKeys(kr *jwt.Keyring, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		for _, id := range kr.Prune(now) {
+			log.Printf("[main] jwt: pruned retired key %q\n", id)
+		}
+	}
+}
+
+// newStore returns the data store for the server.
+// It uses the SQL store if a driver is configured, the file store if the data
+// should persist, and the memory store otherwise.
+func newStore(cfg *config.Config) (store.Store, error) {
+	hasher := password.NewHasher(cfg.Server.Salt)
+	switch cfg.Data.Driver {
+	case "":
+		if cfg.Data.Persist {
+			return file.Open(cfg.Data.Path, file.WithPasswordHasher(hasher))
+		}
+		return memory.New(memory.WithPasswordHasher(hasher))
+	case "postgres", "sqlite":
+		db, err := sql.Open(cfg.Data.Driver, cfg.Data.Source)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Data.Driver == "sqlite" {
+			// SQLite allows one writer at a time, and each connection
+			// to ":memory:" would get its own database
+			db.SetMaxOpenConns(1)
+		}
+		if err = db.Ping(); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.Data.Driver, err)
+		}
+		return postgres.New(db, postgres.WithPasswordHasher(hasher))
+	}
+	return nil, fmt.Errorf("data: unknown driver %q", cfg.Data.Driver)
+}
+
+// newCookies returns the cookie session settings for the server.
+func newCookies(cfg *config.Config) (ryer.Cookies, error) {
+	cookies := ryer.Cookies{
+		Enabled:  cfg.Cookies.Sessions,
+		HttpOnly: cfg.Cookies.HttpOnly,
+		Secure:   cfg.Cookies.Secure,
+	}
+	switch strings.ToLower(cfg.Cookies.SameSite) {
+	case "", "lax":
+		cookies.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookies.SameSite = http.SameSiteStrictMode
+	case "none":
+		// browsers reject SameSite=None cookies that aren't Secure
+		if !cfg.Cookies.Secure {
+			return ryer.Cookies{}, fmt.Errorf("cookies: SameSite %q requires Secure cookies", cfg.Cookies.SameSite)
+		}
+		cookies.SameSite = http.SameSiteNoneMode
+	default:
+		return ryer.Cookies{}, fmt.Errorf("cookies: unknown SameSite %q", cfg.Cookies.SameSite)
+	}
+	return cookies, nil
+}
+
+// newTokenFactory returns the factory for creating and validating tokens.
+// It signs with the configured private key if there is one, otherwise
+// it falls back to HS256 with the server key.
+// Previous server keys and configured public keys are still accepted,
+// so rotating the signing key doesn't log anyone out.
+// Previous server keys are retired at the time configured for each of them.
+func newTokenFactory(cfg *config.Config) (jwt.Factory, error) {
+	policy := jwt.Policy{
+		Issuer:    cfg.Server.JWT.Issuer,
+		Audiences: cfg.Server.JWT.Audiences,
+		Leeway:    cfg.Server.JWT.Leeway,
+		MaxAge:    cfg.Server.JWT.MaxAge,
+	}
+	kr := jwt.NewKeyring()
+	for _, key := range cfg.Server.JWT.PreviousKeys {
+		if err := kr.AcceptUntil("", jwt.HS256Signer([]byte(cfg.Server.Salt+key.Key)), key.RetireAt); err != nil {
+			return jwt.Factory{}, err
+		}
+	}
+	for _, name := range cfg.Server.JWT.PublicKeyFiles {
+		v, err := jwt.LoadVerifier(name)
+		if err != nil {
+			return jwt.Factory{}, err
+		} else if err = kr.Accept(jwt.KeyID(v), v); err != nil {
+			return jwt.Factory{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if cfg.Server.JWT.PrivateKeyFile == "" {
+		if err := kr.Rotate("", jwt.HS256Signer([]byte(cfg.Server.Salt+cfg.Server.Key)), -1); err != nil {
+			return jwt.Factory{}, err
+		}
+		return jwt.NewKeyringFactory(kr).WithPolicy(policy), nil
+	}
+	s, err := jwt.LoadSigner(cfg.Server.JWT.PrivateKeyFile)
+	if err != nil {
+		return jwt.Factory{}, err
+	}
+	v, _ := s.(jwt.Verifier)
+	if err = kr.Rotate(jwt.KeyID(v), s, -1); err != nil {
+		return jwt.Factory{}, fmt.Errorf("%s: %w", cfg.Server.JWT.PrivateKeyFile, err)
+	}
+	return jwt.NewKeyringFactory(kr).WithPolicy(policy), nil
+}
@@ -2,4 +2,8 @@ module github.com/mdhender/conduit
 
 go 1.15
 
-require github.com/peterbourgon/ff/v3 v3.0.0
+require (
+	github.com/lib/pq v1.10.9
+	github.com/peterbourgon/ff/v3 v3.0.0
+	modernc.org/sqlite v1.10.6
+)
@@ -0,0 +1,55 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package conduit
+
+// The admin types are not in the RealWorld spec.
+
+type AdminUser struct {
+	Id                    int      `json:"id"`
+	Username              string   `json:"username"`              // "username": "jake"
+	Email                 string   `json:"email"`                 // "email": "jake@jake.jake"
+	Bio                   *string  `json:"bio"`                   // "bio": "I work at statefarm"
+	Image                 *string  `json:"image"`                 // "image": null
+	CreatedAt             string   `json:"createdAt"`             // "createdAt": "2016-02-18T03:22:56.637Z"
+	UpdatedAt             string   `json:"updatedAt"`             // "updatedAt": "2016-02-18T03:22:56.637Z"
+	Roles                 []string `json:"roles"`                 // "roles": ["moderator"] // granted roles, not including "authenticated"
+	Following             []string `json:"following"`             // "following": ["anne"]
+	Suspended             bool     `json:"suspended"`             // "suspended": false
+	PasswordResetRequired bool     `json:"passwordResetRequired"` // "passwordResetRequired": false
+}
+
+type AdminIndexResponse struct {
+	Roles      []string `json:"roles"`      // "roles": ["admin", "moderator"] // roles that can be granted
+	UsersCount int      `json:"usersCount"` // "usersCount": 2
+}
+
+type AdminUserResponse struct {
+	User AdminUser `json:"user"`
+}
+
+type AdminUsersResponse struct {
+	Users      []AdminUser `json:"users"`
+	UsersCount int         `json:"usersCount"` // "usersCount": 2 // number of users matching the search
+}
@@ -37,12 +37,24 @@ type ArticleCreateRequest struct {
 
 type ArticleUpdateRequest struct {
 	Article struct {
-		Body        string `json:"body"`        // "body": "You have to believe" // optional
-		Description string `json:"description"` // "description": "Ever wonder how?" // optional
-		Title       string `json:"title"`       // "title": "How to train your dragon" // optional
+		Body        *string   `json:"body,omitempty"`        // "body": "You have to believe" // optional
+		Description *string   `json:"description,omitempty"` // "description": "Ever wonder how?" // optional
+		TagList     *[]string `json:"tagList,omitempty"`     // "tagList": ["reactjs", "angularjs", "dragons"] // optional
+		Title       *string   `json:"title,omitempty"`       // "title": "How to train your dragon" // optional
 	} `json:"article"`
 }
 
+// ChangePasswordRequest is not in the RealWorld spec.
+type ChangePasswordRequest struct {
+	User ChangePassword `json:"user"`
+}
+
+type ChangePassword struct {
+	Email       string `json:"email"`       // "email": "jake@jake.jake" // required
+	Password    string `json:"password"`    // "password": "jakejake" // required
+	NewPassword string `json:"newPassword"` // "newPassword": "jakejakejake" // required
+}
+
 type CommentAddRequest struct {
 	Comment struct {
 		Body string `json:"body"` // "body": "His name was my name too." // required
@@ -68,6 +80,15 @@ type NewUser struct {
 	Username string `json:"username"` // "username": "Jacob" // required
 }
 
+// RefreshUserRequest is not in the RealWorld spec.
+type RefreshUserRequest struct {
+	User RefreshUser `json:"user"`
+}
+
+type RefreshUser struct {
+	RefreshToken string `json:"refreshToken"` // "refreshToken": "..." // required
+}
+
 type UpdateUserRequest struct {
 	User UpdateUser `json:"user"`
 }
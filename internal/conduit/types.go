@@ -28,16 +28,16 @@ package conduit
 // all types are derived from https://github.com/gothinkster/realworld/blob/9686244365bf5681e27e2e9ea59a4d905d8080db/api/swagger.json
 
 type Article struct {
-	Slug           string `json:"slug"`           // "slug": "how-to-train-your-dragon"
-	Title          string `json:"title"`          // "title": "How to train your dragon"
-	Description    string `json:"description"`    // "description": "Ever wonder how?"
-	Body           string `json:"body"`           // "body": "It takes a Jacobian"
-	TagList        string `json:"tagList"`        // "tagList": ["dragons", "training"]
-	CreatedAt      string `json:"createdAt"`      // "createdAt": "2016-02-18T03:22:56.637Z"
-	UpdatedAt      string `json:"updatedAt"`      // "updatedAt": "2016-02-18T03:48:35.824Z"
-	Favorited      bool   `json:"favorited"`      // "favorited": false
-	FavoritesCount int    `json:"favoritesCount"` // "favoritesCount": 0
-	Author         Author `json:"author"`
+	Slug           string   `json:"slug"`           // "slug": "how-to-train-your-dragon"
+	Title          string   `json:"title"`          // "title": "How to train your dragon"
+	Description    string   `json:"description"`    // "description": "Ever wonder how?"
+	Body           string   `json:"body"`           // "body": "It takes a Jacobian"
+	TagList        []string `json:"tagList"`        // "tagList": ["dragons", "training"]
+	CreatedAt      string   `json:"createdAt"`      // "createdAt": "2016-02-18T03:22:56.637Z"
+	UpdatedAt      string   `json:"updatedAt"`      // "updatedAt": "2016-02-18T03:48:35.824Z"
+	Favorited      bool     `json:"favorited"`      // "favorited": false
+	FavoritesCount int      `json:"favoritesCount"` // "favoritesCount": 0
+	Author         Author   `json:"author"`
 }
 
 type Author struct {
@@ -71,4 +71,8 @@ type User struct {
 	Token     string  `json:"token,omitempty"` // "token": "jwt.token.here"
 	Bio       *string `json:"bio"`             // "bio": "I work at statefarm" // API requires this to be nullable
 	Image     *string `json:"image"`           // "image": null // API requires this to be nullable
+
+	// RefreshToken is not in the RealWorld spec. It is only returned when a session
+	// starts or is refreshed, and can be traded for a new token at POST /api/users/refresh.
+	RefreshToken string `json:"refreshToken,omitempty"`
 }
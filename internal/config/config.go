@@ -29,6 +29,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/peterbourgon/ff/v3"
@@ -41,6 +42,7 @@ type Config struct {
 		TimestampFormat string
 	}
 	FileName string
+	Args     []string // arguments left after the flags; the first one names a command
 	Server   struct {
 		Scheme  string
 		Host    string
@@ -58,22 +60,49 @@ type Config struct {
 		Salt    string
 		Key     string
 		WebRoot string
+		JWT     struct {
+			PrivateKeyFile string        // PEM private key for signing tokens; uses HS256 and Key if empty
+			PublicKeyFiles []string      // PEM public keys for tokens signed elsewhere or by retired keys
+			PreviousKeys   []PreviousKey // retired server keys whose HS256 tokens are still accepted until they are retired
+			Issuer         string        // expected iss claim; not checked if empty
+			Audiences      []string      // accepted aud claims; not checked if empty
+			Leeway         time.Duration
+			MaxAge         time.Duration // reject tokens issued longer ago than this; not checked if zero
+			AccessTTL      time.Duration // lifetime of access tokens
+			RefreshTTL     time.Duration // lifetime of refresh tokens
+		}
 	}
 	Cookies struct {
 		HttpOnly bool
+		SameSite string // lax, strict, or none
 		Secure   bool
+		Sessions bool // set session cookies on login and accept them in place of bearer tokens
 	}
 	Data struct {
 		Path string
+
+		Driver string // database/sql driver for the SQL store, either "postgres" or "sqlite"; the memory store is used if empty
+		Source string // data source name passed to the driver
+
+		Persist bool // keep the memory store's data in files under Path so that it survives a restart
 	}
 }
 
+// PreviousKey is a retired server key and the time its tokens stop being accepted.
+// The time is absolute, so restarting the server doesn't extend it.
+type PreviousKey struct {
+	Key      string
+	RetireAt time.Time
+}
+
 // Default returns a default configuration.
 // These are the values without loading the environment, configuration file, or command line.
 func Default() *Config {
 	var cfg Config
 	cfg.App.Root = "D:/GoLand/conduit/"
 	cfg.App.TimestampFormat = "2006-01-02T15:04:05.99999999Z"
+	cfg.Cookies.HttpOnly = true
+	cfg.Cookies.SameSite = "lax"
 	cfg.Data.Path = cfg.App.Root + "test/data/"
 	cfg.Server.Scheme = "http"
 	cfg.Server.Host = "localhost"
@@ -83,6 +112,9 @@ func Default() *Config {
 	cfg.Server.Timeout.Write = 10 * time.Second
 	cfg.Server.Key = "curry.aka.yrruc"
 	cfg.Server.Salt = "pepper"
+	cfg.Server.JWT.Leeway = 30 * time.Second
+	cfg.Server.JWT.AccessTTL = 15 * time.Minute
+	cfg.Server.JWT.RefreshTTL = 30 * 24 * time.Hour
 	cfg.Server.WebRoot = cfg.App.Root + "web/"
 	return &cfg
 }
@@ -99,8 +131,13 @@ func (cfg *Config) Load() error {
 	debug := fs.Bool("debug", cfg.Debug, "log debug information (optional)")
 	appRoot := fs.String("root", cfg.App.Root, "path to treat as root for relative file references")
 	dataPath := fs.String("data-path", cfg.Data.Path, "path containing data files")
+	dataDriver := fs.String("data-driver", cfg.Data.Driver, "SQL driver for the data store, either 'postgres' or 'sqlite' (optional)")
+	dataSource := fs.String("data-source", cfg.Data.Source, "data source name for the SQL driver")
+	dataPersist := fs.Bool("data-persist", cfg.Data.Persist, "keep data in files under the data path")
 	serverCookiesHttpOnly := fs.Bool("cookies-http-only", cfg.Cookies.HttpOnly, "set HttpOnly flag on cookies")
+	serverCookiesSameSite := fs.String("cookies-same-site", cfg.Cookies.SameSite, "set SameSite attribute on cookies, either 'lax', 'strict' or 'none'")
 	serverCookiesSecure := fs.Bool("cookies-secure", cfg.Cookies.Secure, "set Secure flag on cookies")
+	serverCookiesSessions := fs.Bool("cookies-sessions", cfg.Cookies.Sessions, "accept session cookies as well as bearer tokens")
 	serverScheme := fs.String("scheme", cfg.Server.Scheme, "http scheme, either 'http' or 'https'")
 	serverHost := fs.String("host", cfg.Server.Host, "host name (or IP) to listen on")
 	serverPort := fs.String("port", cfg.Server.Port, "port to listen on")
@@ -113,17 +150,32 @@ func (cfg *Config) Load() error {
 	serverTLSCertFile := fs.String("https-cert-file", cfg.Server.Host, "https certificate file")
 	serverTLSKeyFile := fs.String("https-key-file", cfg.Server.Host, "https certificate key file")
 	serverWebRoot := fs.String("web-root", cfg.Server.WebRoot, "path to serve web assets from")
+	serverJWTPrivateKeyFile := fs.String("jwt-private-key-file", cfg.Server.JWT.PrivateKeyFile, "PEM file with the private key for signing tokens (optional)")
+	serverJWTIssuer := fs.String("jwt-issuer", cfg.Server.JWT.Issuer, "issuer stamped on and required in tokens (optional)")
+	serverJWTAudiences := fs.String("jwt-audiences", strings.Join(cfg.Server.JWT.Audiences, ","), "comma separated audiences accepted in tokens; the first is stamped on new tokens (optional)")
+	serverJWTLeeway := fs.Duration("jwt-leeway", cfg.Server.JWT.Leeway, "clock skew allowed when checking token times")
+	serverJWTMaxAge := fs.Duration("jwt-max-age", cfg.Server.JWT.MaxAge, "reject tokens issued longer ago than this (optional)")
+	serverJWTAccessTTL := fs.Duration("jwt-access-ttl", cfg.Server.JWT.AccessTTL, "lifetime of access tokens")
+	serverJWTRefreshTTL := fs.Duration("jwt-refresh-ttl", cfg.Server.JWT.RefreshTTL, "lifetime of refresh tokens")
+	serverJWTPreviousKeys := fs.String("jwt-previous-keys", joinPreviousKeys(cfg.Server.JWT.PreviousKeys), "comma separated retired keys still accepted for tokens, each as key@time with the RFC 3339 time it stops being accepted (optional)")
+	serverJWTPublicKeyFiles := fs.String("jwt-public-key-files", strings.Join(cfg.Server.JWT.PublicKeyFiles, ","), "comma separated PEM files with public keys accepted for tokens (optional)")
 
 	if err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("CONDUIT_RYER_SERVER"), ff.WithConfigFileFlag("config"), ff.WithConfigFileParser(ff.JSONParser)); err != nil {
 		return err
 	}
 
 	cfg.Debug = *debug
+	cfg.Args = fs.Args()
 	cfg.App.Root = path.Clean(*appRoot)
 	cfg.FileName = *fileName
 	cfg.Cookies.HttpOnly = *serverCookiesHttpOnly
+	cfg.Cookies.SameSite = *serverCookiesSameSite
 	cfg.Cookies.Secure = *serverCookiesSecure
+	cfg.Cookies.Sessions = *serverCookiesSessions
 	cfg.Data.Path = path.Clean(*dataPath)
+	cfg.Data.Driver = *dataDriver
+	cfg.Data.Source = *dataSource
+	cfg.Data.Persist = *dataPersist
 	cfg.Server.Scheme = *serverScheme
 	cfg.Server.Host = *serverHost
 	cfg.Server.Port = *serverPort
@@ -136,6 +188,19 @@ func (cfg *Config) Load() error {
 	cfg.Server.TLS.CertFile = *serverTLSCertFile
 	cfg.Server.TLS.KeyFile = *serverTLSKeyFile
 	cfg.Server.WebRoot = path.Clean(*serverWebRoot)
+	cfg.Server.JWT.PrivateKeyFile = *serverJWTPrivateKeyFile
+	cfg.Server.JWT.PublicKeyFiles = splitList(*serverJWTPublicKeyFiles)
+	previousKeys, err := parsePreviousKeys(*serverJWTPreviousKeys)
+	if err != nil {
+		return err
+	}
+	cfg.Server.JWT.PreviousKeys = previousKeys
+	cfg.Server.JWT.Issuer = *serverJWTIssuer
+	cfg.Server.JWT.Audiences = splitList(*serverJWTAudiences)
+	cfg.Server.JWT.Leeway = *serverJWTLeeway
+	cfg.Server.JWT.MaxAge = *serverJWTMaxAge
+	cfg.Server.JWT.AccessTTL = *serverJWTAccessTTL
+	cfg.Server.JWT.RefreshTTL = *serverJWTRefreshTTL
 
 	if cfg.Server.TLS.Serve == true {
 		if cfg.Server.TLS.CertFile == "" {
@@ -148,3 +213,40 @@ func (cfg *Config) Load() error {
 
 	return nil
 }
+
+// splitList returns the non-empty, trimmed elements of a comma separated list.
+func splitList(s string) (list []string) {
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
+
+// parsePreviousKeys parses a comma separated list of key@time entries.
+// The time is required, so that a previous key isn't accepted forever by mistake.
+// The errors don't quote the entry, since it holds a secret.
+func parsePreviousKeys(s string) (keys []PreviousKey, err error) {
+	for _, elem := range splitList(s) {
+		i := strings.LastIndex(elem, "@")
+		if i < 1 {
+			return nil, fmt.Errorf("jwt-previous-keys: expected key@time")
+		}
+		at, err := time.Parse(time.RFC3339, elem[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("jwt-previous-keys: %w", err)
+		}
+		keys = append(keys, PreviousKey{Key: elem[:i], RetireAt: at})
+	}
+	return keys, nil
+}
+
+// joinPreviousKeys is the inverse of parsePreviousKeys.
+func joinPreviousKeys(keys []PreviousKey) string {
+	var list []string
+	for _, key := range keys {
+		list = append(list, key.Key+"@"+key.RetireAt.Format(time.RFC3339))
+	}
+	return strings.Join(list, ",")
+}
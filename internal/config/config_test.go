@@ -58,4 +58,8 @@ func TestDefault(t *testing.T) {
 	if expected := "3000"; cfg.Server.Port != expected {
 		t.Errorf("default: expected server.port to be %q: got %q\n", expected, cfg.Server.Port)
 	}
+	// And no previous keys should be accepted
+	if len(cfg.Server.JWT.PreviousKeys) != 0 {
+		t.Errorf("default: expected no server.jwt.previousKeys: got %d\n", len(cfg.Server.JWT.PreviousKeys))
+	}
 }
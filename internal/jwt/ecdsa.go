@@ -0,0 +1,90 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+)
+
+// ES256 implements a Signer and Verifier using ECDSA on the P-256 curve and SHA-256.
+// It can only sign if it was created from a private key.
+type ES256 struct {
+	private *ecdsa.PrivateKey
+	public  *ecdsa.PublicKey
+}
+
+// es256Size is the length of each half of the signature.
+// JWS wants the fixed-width R || S, not the ASN.1 form the standard library uses.
+const es256Size = 32
+
+func ES256Signer(key *ecdsa.PrivateKey) *ES256 {
+	return &ES256{private: key, public: &key.PublicKey}
+}
+
+func ES256Verifier(key *ecdsa.PublicKey) *ES256 {
+	return &ES256{public: key}
+}
+
+// Algorithm implements the Signer and Verifier interfaces
+func (e *ES256) Algorithm() string {
+	return "ES256"
+}
+
+// Public returns the public key.
+func (e *ES256) Public() interface{} {
+	return e.public
+}
+
+// Sign implements the Signer interface
+func (e *ES256) Sign(msg []byte) ([]byte, error) {
+	if e.private == nil {
+		return nil, ErrMissingSigner
+	}
+	digest := sha256.Sum256(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, e.private, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 2*es256Size)
+	r.FillBytes(sig[:es256Size])
+	s.FillBytes(sig[es256Size:])
+	return sig, nil
+}
+
+// Verify implements the Verifier interface
+func (e *ES256) Verify(msg, sig []byte) error {
+	if len(sig) != 2*es256Size {
+		return ErrUnauthorized
+	}
+	digest := sha256.Sum256(msg)
+	r, s := new(big.Int).SetBytes(sig[:es256Size]), new(big.Int).SetBytes(sig[es256Size:])
+	if !ecdsa.Verify(e.public, digest[:], r, s) {
+		return ErrUnauthorized
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"crypto/ed25519"
+)
+
+// EdDSA implements a Signer and Verifier using Ed25519.
+// It can only sign if it was created from a private key.
+type EdDSA struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+func EdDSASigner(key ed25519.PrivateKey) *EdDSA {
+	return &EdDSA{private: key, public: key.Public().(ed25519.PublicKey)}
+}
+
+func EdDSAVerifier(key ed25519.PublicKey) *EdDSA {
+	return &EdDSA{public: key}
+}
+
+// Algorithm implements the Signer and Verifier interfaces
+func (e *EdDSA) Algorithm() string {
+	return "EdDSA"
+}
+
+// Public returns the public key.
+func (e *EdDSA) Public() interface{} {
+	return e.public
+}
+
+// Sign implements the Signer interface
+func (e *EdDSA) Sign(msg []byte) ([]byte, error) {
+	if e.private == nil {
+		return nil, ErrMissingSigner
+	}
+	return ed25519.Sign(e.private, msg), nil
+}
+
+// Verify implements the Verifier interface
+func (e *EdDSA) Verify(msg, sig []byte) error {
+	if len(e.public) != ed25519.PublicKeySize || !ed25519.Verify(e.public, msg, sig) {
+		return ErrUnauthorized
+	}
+	return nil
+}
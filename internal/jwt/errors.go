@@ -26,10 +26,21 @@ package jwt
 
 import "errors"
 
+var ErrActiveKey = errors.New("active key")
 var ErrBadRequest = errors.New("bad request")
+var ErrDuplicateKey = errors.New("duplicate key")
 var ErrExpired = errors.New("expired")
+var ErrInvalidAudience = errors.New("invalid audience")
+var ErrInvalidIssuer = errors.New("invalid issuer")
+var ErrInvalidSubject = errors.New("invalid subject")
 var ErrMissingAuthHeader = errors.New("missing auth header")
+var ErrMissingClaim = errors.New("missing claim")
 var ErrMissingSigner = errors.New("missing signer")
 var ErrNotBearer = errors.New("not a bearer token")
 var ErrNotJWT = errors.New("not a jwt")
+var ErrNotYetValid = errors.New("not yet valid")
+var ErrRevoked = errors.New("revoked")
+var ErrTooOld = errors.New("too old")
 var ErrUnauthorized = errors.New("unauthorized")
+var ErrUnknownKey = errors.New("unknown key")
+var ErrUnsupportedKey = errors.New("unsupported key")
@@ -25,51 +25,132 @@
 package jwt
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"strconv"
 	"time"
 )
 
 // NewFactory returns an initialized factory.
-// The signer is used to sign the generated tokens.
+// The secret is used to sign and verify the generated tokens with HS256.
 func NewFactory(secret string) Factory {
-	return Factory{s: HS256Signer([]byte(secret))}
+	kr := NewKeyring()
+	_ = kr.Rotate("", HS256Signer([]byte(secret)), -1)
+	return Factory{kr: kr}
 }
 
-// Consider setting up factory to use only one algorithm.
-// If we do, can it still use a key id from the header?
-// You should create a new factory when you rotate keys!
+// NewKeyFactory returns a factory that signs tokens with s and accepts
+// tokens verified by s (if it is also a Verifier) or by any of the verifiers.
+// If s is nil, the factory can validate tokens but not create them.
+func NewKeyFactory(s Signer, verifiers ...Verifier) Factory {
+	kr := NewKeyring()
+	if s != nil {
+		_ = kr.Rotate("", s, -1)
+	}
+	for _, v := range verifiers {
+		_ = kr.Accept("", v)
+	}
+	return Factory{kr: kr}
+}
+
+// NewKeyringFactory returns a factory that signs tokens with the active key
+// from the keyring and accepts tokens signed by any key it still holds.
+// Changes to the keyring take effect immediately.
+func NewKeyringFactory(kr *Keyring) Factory {
+	return Factory{kr: kr}
+}
+
+// The verifier is picked by the kid and algorithm in the token header,
+// so a token can't trick us into checking an RSA signature with HMAC.
+// Rotate keys through the Keyring; the factory doesn't need to be replaced.
 type Factory struct {
-	s         Signer
+	kr        *Keyring
+	policy    Policy
 	tokenType string
+	clock     func() time.Time // time.Now if nil
+}
+
+// WithPolicy returns a copy of the factory that stamps and checks claims using the policy.
+func (f Factory) WithPolicy(p Policy) Factory {
+	f.policy = p
+	f.policy.Audiences = append([]string{}, p.Audiences...)
+	f.policy.Required = append([]string{}, p.Required...)
+	return f
 }
 
-// Validate will return an error if the JWT is not properly signed.
+// WithClock returns a copy of the factory that takes the current time from clock.
+// Tests use it to check expiry and key retirement without waiting.
+func (f Factory) WithClock(clock func() time.Time) Factory {
+	f.clock = clock
+	return f
+}
+
+// Keyring returns the keys used by the factory.
+func (f *Factory) Keyring() *Keyring {
+	return f.kr
+}
+
+// Validate will return an error if the JWT is not properly signed
+// or if its claims are rejected by the factory's policy.
+// The error says why the token was rejected: ErrUnauthorized for
+// a bad signature, ErrExpired for an expired token, and so on.
 func (f *Factory) Validate(j *JWT) error {
-	expectedSignature, err := f.s.Sign([]byte(j.h.b64 + "." + j.p.b64))
-	if err != nil {
-		return err
+	j.isSigned = false
+	if f.kr == nil {
+		return ErrUnauthorized
 	}
-
-	if j.isSigned = j.s == encode(expectedSignature); !j.isSigned {
+	signature, err := decode(j.s)
+	if err != nil {
 		return ErrUnauthorized
 	}
-
-	return nil // valid signature
+	msg := []byte(j.h.b64 + "." + j.p.b64)
+	now := f.now()
+	for _, v := range f.kr.verifiers(j.h.KeyID, j.h.Algorithm, now) {
+		if err = v.Verify(msg, signature); err == nil {
+			j.isSigned = true
+			return f.policy.Check(j, now)
+		}
+	}
+	return ErrUnauthorized
 }
 
+// NewToken returns a token signed by the active key.
+// It returns an empty string if the factory has no signer.
 func (f *Factory) NewToken(ttl time.Duration, id int, username, email string, roles ...string) string {
+	return f.NewTokenFor(ttl, Data{Id: id, Username: username, Email: email, Roles: roles})
+}
+
+// NewTokenFor is NewToken with the private claims taken from d.
+func (f *Factory) NewTokenFor(ttl time.Duration, d Data) string {
+	if f.kr == nil {
+		return ""
+	}
+	kid, signer := f.kr.signer()
+	if signer == nil {
+		return ""
+	}
+
 	var j JWT
 
 	j.h.TokenType = "JWT"
-	j.h.Algorithm = f.s.Algorithm()
-	j.p.IssuedAt = time.Now().Unix()
-	j.p.ExpirationTime = time.Now().Add(ttl).Unix()
+	j.h.Algorithm = signer.Algorithm()
+	j.h.KeyID = kid
+	j.p.Issuer = f.policy.Issuer
+	if len(f.policy.Audiences) != 0 {
+		j.p.Audience = []string{f.policy.Audiences[0]}
+	}
+	j.p.Subject = strconv.Itoa(d.Id)
+	j.p.JWTID = newJWTID()
+	now := f.now()
+	j.p.IssuedAt = now.Unix()
+	j.p.ExpirationTime = now.Add(ttl).Unix()
 	j.p.Private.TokenType = j.h.TokenType
 	j.p.Private.Algorithm = j.h.Algorithm
-	j.p.Private.Id = id
-	j.p.Private.Username = username
-	j.p.Private.Email = email
-	j.p.Private.Roles = roles
+	j.p.Private.Id = d.Id
+	j.p.Private.Username = d.Username
+	j.p.Private.Email = d.Email
+	j.p.Private.Roles = d.Roles
+	j.p.Private.Generation = d.Generation
 
 	if h, err := json.MarshalIndent(j.h, "  ", "  "); err == nil {
 		j.h.b64 = encode(h)
@@ -77,9 +158,26 @@ func (f *Factory) NewToken(ttl time.Duration, id int, username, email string, ro
 	if p, err := json.MarshalIndent(j.p, "  ", "  "); err == nil {
 		j.p.b64 = encode(p)
 	}
-	if rawSignature, err := f.s.Sign([]byte(j.h.b64 + "." + j.p.b64)); err == nil {
+	if rawSignature, err := signer.Sign([]byte(j.h.b64 + "." + j.p.b64)); err == nil {
 		j.s = encode(rawSignature)
 	}
 
 	return j.h.b64 + "." + j.p.b64 + "." + j.s
 }
+
+// now returns the current time from the factory's clock.
+func (f *Factory) now() time.Time {
+	if f.clock == nil {
+		return time.Now()
+	}
+	return f.clock()
+}
+
+// newJWTID returns a random identifier for the jti claim.
+func newJWTID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return encode(b)
+}
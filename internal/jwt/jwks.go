@@ -0,0 +1,138 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// JWKS is a JSON Web Key Set (RFC 7517).
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is the public half of a signing key (RFC 7517, 7518 and 8037).
+// It never carries private or symmetric key material.
+type JWK struct {
+	KeyType   string `json:"kty"`           // "RSA", "EC" or "OKP"
+	KeyID     string `json:"kid,omitempty"` // matches the kid header of tokens signed with the key
+	Use       string `json:"use"`           // always "sig"
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"` // "P-256" or "Ed25519"
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA public exponent
+	X         string `json:"x,omitempty"`   // EC x coordinate or Ed25519 public key
+	Y         string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// JWKS returns the public half of every key that is still accepted at the given time.
+// Keys without a public half, like HS256, are never published.
+func (kr *Keyring) JWKS(now time.Time) JWKS {
+	kr.RLock()
+	defer kr.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range kr.keys {
+		if key.verifier == nil || key.isRetired(now) {
+			continue
+		} else if jwk, ok := asJWK(key.id, key.verifier); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// asJWK returns the JWK for an asymmetric verifier.
+func asJWK(id string, v Verifier) (JWK, bool) {
+	jwk := JWK{KeyID: id, Use: "sig", Algorithm: v.Algorithm()}
+	switch k := v.(type) {
+	case *RS256:
+		jwk.KeyType = "RSA"
+		jwk.N = encode(k.public.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(k.public.E)).Bytes())
+	case *ES256:
+		jwk.KeyType, jwk.Curve = "EC", "P-256"
+		x, y := make([]byte, es256Size), make([]byte, es256Size)
+		jwk.X = encode(k.public.X.FillBytes(x))
+		jwk.Y = encode(k.public.Y.FillBytes(y))
+	case *EdDSA:
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = encode(k.public)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// Verifier returns a verifier for the key so that a service
+// can check our tokens using only the published key set.
+func (jwk JWK) Verifier() (Verifier, error) {
+	switch {
+	case jwk.KeyType == "RSA" && jwk.Algorithm == "RS256":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		} else if len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("rsa exponent: %w", ErrUnsupportedKey)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if err = checkPublicKey(key); err != nil {
+			return nil, err
+		}
+		return RS256Verifier(key), nil
+	case jwk.KeyType == "EC" && jwk.Curve == "P-256" && jwk.Algorithm == "ES256":
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("ec point: %w", ErrUnsupportedKey)
+		}
+		return ES256Verifier(key), nil
+	case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519" && jwk.Algorithm == "EdDSA":
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		} else if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519 key: %w", ErrUnsupportedKey)
+		}
+		return EdDSAVerifier(ed25519.PublicKey(x)), nil
+	}
+	return nil, fmt.Errorf("kty %q crv %q alg %q: %w", jwk.KeyType, jwk.Curve, jwk.Algorithm, ErrUnsupportedKey)
+}
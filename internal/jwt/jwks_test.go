@@ -0,0 +1,136 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/mdhender/conduit/internal/jwt"
+	"testing"
+	"time"
+)
+
+func TestJWKS(t *testing.T) {
+	// Specification: publishing the key set
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+
+	// Given a keyring with an HS256 key, a retired RSA key, an ECDSA key and an active Ed25519 key
+	kr := jwt.NewKeyring()
+	if err := kr.Accept("hmac", jwt.HS256Signer([]byte("secret"))); err != nil {
+		t.Fatalf("accept: %+v\n", err)
+	}
+	for _, key := range []struct {
+		id     string
+		signer jwt.Signer
+	}{
+		{"rsa", jwt.RS256Signer(rsaKey)},
+		{"ec", jwt.ES256Signer(ecKey)},
+		{"ed", jwt.EdDSASigner(edKey)},
+	} {
+		if err := kr.Rotate(key.id, key.signer, -1); err != nil {
+			t.Fatalf("rotate: %+v\n", err)
+		}
+	}
+	if err := kr.Retire("rsa", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("retire: %+v\n", err)
+	}
+
+	// When the key set is published
+	data, err := json.Marshal(kr.JWKS(time.Now()))
+	if err != nil {
+		t.Fatalf("marshal: %+v\n", err)
+	}
+	var set jwt.JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatalf("unmarshal: %+v\n", err)
+	}
+
+	// Then only the unretired asymmetric keys should be in it
+	kids := map[string]jwt.JWK{}
+	for _, key := range set.Keys {
+		kids[key.KeyID] = key
+	}
+	if len(kids) != 2 || kids["ec"].KeyID == "" || kids["ed"].KeyID == "" {
+		t.Fatalf("jwks: expected keys ec and ed: got %s\n", data)
+	}
+	if key := kids["ec"]; key.KeyType != "EC" || key.Curve != "P-256" || key.Algorithm != "ES256" || len(key.X) != 43 || len(key.Y) != 43 {
+		t.Errorf("jwks: ec: unexpected encoding %+v\n", key)
+	}
+	if key := kids["ed"]; key.KeyType != "OKP" || key.Curve != "Ed25519" || key.Algorithm != "EdDSA" || len(key.X) != 43 || key.Y != "" {
+		t.Errorf("jwks: ed: unexpected encoding %+v\n", key)
+	}
+
+	// And a relying party using only the key set should accept our tokens
+	f := jwt.NewKeyringFactory(kr)
+	token := f.NewToken(time.Hour, 1, "jake", "jake@jake.jake", "authenticated")
+	relying := jwt.NewKeyring()
+	for _, key := range set.Keys {
+		v, err := key.Verifier()
+		if err != nil {
+			t.Fatalf("jwks: %s: verifier: %+v\n", key.KeyID, err)
+		} else if err = relying.Accept(key.KeyID, v); err != nil {
+			t.Fatalf("jwks: %s: accept: %+v\n", key.KeyID, err)
+		}
+	}
+	rf := jwt.NewKeyringFactory(relying)
+	if err := rf.Validate(bearer(t, token)); err != nil {
+		t.Errorf("jwks: relying party: expected nil: got %v\n", err)
+	}
+}
+
+func TestJWKRSAEncoding(t *testing.T) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+	kr := jwt.NewKeyring()
+	if err := kr.Rotate("rsa", jwt.RS256Signer(k), -1); err != nil {
+		t.Fatalf("rotate: %+v\n", err)
+	}
+	set := kr.JWKS(time.Now())
+	if len(set.Keys) != 1 {
+		t.Fatalf("jwks: expected 1 key: got %d\n", len(set.Keys))
+	}
+	// 65537 is the usual exponent and must be encoded without leading zeros
+	if key := set.Keys[0]; key.KeyType != "RSA" || key.E != "AQAB" || len(key.N) != 342 || key.Curve != "" {
+		t.Errorf("jwks: rsa: unexpected encoding %+v\n", key)
+	}
+}
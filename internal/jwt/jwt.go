@@ -38,7 +38,7 @@ type JWT struct {
 		Algorithm   string `json:"alg,omitempty"` // message authentication code algorithm
 		TokenType   string `json:"typ,omitempty"`
 		ContentType string `json:"cty,omitempty"`
-		KeyID       string `json:"kid,omitempty"` // optional identifier used to sign. doesn't work.
+		KeyID       string `json:"kid,omitempty"` // optional identifier of the key used to sign
 		b64         string // header marshalled to JSON and then base-64 encoded
 	}
 	p struct {
@@ -70,6 +70,8 @@ type JWT struct {
 			Username  string   `json:"username,omitempty"`
 			Email     string   `json:"email,omitempty"`
 			Roles     []string `json:"roles,omitempty"`
+			// Generation of the user's sessions when the token was issued.
+			Generation int `json:"gen,omitempty"`
 		} `json:"private"`
 		b64 string // payload marshalled to JSON and then base-64 encoded
 	}
@@ -78,10 +80,11 @@ type JWT struct {
 }
 
 type Data struct {
-	Id       int
-	Username string
-	Email    string
-	Roles    []string
+	Id         int
+	Username   string
+	Email      string
+	Roles      []string
+	Generation int
 }
 
 // pull the bearer token from a request header.
@@ -98,8 +101,13 @@ func GetBearerToken(r *http.Request) (*JWT, error) {
 	if authType != "Bearer" {
 		return nil, ErrNotBearer
 	}
+	return Parse(authToken)
+}
 
-	sections := strings.Split(authToken, ".")
+// Parse extracts the header and payload from a token.
+// The signature is not checked; use Factory.Validate for that.
+func Parse(token string) (*JWT, error) {
+	sections := strings.Split(token, ".")
 	if len(sections) != 3 || len(sections[0]) == 0 || len(sections[1]) == 0 || len(sections[2]) == 0 {
 		return nil, ErrNotJWT
 	}
@@ -138,32 +146,23 @@ func (j *JWT) Data() Data {
 		Username: j.p.Private.Username,
 		Email:    j.p.Private.Email,
 		Roles:    j.p.Private.Roles,
+
+		Generation: j.p.Private.Generation,
 	}
 }
 
+// ExpiresAt returns the expiration time of the token.
+func (j *JWT) ExpiresAt() time.Time {
+	return time.Unix(j.p.ExpirationTime, 0)
+}
+
+// ID returns the unique identifier (jti) of the token.
+func (j *JWT) ID() string {
+	return j.p.JWTID
+}
+
+// IsValid returns true if the signature has been verified and the
+// claims are acceptable under the default Policy.
 func (j *JWT) IsValid() bool {
-	now := time.Now().UTC()
-	if j == nil {
-		//log.Printf("jwt is nil\n")
-		return false
-	} else if !j.isSigned || j.h.Algorithm != j.p.Private.Algorithm || j.h.TokenType != j.p.Private.TokenType {
-		//log.Printf("alg %q typ %q signed %v borked\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if j.p.NotBefore != 0 && !now.Before(time.Unix(j.p.NotBefore, 0)) {
-		//log.Printf("alg %q typ %q signed %v !now.Before(notBefore)\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if j.p.IssuedAt == 0 {
-		//log.Printf("alg %q typ %q signed %v no issue timestamp\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if !now.After(time.Unix(j.p.IssuedAt, 0)) {
-		//log.Printf("alg %q typ %q signed %v !now.After(issuedAt) %s %s\n", j.h.Algorithm, j.h.TokenType, j.isSigned, now.Format("2006-01-02T15:04:05.99999999Z"), time.Unix(j.p.IssuedAt, 0).Format("2006-01-02T15:04:05.99999999Z"))
-		return false
-	} else if j.p.ExpirationTime == 0 {
-		//log.Printf("alg %q typ %q signed %v no expiration timestamp\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	} else if !time.Unix(j.p.ExpirationTime, 0).After(now) {
-		//log.Printf("alg %q typ %q signed %v !expiresAt.After(now)\n", j.h.Algorithm, j.h.TokenType, j.isSigned)
-		return false
-	}
-	return true
+	return j != nil && j.isSigned && Policy{}.Check(j, time.Now().UTC()) == nil
 }
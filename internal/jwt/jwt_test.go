@@ -0,0 +1,188 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/mdhender/conduit/internal/jwt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsymmetricSigners(t *testing.T) {
+	// Specification: asymmetric signers
+	// Tokens signed with a private key are accepted by a factory that
+	// holds only the public key, and that factory can't mint tokens.
+
+	for _, tc := range []struct {
+		alg string
+		gen func() (interface{}, interface{}, error)
+	}{
+		{"RS256", func() (interface{}, interface{}, error) {
+			k, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				return nil, nil, err
+			}
+			return k, &k.PublicKey, nil
+		}},
+		{"ES256", func() (interface{}, interface{}, error) {
+			k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				return nil, nil, err
+			}
+			return k, &k.PublicKey, nil
+		}},
+		{"EdDSA", func() (interface{}, interface{}, error) {
+			pub, k, err := ed25519.GenerateKey(rand.Reader)
+			return k, pub, err
+		}},
+	} {
+		private, public, err := tc.gen()
+		if err != nil {
+			t.Fatalf("%s: generate: %+v\n", tc.alg, err)
+		}
+		signer, err := jwt.ParseSigner(pemPrivate(t, private))
+		if err != nil {
+			t.Fatalf("%s: parse signer: %+v\n", tc.alg, err)
+		}
+		verifier, err := jwt.ParseVerifier(pemPublic(t, public))
+		if err != nil {
+			t.Fatalf("%s: parse verifier: %+v\n", tc.alg, err)
+		}
+		if signer.Algorithm() != tc.alg || verifier.Algorithm() != tc.alg {
+			t.Errorf("%s: expected algorithm %q: got %q and %q\n", tc.alg, tc.alg, signer.Algorithm(), verifier.Algorithm())
+		}
+
+		issuer := jwt.NewKeyFactory(signer)
+		token := issuer.NewToken(time.Hour, 1, "jake", "jake@jake.jake", "authenticated")
+		if token == "" {
+			t.Fatalf("%s: new token: expected token: got empty string\n", tc.alg)
+		}
+
+		// Given a factory with only the public key
+		// When it validates the token
+		// Then the token should be accepted
+		relying := jwt.NewKeyFactory(nil, verifier)
+		j := bearer(t, token)
+		if err := relying.Validate(j); err != nil {
+			t.Errorf("%s: validate: expected nil: got %v\n", tc.alg, err)
+		} else if !j.IsValid() {
+			t.Errorf("%s: validate: expected valid token\n", tc.alg)
+		} else if got := j.Data().Username; got != "jake" {
+			t.Errorf("%s: validate: expected username %q: got %q\n", tc.alg, "jake", got)
+		}
+		// And it should not be able to mint tokens
+		if got := relying.NewToken(time.Hour, 1, "jake", "jake@jake.jake", "admin"); got != "" {
+			t.Errorf("%s: relying party: expected no token: got %q\n", tc.alg, got)
+		}
+
+		// When the payload is tampered with
+		// Then the token should be rejected
+		sections := strings.Split(token, ".")
+		forged := issuer.NewToken(time.Hour, 2, "anne", "anne@anne.anne", "admin")
+		sections[1] = strings.Split(forged, ".")[1]
+		if err := relying.Validate(bearer(t, strings.Join(sections, "."))); err != jwt.ErrUnauthorized {
+			t.Errorf("%s: tampered: expected %v: got %v\n", tc.alg, jwt.ErrUnauthorized, err)
+		}
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	// Specification: a token is only checked by a verifier for its own algorithm
+
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+	public := pemPublic(t, &k.PublicKey)
+	verifier, err := jwt.ParseVerifier(public)
+	if err != nil {
+		t.Fatalf("parse verifier: %+v\n", err)
+	}
+
+	// Given an attacker who signs an HS256 token using the public key as the secret
+	attacker := jwt.NewFactory(string(public))
+	token := attacker.NewToken(time.Hour, 1, "jake", "jake@jake.jake", "admin")
+	// When a factory holding that public key validates it
+	// Then the token should be rejected
+	relying := jwt.NewKeyFactory(nil, verifier)
+	if err := relying.Validate(bearer(t, token)); err != jwt.ErrUnauthorized {
+		t.Errorf("confusion: expected %v: got %v\n", jwt.ErrUnauthorized, err)
+	}
+}
+
+func TestParseRejectsWeakKeys(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+	if _, err := jwt.ParseSigner(pemPrivate(t, small)); err == nil {
+		t.Errorf("rsa 1024: expected error: got nil\n")
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+	if _, err := jwt.ParseVerifier(pemPublic(t, &p384.PublicKey)); err == nil {
+		t.Errorf("ecdsa p-384: expected error: got nil\n")
+	}
+	if _, err := jwt.ParseSigner([]byte("not a pem file")); err == nil {
+		t.Errorf("garbage: expected error: got nil\n")
+	}
+}
+
+func bearer(t *testing.T, token string) *jwt.JWT {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	j, err := jwt.GetBearerToken(r)
+	if err != nil {
+		t.Fatalf("bearer: %+v\n", err)
+	}
+	return j
+}
+
+func pemPrivate(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal private key: %+v\n", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func pemPublic(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("marshal public key: %+v\n", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
@@ -0,0 +1,198 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"sync"
+	"time"
+)
+
+// Keyring holds the keys used to sign and verify tokens.
+// One key is active and signs new tokens.
+// Other keys are accepted for verification until they are retired,
+// so rotating the active key doesn't invalidate tokens that are already out there.
+// It is safe for concurrent use, so keys can be rotated on a running server.
+type Keyring struct {
+	sync.RWMutex
+	active *ringKey
+	keys   []*ringKey // in the order they were added
+}
+
+type ringKey struct {
+	id       string
+	signer   Signer
+	verifier Verifier
+	retireAt time.Time // zero means the key is never retired
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{}
+}
+
+// Accept adds a key that is accepted for verification but never signs.
+// The id is matched against the kid header of incoming tokens.
+// Tokens without a kid are checked against every key with a matching algorithm.
+func (kr *Keyring) Accept(id string, v Verifier) error {
+	return kr.AcceptUntil(id, v, time.Time{})
+}
+
+// AcceptUntil is like Accept, but the key is retired at the given time.
+// A zero time means the key is never retired.
+func (kr *Keyring) AcceptUntil(id string, v Verifier, at time.Time) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if id != "" && kr.find(id) != nil {
+		return ErrDuplicateKey
+	}
+	kr.keys = append(kr.keys, &ringKey{id: id, verifier: v, retireAt: at})
+	return nil
+}
+
+// Rotate makes s the active key and stamps the id on every new token.
+// If s is also a Verifier, the tokens it signs are accepted.
+// The previously active key is accepted for the grace period and then retired.
+// A negative grace keeps it until it is explicitly retired.
+//
+// If the id is already in the keyring (say, published ahead of time
+// so that other services can cache it) the key is replaced by s.
+func (kr *Keyring) Rotate(id string, s Signer, grace time.Duration) error {
+	if s == nil {
+		return ErrMissingSigner
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	if kr.active != nil && kr.active.id == id && id != "" {
+		return ErrDuplicateKey
+	}
+	v, _ := s.(Verifier)
+	key := kr.find(id)
+	if key == nil || id == "" {
+		key = &ringKey{id: id}
+		kr.keys = append(kr.keys, key)
+	}
+	key.signer, key.verifier, key.retireAt = s, v, time.Time{}
+	if kr.active != nil && grace >= 0 {
+		kr.active.retireAt = time.Now().Add(grace)
+	}
+	kr.active = key
+	return nil
+}
+
+// Retire stops accepting the key at the given time.
+// The active key can't be retired; rotate to a new key first.
+func (kr *Keyring) Retire(id string, at time.Time) error {
+	kr.Lock()
+	defer kr.Unlock()
+	key := kr.find(id)
+	if key == nil {
+		return ErrUnknownKey
+	} else if key == kr.active {
+		return ErrActiveKey
+	}
+	key.retireAt = at
+	return nil
+}
+
+// Prune removes keys that were retired before now and returns their ids.
+func (kr *Keyring) Prune(now time.Time) (ids []string) {
+	kr.Lock()
+	defer kr.Unlock()
+	keys := kr.keys[:0]
+	for _, key := range kr.keys {
+		if key.isRetired(now) {
+			ids = append(ids, key.id)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	for i := len(keys); i < len(kr.keys); i++ {
+		kr.keys[i] = nil
+	}
+	kr.keys = keys
+	return ids
+}
+
+// signer returns the active key.
+func (kr *Keyring) signer() (id string, s Signer) {
+	kr.RLock()
+	defer kr.RUnlock()
+	if kr.active == nil {
+		return "", nil
+	}
+	return kr.active.id, kr.active.signer
+}
+
+// verifiers returns the unretired keys that could have signed a token
+// with the given kid and algorithm.
+func (kr *Keyring) verifiers(kid, alg string, now time.Time) (verifiers []Verifier) {
+	kr.RLock()
+	defer kr.RUnlock()
+	for _, key := range kr.keys {
+		if key.verifier == nil || key.verifier.Algorithm() != alg || key.isRetired(now) {
+			continue
+		} else if kid != "" && key.id != kid {
+			continue
+		}
+		verifiers = append(verifiers, key.verifier)
+	}
+	return verifiers
+}
+
+// find returns the key with the given id or nil.
+// Anonymous keys are never found.
+func (kr *Keyring) find(id string) *ringKey {
+	if id == "" {
+		return nil
+	}
+	for _, key := range kr.keys {
+		if key.id == id {
+			return key
+		}
+	}
+	return nil
+}
+
+func (key *ringKey) isRetired(now time.Time) bool {
+	return !key.retireAt.IsZero() && !now.Before(key.retireAt)
+}
+
+// KeyID returns an identifier derived from the public half of an asymmetric key.
+// It is stable across restarts, so every server holding the same key agrees on it.
+// It returns an empty string for keys that have no public half, like HS256.
+func KeyID(v Verifier) string {
+	p, ok := v.(interface{ Public() interface{} })
+	if !ok {
+		return ""
+	}
+	der, err := x509.MarshalPKIXPublicKey(p.Public())
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return encode(sum[:12])
+}
@@ -0,0 +1,220 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/mdhender/conduit/internal/jwt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	// Specification: rotating the signing key
+
+	// Given a factory signing with key "2021-01"
+	kr := jwt.NewKeyring()
+	if err := kr.Rotate("2021-01", jwt.HS256Signer([]byte("january")), -1); err != nil {
+		t.Fatalf("rotate: %+v\n", err)
+	}
+	f := jwt.NewKeyringFactory(kr)
+	january := f.NewToken(time.Hour, 1, "jake", "jake@jake.jake", "authenticated")
+	if got := kid(t, january); got != "2021-01" {
+		t.Errorf("rotate: expected kid %q: got %q\n", "2021-01", got)
+	}
+
+	// When the key is rotated to "2021-02"
+	if err := kr.Rotate("2021-02", jwt.HS256Signer([]byte("february")), time.Hour); err != nil {
+		t.Fatalf("rotate: %+v\n", err)
+	}
+	// Then new tokens should be signed with "2021-02"
+	february := f.NewToken(time.Hour, 1, "jake", "jake@jake.jake", "authenticated")
+	if got := kid(t, february); got != "2021-02" {
+		t.Errorf("rotate: expected kid %q: got %q\n", "2021-02", got)
+	}
+	// And tokens signed with either key should be accepted
+	for _, token := range []string{january, february} {
+		if err := f.Validate(bearer(t, token)); err != nil {
+			t.Errorf("rotate: kid %q: expected nil: got %v\n", kid(t, token), err)
+		}
+	}
+
+	// When the old key is retired
+	if err := kr.Retire("2021-01", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("retire: %+v\n", err)
+	}
+	// Then tokens signed with it should be rejected
+	if err := f.Validate(bearer(t, january)); err != jwt.ErrUnauthorized {
+		t.Errorf("retire: expected %v: got %v\n", jwt.ErrUnauthorized, err)
+	}
+	// And tokens signed with the active key should still be accepted
+	if err := f.Validate(bearer(t, february)); err != nil {
+		t.Errorf("retire: expected nil: got %v\n", err)
+	}
+	// And pruning should drop the retired key
+	if ids := kr.Prune(time.Now()); len(ids) != 1 || ids[0] != "2021-01" {
+		t.Errorf("prune: expected [2021-01]: got %v\n", ids)
+	}
+}
+
+func TestKeyringKeyID(t *testing.T) {
+	// Specification: the kid header selects the key
+
+	kr := jwt.NewKeyring()
+	if err := kr.Accept("old", jwt.HS256Signer([]byte("secret"))); err != nil {
+		t.Fatalf("accept: %+v\n", err)
+	}
+	if err := kr.Rotate("new", jwt.HS256Signer([]byte("secret")), -1); err != nil {
+		t.Fatalf("rotate: %+v\n", err)
+	}
+	f := jwt.NewKeyringFactory(kr)
+
+	// Given a token whose kid names a key that isn't in the keyring
+	// When it is validated
+	// Then it should be rejected even though another key would verify it
+	if err := f.Validate(bearer(t, tokenWithKid(t, "unknown", "secret"))); err != jwt.ErrUnauthorized {
+		t.Errorf("kid: unknown: expected %v: got %v\n", jwt.ErrUnauthorized, err)
+	}
+	// And a token for a known kid should be accepted until that key is retired
+	old := tokenWithKid(t, "old", "secret")
+	if err := f.Validate(bearer(t, old)); err != nil {
+		t.Errorf("kid: old: expected nil: got %v\n", err)
+	}
+	if err := kr.Retire("old", time.Now()); err != nil {
+		t.Fatalf("retire: %+v\n", err)
+	}
+	if err := f.Validate(bearer(t, old)); err != jwt.ErrUnauthorized {
+		t.Errorf("kid: retired: expected %v: got %v\n", jwt.ErrUnauthorized, err)
+	}
+}
+
+func TestKeyringAcceptUntil(t *testing.T) {
+	// Specification: previous keys are retired at a fixed time
+
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	// Given a token signed with the "january" key
+	january := jwt.NewFactory("january").WithClock(clock)
+	token := january.NewToken(24*time.Hour, 1, "jake", "jake@jake.jake", "authenticated")
+
+	// And a server that signs with "february" and accepts "january" until 13:00
+	kr := jwt.NewKeyring()
+	if err := kr.AcceptUntil("", jwt.HS256Signer([]byte("january")), now.Add(time.Hour)); err != nil {
+		t.Fatalf("accept: %+v\n", err)
+	}
+	if err := kr.Rotate("", jwt.HS256Signer([]byte("february")), -1); err != nil {
+		t.Fatalf("rotate: %+v\n", err)
+	}
+	f := jwt.NewKeyringFactory(kr).WithClock(clock)
+
+	// Then the token should be accepted before 13:00
+	now = now.Add(59 * time.Minute)
+	if err := f.Validate(bearer(t, token)); err != nil {
+		t.Errorf("accept until: before: expected nil: got %v\n", err)
+	}
+	// And rejected from 13:00 on, even though it hasn't expired
+	now = now.Add(time.Minute)
+	if err := f.Validate(bearer(t, token)); err != jwt.ErrUnauthorized {
+		t.Errorf("accept until: after: expected %v: got %v\n", jwt.ErrUnauthorized, err)
+	}
+	// And pruning should drop the retired key
+	if ids := kr.Prune(now); len(ids) != 1 || ids[0] != "" {
+		t.Errorf("prune: expected one anonymous key: got %q\n", ids)
+	}
+}
+
+func TestKeyringErrors(t *testing.T) {
+	kr := jwt.NewKeyring()
+	if err := kr.Rotate("a", nil, -1); err != jwt.ErrMissingSigner {
+		t.Errorf("rotate nil: expected %v: got %v\n", jwt.ErrMissingSigner, err)
+	}
+	if err := kr.Rotate("a", jwt.HS256Signer([]byte("a")), -1); err != nil {
+		t.Fatalf("rotate: %+v\n", err)
+	}
+	if err := kr.Rotate("a", jwt.HS256Signer([]byte("a")), -1); err != jwt.ErrDuplicateKey {
+		t.Errorf("rotate active: expected %v: got %v\n", jwt.ErrDuplicateKey, err)
+	}
+	if err := kr.Accept("a", jwt.HS256Signer([]byte("b"))); err != jwt.ErrDuplicateKey {
+		t.Errorf("accept duplicate: expected %v: got %v\n", jwt.ErrDuplicateKey, err)
+	}
+	if err := kr.Retire("a", time.Now()); err != jwt.ErrActiveKey {
+		t.Errorf("retire active: expected %v: got %v\n", jwt.ErrActiveKey, err)
+	}
+	if err := kr.Retire("b", time.Now()); err != jwt.ErrUnknownKey {
+		t.Errorf("retire unknown: expected %v: got %v\n", jwt.ErrUnknownKey, err)
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate: %+v\n", err)
+	}
+	signer, err := jwt.ParseSigner(pemPrivate(t, k))
+	if err != nil {
+		t.Fatalf("parse signer: %+v\n", err)
+	}
+	verifier, err := jwt.ParseVerifier(pemPublic(t, &k.PublicKey))
+	if err != nil {
+		t.Fatalf("parse verifier: %+v\n", err)
+	}
+	// the private and public halves must agree so a relying party finds the key
+	if a, b := jwt.KeyID(signer.(jwt.Verifier)), jwt.KeyID(verifier); a == "" || a != b {
+		t.Errorf("kid: expected matching ids: got %q and %q\n", a, b)
+	}
+	if got := jwt.KeyID(jwt.HS256Signer([]byte("secret"))); got != "" {
+		t.Errorf("kid: hs256: expected empty id: got %q\n", got)
+	}
+}
+
+// kid returns the key id from the token header.
+func kid(t *testing.T, token string) string {
+	var h struct {
+		KeyID string `json:"kid"`
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatalf("kid: %+v\n", err)
+	} else if err = json.Unmarshal(raw, &h); err != nil {
+		t.Fatalf("kid: %+v\n", err)
+	}
+	return h.KeyID
+}
+
+// tokenWithKid returns a token signed with the secret and the given kid.
+func tokenWithKid(t *testing.T, kid, secret string) string {
+	kr := jwt.NewKeyring()
+	if err := kr.Rotate(kid, jwt.HS256Signer([]byte(secret)), -1); err != nil {
+		t.Fatalf("tokenWithKid: %+v\n", err)
+	}
+	f := jwt.NewKeyringFactory(kr)
+	return f.NewToken(time.Hour, 1, "jake", "jake@jake.jake", "authenticated")
+}
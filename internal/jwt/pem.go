@@ -0,0 +1,162 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// minRSABits is the smallest RSA key we will sign or verify with.
+const minRSABits = 2048
+
+// LoadSigner reads a PEM encoded private key from a file.
+// See ParseSigner for the accepted formats.
+func LoadSigner(name string) (Signer, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSigner(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return s, nil
+}
+
+// LoadVerifier reads a PEM encoded public key or certificate from a file.
+// See ParseVerifier for the accepted formats.
+func LoadVerifier(name string) (Verifier, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := ParseVerifier(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// ParseSigner returns a Signer for the first PEM block in data.
+// It accepts "RSA PRIVATE KEY" (PKCS #1), "EC PRIVATE KEY" (SEC 1)
+// and "PRIVATE KEY" (PKCS #8) blocks.
+// The signer can also verify the tokens it signs.
+func ParseSigner(data []byte) (Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block: %w", ErrUnsupportedKey)
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("pem block %q: %w", block.Type, ErrUnsupportedKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if err := checkPublicKey(&k.PublicKey); err != nil {
+			return nil, err
+		}
+		return RS256Signer(k), nil
+	case *ecdsa.PrivateKey:
+		if err := checkPublicKey(&k.PublicKey); err != nil {
+			return nil, err
+		}
+		return ES256Signer(k), nil
+	case ed25519.PrivateKey:
+		return EdDSASigner(k), nil
+	}
+	return nil, fmt.Errorf("private key %T: %w", key, ErrUnsupportedKey)
+}
+
+// ParseVerifier returns a Verifier for the first PEM block in data.
+// It accepts "PUBLIC KEY" (PKIX), "RSA PUBLIC KEY" (PKCS #1)
+// and "CERTIFICATE" blocks.
+func ParseVerifier(data []byte) (Verifier, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block: %w", ErrUnsupportedKey)
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("pem block %q: %w", block.Type, ErrUnsupportedKey)
+	}
+	if err != nil {
+		return nil, err
+	} else if err = checkPublicKey(key); err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return RS256Verifier(k), nil
+	case *ecdsa.PublicKey:
+		return ES256Verifier(k), nil
+	case ed25519.PublicKey:
+		return EdDSAVerifier(k), nil
+	}
+	return nil, fmt.Errorf("public key %T: %w", key, ErrUnsupportedKey)
+}
+
+// checkPublicKey rejects keys that we don't have an algorithm for.
+func checkPublicKey(key interface{}) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return fmt.Errorf("rsa key is %d bits: %w", k.N.BitLen(), ErrUnsupportedKey)
+		}
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return fmt.Errorf("ecdsa curve %s: %w", k.Curve.Params().Name, ErrUnsupportedKey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Policy describes the claims a token must carry to be accepted.
+// The zero value only requires unexpired tokens with an issue time.
+type Policy struct {
+	// Issuer, if set, must match the iss claim. NewToken stamps it on every token.
+	Issuer string
+	// Audiences, if set, must include one of the values in the aud claim.
+	// NewToken stamps the first one on every token.
+	Audiences []string
+	// Leeway is the clock skew allowed when checking exp, nbf and iat.
+	Leeway time.Duration
+	// MaxAge, if set, rejects tokens issued longer ago than this, whatever their exp.
+	MaxAge time.Duration
+	// Required lists claims that must be present, like "sub" or "jti".
+	// The exp and iat claims are always required.
+	Required []string
+}
+
+// Check returns nil if the claims in the token are acceptable at the given time.
+// It does not check the signature; use Factory.Validate for that.
+func (p Policy) Check(j *JWT, now time.Time) error {
+	if j == nil {
+		return ErrUnauthorized
+	} else if j.h.Algorithm != j.p.Private.Algorithm || j.h.TokenType != j.p.Private.TokenType {
+		return ErrUnauthorized
+	}
+	for _, claim := range append([]string{"exp", "iat"}, p.Required...) {
+		if !j.hasClaim(claim) {
+			return fmt.Errorf("%w: %s", ErrMissingClaim, claim)
+		}
+	}
+	if p.Issuer != "" && j.p.Issuer != p.Issuer {
+		return ErrInvalidIssuer
+	}
+	if len(p.Audiences) != 0 && !j.hasAudience(p.Audiences) {
+		return ErrInvalidAudience
+	}
+	if j.p.Subject != "" && j.p.Subject != strconv.Itoa(j.p.Private.Id) {
+		return ErrInvalidSubject
+	}
+	if !now.Add(p.Leeway).After(time.Unix(j.p.IssuedAt, 0)) {
+		return ErrNotYetValid // issued in the future
+	} else if j.p.NotBefore != 0 && now.Add(p.Leeway).Before(time.Unix(j.p.NotBefore, 0)) {
+		return ErrNotYetValid
+	} else if !now.Add(-p.Leeway).Before(time.Unix(j.p.ExpirationTime, 0)) {
+		return ErrExpired
+	} else if p.MaxAge != 0 && now.Add(-p.Leeway).Sub(time.Unix(j.p.IssuedAt, 0)) > p.MaxAge {
+		return ErrTooOld
+	}
+	return nil
+}
+
+// hasClaim returns true if the registered claim is set.
+func (j *JWT) hasClaim(claim string) bool {
+	switch claim {
+	case "iss":
+		return j.p.Issuer != ""
+	case "sub":
+		return j.p.Subject != ""
+	case "aud":
+		return len(j.p.Audience) != 0
+	case "exp":
+		return j.p.ExpirationTime != 0
+	case "nbf":
+		return j.p.NotBefore != 0
+	case "iat":
+		return j.p.IssuedAt != 0
+	case "jti":
+		return j.p.JWTID != ""
+	}
+	return false
+}
+
+// hasAudience returns true if the aud claim includes any of the audiences.
+func (j *JWT) hasAudience(audiences []string) bool {
+	for _, aud := range j.p.Audience {
+		for _, accepted := range audiences {
+			if aud == accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
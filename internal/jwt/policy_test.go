@@ -0,0 +1,158 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/conduit/internal/jwt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	// Specification: claim validation
+
+	now := time.Now().Unix()
+	for _, tc := range []struct {
+		name     string
+		policy   jwt.Policy
+		claims   map[string]interface{}
+		expected error
+	}{
+		{"valid", jwt.Policy{}, map[string]interface{}{}, nil},
+		{"expired", jwt.Policy{}, map[string]interface{}{"exp": now - 60}, jwt.ErrExpired},
+		{"expired within leeway", jwt.Policy{Leeway: 30 * time.Second}, map[string]interface{}{"exp": now - 10}, nil},
+		{"missing exp", jwt.Policy{}, map[string]interface{}{"exp": nil}, jwt.ErrMissingClaim},
+		{"missing iat", jwt.Policy{}, map[string]interface{}{"iat": nil}, jwt.ErrMissingClaim},
+		{"issued in the future", jwt.Policy{}, map[string]interface{}{"iat": now + 60}, jwt.ErrNotYetValid},
+		{"issued in the future within leeway", jwt.Policy{Leeway: 30 * time.Second}, map[string]interface{}{"iat": now + 10}, nil},
+		{"nbf in the past", jwt.Policy{}, map[string]interface{}{"nbf": now - 60}, nil},
+		{"nbf in the future", jwt.Policy{}, map[string]interface{}{"nbf": now + 60}, jwt.ErrNotYetValid},
+		{"nbf in the future within leeway", jwt.Policy{Leeway: 30 * time.Second}, map[string]interface{}{"nbf": now + 10}, nil},
+		{"issuer matches", jwt.Policy{Issuer: "conduit"}, map[string]interface{}{"iss": "conduit"}, nil},
+		{"issuer differs", jwt.Policy{Issuer: "conduit"}, map[string]interface{}{"iss": "someone-else"}, jwt.ErrInvalidIssuer},
+		{"issuer missing", jwt.Policy{Issuer: "conduit"}, map[string]interface{}{}, jwt.ErrInvalidIssuer},
+		{"audience accepted", jwt.Policy{Audiences: []string{"api", "gateway"}}, map[string]interface{}{"aud": []string{"other", "gateway"}}, nil},
+		{"audience rejected", jwt.Policy{Audiences: []string{"api"}}, map[string]interface{}{"aud": []string{"other"}}, jwt.ErrInvalidAudience},
+		{"audience missing", jwt.Policy{Audiences: []string{"api"}}, map[string]interface{}{}, jwt.ErrInvalidAudience},
+		{"subject matches id", jwt.Policy{}, map[string]interface{}{"sub": "1"}, nil},
+		{"subject differs from id", jwt.Policy{}, map[string]interface{}{"sub": "2"}, jwt.ErrInvalidSubject},
+		{"required jti present", jwt.Policy{Required: []string{"jti"}}, map[string]interface{}{"jti": "abc"}, nil},
+		{"required jti missing", jwt.Policy{Required: []string{"jti"}}, map[string]interface{}{}, jwt.ErrMissingClaim},
+		{"required sub missing", jwt.Policy{Required: []string{"sub"}}, map[string]interface{}{}, jwt.ErrMissingClaim},
+		{"within max age", jwt.Policy{MaxAge: time.Hour}, map[string]interface{}{"iat": now - 60}, nil},
+		{"older than max age", jwt.Policy{MaxAge: time.Hour}, map[string]interface{}{"iat": now - 7200}, jwt.ErrTooOld},
+	} {
+		f := jwt.NewFactory("secret").WithPolicy(tc.policy)
+		err := f.Validate(bearer(t, craft(t, "secret", tc.claims)))
+		if !errors.Is(err, tc.expected) || (err == nil) != (tc.expected == nil) {
+			t.Errorf("policy: %s: expected %v: got %v\n", tc.name, tc.expected, err)
+		}
+	}
+
+	// a valid claim set with a bad signature is still rejected
+	f := jwt.NewFactory("secret")
+	if err := f.Validate(bearer(t, craft(t, "not the secret", map[string]interface{}{}))); err != jwt.ErrUnauthorized {
+		t.Errorf("policy: bad signature: expected %v: got %v\n", jwt.ErrUnauthorized, err)
+	}
+}
+
+func TestPolicyNewToken(t *testing.T) {
+	// Specification: tokens minted by a factory satisfy its own policy
+
+	policy := jwt.Policy{
+		Issuer:    "conduit",
+		Audiences: []string{"conduit-api", "gateway"},
+		MaxAge:    time.Hour,
+		Required:  []string{"iss", "sub", "aud", "jti"},
+	}
+	f := jwt.NewFactory("secret").WithPolicy(policy)
+	token := f.NewToken(time.Minute, 7, "jake", "jake@jake.jake", "authenticated")
+	if err := f.Validate(bearer(t, token)); err != nil {
+		t.Fatalf("new token: expected nil: got %v\n", err)
+	}
+
+	// And other factories with a different issuer should reject it
+	other := jwt.NewFactory("secret").WithPolicy(jwt.Policy{Issuer: "someone-else"})
+	if err := other.Validate(bearer(t, token)); err != jwt.ErrInvalidIssuer {
+		t.Errorf("new token: other issuer: expected %v: got %v\n", jwt.ErrInvalidIssuer, err)
+	}
+
+	// And every token should get its own jti
+	if a, b := claim(t, token, "jti"), claim(t, f.NewToken(time.Minute, 7, "jake", "jake@jake.jake", "authenticated"), "jti"); a == "" || a == b {
+		t.Errorf("new token: expected unique jti: got %q and %q\n", a, b)
+	}
+	if got := claim(t, token, "sub"); got != "7" {
+		t.Errorf("new token: expected sub %q: got %q\n", "7", got)
+	}
+}
+
+// craft returns an HS256 token with the default claims overridden by claims.
+// A nil value removes the claim.
+func craft(t *testing.T, secret string, claims map[string]interface{}) string {
+	now := time.Now().Unix()
+	payload := map[string]interface{}{
+		"iat":     now - 1,
+		"exp":     now + 60,
+		"private": map[string]interface{}{"alg": "HS256", "typ": "JWT", "id": 1},
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(payload, k)
+			continue
+		}
+		payload[k] = v
+	}
+	h, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		t.Fatalf("craft: %+v\n", err)
+	}
+	p, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("craft: %+v\n", err)
+	}
+	msg := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	sig, err := jwt.HS256Signer([]byte(secret)).Sign([]byte(msg))
+	if err != nil {
+		t.Fatalf("craft: %+v\n", err)
+	}
+	return msg + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// claim returns a string claim from the token payload.
+func claim(t *testing.T, token, name string) string {
+	var payload map[string]interface{}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[1])
+	if err != nil {
+		t.Fatalf("claim: %+v\n", err)
+	} else if err = json.Unmarshal(raw, &payload); err != nil {
+		t.Fatalf("claim: %+v\n", err)
+	}
+	s, _ := payload[name].(string)
+	return s
+}
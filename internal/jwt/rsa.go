@@ -0,0 +1,75 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+)
+
+// RS256 implements a Signer and Verifier using RSASSA-PKCS1-v1_5 and SHA-256.
+// It can only sign if it was created from a private key.
+type RS256 struct {
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+}
+
+func RS256Signer(key *rsa.PrivateKey) *RS256 {
+	return &RS256{private: key, public: &key.PublicKey}
+}
+
+func RS256Verifier(key *rsa.PublicKey) *RS256 {
+	return &RS256{public: key}
+}
+
+// Algorithm implements the Signer and Verifier interfaces
+func (r *RS256) Algorithm() string {
+	return "RS256"
+}
+
+// Public returns the public key.
+func (r *RS256) Public() interface{} {
+	return r.public
+}
+
+// Sign implements the Signer interface
+func (r *RS256) Sign(msg []byte) ([]byte, error) {
+	if r.private == nil {
+		return nil, ErrMissingSigner
+	}
+	digest := sha256.Sum256(msg)
+	return rsa.SignPKCS1v15(rand.Reader, r.private, crypto.SHA256, digest[:])
+}
+
+// Verify implements the Verifier interface
+func (r *RS256) Verify(msg, sig []byte) error {
+	digest := sha256.Sum256(msg)
+	if err := rsa.VerifyPKCS1v15(r.public, crypto.SHA256, digest[:], sig); err != nil {
+		return ErrUnauthorized
+	}
+	return nil
+}
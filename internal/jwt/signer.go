@@ -35,6 +35,15 @@ type Signer interface {
 	Sign(msg []byte) ([]byte, error)
 }
 
+// Verifier interface.
+// Verify returns nil only if sig is a valid signature of msg.
+// Asymmetric verifiers need only the public key, so a service
+// can accept our tokens without being able to mint them.
+type Verifier interface {
+	Algorithm() string
+	Verify(msg, sig []byte) error
+}
+
 // HS256 implements a Signer using HMAC256.
 type HS256 struct {
 	secret []byte
@@ -59,3 +68,14 @@ func (h *HS256) Sign(msg []byte) ([]byte, error) {
 	}
 	return hm.Sum(nil), nil
 }
+
+// Verify implements the Verifier interface
+func (h *HS256) Verify(msg, sig []byte) error {
+	expected, err := h.Sign(msg)
+	if err != nil {
+		return err
+	} else if !hmac.Equal(sig, expected) {
+		return ErrUnauthorized
+	}
+	return nil
+}
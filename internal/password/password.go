@@ -0,0 +1,162 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package password implements salted password hashing.
+//
+// Hashes are encoded in a self-describing format so that the parameters
+// can be upgraded without invalidating existing hashes:
+//
+//	$pbkdf2-sha256$i=600000$<salt>$<hash>
+//
+// where the salt and hash are base-64 encoded without padding.
+// Verify reports when a hash was made with weaker parameters than the
+// Hasher currently uses, so the caller can replace it after a login.
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+// Algorithm is the identifier for the only algorithm we support.
+const Algorithm = "pbkdf2-sha256"
+
+// DefaultIterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256.
+const DefaultIterations = 600000
+
+// Hasher hashes and verifies passwords.
+type Hasher struct {
+	Iterations int    // number of PBKDF2 iterations for new hashes
+	SaltLength int    // number of bytes of random salt for new hashes
+	KeyLength  int    // number of bytes of derived key for new hashes
+	Pepper     []byte // optional server-wide secret mixed into every hash
+}
+
+// NewHasher returns a Hasher with the default parameters.
+// The pepper is a server-wide secret; if it changes, existing hashes
+// will no longer verify.
+func NewHasher(pepper string) *Hasher {
+	return &Hasher{
+		Iterations: DefaultIterations,
+		SaltLength: 16,
+		KeyLength:  32,
+		Pepper:     []byte(pepper),
+	}
+}
+
+// Hash returns the encoded hash of the password using a new random salt.
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2(h.pepper(password), salt, h.Iterations, h.KeyLength)
+	return encodeHash(h.Iterations, salt, key), nil
+}
+
+// Verify returns true if the password matches the encoded hash.
+// If it matches and the hash was created with weaker parameters than
+// the Hasher's, rehash is also true and the caller should store a new hash.
+func (h *Hasher) Verify(password, encoded string) (ok, rehash bool, err error) {
+	iterations, salt, key, err := decodeHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	derived := pbkdf2(h.pepper(password), salt, iterations, len(key))
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return false, false, nil
+	}
+	rehash = iterations < h.Iterations || len(salt) < h.SaltLength || len(key) != h.KeyLength
+	return true, rehash, nil
+}
+
+// pepper mixes the server-wide secret into the password.
+func (h *Hasher) pepper(password string) []byte {
+	if len(h.Pepper) == 0 {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.Pepper)
+	_, _ = mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func encodeHash(iterations int, salt, key []byte) string {
+	return fmt.Sprintf("$%s$i=%d$%s$%s", Algorithm, iterations, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeHash(encoded string) (iterations int, salt, key []byte, err error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 5 || fields[0] != "" {
+		return 0, nil, nil, ErrMalformedHash
+	} else if fields[1] != Algorithm {
+		return 0, nil, nil, fmt.Errorf("%q: %w", fields[1], ErrUnknownAlgorithm)
+	} else if !strings.HasPrefix(fields[2], "i=") {
+		return 0, nil, nil, ErrMalformedHash
+	}
+	if iterations, err = strconv.Atoi(strings.TrimPrefix(fields[2], "i=")); err != nil || iterations < 1 {
+		return 0, nil, nil, ErrMalformedHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(fields[3]); err != nil || len(salt) == 0 {
+		return 0, nil, nil, ErrMalformedHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(fields[4]); err != nil || len(key) == 0 {
+		return 0, nil, nil, ErrMalformedHash
+	}
+	return iterations, salt, key, nil
+}
+
+// pbkdf2 implements PBKDF2 (RFC 8018) using HMAC-SHA256 as the pseudorandom function.
+func pbkdf2(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var dk []byte
+	u := make([]byte, 0, prf.Size())
+	t := make([]byte, prf.Size())
+	for block := uint32(1); len(dk) < keyLength; block++ {
+		prf.Reset()
+		_, _ = prf.Write(salt)
+		_, _ = prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			_, _ = prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLength]
+}
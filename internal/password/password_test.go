@@ -0,0 +1,124 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package password
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// test vectors from RFC 7914, section 11
+	for _, tc := range []struct {
+		password, salt string
+		iterations     int
+		expect         string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		got := hex.EncodeToString(pbkdf2([]byte(tc.password), []byte(tc.salt), tc.iterations, len(tc.expect)/2))
+		if got != tc.expect {
+			t.Errorf("pbkdf2: %q %q %d: expected %s: got %s\n", tc.password, tc.salt, tc.iterations, tc.expect, got)
+		}
+	}
+}
+
+func TestHasher(t *testing.T) {
+	// Specification: Password API
+
+	// Given a hasher
+	// When we hash a password twice
+	// Then the hashes should be self-describing and different (salted)
+	// And they should not contain the password
+	// And both should verify without needing a rehash
+	h := &Hasher{Iterations: 1000, SaltLength: 16, KeyLength: 32, Pepper: []byte("pepper")}
+	first, err := h.Hash("jakejake")
+	if err != nil {
+		t.Fatalf("hash: expected no error: got %v\n", err)
+	}
+	second, err := h.Hash("jakejake")
+	if err != nil {
+		t.Fatalf("hash: expected no error: got %v\n", err)
+	}
+	if !strings.HasPrefix(first, "$pbkdf2-sha256$i=1000$") {
+		t.Errorf("hash: expected self-describing prefix: got %q\n", first)
+	}
+	if first == second {
+		t.Errorf("hash: expected different salts: got %q twice\n", first)
+	}
+	if strings.Contains(first, "jakejake") {
+		t.Errorf("hash: expected no cleartext: got %q\n", first)
+	}
+	for _, encoded := range []string{first, second} {
+		if ok, rehash, err := h.Verify("jakejake", encoded); err != nil || !ok || rehash {
+			t.Errorf("verify: expected ok without rehash: got %v %v %v\n", ok, rehash, err)
+		}
+	}
+
+	// When we verify the wrong password, or with the wrong pepper
+	// Then verification should fail without an error
+	if ok, _, err := h.Verify("fakefake", first); err != nil || ok {
+		t.Errorf("verify: wrong password: expected not ok: got %v %v\n", ok, err)
+	}
+	other := &Hasher{Iterations: 1000, SaltLength: 16, KeyLength: 32, Pepper: []byte("salt")}
+	if ok, _, err := other.Verify("jakejake", first); err != nil || ok {
+		t.Errorf("verify: wrong pepper: expected not ok: got %v %v\n", ok, err)
+	}
+
+	// When the hasher's parameters are upgraded
+	// Then old hashes should still verify but ask for a rehash
+	// And the new hash should not ask for a rehash
+	upgraded := &Hasher{Iterations: 2000, SaltLength: 16, KeyLength: 32, Pepper: []byte("pepper")}
+	if ok, rehash, err := upgraded.Verify("jakejake", first); err != nil || !ok || !rehash {
+		t.Errorf("verify: upgraded: expected ok with rehash: got %v %v %v\n", ok, rehash, err)
+	}
+	if encoded, err := upgraded.Hash("jakejake"); err != nil {
+		t.Errorf("hash: upgraded: expected no error: got %v\n", err)
+	} else if ok, rehash, err := upgraded.Verify("jakejake", encoded); err != nil || !ok || rehash {
+		t.Errorf("verify: rehashed: expected ok without rehash: got %v %v %v\n", ok, rehash, err)
+	}
+
+	// When we verify malformed hashes
+	// Then we should get an error
+	for _, tc := range []struct {
+		encoded string
+		target  error
+	}{
+		{"", ErrMalformedHash},
+		{"jakejake", ErrMalformedHash},
+		{"$bcrypt$i=1000$c2FsdA$a2V5", ErrUnknownAlgorithm},
+		{"$pbkdf2-sha256$1000$c2FsdA$a2V5", ErrMalformedHash},
+		{"$pbkdf2-sha256$i=0$c2FsdA$a2V5", ErrMalformedHash},
+		{"$pbkdf2-sha256$i=1000$$a2V5", ErrMalformedHash},
+		{"$pbkdf2-sha256$i=1000$c2FsdA$!!!", ErrMalformedHash},
+	} {
+		if ok, _, err := h.Verify("jakejake", tc.encoded); ok || !errors.Is(err, tc.target) {
+			t.Errorf("verify: %q: expected error %v: got %v %v\n", tc.encoded, tc.target, ok, err)
+		}
+	}
+}
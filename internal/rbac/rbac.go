@@ -0,0 +1,93 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package rbac decides what a user may do based on their roles.
+//
+// Every signed-in user has the Authenticated role. Other roles are
+// granted by an admin and stored on the user. A Policy maps each role
+// to the permissions it grants; servers check permissions, never role
+// names, so adding a role only means changing the policy.
+package rbac
+
+import "sort"
+
+// Permission is something a user may be allowed to do.
+type Permission string
+
+const (
+	ManageAccount    Permission = "account:manage"    // view and update your own account, read your feed, log out
+	FollowUsers      Permission = "profiles:follow"   // follow and unfollow other users
+	WriteArticles    Permission = "articles:write"    // create, update, delete and favorite articles (your own, for updates and deletes)
+	WriteComments    Permission = "comments:write"    // add comments and delete your own
+	ModerateComments Permission = "comments:moderate" // delete anyone's comments
+	ManageUsers      Permission = "users:manage"      // view, suspend and delete users, revoke their sessions, grant roles
+)
+
+// Roles known to the default policy.
+const (
+	Admin         = "admin"
+	Authenticated = "authenticated"
+	Moderator     = "moderator"
+)
+
+// Policy maps a role to the permissions that it grants.
+type Policy map[string][]Permission
+
+// DefaultPolicy returns the policy used when a server isn't given one.
+func DefaultPolicy() Policy {
+	return Policy{
+		Admin:         {ManageUsers, ModerateComments},
+		Authenticated: {ManageAccount, FollowUsers, WriteArticles, WriteComments},
+		Moderator:     {ModerateComments},
+	}
+}
+
+// Can returns true if any of the roles grants the permission.
+// Roles that aren't in the policy grant nothing.
+func (p Policy) Can(roles []string, perm Permission) bool {
+	for _, role := range roles {
+		for _, granted := range p[role] {
+			if granted == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRole returns true if the role is defined by the policy.
+func (p Policy) HasRole(role string) bool {
+	_, ok := p[role]
+	return ok
+}
+
+// Roles returns the roles defined by the policy, sorted by name.
+func (p Policy) Roles() []string {
+	var roles []string
+	for role := range p {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,67 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rbac_test
+
+import (
+	"github.com/mdhender/conduit/internal/rbac"
+	"testing"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	p := rbac.DefaultPolicy()
+	for _, tc := range []struct {
+		id    int
+		roles []string
+		perm  rbac.Permission
+		can   bool
+	}{
+		{1, nil, rbac.ManageAccount, false},
+		{2, []string{"guest"}, rbac.ManageAccount, false},
+		{3, []string{rbac.Authenticated}, rbac.ManageAccount, true},
+		{4, []string{rbac.Authenticated}, rbac.WriteComments, true},
+		{5, []string{rbac.Authenticated}, rbac.ModerateComments, false},
+		{6, []string{rbac.Authenticated}, rbac.ManageUsers, false},
+		{7, []string{rbac.Authenticated, rbac.Moderator}, rbac.ModerateComments, true},
+		{8, []string{rbac.Authenticated, rbac.Moderator}, rbac.ManageUsers, false},
+		{9, []string{rbac.Moderator}, rbac.WriteComments, false},
+		{10, []string{rbac.Admin}, rbac.ManageUsers, true},
+		{11, []string{rbac.Admin}, rbac.ModerateComments, true},
+		{12, []string{rbac.Admin}, rbac.WriteArticles, false},
+		{13, []string{rbac.Authenticated, rbac.Admin}, rbac.WriteArticles, true},
+	} {
+		if got := p.Can(tc.roles, tc.perm); got != tc.can {
+			t.Errorf("policy: %d: %v %s: expected %v: got %v\n", tc.id, tc.roles, tc.perm, tc.can, got)
+		}
+	}
+
+	if !p.HasRole(rbac.Moderator) {
+		t.Errorf("policy: expected role %q\n", rbac.Moderator)
+	} else if p.HasRole("guest") {
+		t.Errorf("policy: unexpected role %q\n", "guest")
+	}
+	if got := p.Roles(); len(got) != 3 || got[0] != rbac.Admin || got[1] != rbac.Authenticated || got[2] != rbac.Moderator {
+		t.Errorf("policy: roles: got %v\n", got)
+	}
+}
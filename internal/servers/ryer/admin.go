@@ -0,0 +1,283 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/rbac"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"github.com/mdhender/conduit/internal/way"
+	"log"
+	"net/http"
+)
+
+// Returns the roles that can be granted and the number of users.
+func (s *Server) handleAdminIndex() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.debug {
+			log.Printf("adminIndex(%s)\n", r.URL.Path)
+		}
+		_, count, err := s.DB.ListUsers(model.UserFilter{})
+		if err != nil {
+			log.Printf("adminIndex: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		result := conduit.AdminIndexResponse{Roles: []string{}, UsersCount: count}
+		for _, role := range s.policy().Roles() {
+			if role != rbac.Authenticated {
+				result.Roles = append(result.Roles, role)
+			}
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("adminIndex: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// Returns the users, oldest first, with the limit and offset query parameters
+// for paging. The search query parameter matches usernames and e-mails.
+func (s *Server) handleAdminListUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, errs := pageParams(r)
+		if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "adminListUsers")
+			return
+		}
+		users, count, err := s.DB.ListUsers(model.UserFilter{
+			Search: r.URL.Query().Get("search"),
+			Limit:  limit,
+			Offset: offset,
+		})
+		if err != nil {
+			log.Printf("adminListUsers: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		result := conduit.AdminUsersResponse{Users: []conduit.AdminUser{}, UsersCount: count}
+		for _, u := range users {
+			result.Users = append(result.Users, asAdminUser(u))
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("adminListUsers: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+func (s *Server) handleAdminGetUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if u := s.adminTarget(w, r, "adminGetUser"); u != nil {
+			writeAdminUser(w, u, "adminGetUser")
+		}
+	}
+}
+
+// Deletes the user along with their articles, comments, favorites and follows.
+// Admins can't delete their own account.
+func (s *Server) handleAdminDeleteUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := s.adminTarget(w, r, "adminDeleteUser")
+		if u == nil {
+			return
+		} else if cu := s.currentUser(r).User; cu != nil && cu.Id == u.Id {
+			writeErrors(w, http.StatusUnprocessableEntity, map[string][]string{"username": {"can't be your own account"}}, "adminDeleteUser")
+			return
+		}
+		if err := s.DB.DeleteUser(u.Id); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("adminDeleteUser: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Suspends or restores the user. Suspended users can't log in,
+// refresh their sessions, or use the tokens they already have.
+// Admins can't suspend their own account.
+func (s *Server) handleAdminSuspendUser(suspended bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := s.adminTarget(w, r, "adminSuspendUser")
+		if u == nil {
+			return
+		} else if cu := s.currentUser(r).User; suspended && cu != nil && cu.Id == u.Id {
+			writeErrors(w, http.StatusUnprocessableEntity, map[string][]string{"username": {"can't be your own account"}}, "adminSuspendUser")
+			return
+		}
+		u, err := s.DB.SuspendUser(u.Id, suspended)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("adminSuspendUser: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeAdminUser(w, u, "adminSuspendUser")
+	}
+}
+
+// Revokes the user's sessions and stops them from logging in
+// until they change their password at POST /api/users/password.
+func (s *Server) handleAdminResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := s.adminTarget(w, r, "adminResetPassword")
+		if u == nil {
+			return
+		}
+		u, err := s.DB.RequirePasswordReset(u.Id)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("adminResetPassword: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeAdminUser(w, u, "adminResetPassword")
+	}
+}
+
+// Grants or revokes the role named in the path. The role must be in the
+// server's policy. Admins can't revoke a role if that would stop them
+// from managing users.
+func (s *Server) handleAdminSetRole(grant bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := s.adminTarget(w, r, "adminSetRole")
+		if u == nil {
+			return
+		}
+		role := way.Param(r.Context(), "role")
+		if role == rbac.Authenticated || !s.policy().HasRole(role) {
+			writeErrors(w, http.StatusUnprocessableEntity, map[string][]string{"role": {"is not a role that can be granted"}}, "adminSetRole")
+			return
+		}
+
+		var err error
+		if grant {
+			u, err = s.DB.GrantRole(u.Id, role)
+		} else {
+			if cu := s.currentUser(r).User; cu != nil && cu.Id == u.Id {
+				remaining := []string{rbac.Authenticated}
+				for _, other := range u.Roles {
+					if other != role {
+						remaining = append(remaining, other)
+					}
+				}
+				if !s.policy().Can(remaining, rbac.ManageUsers) {
+					writeErrors(w, http.StatusUnprocessableEntity, map[string][]string{"role": {"can't remove your own access to users"}}, "adminSetRole")
+					return
+				}
+			}
+			u, err = s.DB.RevokeRole(u.Id, role)
+		}
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Printf("adminSetRole: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeAdminUser(w, u, "adminSetRole")
+	}
+}
+
+// adminTarget returns the user named in the request path.
+// If there is no such user, it writes the response and returns nil.
+func (s *Server) adminTarget(w http.ResponseWriter, r *http.Request, what string) *model.User {
+	p, err := s.DB.GetProfileByUsername(0, way.Param(r.Context(), "username"))
+	if err == nil {
+		var u *model.User
+		if u, err = s.DB.GetUser(p.Id); err == nil {
+			return u
+		}
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return nil
+	}
+	log.Printf("%s: %+v\n", what, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	return nil
+}
+
+func asAdminUser(u *model.User) conduit.AdminUser {
+	au := conduit.AdminUser{
+		Id:                    u.Id,
+		Username:              u.Username,
+		Email:                 u.Email,
+		Bio:                   u.Bio,
+		Image:                 u.Image,
+		CreatedAt:             u.CreatedAt,
+		UpdatedAt:             u.UpdatedAt,
+		Roles:                 u.Roles,
+		Following:             u.Following,
+		Suspended:             u.Suspended,
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+	if au.Roles == nil {
+		au.Roles = []string{}
+	}
+	if au.Following == nil {
+		au.Following = []string{}
+	}
+	return au
+}
+
+// writeAdminUser writes the user as an AdminUserResponse.
+func writeAdminUser(w http.ResponseWriter, u *model.User, what string) {
+	data, err := json.Marshal(conduit.AdminUserResponse{User: asAdminUser(u)})
+	if err != nil {
+		log.Printf("%s: %+v\n", what, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
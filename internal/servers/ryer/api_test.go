@@ -27,22 +27,56 @@ package ryer
 import (
 	"github.com/mdhender/conduit/internal/jwt"
 	"github.com/mdhender/conduit/internal/store/memory"
+	"github.com/mdhender/conduit/internal/store/storetest"
 	"github.com/mdhender/conduit/internal/tests"
 	"github.com/mdhender/conduit/internal/way"
+	"net/http"
+	"strings"
 	"testing"
 )
 
 func TestApi(t *testing.T) {
 	testServer := func(secret string) tests.Server {
-		srv := &Server{
-			DtFmt:        "2006-01-02T15:04:05.99999999Z",
-			Router:       way.NewRouter(),
-			TokenFactory: jwt.NewFactory(secret),
-		}
-		srv.DB, _ = memory.New()
-		srv.Handler = srv.Router
-		srv.Routes()
-		return srv
+		return newTestServer(secret, Cookies{})
 	}
 	tests.Suite(testServer, t)
 }
+
+// TestApiCookies runs the suite with cookie sessions enabled.
+// The client moves bearer tokens into the session cookie so that
+// every authenticated request goes through the cookie path.
+func TestApiCookies(t *testing.T) {
+	testServer := func(secret string) tests.Server {
+		return cookieClient{newTestServer(secret, Cookies{Enabled: true, HttpOnly: true})}
+	}
+	tests.Suite(testServer, t)
+}
+
+func newTestServer(secret string, cookies Cookies) *Server {
+	srv := &Server{
+		Cookies:      cookies,
+		DtFmt:        "2006-01-02T15:04:05.99999999Z",
+		Router:       way.NewRouter(),
+		TokenFactory: jwt.NewFactory(secret),
+	}
+	// keep the hashing cost low so the suite stays fast
+	srv.DB, _ = memory.New(memory.WithPasswordHasher(storetest.Hasher))
+	srv.Handler = srv.Router
+	srv.Routes()
+	return srv
+}
+
+// cookieClient sends bearer tokens as a session cookie with a matching CSRF token.
+type cookieClient struct {
+	*Server
+}
+
+func (c cookieClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		r.Header.Del("Authorization")
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: strings.TrimPrefix(auth, "Bearer ")})
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "csrf"})
+		r.Header.Set(csrfHeader, "csrf")
+	}
+	c.Server.ServeHTTP(w, r)
+}
@@ -0,0 +1,352 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/jsonapi"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"github.com/mdhender/conduit/internal/way"
+	"log"
+	"net/http"
+)
+
+// post body should contain an ArticleCreateRequest which wraps an Article
+// Returns an ArticleResponse which wraps an Article
+func (s *Server) handleCreateArticle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cu := s.currentUser(r).User
+		if cu == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		var req conduit.ArticleCreateRequest
+		err := jsonapi.Data(w, r, s.rejectUnknownFields, &req)
+		if err != nil {
+			if s.debug {
+				log.Printf("createArticle: %+v\n", err)
+			}
+			if errors.Is(err, jsonapi.ErrBadRequest) {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			} else if errors.Is(err, jsonapi.ErrRequestEntityTooLarge) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			} else if errors.Is(err, jsonapi.ErrUnsupportedMediaType) {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		a, errs, err := s.DB.CreateArticle(cu.Id, req.Article.Title, req.Article.Description, req.Article.Body, req.Article.TagList)
+		if err != nil {
+			if s.debug {
+				log.Printf("createArticle: %+v\n", err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "createArticle")
+			return
+		}
+		data, err := json.Marshal(conduit.ArticleResponse{Article: asArticle(a)})
+		if err != nil {
+			if s.debug {
+				log.Printf("createArticle: %+v\n", err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(data)
+	}
+}
+
+// Returns 412 if the article was changed since the client read it
+// (that is, if the If-Match header doesn't match).
+func (s *Server) handleDeleteArticle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userId int
+		if cu := s.currentUser(r).User; cu != nil {
+			userId = cu.Id
+		}
+
+		slug := way.Param(r.Context(), "slug")
+		err := s.conditional(r, func(db store.Store) (string, error) {
+			a, err := db.GetArticleBySlug(userId, slug)
+			if err != nil {
+				return "", err
+			}
+			return articleETag(a), nil
+		}, func(db store.Store) error {
+			return db.DeleteArticle(userId, slug)
+		})
+		if err != nil {
+			if errors.Is(err, errPreconditionFailed) {
+				http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			} else if errors.Is(err, store.ErrNotAuthorized) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			} else if errors.Is(err, store.ErrForbidden) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			} else if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+			} else {
+				log.Printf("deleteArticle: %+v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleFavoriteArticle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userId int
+		if cu := s.currentUser(r).User; cu != nil {
+			userId = cu.Id
+		}
+
+		slug := way.Param(r.Context(), "slug")
+		a, err := s.DB.FavoriteArticle(userId, slug)
+		if err != nil {
+			if errors.Is(err, store.ErrNotAuthorized) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			} else if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+			} else {
+				log.Printf("favoriteArticle: %+v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+		data, err := json.Marshal(conduit.ArticleResponse{Article: asArticle(a)})
+		if err != nil {
+			log.Printf("favoriteArticle: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// Returns 304 if the client's copy matches the If-None-Match header.
+func (s *Server) handleGetArticle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// client doesn't have to be authenticated, but if she is,
+		// we will fetch the favorited and following flags for her.
+		var userId int
+		if cu := s.currentUser(r); errors.Is(cu.TokenError, errStore) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if cu.User != nil {
+			userId = cu.User.Id
+		}
+
+		slug := way.Param(r.Context(), "slug")
+		a, err := s.DB.GetArticleBySlug(userId, slug)
+		if errors.Is(err, store.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Printf("getArticle: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		etag := articleETag(a)
+		setETag(w, etag)
+		if notModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		data, err := json.Marshal(conduit.ArticleResponse{Article: asArticle(a)})
+		if err != nil {
+			log.Printf("getArticle: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+func (s *Server) handleUnfavoriteArticle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userId int
+		if cu := s.currentUser(r).User; cu != nil {
+			userId = cu.Id
+		}
+
+		slug := way.Param(r.Context(), "slug")
+		a, err := s.DB.UnfavoriteArticle(userId, slug)
+		if err != nil {
+			if errors.Is(err, store.ErrNotAuthorized) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			} else if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+			} else {
+				log.Printf("unfavoriteArticle: %+v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+		data, err := json.Marshal(conduit.ArticleResponse{Article: asArticle(a)})
+		if err != nil {
+			log.Printf("unfavoriteArticle: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// put body should contain an ArticleUpdateRequest which wraps an Article
+// Returns an ArticleResponse which wraps an Article
+//
+// Returns 404 if the article doesn't exist, even for clients that aren't
+// allowed to update it, and 403 if the client isn't the article's author.
+// Returns 412 if the article was changed since the client read it
+// (that is, if the If-Match header doesn't match).
+func (s *Server) handleUpdateArticle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cu := s.currentUser(r).User
+		if cu == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		slug := way.Param(r.Context(), "slug")
+
+		var req conduit.ArticleUpdateRequest
+		err := jsonapi.Data(w, r, s.rejectUnknownFields, &req)
+		if err != nil {
+			if s.debug {
+				log.Printf("updateArticle: %+v\n", err)
+			}
+			if errors.Is(err, jsonapi.ErrBadRequest) {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			} else if errors.Is(err, jsonapi.ErrRequestEntityTooLarge) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			} else if errors.Is(err, jsonapi.ErrUnsupportedMediaType) {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		var a *model.Article
+		var errs map[string][]string
+		err = s.conditional(r, func(db store.Store) (string, error) {
+			current, err := db.GetArticleBySlug(cu.Id, slug)
+			if err != nil {
+				return "", err
+			}
+			return articleETag(current), nil
+		}, func(db store.Store) (err error) {
+			a, errs, err = db.UpdateArticle(cu.Id, slug, req.Article.Title, req.Article.Description, req.Article.Body, req.Article.TagList)
+			return err
+		})
+		if errors.Is(err, errPreconditionFailed) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		} else if errors.Is(err, store.ErrNotAuthorized) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if errors.Is(err, store.ErrForbidden) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		} else if errors.Is(err, store.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			if s.debug {
+				log.Printf("updateArticle: %+v\n", err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "updateArticle")
+			return
+		}
+		data, err := json.Marshal(conduit.ArticleResponse{Article: asArticle(a)})
+		if err != nil {
+			if s.debug {
+				log.Printf("updateArticle: %+v\n", err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", contentType)
+		setETag(w, articleETag(a))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// asArticle converts a model Article to the type exposed to the client.
+func asArticle(a *model.Article) conduit.Article {
+	return conduit.Article{
+		Slug:           a.Slug,
+		Title:          a.Title,
+		Description:    a.Description,
+		Body:           a.Body,
+		TagList:        append([]string{}, a.TagList...), // API requires a list, not null
+		CreatedAt:      a.CreatedAt,
+		UpdatedAt:      a.UpdatedAt,
+		Favorited:      a.Favorited,
+		FavoritesCount: a.FavoritesCount,
+		Author:         asAuthor(a.Author),
+	}
+}
+
+// asAuthor converts a model Profile to the author exposed to the client.
+func asAuthor(p model.Profile) conduit.Author {
+	author := conduit.Author{
+		Username:  p.Username,
+		Following: p.Following,
+	}
+	if p.Bio != nil {
+		author.Bio = *p.Bio
+	}
+	if p.Image != nil {
+		author.Image = *p.Image
+	}
+	return author
+}
@@ -0,0 +1,191 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/jsonapi"
+	"github.com/mdhender/conduit/internal/rbac"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"github.com/mdhender/conduit/internal/way"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// post body should contain a CommentAddRequest which wraps a Comment
+// Returns a CommentResponse which wraps a Comment
+func (s *Server) handleAddComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cu := s.currentUser(r).User
+		if cu == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		slug := way.Param(r.Context(), "slug")
+		if _, err := s.DB.GetArticleBySlug(cu.Id, slug); errors.Is(err, store.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Printf("addComment: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		var req conduit.CommentAddRequest
+		err := jsonapi.Data(w, r, s.rejectUnknownFields, &req)
+		if err != nil {
+			if s.debug {
+				log.Printf("addComment: %+v\n", err)
+			}
+			if errors.Is(err, jsonapi.ErrBadRequest) {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			} else if errors.Is(err, jsonapi.ErrRequestEntityTooLarge) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			} else if errors.Is(err, jsonapi.ErrUnsupportedMediaType) {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		c, errs, err := s.DB.AddComment(cu.Id, slug, req.Comment.Body)
+		if err != nil {
+			if s.debug {
+				log.Printf("addComment: %+v\n", err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "addComment")
+			return
+		}
+		data, err := json.Marshal(conduit.CommentResponse{Comment: asComment(c)})
+		if err != nil {
+			if s.debug {
+				log.Printf("addComment: %+v\n", err)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// Authors may delete their own comments.
+// Users who can moderate comments may delete anyone's.
+func (s *Server) handleDeleteComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cu := s.currentUser(r)
+		var userId int
+		if cu.User != nil {
+			userId = cu.User.Id
+		}
+
+		slug := way.Param(r.Context(), "slug")
+		id, err := strconv.Atoi(way.Param(r.Context(), "id"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if s.policy().Can(cu.Roles, rbac.ModerateComments) {
+			err = s.DB.RemoveComment(slug, id)
+		} else {
+			err = s.DB.DeleteComment(userId, slug, id)
+		}
+		if err != nil {
+			if errors.Is(err, store.ErrNotAuthorized) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			} else if errors.Is(err, store.ErrForbidden) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			} else if errors.Is(err, store.ErrNotFound) {
+				http.NotFound(w, r)
+			} else {
+				log.Printf("deleteComment: %+v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) handleGetComments() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// client doesn't have to be authenticated, but if she is,
+		// we will fetch the following flag for her.
+		var userId int
+		if cu := s.currentUser(r); errors.Is(cu.TokenError, errStore) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if cu.User != nil {
+			userId = cu.User.Id
+		}
+
+		slug := way.Param(r.Context(), "slug")
+		comments, err := s.DB.GetComments(userId, slug)
+		if errors.Is(err, store.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Printf("getComments: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		result := conduit.CommentsResponse{Comments: []conduit.Comment{}}
+		for _, c := range comments {
+			result.Comments = append(result.Comments, asComment(c))
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("getComments: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// asComment converts a model Comment to the type exposed to the client.
+func asComment(c *model.Comment) conduit.Comment {
+	return conduit.Comment{
+		Id:        c.Id,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Body:      c.Body,
+		Author:    asAuthor(c.Author),
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// names of the cookies and header used by cookie sessions
+const (
+	sessionCookie = "conduit_session"
+	refreshCookie = "conduit_refresh"
+	csrfCookie    = "conduit_csrf"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// refreshCookiePath limits the refresh cookie to the refresh and logout endpoints.
+const refreshCookiePath = "/api/users/"
+
+// errCSRF is returned when a request authenticated by a session cookie
+// changes state without echoing the CSRF cookie in the CSRF header.
+var errCSRF = errors.New("missing or invalid csrf token")
+
+// Cookies configures cookie-based sessions.
+// When enabled, login and registration set a session cookie holding the
+// access token, a refresh cookie, and a CSRF cookie. The session cookie is
+// accepted in place of a bearer token, but requests that change state must
+// copy the CSRF cookie into the X-CSRF-Token header (the "double submit"
+// pattern). Bearer tokens work the same in both modes.
+type Cookies struct {
+	Enabled  bool          // set and accept session cookies
+	HttpOnly bool          // hide the session and refresh cookies from scripts
+	Secure   bool          // only send the cookies over https
+	SameSite http.SameSite // Lax if zero
+}
+
+// setSessionCookies sets the cookies for a new or refreshed session.
+// It does nothing unless cookie sessions are enabled.
+func (s *Server) setSessionCookies(w http.ResponseWriter, accessToken, refreshToken string) error {
+	if !s.Cookies.Enabled {
+		return nil
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	http.SetCookie(w, s.newCookie(sessionCookie, accessToken, "/", s.accessTokenTTL(), s.Cookies.HttpOnly))
+	http.SetCookie(w, s.newCookie(refreshCookie, refreshToken, refreshCookiePath, s.refreshTokenTTL(), s.Cookies.HttpOnly))
+	// the client must be able to read the csrf cookie to echo it back
+	http.SetCookie(w, s.newCookie(csrfCookie, base64.RawURLEncoding.EncodeToString(b), "/", s.refreshTokenTTL(), false))
+	return nil
+}
+
+// clearSessionCookies tells the client to delete the session cookies.
+func (s *Server) clearSessionCookies(w http.ResponseWriter) {
+	if !s.Cookies.Enabled {
+		return
+	}
+	http.SetCookie(w, s.newCookie(sessionCookie, "", "/", -1, s.Cookies.HttpOnly))
+	http.SetCookie(w, s.newCookie(refreshCookie, "", refreshCookiePath, -1, s.Cookies.HttpOnly))
+	http.SetCookie(w, s.newCookie(csrfCookie, "", "/", -1, false))
+}
+
+// newCookie returns a cookie with the server's security settings.
+// A negative ttl deletes the cookie.
+func (s *Server) newCookie(name, value, path string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: httpOnly,
+		Secure:   s.Cookies.Secure,
+		SameSite: s.Cookies.SameSite,
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	if ttl < 0 {
+		c.MaxAge = -1
+	} else {
+		c.MaxAge = int(ttl / time.Second)
+		c.Expires = time.Now().Add(ttl).UTC()
+	}
+	return c
+}
+
+// sessionCookieValue returns the value of a session cookie.
+// It returns an empty string if cookie sessions are disabled or the cookie
+// is missing, and errCSRF if the request changes state without a valid
+// CSRF header.
+func (s *Server) sessionCookieValue(r *http.Request, name string) (string, error) {
+	if !s.Cookies.Enabled {
+		return "", nil
+	}
+	c, err := r.Cookie(name)
+	if err != nil || c.Value == "" {
+		return "", nil
+	}
+	if !isSafeMethod(r.Method) && !validCSRF(r) {
+		return "", errCSRF
+	}
+	return c.Value, nil
+}
+
+// validCSRF returns true if the CSRF header matches the CSRF cookie.
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.Header.Get(csrfHeader))) == 1
+}
+
+// isSafeMethod returns true for methods that must not change state (see RFC 7231, section 4.2.1).
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
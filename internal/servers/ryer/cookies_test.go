@@ -0,0 +1,186 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCookieSessions(t *testing.T) {
+	srv := newTestServer("cookies", Cookies{Enabled: true, HttpOnly: true, Secure: true, SameSite: http.SameSiteStrictMode})
+
+	serve := func(method, target string, body interface{}, cookies []*http.Cookie, csrf string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r := httptest.NewRequest(method, target, &buf)
+		if body != nil {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		if csrf != "" {
+			r.Header.Set(csrfHeader, csrf)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	jar := func(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+		cookies := make(map[string]*http.Cookie)
+		for _, c := range w.Result().Cookies() {
+			cookies[c.Name] = c
+		}
+		return cookies
+	}
+
+	w := serve("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("register: expected %d: got %d\n", http.StatusOK, w.Code)
+	}
+	w = serve("POST", "/api/users/login", conduit.LoginUserRequest{User: conduit.LoginUser{Email: "jake@jake.jake", Password: "jakejake"}}, nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: expected %d: got %d\n", http.StatusOK, w.Code)
+	}
+	cookies := jar(w)
+	for _, tc := range []struct {
+		name     string
+		path     string
+		httpOnly bool
+	}{
+		{sessionCookie, "/", true},
+		{refreshCookie, refreshCookiePath, true},
+		{csrfCookie, "/", false},
+	} {
+		c, ok := cookies[tc.name]
+		if !ok {
+			t.Fatalf("login: expected cookie %q\n", tc.name)
+		} else if c.Value == "" {
+			t.Errorf("login: %s: expected value\n", tc.name)
+		} else if c.Path != tc.path {
+			t.Errorf("login: %s: expected path %q: got %q\n", tc.name, tc.path, c.Path)
+		} else if c.HttpOnly != tc.httpOnly {
+			t.Errorf("login: %s: expected HttpOnly %v: got %v\n", tc.name, tc.httpOnly, c.HttpOnly)
+		} else if !c.Secure {
+			t.Errorf("login: %s: expected Secure\n", tc.name)
+		} else if c.SameSite != http.SameSiteStrictMode {
+			t.Errorf("login: %s: expected SameSite %v: got %v\n", tc.name, http.SameSiteStrictMode, c.SameSite)
+		}
+	}
+	session := []*http.Cookie{cookies[sessionCookie], cookies[csrfCookie]}
+	csrf := cookies[csrfCookie].Value
+
+	// safe methods don't need the csrf header
+	if w = serve("GET", "/api/user", nil, session, ""); w.Code != http.StatusOK {
+		t.Errorf("get user: expected %d: got %d\n", http.StatusOK, w.Code)
+	}
+
+	// unsafe methods do
+	bio := "I work at statefarm"
+	update := conduit.UpdateUserRequest{User: conduit.UpdateUser{Bio: &bio}}
+	for _, tc := range []struct {
+		csrf string
+		code int
+	}{
+		{"", http.StatusForbidden},
+		{"not-the-cookie", http.StatusForbidden},
+		{csrf, http.StatusOK},
+	} {
+		if w = serve("PUT", "/api/user", update, session, tc.csrf); w.Code != tc.code {
+			t.Errorf("update user: csrf %q: expected %d: got %d\n", tc.csrf, tc.code, w.Code)
+		}
+	}
+
+	// the refresh cookie is used when the body is empty
+	refresh := []*http.Cookie{cookies[refreshCookie], cookies[csrfCookie]}
+	if w = serve("POST", "/api/users/refresh", nil, refresh, ""); w.Code != http.StatusForbidden {
+		t.Errorf("refresh: no csrf: expected %d: got %d\n", http.StatusForbidden, w.Code)
+	}
+	if w = serve("POST", "/api/users/refresh", nil, refresh, csrf); w.Code != http.StatusOK {
+		t.Fatalf("refresh: expected %d: got %d\n", http.StatusOK, w.Code)
+	}
+	cookies = jar(w)
+	if c, ok := cookies[refreshCookie]; !ok || c.Value == refresh[0].Value {
+		t.Errorf("refresh: expected new refresh cookie\n")
+	}
+	session = []*http.Cookie{cookies[sessionCookie], cookies[refreshCookie], cookies[csrfCookie]}
+	csrf = cookies[csrfCookie].Value
+
+	// logout revokes the session and clears the cookies
+	if w = serve("POST", "/api/users/logout", nil, session, csrf); w.Code != http.StatusNoContent {
+		t.Fatalf("logout: expected %d: got %d\n", http.StatusNoContent, w.Code)
+	}
+	for name, c := range jar(w) {
+		if c.MaxAge >= 0 {
+			t.Errorf("logout: %s: expected cookie to be deleted\n", name)
+		}
+	}
+	if w = serve("GET", "/api/user", nil, session, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("logout: session: expected %d: got %d\n", http.StatusUnauthorized, w.Code)
+	}
+	if w = serve("POST", "/api/users/refresh", nil, session, csrf); w.Code != http.StatusUnauthorized {
+		t.Errorf("logout: refresh: expected %d: got %d\n", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestCookieSessionsDisabled(t *testing.T) {
+	srv := newTestServer("cookies", Cookies{})
+
+	body, err := json.Marshal(conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/api/users", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("register: expected %d: got %d\n", http.StatusOK, w.Code)
+	} else if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("register: expected no cookies: got %d\n", len(cookies))
+	}
+	var resp conduit.UserResponse
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// a session cookie is ignored
+	r = httptest.NewRequest("GET", "/api/user", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: resp.User.Token})
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("get user: expected %d: got %d\n", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"net/http"
+	"strings"
+)
+
+// Entity tags let clients detect that a resource changed since they read it
+// (see RFC 7232). A client sends the tag back in an If-Match header when it
+// updates the resource and gets a 412 if someone else changed it first, or
+// in an If-None-Match header when it reads the resource again and gets a 304
+// if nothing changed.
+//
+// Tags are built from the versions kept by the store. Some representations
+// depend on the client (the favorited and following flags), so their tags do
+// too, and the tag for an article also changes when its author's profile or
+// its number of favorites does.
+//
+// The current user is the exception to the 304: each response carries a new
+// access token, so GET /api/user ignores If-None-Match and its tag is only
+// good for If-Match.
+//
+// Since the tags depend on who is asking, responses that carry one say so
+// in a Vary header, so that shared caches don't hand one client's copy
+// (or a 304 meant for it) to another.
+
+// setETag sets the entity tag on the response along with the headers
+// that the caller's credentials come in, since the tag depends on them.
+func setETag(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Authorization, Cookie")
+}
+
+// errPreconditionFailed is returned when the If-Match header doesn't match.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// articleETag returns the entity tag for the article as seen by the client.
+func articleETag(a *model.Article) string {
+	return fmt.Sprintf(`"a%d.%d.%d.%d.%d.%d"`, a.Id, a.Version, a.Author.Version, a.FavoritesCount, flag(a.Favorited), flag(a.Author.Following))
+}
+
+// profileETag returns the entity tag for the profile as seen by the client.
+func profileETag(p *model.Profile) string {
+	return fmt.Sprintf(`"p%d.%d.%d"`, p.Id, p.Version, flag(p.Following))
+}
+
+// userETag returns the entity tag for the user's own record.
+func userETag(u *model.User) string {
+	return fmt.Sprintf(`"u%d.%d"`, u.Id, u.Version)
+}
+
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ifMatch returns true if the request doesn't have an If-Match header
+// or if one of the tags in it matches the current tag.
+// Weak tags never match (see RFC 7232, section 3.1).
+func ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified returns true if the request has an If-None-Match header
+// and one of the tags in it matches the current tag.
+// Weak tags match their strong counterparts (see RFC 7232, section 3.2).
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/"); tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// conditional runs fn against the store. If the request has an If-Match header,
+// fn runs in a unit of work and only if the tag that etag returns for the resource,
+// as it is at the start of the unit of work, matches. Otherwise, it returns
+// errPreconditionFailed. A resource that another request changes before fn is
+// done with it doesn't match either. A resource that no longer exists returns
+// etag's error, so that the handler answers it as it would without the header.
+func (s *Server) conditional(r *http.Request, etag func(db store.Store) (string, error), fn func(db store.Store) error) error {
+	if r.Header.Get("If-Match") == "" {
+		return fn(s.DB)
+	}
+	err := s.DB.Transact(func(tx store.Store) error {
+		tag, err := etag(tx)
+		if err != nil {
+			return err
+		} else if !ifMatch(r, tag) {
+			return errPreconditionFailed
+		}
+		return fn(tx)
+	})
+	if errors.Is(err, store.ErrConflict) {
+		return errPreconditionFailed
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"encoding/json"
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCurrentUserTokenExpiry reads the current user, waits until the token
+// in the response has expired, and checks that a conditional GET hands out
+// a new token instead of a 304 that would leave the client with the old one.
+func TestCurrentUserTokenExpiry(t *testing.T) {
+	srv := newTestServer("etags", Cookies{})
+	srv.AccessTokenTTL = time.Second
+	u, errs, err := srv.DB.CreateUser("Jacob", "jake@jake.jake", "jakejake")
+	if err != nil || errs != nil {
+		t.Fatalf("createUser: %v %v\n", errs, err)
+	}
+	// the session outlives the access tokens, like a session cookie would
+	session := srv.NewJWT(time.Minute, u.Id, u.Username, u.Email, "authenticated")
+	get := func(token, tag string) (int, string, string) {
+		r := httptest.NewRequest("GET", "/api/user", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if tag != "" {
+			r.Header.Set("If-None-Match", tag)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		var resp conduit.UserResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("get user: %v\n", err)
+			}
+		}
+		return w.Code, w.Header().Get("ETag"), resp.User.Token
+	}
+
+	code, tag, token := get(session, "")
+	if code != http.StatusOK || tag == "" || token == "" {
+		t.Fatalf("get user: expected %d with ETag and token: got %d %q %q\n", http.StatusOK, code, tag, token)
+	}
+	time.Sleep(2 * time.Second)
+	if code, _, _ := get(token, ""); code != http.StatusUnauthorized {
+		t.Fatalf("get user: expired token: expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+
+	code, _, fresh := get(session, tag)
+	if code != http.StatusOK || fresh == "" || fresh == token {
+		t.Fatalf("get user: if-none-match: expected %d with a new token: got %d %q\n", http.StatusOK, code, fresh)
+	}
+	if code, _, _ := get(fresh, ""); code != http.StatusOK {
+		t.Errorf("get user: new token: expected %d: got %d\n", http.StatusOK, code)
+	}
+}
+
+// TestETagVary checks that every response with a tag, including a 304,
+// tells caches that the tag depends on the caller's credentials.
+func TestETagVary(t *testing.T) {
+	srv := newTestServer("etags", Cookies{})
+	u, errs, err := srv.DB.CreateUser("Jacob", "jake@jake.jake", "jakejake")
+	if err != nil || errs != nil {
+		t.Fatalf("createUser: %v %v\n", errs, err)
+	}
+	a, errs, err := srv.DB.CreateArticle(u.Id, "How to train your dragon", "Ever wonder how?", "You have to believe", nil)
+	if err != nil || errs != nil {
+		t.Fatalf("createArticle: %v %v\n", errs, err)
+	}
+	token := srv.NewJWT(time.Minute, u.Id, u.Username, u.Email, "authenticated")
+	do := func(method, target, body, tag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		if body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		if tag != "" {
+			r.Header.Set("If-None-Match", tag)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	article := "/api/articles/" + a.Slug
+	tag := do("GET", article, "", "").Header().Get("ETag")
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		body, tag    string
+		code         int
+	}{
+		{"get article", "GET", article, "", "", http.StatusOK},
+		{"get article: not modified", "GET", article, "", tag, http.StatusNotModified},
+		{"update article", "PUT", article, `{"article":{"body":"With love."}}`, "", http.StatusOK},
+		{"get profile", "GET", "/api/profiles/Jacob", "", "", http.StatusOK},
+		{"get user", "GET", "/api/user", "", "", http.StatusOK},
+		{"update user", "PUT", "/api/user", `{"user":{"bio":"I like to skateboard"}}`, "", http.StatusOK},
+	} {
+		w := do(tc.method, tc.path, tc.body, tc.tag)
+		if w.Code != tc.code || w.Header().Get("ETag") == "" {
+			t.Errorf("vary: %s: expected %d with ETag: got %d %q\n", tc.name, tc.code, w.Code, w.Header().Get("ETag"))
+		} else if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(vary, "Authorization") || !strings.Contains(vary, "Cookie") {
+			t.Errorf("vary: %s: expected Vary with Authorization and Cookie: got %q\n", tc.name, vary)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/memory"
+	"github.com/mdhender/conduit/internal/store/model"
+	"github.com/mdhender/conduit/internal/store/storetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// failingReads is a store whose lookups fail, like a lost database connection would.
+type failingReads struct {
+	store.Store
+}
+
+func (db failingReads) GetArticleBySlug(id int, slug string) (*model.Article, error) {
+	return nil, errors.New("connection lost")
+}
+
+func (db failingReads) GetComments(id int, slug string) ([]*model.Comment, error) {
+	return nil, errors.New("connection lost")
+}
+
+func (db failingReads) IsTokenRevoked(jti string) (bool, error) {
+	return false, errors.New("connection lost")
+}
+
+func (db failingReads) GetProfileByUsername(id int, username string) (*model.Profile, error) {
+	return nil, errors.New("connection lost")
+}
+
+// TestStoreFailures checks that a store that can't save a change or
+// look something up gets a 500 rather than being reported to the client
+// as a validation error, a missing resource or a bad token.
+func TestStoreFailures(t *testing.T) {
+	journal := &memory.FailingJournal{}
+	srv := newTestServer("failures", Cookies{})
+	srv.DB, _ = memory.New(memory.WithJournal(journal), memory.WithPasswordHasher(storetest.Hasher))
+	do := func(method, target string, body interface{}, token string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(method, target, bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, "")
+	var resp conduit.UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("createUser: %d: %v\n", w.Code, err)
+	}
+	token := resp.User.Token
+	var articleResp conduit.ArticleResponse
+	var article conduit.ArticleCreateRequest
+	article.Article.Title, article.Article.Description, article.Article.Body = "How to train your dragon", "Ever wonder how?", "You have to believe"
+	w = do("POST", "/api/articles", article, token)
+	if err := json.Unmarshal(w.Body.Bytes(), &articleResp); err != nil {
+		t.Fatalf("createArticle: %d: %v\n", w.Code, err)
+	}
+	slug := articleResp.Article.Slug
+	var reply conduit.CommentAddRequest
+	reply.Comment.Body = "Thank you so much!"
+	w = do("POST", "/api/articles/"+slug+"/comments", reply, token)
+	var commentResp conduit.CommentResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &commentResp); err != nil {
+		t.Fatalf("addComment: %d: %v\n", w.Code, err)
+	}
+	comment := fmt.Sprintf("/api/articles/%s/comments/%d", slug, commentResp.Comment.Id)
+
+	journal.Broken = true
+	bio := "I like to skateboard"
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		body         interface{}
+		token        string
+	}{
+		{"createUser", "POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"}}, ""},
+		{"updateUser", "PUT", "/api/user", conduit.UpdateUserRequest{User: conduit.UpdateUser{Bio: &bio}}, token},
+		{"createArticle", "POST", "/api/articles", article, token},
+		{"favoriteArticle", "POST", "/api/articles/" + slug + "/favorite", nil, token},
+		{"unfavoriteArticle", "DELETE", "/api/articles/" + slug + "/favorite", nil, token},
+		{"deleteComment", "DELETE", comment, nil, token},
+		{"deleteArticle", "DELETE", "/api/articles/" + slug, nil, token},
+		{"followUser", "POST", "/api/profiles/Anne/follow", nil, token},
+		{"refresh", "POST", "/api/users/refresh", conduit.RefreshUserRequest{User: conduit.RefreshUser{RefreshToken: resp.User.RefreshToken}}, ""},
+	} {
+		if w := do(tc.method, tc.path, tc.body, tc.token); w.Code != http.StatusInternalServerError {
+			t.Errorf("failures: %s: expected %d: got %d %s\n", tc.name, http.StatusInternalServerError, w.Code, w.Body.String())
+		}
+	}
+
+	srv.DB = failingReads{Store: srv.DB}
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		body         interface{}
+		token        string
+	}{
+		{"getArticle", "GET", "/api/articles/" + slug, nil, ""},
+		{"getComments", "GET", "/api/articles/" + slug + "/comments", nil, ""},
+		{"addComment", "POST", "/api/articles/" + slug + "/comments", reply, token},
+		{"getProfile", "GET", "/api/profiles/Jacob", nil, ""},
+		{"getArticles", "GET", "/api/articles", nil, token},
+		{"currentUser", "GET", "/api/user", nil, token},
+	} {
+		if w := do(tc.method, tc.path, tc.body, tc.token); w.Code != http.StatusInternalServerError {
+			t.Errorf("failures: %s: expected %d: got %d %s\n", tc.name, http.StatusInternalServerError, w.Code, w.Body.String())
+		}
+	}
+}
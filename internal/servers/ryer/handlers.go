@@ -25,32 +25,61 @@
 package ryer
 
 import (
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/rbac"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
 	"log"
 	"net/http"
 )
 
 var contentType = "application/json; charset=utf-8"
 
-func (s *Server) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+// requires returns a handler that only calls h if the user's roles grant the permission.
+// It returns 401 (with a WWW-Authenticate challenge) if the request isn't
+// authenticated and 403 if it is but the permission isn't granted.
+func (s *Server) requires(perm rbac.Permission, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !s.currentUser(r).IsAdmin {
+		cu := s.currentUser(r)
+		if cu.User == nil || !s.isAuthenticated(cu.Token, cu.Roles) {
 			if s.debug {
-				log.Printf("%s: not admin\n", r.URL.Path)
+				log.Printf("%s: not authenticated: %v\n", r.URL.Path, cu.TokenError)
 			}
-			http.NotFound(w, r)
+			if errors.Is(cu.TokenError, errCSRF) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			} else if errors.Is(cu.TokenError, errStore) {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", bearerChallenge(cu.TokenError))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if !s.policy().Can(cu.Roles, perm) {
+			if s.debug {
+				log.Printf("%s: %v: not granted %q\n", r.URL.Path, cu.Roles, perm)
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
 		h(w, r)
 	}
 }
 
-func (s *Server) authenticatedOnly(h http.HandlerFunc) http.HandlerFunc {
+// restricted is like requires, but returns 404 to anyone without the
+// permission so that the route isn't revealed. It's used for the admin API.
+func (s *Server) restricted(perm rbac.Permission, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !s.currentUser(r).IsAuthenticated {
+		if cu := s.currentUser(r); errors.Is(cu.TokenError, errStore) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if cu.User == nil || !s.isAuthenticated(cu.Token, cu.Roles) || !s.policy().Can(cu.Roles, perm) {
 			if s.debug {
-				log.Printf("%s: not authenticated\n", r.URL.Path)
+				log.Printf("%s: not granted %q\n", r.URL.Path, perm)
 			}
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			http.NotFound(w, r)
 			return
 		}
 		h(w, r)
@@ -62,16 +91,42 @@ func (s *Server) getArticlesFeed() http.HandlerFunc {
 		if s.debug {
 			log.Printf("getArticlesFeed(%s)\n", r.URL.Path)
 		}
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-	}
-}
+		cu := s.currentUser(r).User
+		if cu == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
 
-func (s *Server) handleAdminIndex() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if s.debug {
-			log.Printf("adminIndex(%s)\n", r.URL.Path)
+		limit, offset, errs := pageParams(r)
+		if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "getArticlesFeed")
+			return
 		}
-		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+		articles, count, err := s.DB.FeedArticles(cu.Id, limit, offset)
+		if err != nil {
+			if errors.Is(err, store.ErrNotAuthorized) {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			log.Printf("getArticlesFeed: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		result := conduit.MultipleArticlesResponse{Articles: []conduit.Article{}, ArticlesCount: count}
+		for _, a := range articles {
+			result.Articles = append(result.Articles, asArticle(a))
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("getArticlesFeed: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
 	}
 }
 
@@ -80,7 +135,49 @@ func (s *Server) handleGetArticles() http.HandlerFunc {
 		if s.debug {
 			log.Printf("getArticles(%s)\n", r.URL.Path)
 		}
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		// client doesn't have to be authenticated, but if she is,
+		// we will fetch the favorited and following flags for her.
+		var userId int
+		if cu := s.currentUser(r); errors.Is(cu.TokenError, errStore) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if cu.User != nil {
+			userId = cu.User.Id
+		}
+
+		limit, offset, errs := pageParams(r)
+		if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "getArticles")
+			return
+		}
+
+		articles, count, err := s.DB.ListArticles(userId, model.ArticleFilter{
+			Tag:       r.URL.Query().Get("tag"),
+			Author:    r.URL.Query().Get("author"),
+			Favorited: r.URL.Query().Get("favorited"),
+			Limit:     limit,
+			Offset:    offset,
+		})
+		if err != nil {
+			log.Printf("getArticles: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		result := conduit.MultipleArticlesResponse{Articles: []conduit.Article{}, ArticlesCount: count}
+		for _, a := range articles {
+			result.Articles = append(result.Articles, asArticle(a))
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("getArticles: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
 	}
 }
 
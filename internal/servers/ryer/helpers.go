@@ -25,39 +25,190 @@
 package ryer
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/mdhender/conduit/internal/jwt"
+	"github.com/mdhender/conduit/internal/rbac"
+	"github.com/mdhender/conduit/internal/store"
 	"github.com/mdhender/conduit/internal/store/model"
+	"log"
 	"net/http"
+	"strconv"
 )
 
+// errSuspended is returned for tokens belonging to a suspended user.
+var errSuspended = errors.New("account suspended")
+
+// errStore is wrapped around store failures met while checking a token.
+// The token may well be good, so these are answered with a 500, not a 401.
+var errStore = errors.New("store failure")
+
 // currentUser extracts data for the user making the request.
 // It always returns a user struct, even if the request does
-// not have a valid bearer token.
+// not have a valid bearer token. If a token was presented but
+// rejected, TokenError says why; if the store failed while checking
+// the token, TokenError wraps errStore.
+// When cookie sessions are enabled, the session cookie is used
+// if the request does not have an Authorization header.
+// Roles are the roles claimed by the token plus any stored on the user.
 // TODO: should return a Conduit User.
 func (s *Server) currentUser(r *http.Request) (user struct {
-	IsAdmin         bool
-	IsAuthenticated bool
-	User            *model.User
+	Roles      []string
+	User       *model.User
+	Token      *jwt.JWT
+	TokenError error
 }) {
 	j, err := jwt.GetBearerToken(r)
+	if err == jwt.ErrMissingAuthHeader {
+		var token string
+		if token, err = s.sessionCookieValue(r, sessionCookie); err != nil {
+			user.TokenError = err
+			return user
+		} else if token == "" {
+			return user
+		}
+		j, err = jwt.Parse(token)
+	}
 	if err != nil {
 		//log.Printf("currentUser: bearerToken %v\n", j)
 		//log.Printf("currentUser: getBearerToken %+v\n", err)
+		if err != jwt.ErrMissingAuthHeader {
+			user.TokenError = err
+		}
 		return user
 	} else if err = s.TokenFactory.Validate(j); err != nil {
 		//log.Printf("currentUser: validateToken %+v\n", err)
+		user.TokenError = err
 		return user
-	} else if !j.IsValid() {
+	} else if revoked, err := s.DB.IsTokenRevoked(j.ID()); err != nil {
+		log.Printf("currentUser: %+v\n", err)
+		user.TokenError = fmt.Errorf("%w: %v", errStore, err)
+		return user
+	} else if revoked {
+		user.TokenError = jwt.ErrRevoked
 		return user
 	}
-	user.User, err = s.DB.GetUser(j.Data().Id)
-	for _, role := range j.Data().Roles {
-		switch role {
-		case "admin":
-			user.IsAdmin = true
-		case "authenticated":
-			user.IsAuthenticated = true
-		}
+	if user.User, err = s.DB.GetUser(j.Data().Id); errors.Is(err, store.ErrNotFound) {
+		// the user was deleted after the token was issued (or never existed),
+		// so the roles the token claims can't be trusted
+		user.User, user.TokenError = nil, jwt.ErrRevoked
+		return user
+	} else if err != nil {
+		log.Printf("currentUser: %+v\n", err)
+		user.User, user.TokenError = nil, fmt.Errorf("%w: %v", errStore, err)
+		return user
+	} else if user.User.TokenGeneration != j.Data().Generation {
+		// all of the user's sessions were revoked after this token was issued
+		user.User, user.TokenError = nil, jwt.ErrRevoked
+		return user
+	} else if user.User.Suspended {
+		user.User, user.TokenError = nil, errSuspended
+		return user
 	}
+	user.Token = j
+	// roles granted since the token was issued count right away;
+	// revoking a role bumps the generation, so stale claims don't
+	user.Roles = append(append([]string{}, j.Data().Roles...), user.User.Roles...)
 	return user
 }
+
+// isAuthenticated returns true if the token is valid and claims
+// at least one role that the policy knows about.
+func (s *Server) isAuthenticated(token *jwt.JWT, roles []string) bool {
+	if token == nil {
+		return false
+	}
+	p := s.policy()
+	for _, role := range roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultPolicy is used by servers that aren't given a policy.
+var defaultPolicy = rbac.DefaultPolicy()
+
+// policy returns the server's access control policy.
+func (s *Server) policy() rbac.Policy {
+	if s.Policy == nil {
+		return defaultPolicy
+	}
+	return s.Policy
+}
+
+// bearerChallenge returns the WWW-Authenticate header for a request
+// that was not authenticated (see RFC 6750, section 3).
+// If a token was rejected, the description tells the client why.
+func bearerChallenge(tokenError error) string {
+	if tokenError == nil {
+		return "Bearer"
+	}
+	description := "invalid token"
+	for _, err := range []error{
+		jwt.ErrExpired,
+		jwt.ErrInvalidAudience,
+		jwt.ErrInvalidIssuer,
+		jwt.ErrInvalidSubject,
+		jwt.ErrMissingClaim,
+		jwt.ErrNotBearer,
+		jwt.ErrNotYetValid,
+		jwt.ErrRevoked,
+		jwt.ErrTooOld,
+		errSuspended,
+	} {
+		if errors.Is(tokenError, err) {
+			description = tokenError.Error()
+			break
+		}
+	}
+	return fmt.Sprintf("Bearer error=%q, error_description=%q", "invalid_token", description)
+}
+
+// pageParams extracts the limit and offset query parameters from the request.
+// The limit defaults to 20 and the offset to 0 if they are not provided.
+// Returns a map of errors if either parameter is not a non-negative integer.
+func pageParams(r *http.Request) (limit, offset int, errs map[string][]string) {
+	limit, offset = 20, 0
+	for _, p := range []struct {
+		key string
+		val *int
+	}{
+		{"limit", &limit},
+		{"offset", &offset},
+	} {
+		raw := r.URL.Query().Get(p.key)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			if errs == nil {
+				errs = make(map[string][]string)
+			}
+			errs[p.key] = append(errs[p.key], "must be a non-negative integer")
+			continue
+		}
+		*p.val = n
+	}
+	return limit, offset, errs
+}
+
+// writeErrors writes the errors in the format that the RealWorld spec uses for a 422.
+func writeErrors(w http.ResponseWriter, status int, errs map[string][]string, what string) {
+	var result struct {
+		Errors map[string][]string `json:"errors"`
+	}
+	result.Errors = errs
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("%s: %+v\n", what, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
@@ -26,7 +26,9 @@ package ryer
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/store"
 	"github.com/mdhender/conduit/internal/way"
 	"log"
 	"net/http"
@@ -38,9 +40,13 @@ func (s *Server) handleFollowUserByUsername() http.HandlerFunc {
 
 		username := way.Param(r.Context(), "username")
 		profile, err := s.DB.FollowUserByUsername(cu.Id, username)
-		if err != nil {
+		if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrNotAuthorized) {
 			http.NotFound(w, r)
 			return
+		} else if err != nil {
+			log.Printf("followUserByUsername: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
 		}
 		data, err := json.Marshal(conduit.ProfileResponse{Profile: conduit.Profile{
 			Bio:       profile.Bio,
@@ -60,20 +66,34 @@ func (s *Server) handleFollowUserByUsername() http.HandlerFunc {
 	}
 }
 
+// Returns 304 if the client's copy matches the If-None-Match header.
 func (s *Server) handleGetProfileByUsername() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// client doesn't have to be authenticated, but if she is,
 		// we will fetch the following flag for her.
 		var userId int
-		if cu := s.currentUser(r).User; cu != nil {
-			userId = cu.Id
+		if cu := s.currentUser(r); errors.Is(cu.TokenError, errStore) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if cu.User != nil {
+			userId = cu.User.Id
 		}
 
 		username := way.Param(r.Context(), "username")
 		profile, err := s.DB.GetProfileByUsername(userId, username)
-		if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
 			http.NotFound(w, r)
 			return
+		} else if err != nil {
+			log.Printf("getProfileByUsername: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		etag := profileETag(profile)
+		setETag(w, etag)
+		if notModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
 		data, err := json.Marshal(conduit.ProfileResponse{Profile: conduit.Profile{
 			Bio:       profile.Bio,
@@ -99,9 +119,13 @@ func (s *Server) handleUnfollowUserByUsername() http.HandlerFunc {
 
 		username := way.Param(r.Context(), "username")
 		profile, err := s.DB.UnfollowUserByUsername(cu.Id, username)
-		if err != nil {
+		if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrNotAuthorized) {
 			http.NotFound(w, r)
 			return
+		} else if err != nil {
+			log.Printf("unfollowUserByUsername: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
 		}
 		data, err := json.Marshal(conduit.ProfileResponse{Profile: conduit.Profile{
 			Bio:       profile.Bio,
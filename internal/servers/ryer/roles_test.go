@@ -0,0 +1,105 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/jwt"
+	"github.com/mdhender/conduit/internal/rbac"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStoredRoles(t *testing.T) {
+	srv := newTestServer("roles", Cookies{})
+	login := func() string {
+		body, err := json.Marshal(conduit.LoginUserRequest{User: conduit.LoginUser{Email: "morgan@morgan.morgan", Password: "morganmorgan"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("POST", "/api/users/login", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		var resp conduit.UserResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("login: %d: %v\n", w.Code, err)
+		}
+		return resp.User.Token
+	}
+	roles := func(token string) []string {
+		r := httptest.NewRequest("GET", "/api/user", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return srv.currentUser(r).Roles
+	}
+	has := func(roles []string, role string) bool {
+		for _, r := range roles {
+			if r == role {
+				return true
+			}
+		}
+		return false
+	}
+
+	u, errs, err := srv.DB.CreateUser("Morgan", "morgan@morgan.morgan", "morganmorgan")
+	if err != nil || errs != nil {
+		t.Fatalf("createUser: %v %v\n", errs, err)
+	}
+	before := login()
+
+	// a role granted after a token was issued counts right away
+	if _, err := srv.DB.GrantRole(u.Id, rbac.Moderator); err != nil {
+		t.Fatalf("grantRole: %v\n", err)
+	}
+	if got := roles(before); !has(got, rbac.Moderator) || !has(got, rbac.Authenticated) {
+		t.Errorf("grant: expected authenticated and moderator: got %v\n", got)
+	}
+
+	// and tokens issued afterwards claim it
+	after := login()
+	if j, err := jwt.Parse(after); err != nil {
+		t.Fatalf("parse: %v\n", err)
+	} else if got := j.Data().Roles; !has(got, rbac.Moderator) {
+		t.Errorf("login: expected token to claim moderator: got %v\n", got)
+	}
+
+	// revoking the role rejects tokens that claim it
+	if _, err := srv.DB.RevokeRole(u.Id, rbac.Moderator); err != nil {
+		t.Fatalf("revokeRole: %v\n", err)
+	}
+	r := httptest.NewRequest("GET", "/api/user", nil)
+	r.Header.Set("Authorization", "Bearer "+after)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("revoke: expected %d: got %d\n", http.StatusUnauthorized, w.Code)
+	}
+	if got := roles(login()); has(got, rbac.Moderator) {
+		t.Errorf("revoke: expected no moderator role: got %v\n", got)
+	}
+}
@@ -25,6 +25,7 @@
 package ryer
 
 import (
+	"github.com/mdhender/conduit/internal/rbac"
 	"net/http"
 )
 
@@ -36,26 +37,39 @@ func (s *Server) Routes() {
 		method  string
 		handler http.HandlerFunc
 	}{
-		{"/api/admin", "GET", s.adminOnly(s.handleAdminIndex())},
-		{"/api/articles", "GET", s.handleNotImplemented()},
-		{"/api/articles", "POST", s.handleNotImplemented()},
-		{"/api/articles/feed", "GET", s.authenticatedOnly(s.getArticlesFeed())},
-		{"/api/articles/:slug", "DELETE", s.handleNotImplemented()},
-		{"/api/articles/:slug", "GET", s.handleGetArticles()},
-		{"/api/articles/:slug", "PUT", s.handleNotImplemented()},
-		{"/api/articles/:slug/comments", "GET", s.handleNotImplemented()},
-		{"/api/articles/:slug/comments", "POST", s.handleNotImplemented()},
-		{"/api/articles/:slug/comments/:id", "DELETE", s.handleNotImplemented()},
-		{"/api/articles/:slug/favorite", "DELETE", s.handleNotImplemented()},
-		{"/api/articles/:slug/favorite", "POST", s.handleNotImplemented()},
+		{"/.well-known/jwks.json", "GET", s.handleGetJWKS()},
+		{"/api/admin", "GET", s.restricted(rbac.ManageUsers, s.handleAdminIndex())},
+		{"/api/admin/users", "GET", s.restricted(rbac.ManageUsers, s.handleAdminListUsers())},
+		{"/api/admin/users/:username", "DELETE", s.restricted(rbac.ManageUsers, s.handleAdminDeleteUser())},
+		{"/api/admin/users/:username", "GET", s.restricted(rbac.ManageUsers, s.handleAdminGetUser())},
+		{"/api/admin/users/:username/password-reset", "POST", s.restricted(rbac.ManageUsers, s.handleAdminResetPassword())},
+		{"/api/admin/users/:username/roles/:role", "DELETE", s.restricted(rbac.ManageUsers, s.handleAdminSetRole(false))},
+		{"/api/admin/users/:username/roles/:role", "POST", s.restricted(rbac.ManageUsers, s.handleAdminSetRole(true))},
+		{"/api/admin/users/:username/sessions", "DELETE", s.restricted(rbac.ManageUsers, s.handleRevokeSessions())},
+		{"/api/admin/users/:username/suspend", "DELETE", s.restricted(rbac.ManageUsers, s.handleAdminSuspendUser(false))},
+		{"/api/admin/users/:username/suspend", "POST", s.restricted(rbac.ManageUsers, s.handleAdminSuspendUser(true))},
+		{"/api/articles", "GET", s.handleGetArticles()},
+		{"/api/articles", "POST", s.requires(rbac.WriteArticles, s.handleCreateArticle())},
+		{"/api/articles/feed", "GET", s.requires(rbac.ManageAccount, s.getArticlesFeed())},
+		{"/api/articles/:slug", "DELETE", s.requires(rbac.WriteArticles, s.handleDeleteArticle())},
+		{"/api/articles/:slug", "GET", s.handleGetArticle()},
+		{"/api/articles/:slug", "PUT", s.requires(rbac.WriteArticles, s.handleUpdateArticle())},
+		{"/api/articles/:slug/comments", "GET", s.handleGetComments()},
+		{"/api/articles/:slug/comments", "POST", s.requires(rbac.WriteComments, s.handleAddComment())},
+		{"/api/articles/:slug/comments/:id", "DELETE", s.requires(rbac.WriteComments, s.handleDeleteComment())},
+		{"/api/articles/:slug/favorite", "DELETE", s.requires(rbac.WriteArticles, s.handleUnfavoriteArticle())},
+		{"/api/articles/:slug/favorite", "POST", s.requires(rbac.WriteArticles, s.handleFavoriteArticle())},
 		{"/api/profiles/:username", "GET", s.handleGetProfileByUsername()},
-		{"/api/profiles/:username/follow", "DELETE", s.authenticatedOnly(s.handleUnfollowUserByUsername())},
-		{"/api/profiles/:username/follow", "POST", s.authenticatedOnly(s.handleFollowUserByUsername())},
-		{"/api/tags", "GET", s.handleNotImplemented()},
-		{"/api/user", "GET", s.authenticatedOnly(s.handleCurrentUser())},
-		{"/api/user", "PUT", s.authenticatedOnly(s.handleUpdateCurrentUser())},
+		{"/api/profiles/:username/follow", "DELETE", s.requires(rbac.FollowUsers, s.handleUnfollowUserByUsername())},
+		{"/api/profiles/:username/follow", "POST", s.requires(rbac.FollowUsers, s.handleFollowUserByUsername())},
+		{"/api/tags", "GET", s.handleGetTags()},
+		{"/api/user", "GET", s.requires(rbac.ManageAccount, s.handleCurrentUser())},
+		{"/api/user", "PUT", s.requires(rbac.ManageAccount, s.handleUpdateCurrentUser())},
 		{"/api/users", "POST", s.handleCreateUser()},
 		{"/api/users/login", "POST", s.handleLogin()},
+		{"/api/users/logout", "POST", s.requires(rbac.ManageAccount, s.handleLogout())},
+		{"/api/users/password", "POST", s.handleChangePassword()},
+		{"/api/users/refresh", "POST", s.handleRefresh()},
 	} {
 		s.Router.HandleFunc(route.method, route.pattern, route.handler)
 	}
@@ -33,7 +33,8 @@ package ryer
 
 import (
 	"github.com/mdhender/conduit/internal/jwt"
-	"github.com/mdhender/conduit/internal/store/memory"
+	"github.com/mdhender/conduit/internal/rbac"
+	"github.com/mdhender/conduit/internal/store"
 	"github.com/mdhender/conduit/internal/way"
 	"net/http"
 	"time"
@@ -41,8 +42,12 @@ import (
 
 type Server struct {
 	http.Server
-	DB                  *memory.Store
-	DtFmt               string // format string for timestamps in responses
+	AccessTokenTTL      time.Duration // lifetime of access tokens; 15 minutes if zero
+	Cookies             Cookies       // cookie sessions; only bearer tokens are accepted if not enabled
+	DB                  store.Store
+	DtFmt               string        // format string for timestamps in responses
+	Policy              rbac.Policy   // permissions granted to each role; rbac.DefaultPolicy() if nil
+	RefreshTokenTTL     time.Duration // lifetime of refresh tokens; 30 days if zero
 	Router              *way.Router
 	TokenFactory        jwt.Factory
 	debug               bool
@@ -0,0 +1,268 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package ryer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/mdhender/conduit/internal/conduit"
+	"github.com/mdhender/conduit/internal/jsonapi"
+	"github.com/mdhender/conduit/internal/jwt"
+	"github.com/mdhender/conduit/internal/rbac"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"github.com/mdhender/conduit/internal/way"
+	"log"
+	"net/http"
+	"time"
+)
+
+// default lifetimes for the tokens in a session
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// post body should contain a RefreshUserRequest which wraps a RefreshUser
+// Returns a UserResponse with a new access token and a new refresh token.
+// The refresh token in the request can't be used again.
+// When cookie sessions are enabled, the body may be empty and the
+// refresh cookie is used instead.
+func (s *Server) handleRefresh() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req conduit.RefreshUserRequest
+		if r.ContentLength != 0 {
+			if err := jsonapi.Data(w, r, s.rejectUnknownFields, &req); err != nil {
+				if s.debug {
+					log.Printf("refresh: %+v\n", err)
+				}
+				if errors.Is(err, jsonapi.ErrBadRequest) {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				} else if errors.Is(err, jsonapi.ErrRequestEntityTooLarge) {
+					http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				} else if errors.Is(err, jsonapi.ErrUnsupportedMediaType) {
+					http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				} else {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+		if req.User.RefreshToken == "" {
+			token, err := s.sessionCookieValue(r, refreshCookie)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			req.User.RefreshToken = token
+		}
+		if req.User.RefreshToken == "" {
+			writeErrors(w, http.StatusUnprocessableEntity, map[string][]string{"refreshToken": {"can't be blank"}}, "refresh")
+			return
+		}
+
+		refreshToken, hash, err := newRefreshToken()
+		if err != nil {
+			log.Printf("refresh: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		u, err := s.DB.RotateRefreshToken(hashRefreshToken(req.User.RefreshToken), hash, time.Now().Add(s.refreshTokenTTL()))
+		if errors.Is(err, store.ErrNotAuthorized) {
+			if s.debug {
+				log.Printf("refresh: %+v\n", err)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Printf("refresh: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		user := conduit.User{
+			Id:           u.Id,
+			Bio:          u.Bio,
+			CreatedAt:    u.CreatedAt,
+			Email:        u.Email,
+			Image:        u.Image,
+			UpdatedAt:    u.UpdatedAt,
+			Username:     u.Username,
+			Token:        s.newAccessToken(u),
+			RefreshToken: refreshToken,
+		}
+		data, err := json.Marshal(conduit.UserResponse{User: user})
+		if err != nil {
+			log.Printf("refresh: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err = s.setSessionCookies(w, user.Token, user.RefreshToken); err != nil {
+			log.Printf("refresh: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// Revokes the bearer token used to make the request.
+// If the body contains a RefreshUserRequest, that refresh token and
+// every token rotated from the same login are revoked too.
+// When cookie sessions are enabled, the refresh cookie is revoked
+// and the session cookies are cleared.
+func (s *Server) handleLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cu := s.currentUser(r)
+		if cu.Token == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		var req conduit.RefreshUserRequest
+		if r.ContentLength != 0 {
+			if err := jsonapi.Data(w, r, s.rejectUnknownFields, &req); err != nil {
+				if s.debug {
+					log.Printf("logout: %+v\n", err)
+				}
+				if errors.Is(err, jsonapi.ErrBadRequest) {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				} else if errors.Is(err, jsonapi.ErrRequestEntityTooLarge) {
+					http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				} else if errors.Is(err, jsonapi.ErrUnsupportedMediaType) {
+					http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				} else {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+
+		refreshTokens := []string{req.User.RefreshToken}
+		if token, err := s.sessionCookieValue(r, refreshCookie); err == nil {
+			refreshTokens = append(refreshTokens, token)
+		}
+		for _, refreshToken := range refreshTokens {
+			if refreshToken == "" {
+				continue
+			} else if err := s.DB.RevokeRefreshToken(hashRefreshToken(refreshToken)); err != nil {
+				log.Printf("logout: %+v\n", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := s.DB.RevokeToken(cu.Token.ID(), cu.Token.ExpiresAt()); err != nil {
+			log.Printf("logout: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		s.clearSessionCookies(w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Revokes every refresh token and access token issued to the user
+func (s *Server) handleRevokeSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.DB.GetProfileByUsername(0, way.Param(r.Context(), "username"))
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			log.Printf("revokeSessions: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err = s.DB.RevokeSessions(p.Id); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			log.Printf("revokeSessions: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newAccessToken returns an access token for the user's current session generation.
+func (s *Server) newAccessToken(u *model.User) string {
+	return s.TokenFactory.NewTokenFor(s.accessTokenTTL(), jwt.Data{
+		Id:         u.Id,
+		Username:   u.Username,
+		Email:      u.Email,
+		Roles:      append([]string{rbac.Authenticated}, u.Roles...),
+		Generation: u.TokenGeneration,
+	})
+}
+
+// startSession returns a refresh token for a new token family.
+func (s *Server) startSession(id int) (string, error) {
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	} else if err = s.DB.CreateRefreshToken(id, hash, time.Now().Add(s.refreshTokenTTL())); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+func (s *Server) accessTokenTTL() time.Duration {
+	if s.AccessTokenTTL == 0 {
+		return defaultAccessTokenTTL
+	}
+	return s.AccessTokenTTL
+}
+
+func (s *Server) refreshTokenTTL() time.Duration {
+	if s.RefreshTokenTTL == 0 {
+		return defaultRefreshTokenTTL
+	}
+	return s.RefreshTokenTTL
+}
+
+// newRefreshToken returns a random, opaque refresh token and the hash to store for it.
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken returns the hash that the store keeps for a refresh token.
+// The tokens are random, so a fast hash is enough to keep a leaked store
+// from being used to refresh sessions.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
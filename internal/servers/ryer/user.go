@@ -29,17 +29,23 @@ import (
 	"errors"
 	"github.com/mdhender/conduit/internal/conduit"
 	"github.com/mdhender/conduit/internal/jsonapi"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
 	"log"
 	"net/http"
-	"time"
 )
 
+// Never returns 304: every response carries a newly issued access token,
+// and a client that kept its old copy would keep a token that may have
+// expired. The ETag is only there for If-Match on updates.
 func (s *Server) handleCurrentUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := conduit.User{}
 		if u := s.currentUser(r).User; u != nil {
+			setETag(w, userETag(u))
+			w.Header().Set("Cache-Control", "no-store")
 			user.Email = u.Email
-			user.Token = s.TokenFactory.NewToken(24*time.Hour, u.Id, u.Username, u.Email, "authenticated")
+			user.Token = s.newAccessToken(u)
 			user.Username = u.Username
 			user.Bio = u.Bio
 			user.Image = u.Image
@@ -56,6 +62,8 @@ func (s *Server) handleCurrentUser() http.HandlerFunc {
 	}
 }
 
+// Returns 412 if the user was changed since the client read it
+// (that is, if the If-Match header doesn't match).
 func (s *Server) handleUpdateCurrentUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cu := s.currentUser(r).User
@@ -75,21 +83,31 @@ func (s *Server) handleUpdateCurrentUser() http.HandlerFunc {
 			}
 			return
 		}
-		u, errs := s.DB.UpdateUser(cu.Id, req.User.Email, req.User.Bio, req.User.Image)
-		if errs != nil {
-			w.Header().Add("Content-Type", contentType)
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			var result struct {
-				Errors map[string][]string `json:"errors"`
-			}
-			result.Errors = errs
-			data, err := json.Marshal(result)
+		var u *model.User
+		var errs map[string][]string
+		err = s.conditional(r, func(db store.Store) (string, error) {
+			current, err := db.GetUser(cu.Id)
 			if err != nil {
-				log.Printf("updateCurrentUser: %+v\n", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
+				return "", err
 			}
-			_, _ = w.Write(data)
+			return userETag(current), nil
+		}, func(db store.Store) (err error) {
+			u, errs, err = db.UpdateUser(cu.Id, req.User.Email, req.User.Bio, req.User.Image)
+			return err
+		})
+		if errors.Is(err, errPreconditionFailed) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		} else if errors.Is(err, store.ErrNotFound) {
+			// the user was deleted after the token was checked
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Printf("updateCurrentUser: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "updateCurrentUser")
 			return
 		}
 		user := conduit.User{
@@ -100,7 +118,7 @@ func (s *Server) handleUpdateCurrentUser() http.HandlerFunc {
 			Image:     u.Image,
 			UpdatedAt: u.UpdatedAt,
 			Username:  u.Username,
-			Token:     s.TokenFactory.NewToken(24*time.Hour, u.Id, u.Username, u.Email, "authenticated"),
+			Token:     s.newAccessToken(u),
 		}
 		data, err := json.Marshal(conduit.UserResponse{User: user})
 		if err != nil {
@@ -109,6 +127,7 @@ func (s *Server) handleUpdateCurrentUser() http.HandlerFunc {
 			return
 		}
 		w.Header().Add("Content-Type", contentType)
+		setETag(w, userETag(u))
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(data)
 	}
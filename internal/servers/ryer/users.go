@@ -29,9 +29,9 @@ import (
 	"errors"
 	"github.com/mdhender/conduit/internal/conduit"
 	"github.com/mdhender/conduit/internal/jsonapi"
+	"github.com/mdhender/conduit/internal/store"
 	"log"
 	"net/http"
-	"time"
 )
 
 // post body should contain a NewUserRequest which wraps a NewUser
@@ -60,32 +60,29 @@ func (s *Server) handleCreateUser() http.HandlerFunc {
 			return
 		}
 
-		u, errs := s.DB.CreateUser(req.User.Username, req.User.Email, req.User.Password)
-		if errs != nil {
-			w.Header().Add("Content-Type", contentType)
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			var result struct {
-				Errors map[string][]string `json:"errors"`
-			}
-			result.Errors = errs
-			data, err := json.Marshal(result)
-			if err != nil {
-				if s.debug {
-					log.Printf("createUser: %+v\n", err)
-				}
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-			_, _ = w.Write(data)
+		u, errs, err := s.DB.CreateUser(req.User.Username, req.User.Email, req.User.Password)
+		if err != nil {
+			log.Printf("createUser: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "createUser")
+			return
+		}
+		refreshToken, err := s.startSession(u.Id)
+		if err != nil {
+			log.Printf("createUser: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 		user := conduit.User{
-			Id:        u.Id,
-			Email:     u.Email,
-			CreatedAt: u.CreatedAt,
-			UpdatedAt: u.UpdatedAt,
-			Username:  u.Username,
-			Token:     s.TokenFactory.NewToken(24*time.Hour, u.Id, u.Username, u.Email, "authenticated"),
+			Id:           u.Id,
+			Email:        u.Email,
+			CreatedAt:    u.CreatedAt,
+			UpdatedAt:    u.UpdatedAt,
+			Username:     u.Username,
+			Token:        s.newAccessToken(u),
+			RefreshToken: refreshToken,
 		}
 		data, err := json.Marshal(conduit.UserResponse{User: user})
 		if err != nil {
@@ -95,6 +92,11 @@ func (s *Server) handleCreateUser() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+		if err = s.setSessionCookies(w, user.Token, user.RefreshToken); err != nil {
+			log.Printf("createUser: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Add("Content-Type", contentType)
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(data)
@@ -120,15 +122,28 @@ func (s *Server) handleLogin() http.HandlerFunc {
 		}
 		u, err := s.DB.Login(req.User.Email, req.User.Password)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			if errors.Is(err, store.ErrSuspended) {
+				writeErrors(w, http.StatusForbidden, map[string][]string{"email": {"is suspended"}}, "login")
+			} else if errors.Is(err, store.ErrPasswordReset) {
+				writeErrors(w, http.StatusForbidden, map[string][]string{"password": {"must be changed at /api/users/password"}}, "login")
+			} else {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			}
+			return
+		}
+		refreshToken, err := s.startSession(u.Id)
+		if err != nil {
+			log.Printf("login: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 		user := conduit.User{
-			Email:    u.Email,
-			Token:    s.TokenFactory.NewToken(24*time.Hour, u.Id, u.Username, u.Email, "authenticated"),
-			Username: u.Username,
-			Bio:      u.Bio,
-			Image:    u.Image,
+			Email:        u.Email,
+			Token:        s.newAccessToken(u),
+			RefreshToken: refreshToken,
+			Username:     u.Username,
+			Bio:          u.Bio,
+			Image:        u.Image,
 		}
 		data, err := json.Marshal(conduit.UserResponse{User: user})
 		if err != nil {
@@ -138,6 +153,82 @@ func (s *Server) handleLogin() http.HandlerFunc {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+		if err = s.setSessionCookies(w, user.Token, user.RefreshToken); err != nil {
+			log.Printf("login: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// post body should contain a ChangePasswordRequest which wraps a ChangePassword
+// Returns a UserResponse for a new session. All other sessions are revoked.
+// This is how users clear a password reset required by an admin.
+func (s *Server) handleChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req conduit.ChangePasswordRequest
+		err := jsonapi.Data(w, r, s.rejectUnknownFields, &req)
+		if err != nil {
+			if s.debug {
+				log.Printf("changePassword: %+v\n", err)
+			}
+			if errors.Is(err, jsonapi.ErrBadRequest) {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			} else if errors.Is(err, jsonapi.ErrRequestEntityTooLarge) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			} else if errors.Is(err, jsonapi.ErrUnsupportedMediaType) {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			} else {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+		u, errs, err := s.DB.ChangePassword(req.User.Email, req.User.Password, req.User.NewPassword)
+		if err != nil {
+			log.Printf("changePassword: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if len(errs["email or password"]) != 0 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if len(errs["email"]) != 0 {
+			writeErrors(w, http.StatusForbidden, errs, "changePassword")
+			return
+		} else if errs != nil {
+			writeErrors(w, http.StatusUnprocessableEntity, errs, "changePassword")
+			return
+		}
+		refreshToken, err := s.startSession(u.Id)
+		if err != nil {
+			log.Printf("changePassword: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		user := conduit.User{
+			Id:           u.Id,
+			Email:        u.Email,
+			CreatedAt:    u.CreatedAt,
+			UpdatedAt:    u.UpdatedAt,
+			Token:        s.newAccessToken(u),
+			RefreshToken: refreshToken,
+			Username:     u.Username,
+			Bio:          u.Bio,
+			Image:        u.Image,
+		}
+		data, err := json.Marshal(conduit.UserResponse{User: user})
+		if err != nil {
+			log.Printf("changePassword: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err = s.setSessionCookies(w, user.Token, user.RefreshToken); err != nil {
+			log.Printf("changePassword: %+v\n", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Add("Content-Type", contentType)
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(data)
@@ -0,0 +1,136 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package slug turns article titles into URL-safe slugs.
+//
+// Slugs contain only lower-case ASCII letters, digits, and single hyphens.
+// Latin letters with diacritics are folded to their base letter (so "Crème
+// Brûlée" becomes "creme-brulee"); other punctuation separates words, and
+// anything that can't be folded is dropped. Slugs are capped at MaxLength
+// bytes, cut on a word boundary when possible.
+package slug
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxLength is the longest slug that Make will return.
+const MaxLength = 64
+
+// Fallback is returned when a title has nothing that can be used in a slug.
+const Fallback = "article"
+
+// Make returns the slug for a title.
+// It never returns an empty string.
+func Make(title string) string {
+	var sb strings.Builder
+	hyphen := false // true if a separator is pending
+	for _, r := range title {
+		var s string
+		if 'a' <= r && r <= 'z' || '0' <= r && r <= '9' {
+			s = string(r)
+		} else if 'A' <= r && r <= 'Z' {
+			s = string(r + 'a' - 'A')
+		} else if f, ok := folds[r]; ok {
+			s = f
+		} else if unicode.Is(unicode.Mn, r) || r == '\'' || r == '’' {
+			// combining marks and apostrophes don't split words: "don't" is "dont"
+			continue
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			// can't be represented in ASCII
+			continue
+		} else {
+			hyphen = sb.Len() != 0
+			continue
+		}
+		if hyphen {
+			sb.WriteByte('-')
+			hyphen = false
+		}
+		sb.WriteString(s)
+	}
+	return truncate(sb.String(), MaxLength)
+}
+
+// WithSuffix returns the n'th candidate for a slug.
+// The first candidate is the slug itself; later candidates have "-n"
+// appended, trimming the slug so that the result still fits in MaxLength.
+func WithSuffix(slug string, n int) string {
+	if n <= 1 {
+		return slug
+	}
+	suffix := "-" + strconv.Itoa(n)
+	return truncate(slug, MaxLength-len(suffix)) + suffix
+}
+
+// truncate cuts a slug to at most n bytes, preferring a word boundary.
+func truncate(slug string, n int) string {
+	if len(slug) > n {
+		slug = slug[:n]
+		if i := strings.LastIndexByte(slug, '-'); i > n/2 {
+			slug = slug[:i]
+		}
+		slug = strings.TrimRight(slug, "-")
+	}
+	if slug == "" {
+		return Fallback
+	}
+	return slug
+}
+
+// folds maps letters to their ASCII equivalents.
+var folds = map[rune]string{
+	'À': "a", 'Á': "a", 'Â': "a", 'Ã': "a", 'Ä': "a", 'Å': "a", 'Ā': "a", 'Ă': "a", 'Ą': "a",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'Æ': "ae", 'æ': "ae",
+	'Ç': "c", 'Ć': "c", 'Ĉ': "c", 'Ċ': "c", 'Č': "c",
+	'ç': "c", 'ć': "c", 'ĉ': "c", 'ċ': "c", 'č': "c",
+	'Ð': "d", 'Ď': "d", 'Đ': "d", 'ð': "d", 'ď': "d", 'đ': "d",
+	'È': "e", 'É': "e", 'Ê': "e", 'Ë': "e", 'Ē': "e", 'Ĕ': "e", 'Ė': "e", 'Ę': "e", 'Ě': "e",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ĕ': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'Ĝ': "g", 'Ğ': "g", 'Ġ': "g", 'Ģ': "g", 'ĝ': "g", 'ğ': "g", 'ġ': "g", 'ģ': "g",
+	'Ĥ': "h", 'Ħ': "h", 'ĥ': "h", 'ħ': "h",
+	'Ì': "i", 'Í': "i", 'Î': "i", 'Ï': "i", 'Ĩ': "i", 'Ī': "i", 'Ĭ': "i", 'Į': "i", 'İ': "i",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ĩ': "i", 'ī': "i", 'ĭ': "i", 'į': "i", 'ı': "i",
+	'Ĳ': "ij", 'ĳ': "ij",
+	'Ĵ': "j", 'ĵ': "j",
+	'Ķ': "k", 'ķ': "k",
+	'Ĺ': "l", 'Ļ': "l", 'Ľ': "l", 'Ŀ': "l", 'Ł': "l", 'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ŀ': "l", 'ł': "l",
+	'Ñ': "n", 'Ń': "n", 'Ņ': "n", 'Ň': "n", 'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n",
+	'Ò': "o", 'Ó': "o", 'Ô': "o", 'Õ': "o", 'Ö': "o", 'Ø': "o", 'Ō': "o", 'Ŏ': "o", 'Ő': "o",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ŏ': "o", 'ő': "o",
+	'Œ': "oe", 'œ': "oe",
+	'Ŕ': "r", 'Ŗ': "r", 'Ř': "r", 'ŕ': "r", 'ŗ': "r", 'ř': "r",
+	'Ś': "s", 'Ŝ': "s", 'Ş': "s", 'Š': "s", 'ś': "s", 'ŝ': "s", 'ş': "s", 'š': "s",
+	'ß': "ss",
+	'Ţ': "t", 'Ť': "t", 'Ŧ': "t", 'ţ': "t", 'ť': "t", 'ŧ': "t",
+	'Þ': "th", 'þ': "th",
+	'Ù': "u", 'Ú': "u", 'Û': "u", 'Ü': "u", 'Ũ': "u", 'Ū': "u", 'Ŭ': "u", 'Ů': "u", 'Ű': "u", 'Ų': "u",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ũ': "u", 'ū': "u", 'ŭ': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'Ŵ': "w", 'ŵ': "w",
+	'Ý': "y", 'Ÿ': "y", 'Ŷ': "y", 'ý': "y", 'ÿ': "y", 'ŷ': "y",
+	'Ź': "z", 'Ż': "z", 'Ž': "z", 'ź': "z", 'ż': "z", 'ž': "z",
+}
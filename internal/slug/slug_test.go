@@ -0,0 +1,117 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package slug_test
+
+import (
+	"github.com/mdhender/conduit/internal/slug"
+	"strings"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	// Specification: Slug API
+
+	// Given a title
+	// When we make a slug from it
+	// Then the slug should contain only lower-case ASCII letters, digits, and single hyphens
+	// And it should never be empty
+	// And it should never be longer than slug.MaxLength
+	for _, tc := range []struct {
+		title  string
+		expect string
+	}{
+		{"How to train your dragon", "how-to-train-your-dragon"},
+		{"How to train your dragon?", "how-to-train-your-dragon"},
+		{"  leading and trailing  ", "leading-and-trailing"},
+		{"Multiple   spaces\tand\ttabs", "multiple-spaces-and-tabs"},
+		{"Hyphens -- and -- dashes — galore", "hyphens-and-dashes-galore"},
+		{"Don't panic", "dont-panic"},
+		{"It’s a trap!", "its-a-trap"},
+		{"C++ & Go: a comparison", "c-go-a-comparison"},
+		{"100% pure, 0% fat", "100-pure-0-fat"},
+		{"Cre\u0300me Bru\u0302le\u0301e", "creme-brulee"}, // decomposed accents
+		{"Straße in Köln", "strasse-in-koln"},
+		{"Smørrebrød & Æbleskiver", "smorrebrod-aebleskiver"},
+		{"Łódź", "lodz"},
+		{"ÇA VA?", "ca-va"},
+		{"emoji 🐉 dragons 🐉", "emoji-dragons"},
+		{"日本語のタイトル", slug.Fallback},
+		{"Привет, world", "world"},
+		{"!!!", slug.Fallback},
+		{"", slug.Fallback},
+		{"a/b\\c.d_e", "a-b-c-d-e"},
+		{"<script>alert(1)</script>", "script-alert-1-script"},
+		{"../../etc/passwd", "etc-passwd"},
+		{"Already-a-slug", "already-a-slug"},
+		{strings.Repeat("dragon ", 20), "dragon-dragon-dragon-dragon-dragon-dragon-dragon-dragon-dragon"},
+		{strings.Repeat("x", 100), strings.Repeat("x", slug.MaxLength)},
+	} {
+		got := slug.Make(tc.title)
+		if got != tc.expect {
+			t.Errorf("make: %q: expected %q: got %q\n", tc.title, tc.expect, got)
+		}
+		if len(got) > slug.MaxLength {
+			t.Errorf("make: %q: expected length <= %d: got %d\n", tc.title, slug.MaxLength, len(got))
+		}
+		for _, r := range got {
+			if !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-') {
+				t.Errorf("make: %q: expected URL-safe slug: got %q\n", tc.title, got)
+				break
+			}
+		}
+		if strings.HasPrefix(got, "-") || strings.HasSuffix(got, "-") || strings.Contains(got, "--") {
+			t.Errorf("make: %q: expected single interior hyphens: got %q\n", tc.title, got)
+		}
+	}
+}
+
+func TestWithSuffix(t *testing.T) {
+	// Given a slug and a collision count
+	// When we ask for the candidate slug
+	// Then the first candidate should be the slug itself
+	// And later candidates should be suffixed with the count
+	// And no candidate should be longer than slug.MaxLength
+	long := slug.Make(strings.Repeat("y", 100))
+	for _, tc := range []struct {
+		slug   string
+		n      int
+		expect string
+	}{
+		{"how-to-train-your-dragon", 0, "how-to-train-your-dragon"},
+		{"how-to-train-your-dragon", 1, "how-to-train-your-dragon"},
+		{"how-to-train-your-dragon", 2, "how-to-train-your-dragon-2"},
+		{"how-to-train-your-dragon", 13, "how-to-train-your-dragon-13"},
+		{long, 2, strings.Repeat("y", slug.MaxLength-2) + "-2"},
+		{long, 100, strings.Repeat("y", slug.MaxLength-4) + "-100"},
+	} {
+		got := slug.WithSuffix(tc.slug, tc.n)
+		if got != tc.expect {
+			t.Errorf("withSuffix: %q %d: expected %q: got %q\n", tc.slug, tc.n, tc.expect, got)
+		}
+		if len(got) > slug.MaxLength {
+			t.Errorf("withSuffix: %q %d: expected length <= %d: got %d\n", tc.slug, tc.n, slug.MaxLength, len(got))
+		}
+	}
+}
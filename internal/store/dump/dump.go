@@ -0,0 +1,217 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package dump reads and writes backups of a store in a versioned format
+// that doesn't depend on the store that wrote it.
+//
+// A dump is a stream of JSON objects, one per line, so it can be written
+// and read without holding the whole backup in memory. The first line is
+// a header that names the format and its version. Each line after that
+// holds one record, in the order the store exported them. The last line
+// is a trailer with the number of records, so a dump that was cut short
+// is rejected instead of being restored in part.
+//
+//	{"format":"conduit-dump","version":1,"createdAt":"2021-03-27T16:58:01.233Z"}
+//	{"user":{"id":1,"username":"jake","email":"jake@jake.jake",...}}
+//	{"user":{"id":2,"username":"anne","email":"anne@anne.anne",...}}
+//	{"follow":{"user":1,"target":2}}
+//	{"end":{"records":3}}
+//
+// Readers accept any version up to their own. A change that older
+// readers can't safely ignore must bump the version.
+package dump
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"io"
+	"time"
+)
+
+// Reader and Writer implement the store.RecordReader and store.RecordWriter interfaces.
+var _ store.RecordReader = (*Reader)(nil)
+var _ store.RecordWriter = (*Writer)(nil)
+
+// Format is the name in the header of every dump.
+const Format = "conduit-dump"
+
+// Version is the version of the format written by this package.
+const Version = 1
+
+var ErrFormat = errors.New("not a dump")                // the header or a record is malformed
+var ErrTruncated = errors.New("truncated dump")         // the dump ended before its trailer
+var ErrVersion = errors.New("unsupported dump version") // the dump was written by a newer version
+
+// Export writes a dump of the store to w.
+func Export(w io.Writer, s store.BackupStore) error {
+	dw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := s.Export(dw); err != nil {
+		return err
+	}
+	return dw.Close()
+}
+
+// Import loads the dump read from r into the store, which must be empty.
+func Import(r io.Reader, s store.BackupStore) error {
+	dr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+	return s.Import(dr)
+}
+
+// Header is the first line of a dump.
+type Header struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Writer writes records to a dump.
+// It implements store.RecordWriter.
+type Writer struct {
+	w     *bufio.Writer
+	enc   *json.Encoder
+	count int
+}
+
+// NewWriter writes the header to w and returns a Writer for the records.
+// The caller must call Close to finish the dump.
+func NewWriter(w io.Writer) (*Writer, error) {
+	dw := &Writer{w: bufio.NewWriter(w)}
+	dw.enc = json.NewEncoder(dw.w)
+	err := dw.enc.Encode(Header{
+		Format:    Format,
+		Version:   Version,
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+// WriteRecord implements the store.RecordWriter interface.
+func (dw *Writer) WriteRecord(rec *model.Record) error {
+	var l line
+	switch {
+	case rec.User != nil:
+		l.User = fromUser(rec.User)
+	case rec.Follow != nil:
+		l.Follow = &follow{User: rec.Follow.User, Target: rec.Follow.Target}
+	case rec.Article != nil:
+		l.Article = fromArticle(rec.Article)
+	case rec.Favorite != nil:
+		l.Favorite = &favorite{User: rec.Favorite.User, Article: rec.Favorite.Article}
+	case rec.Comment != nil:
+		l.Comment = fromComment(rec.Comment)
+	default:
+		return fmt.Errorf("dump: empty record")
+	}
+	if err := dw.enc.Encode(l); err != nil {
+		return err
+	}
+	dw.count++
+	return nil
+}
+
+// Close writes the trailer and flushes the dump.
+// It does not close the underlying writer.
+func (dw *Writer) Close() error {
+	if err := dw.enc.Encode(line{End: &end{Records: dw.count}}); err != nil {
+		return err
+	}
+	return dw.w.Flush()
+}
+
+// Reader reads records from a dump.
+// It implements store.RecordReader.
+type Reader struct {
+	Header Header
+
+	dec   *json.Decoder
+	count int
+	done  bool
+}
+
+// NewReader reads and checks the header of the dump.
+func NewReader(r io.Reader) (*Reader, error) {
+	dr := &Reader{dec: json.NewDecoder(bufio.NewReader(r))}
+	if err := dr.dec.Decode(&dr.Header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrFormat, err)
+	} else if dr.Header.Format != Format {
+		return nil, fmt.Errorf("%w: header: format %q", ErrFormat, dr.Header.Format)
+	} else if dr.Header.Version < 1 || dr.Header.Version > Version {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, dr.Header.Version)
+	}
+	return dr, nil
+}
+
+// ReadRecord implements the store.RecordReader interface.
+// It returns io.EOF after the trailer has been read and checked.
+func (dr *Reader) ReadRecord() (*model.Record, error) {
+	if dr.done {
+		return nil, io.EOF
+	}
+	var l line
+	if err := dr.dec.Decode(&l); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrTruncated
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: record %d: %v", ErrFormat, dr.count+1, err)
+	}
+	if n := l.entries(); n != 1 {
+		return nil, fmt.Errorf("%w: record %d: expected 1 entry: got %d", ErrFormat, dr.count+1, n)
+	}
+
+	rec := &model.Record{}
+	switch {
+	case l.End != nil:
+		if l.End.Records != dr.count {
+			return nil, fmt.Errorf("%w: trailer: expected %d records: got %d", ErrFormat, l.End.Records, dr.count)
+		} else if dr.dec.More() {
+			return nil, fmt.Errorf("%w: data after trailer", ErrFormat)
+		}
+		dr.done = true
+		return nil, io.EOF
+	case l.User != nil:
+		rec.User = l.User.asRecord()
+	case l.Follow != nil:
+		rec.Follow = &model.FollowRecord{User: l.Follow.User, Target: l.Follow.Target}
+	case l.Article != nil:
+		rec.Article = l.Article.asRecord()
+	case l.Favorite != nil:
+		rec.Favorite = &model.FavoriteRecord{User: l.Favorite.User, Article: l.Favorite.Article}
+	case l.Comment != nil:
+		rec.Comment = l.Comment.asRecord()
+	}
+	dr.count++
+	return rec, nil
+}
@@ -0,0 +1,158 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package dump_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"github.com/mdhender/conduit/internal/store/dump"
+	"github.com/mdhender/conduit/internal/store/memory"
+	"github.com/mdhender/conduit/internal/store/postgres"
+	"github.com/mdhender/conduit/internal/store/storetest"
+	"io"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestMove(t *testing.T) {
+	// Specification: moving data between stores
+
+	// Given a memory store with users, a follow, an article, a favorite and a comment
+	src, err := memory.New(memory.WithPasswordHasher(storetest.Hasher))
+	if err != nil {
+		t.Fatalf("move: new: %+v\n", err)
+	}
+	jake, _, _ := src.CreateUser("Jacob", "jake@jake.jake", "jakejake")
+	anne, _, _ := src.CreateUser("Anne", "anne@anne.anne", "anneanne")
+	if _, err := src.FollowUserByUsername(jake.Id, "Anne"); err != nil {
+		t.Fatalf("move: follow: %+v\n", err)
+	}
+	a, _, _ := src.CreateArticle(anne.Id, "How to train your dragon", "Ever wonder how?", "You have to believe", []string{"dragons"})
+	if _, err := src.FavoriteArticle(jake.Id, a.Slug); err != nil {
+		t.Fatalf("move: favorite: %+v\n", err)
+	}
+	if _, errs, err := src.AddComment(jake.Id, a.Slug, "Thank you!"); err != nil || errs != nil {
+		t.Fatalf("move: addComment: %v %v\n", errs, err)
+	}
+
+	// When it is dumped
+	// Then the dump should have a header, one line per record and a trailer
+	var b bytes.Buffer
+	if err := dump.Export(&b, src); err != nil {
+		t.Fatalf("move: export: %+v\n", err)
+	}
+	first := b.Bytes()
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if expected := 2 + 6; len(lines) != expected {
+		t.Fatalf("move: export: expected %d lines: got %d\n%s", expected, len(lines), b.String())
+	}
+	if !strings.HasPrefix(lines[0], `{"format":"conduit-dump","version":1,`) {
+		t.Errorf("move: export: expected header: got %s\n", lines[0])
+	}
+	if expected := `{"end":{"records":6}}`; lines[len(lines)-1] != expected {
+		t.Errorf("move: export: expected trailer %s: got %s\n", expected, lines[len(lines)-1])
+	}
+
+	// When the dump is loaded into a SQL store and dumped again
+	// Then the records should be the same
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("move: open: %+v\n", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	dst, err := postgres.New(db, postgres.WithPasswordHasher(storetest.Hasher))
+	if err != nil {
+		t.Fatalf("move: new: %+v\n", err)
+	}
+	if err := dump.Import(bytes.NewReader(first), dst); err != nil {
+		t.Fatalf("move: import: %+v\n", err)
+	}
+	b.Reset()
+	if err := dump.Export(&b, dst); err != nil {
+		t.Fatalf("move: export: %+v\n", err)
+	}
+	again := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if strings.Join(lines[1:], "\n") != strings.Join(again[1:], "\n") {
+		t.Errorf("move: expected\n%s\ngot\n%s\n", strings.Join(lines[1:], "\n"), strings.Join(again[1:], "\n"))
+	}
+
+	// And the users should be able to log in to the SQL store
+	if _, err := dst.Login("anne@anne.anne", "anneanne"); err != nil {
+		t.Errorf("move: login: expected no error: got %+v\n", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	// Specification: reading damaged dumps
+
+	header := `{"format":"conduit-dump","version":1,"createdAt":"2021-03-27T16:58:01.233Z"}` + "\n"
+	user := `{"user":{"id":1,"username":"jake","email":"jake@jake.jake","passwordHash":"x","createdAt":"","updatedAt":""}}` + "\n"
+	for _, tc := range []struct {
+		why   string
+		input string
+		err   error
+	}{
+		{"empty", "", dump.ErrFormat},
+		{"not json", "users,follows\n", dump.ErrFormat},
+		{"other format", `{"format":"other","version":1}` + "\n", dump.ErrFormat},
+		{"newer version", `{"format":"conduit-dump","version":2}` + "\n", dump.ErrVersion},
+		{"no trailer", header + user, dump.ErrTruncated},
+		{"torn record", header + user[:20], dump.ErrTruncated},
+		{"wrong count", header + user + `{"end":{"records":2}}` + "\n", dump.ErrFormat},
+		{"two entries", header + `{"follow":{"user":1,"target":2},"favorite":{"user":1,"article":1}}` + "\n", dump.ErrFormat},
+		{"no entries", header + `{}` + "\n", dump.ErrFormat},
+		{"after trailer", header + user + `{"end":{"records":1}}` + "\n" + user, dump.ErrFormat},
+	} {
+		err := readAll(tc.input)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("reader: %s: expected error %v: got %v\n", tc.why, tc.err, err)
+		}
+	}
+
+	// When a complete dump is read
+	// Then every record should be returned, followed by io.EOF
+	if err := readAll(header + user + `{"end":{"records":1}}` + "\n"); err != nil {
+		t.Errorf("reader: complete: expected no error: got %v\n", err)
+	}
+}
+
+// readAll reads every record in the input.
+func readAll(input string) error {
+	dr, err := dump.NewReader(strings.NewReader(input))
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := dr.ReadRecord(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
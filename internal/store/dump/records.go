@@ -0,0 +1,180 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package dump
+
+import "github.com/mdhender/conduit/internal/store/model"
+
+// line is one line of a dump after the header.
+// Exactly one of the fields is set.
+// The types are separate from the model so that changes to the
+// model don't silently change the format.
+type line struct {
+	User     *user     `json:"user,omitempty"`
+	Follow   *follow   `json:"follow,omitempty"`
+	Article  *article  `json:"article,omitempty"`
+	Favorite *favorite `json:"favorite,omitempty"`
+	Comment  *comment  `json:"comment,omitempty"`
+	End      *end      `json:"end,omitempty"`
+}
+
+// entries returns the number of fields that are set.
+func (l *line) entries() (n int) {
+	for _, set := range []bool{l.User != nil, l.Follow != nil, l.Article != nil, l.Favorite != nil, l.Comment != nil, l.End != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+type user struct {
+	Id                    int      `json:"id"`
+	Username              string   `json:"username"`
+	Email                 string   `json:"email"`
+	PasswordHash          string   `json:"passwordHash"`
+	CreatedAt             string   `json:"createdAt"`
+	UpdatedAt             string   `json:"updatedAt"`
+	Version               int      `json:"version,omitempty"` // missing from dumps made before versions were kept
+	Bio                   *string  `json:"bio,omitempty"`
+	Image                 *string  `json:"image,omitempty"`
+	TokenGeneration       int      `json:"tokenGeneration,omitempty"`
+	Roles                 []string `json:"roles,omitempty"`
+	Suspended             bool     `json:"suspended,omitempty"`
+	PasswordResetRequired bool     `json:"passwordResetRequired,omitempty"`
+}
+
+type follow struct {
+	User   int `json:"user"`
+	Target int `json:"target"`
+}
+
+type article struct {
+	Id          int      `json:"id"`
+	Slug        string   `json:"slug"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Body        string   `json:"body"`
+	TagList     []string `json:"tagList,omitempty"`
+	Author      int      `json:"author"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+	Version     int      `json:"version,omitempty"`
+}
+
+type favorite struct {
+	User    int `json:"user"`
+	Article int `json:"article"`
+}
+
+type comment struct {
+	Id        int    `json:"id"`
+	Article   int    `json:"article"`
+	Author    int    `json:"author"`
+	Body      string `json:"body"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// end is the trailer of a dump.
+type end struct {
+	Records int `json:"records"` // number of lines between the header and the trailer
+}
+
+func fromUser(u *model.UserRecord) *user {
+	return &user{
+		Id:                    u.Id,
+		Username:              u.Username,
+		Email:                 u.Email,
+		PasswordHash:          u.PasswordHash,
+		CreatedAt:             u.CreatedAt,
+		UpdatedAt:             u.UpdatedAt,
+		Version:               u.Version,
+		Bio:                   u.Bio,
+		Image:                 u.Image,
+		TokenGeneration:       u.TokenGeneration,
+		Roles:                 u.Roles,
+		Suspended:             u.Suspended,
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+}
+
+func (u *user) asRecord() *model.UserRecord {
+	return &model.UserRecord{
+		Id:                    u.Id,
+		Username:              u.Username,
+		Email:                 u.Email,
+		PasswordHash:          u.PasswordHash,
+		CreatedAt:             u.CreatedAt,
+		UpdatedAt:             u.UpdatedAt,
+		Version:               u.Version,
+		Bio:                   u.Bio,
+		Image:                 u.Image,
+		TokenGeneration:       u.TokenGeneration,
+		Roles:                 u.Roles,
+		Suspended:             u.Suspended,
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+}
+
+func fromArticle(a *model.ArticleRecord) *article {
+	return &article{
+		Id:          a.Id,
+		Slug:        a.Slug,
+		Aliases:     a.Aliases,
+		Title:       a.Title,
+		Description: a.Description,
+		Body:        a.Body,
+		TagList:     a.TagList,
+		Author:      a.Author,
+		CreatedAt:   a.CreatedAt,
+		UpdatedAt:   a.UpdatedAt,
+		Version:     a.Version,
+	}
+}
+
+func (a *article) asRecord() *model.ArticleRecord {
+	return &model.ArticleRecord{
+		Id:          a.Id,
+		Slug:        a.Slug,
+		Aliases:     a.Aliases,
+		Title:       a.Title,
+		Description: a.Description,
+		Body:        a.Body,
+		TagList:     a.TagList,
+		Author:      a.Author,
+		CreatedAt:   a.CreatedAt,
+		UpdatedAt:   a.UpdatedAt,
+		Version:     a.Version,
+	}
+}
+
+func fromComment(c *model.CommentRecord) *comment {
+	return &comment{Id: c.Id, Article: c.Article, Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt}
+}
+
+func (c *comment) asRecord() *model.CommentRecord {
+	return &model.CommentRecord{Id: c.Id, Article: c.Article, Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt}
+}
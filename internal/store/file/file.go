@@ -0,0 +1,120 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package file implements a store that keeps its data in memory and
+// writes every change to files so that the data survives a restart.
+//
+// Each commit is appended to a write-ahead log and synced before the
+// change is reported as done. Once the log holds enough commits, the
+// whole store is written to a snapshot and the log is emptied.
+// On startup, the snapshot is loaded and the log replayed on top of it.
+// A record at the end of the log that was only partly written when the
+// server died is detected by its length and checksum and discarded.
+package file
+
+import (
+	"github.com/mdhender/conduit/internal/password"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/memory"
+	"os"
+)
+
+// Store implements the store.Store interface.
+var _ store.Store = (*Store)(nil)
+
+// Store is a memory store whose changes are written to a journal.
+type Store struct {
+	*memory.Store
+	journal   *journal
+	passwords *password.Hasher
+}
+
+// Option configures a Store.
+type Option func(*Store) error
+
+// WithCompactAfter sets the number of commits in the log that triggers a snapshot.
+func WithCompactAfter(n int) Option {
+	return func(db *Store) error {
+		db.journal.compactAfter = n
+		return nil
+	}
+}
+
+// WithPasswordHasher sets the hasher used to store and verify passwords.
+func WithPasswordHasher(h *password.Hasher) Option {
+	return func(db *Store) error {
+		db.passwords = h
+		return nil
+	}
+}
+
+// Open loads the store from the files in the directory, creating the
+// directory if it doesn't exist. The caller must call Close when done.
+func Open(path string, options ...Option) (*Store, error) {
+	db := &Store{
+		journal:   &journal{path: path, compactAfter: 1000},
+		passwords: password.NewHasher(""),
+	}
+	for _, option := range options {
+		if err := option(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	records, err := db.journal.open()
+	if err != nil {
+		return nil, err
+	}
+	memoryOptions := []memory.Option{memory.WithPasswordHasher(db.passwords)}
+	for _, r := range records {
+		memoryOptions = append(memoryOptions, memory.WithRecords(r))
+	}
+	memoryOptions = append(memoryOptions, memory.WithJournal(db.journal))
+	if db.Store, err = memory.New(memoryOptions...); err != nil {
+		_ = db.journal.close()
+		return nil, err
+	}
+
+	// start with an empty log so the next restart doesn't replay it again
+	if db.journal.commits != 0 {
+		if err = db.Compact(); err != nil {
+			_ = db.journal.close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// Close writes a snapshot and closes the log.
+// The store must not be used after it is closed.
+func (db *Store) Close() error {
+	err := db.Compact()
+	if cerr := db.journal.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,282 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package file_test
+
+import (
+	"errors"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/file"
+	"github.com/mdhender/conduit/internal/store/storetest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	newStore := func() store.Store {
+		return open(t, t.TempDir())
+	}
+	storetest.Suite(newStore, t)
+}
+
+func TestReopen(t *testing.T) {
+	for _, crash := range []bool{false, true} {
+		dir := t.TempDir()
+		db := open(t, dir)
+		populate(t, db)
+		expected := db.Snapshot()
+		if !crash {
+			if err := db.Close(); err != nil {
+				t.Fatalf("reopen: close: %+v\n", err)
+			}
+		}
+
+		db = open(t, dir)
+		if got := db.Snapshot(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("reopen: crash %v: expected %+v: got %+v\n", crash, expected, got)
+		}
+		if _, err := db.Login("jake@jake.jake", "jakejake"); err != nil {
+			t.Errorf("reopen: crash %v: login: expected no error: got %+v\n", crash, err)
+		}
+		if u, errs, err := db.CreateUser("Bob", "bob@example.com", "bobbob"); err != nil || errs != nil || u.Id != 4 {
+			t.Errorf("reopen: crash %v: create user: expected id 4: got %+v %v %v\n", crash, u, errs, err)
+		}
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, file.WithCompactAfter(3))
+	populate(t, db)
+	expected := db.Snapshot()
+
+	if fi, err := os.Stat(filepath.Join(dir, "snapshot.json")); err != nil || fi.Size() == 0 {
+		t.Errorf("compaction: expected snapshot: got %v\n", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatalf("compaction: read log: %+v\n", err)
+	} else if n := records(t, data); n >= 3 {
+		t.Errorf("compaction: expected fewer than 3 commits in log: got %d\n", n)
+	}
+
+	db = open(t, dir)
+	if got := db.Snapshot(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("compaction: expected %+v: got %+v\n", expected, got)
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tail func(log []byte) []byte
+	}{
+		{"torn header", func(log []byte) []byte {
+			return append(log, 0, 0, 1)
+		}},
+		{"torn payload", func(log []byte) []byte {
+			return append(log, 0, 0, 1, 0, 1, 2, 3, 4, '{', '"')
+		}},
+		{"bad checksum", func(log []byte) []byte {
+			return append(log, 0, 0, 0, 2, 1, 2, 3, 4, '{', '}')
+		}},
+	} {
+		dir := t.TempDir()
+		db := open(t, dir, file.WithCompactAfter(0))
+		populate(t, db)
+		expected := db.Snapshot()
+
+		// the server dies while writing the next commit
+		name := filepath.Join(dir, "wal.log")
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("torn tail: %s: read log: %+v\n", tc.name, err)
+		} else if err = ioutil.WriteFile(name, tc.tail(data), 0600); err != nil {
+			t.Fatalf("torn tail: %s: write log: %+v\n", tc.name, err)
+		}
+
+		db = open(t, dir)
+		if got := db.Snapshot(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("torn tail: %s: expected %+v: got %+v\n", tc.name, expected, got)
+		}
+	}
+}
+
+func TestCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, file.WithCompactAfter(0))
+	populate(t, db)
+
+	// damage the first record; it isn't the last, so it can't be a torn write
+	name := filepath.Join(dir, "wal.log")
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("corrupt: read log: %+v\n", err)
+	}
+	data[9] ^= 0xff
+	if err = ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatalf("corrupt: write log: %+v\n", err)
+	}
+
+	if db, err := file.Open(dir, file.WithPasswordHasher(storetest.Hasher)); !errors.Is(err, file.ErrCorrupt) {
+		t.Errorf("corrupt: expected %v: got %v\n", file.ErrCorrupt, err)
+		if db != nil {
+			_ = db.Close()
+		}
+	}
+}
+
+func TestTransact(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir, file.WithCompactAfter(0))
+	name := filepath.Join(dir, "wal.log")
+	logged := func() int {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("transact: read log: %+v\n", err)
+		}
+		return records(t, data)
+	}
+
+	// a unit of work that succeeds is logged as one record, however much it changes
+	err := db.Transact(func(tx store.Store) error {
+		populate(t, tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transact: commit: expected no error: got %+v\n", err)
+	} else if n := logged(); n != 1 {
+		t.Errorf("transact: commit: expected 1 record: got %d\n", n)
+	}
+
+	// a unit of work that fails isn't logged at all
+	failed := errors.New("failed")
+	err = db.Transact(func(tx store.Store) error {
+		if _, errs, err := tx.CreateUser("Bob", "bob@example.com", "bobbob"); err != nil || errs != nil {
+			t.Fatalf("transact: create user: %v %v\n", errs, err)
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("transact: rollback: expected %v: got %+v\n", failed, err)
+	} else if n := logged(); n != 1 {
+		t.Errorf("transact: rollback: expected 1 record: got %d\n", n)
+	}
+
+	expected := db.Snapshot()
+	db = open(t, dir)
+	if got := db.Snapshot(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("transact: reopen: expected %+v: got %+v\n", expected, got)
+	}
+}
+
+// open opens the store in the directory and closes it when the test ends.
+// Closing a store twice is harmless, so tests may close it themselves.
+func open(t *testing.T, dir string, options ...file.Option) *file.Store {
+	t.Helper()
+	db, err := file.Open(dir, append([]file.Option{file.WithPasswordHasher(storetest.Hasher)}, options...)...)
+	if err != nil {
+		t.Fatalf("open: %+v\n", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// populate adds users, follows, roles, articles, favorites, comments and sessions.
+func populate(t *testing.T, db store.Store) {
+	t.Helper()
+	var ids []int
+	for _, u := range []struct{ username, email, password string }{
+		{"Jacob", "jake@jake.jake", "jakejake"},
+		{"Anne", "anne@anne.anne", "anneanne"},
+		{"Carol", "carol@example.com", "carolcarol"},
+	} {
+		user, errs, err := db.CreateUser(u.username, u.email, u.password)
+		if err != nil || errs != nil {
+			t.Fatalf("populate: create user %q: %v %v\n", u.username, errs, err)
+		}
+		ids = append(ids, user.Id)
+	}
+	jake, anne, carol := ids[0], ids[1], ids[2]
+	bio := "I work at statefarm"
+	if _, errs, err := db.UpdateUser(jake, nil, &bio, nil); err != nil || errs != nil {
+		t.Fatalf("populate: update user: %v %v\n", errs, err)
+	}
+	for _, err := range []error{
+		ignore(db.FollowUserByUsername(jake, "Anne")),
+		ignore(db.FollowUserByUsername(anne, "Jacob")),
+		ignore(db.FollowUserByUsername(carol, "Jacob")),
+		ignore(db.GrantRole(anne, "moderator")),
+	} {
+		if err != nil {
+			t.Fatalf("populate: %+v\n", err)
+		}
+	}
+	a, errs, err := db.CreateArticle(anne, "How to train your dragon", "Ever wonder how?", "You have to believe", []string{"dragons", "training"})
+	if err != nil || errs != nil {
+		t.Fatalf("populate: create article: %v %v\n", errs, err)
+	}
+	title := "How to train your dragon 2"
+	if _, errs, err = db.UpdateArticle(anne, a.Slug, &title, nil, nil, nil); err != nil || errs != nil {
+		t.Fatalf("populate: update article: %v %v\n", errs, err)
+	}
+	if _, errs, err = db.CreateArticle(carol, "Doomed", "Short lived", "Soon gone", []string{"dragons"}); err != nil || errs != nil {
+		t.Fatalf("populate: create article: %v %v\n", errs, err)
+	}
+	if _, errs, err = db.AddComment(jake, a.Slug, "Thank you so much!"); err != nil || errs != nil {
+		t.Fatalf("populate: add comment: %v %v\n", errs, err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	for _, err := range []error{
+		ignore(db.FavoriteArticle(jake, a.Slug)),
+		ignore(db.FavoriteArticle(carol, a.Slug)),
+		db.CreateRefreshToken(jake, "first", expiresAt),
+		ignore(db.RotateRefreshToken("first", "second", expiresAt)),
+		db.RevokeToken("jti", expiresAt),
+		db.DeleteUser(carol),
+	} {
+		if err != nil {
+			t.Fatalf("populate: %+v\n", err)
+		}
+	}
+}
+
+// ignore drops the result of a store method that returns one.
+func ignore(_ interface{}, err error) error {
+	return err
+}
+
+// records returns the number of records in the log.
+func records(t *testing.T, log []byte) (n int) {
+	t.Helper()
+	for len(log) >= 8 {
+		length := int(log[0])<<24 | int(log[1])<<16 | int(log[2])<<8 | int(log[3])
+		log, n = log[8+length:], n+1
+	}
+	return n
+}
@@ -0,0 +1,284 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package file
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdhender/conduit/internal/store/memory"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ErrCorrupt is returned when a file can't be read back.
+// A torn record at the end of the log is not corruption; it is discarded.
+var ErrCorrupt = errors.New("corrupt")
+
+const (
+	logName      = "wal.log"
+	snapshotName = "snapshot.json"
+
+	// snapshotVersion is bumped whenever the format of the snapshot changes.
+	snapshotVersion = 1
+
+	// headerSize is the size of the header on each log record:
+	// the length of the payload and its checksum, both big endian.
+	headerSize = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// journal implements memory.Journal with a write-ahead log and a snapshot.
+// The store calls it with its lock held, so it doesn't need a lock of its own.
+type journal struct {
+	path         string
+	compactAfter int // number of commits in the log that triggers a snapshot
+
+	log     *os.File
+	size    int64  // size of the log up to the end of the last good record
+	seq     uint64 // sequence number of the last commit
+	commits int    // number of commits in the log
+	err     error  // set if the log couldn't be repaired after a failed write
+}
+
+// entry is a commit in the log.
+type entry struct {
+	Seq     uint64          `json:"seq"`
+	Records *memory.Records `json:"records"`
+}
+
+// snapshot is the contents of the snapshot file.
+// Seq is the last commit included, so older commits in the log are skipped.
+type snapshot struct {
+	Version int             `json:"version"`
+	Seq     uint64          `json:"seq"`
+	Records *memory.Records `json:"records"`
+}
+
+var _ memory.Journal = (*journal)(nil)
+
+// Commit appends the records to the log and syncs it.
+// If the write fails, the log is cut back to the last good record.
+func (j *journal) Commit(r *memory.Records) error {
+	if j.err != nil {
+		return j.err
+	} else if j.log == nil {
+		return os.ErrClosed
+	}
+	payload, err := json.Marshal(entry{Seq: j.seq + 1, Records: r})
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	buf = append(buf, payload...)
+
+	if _, err = j.log.Write(buf); err == nil {
+		err = j.log.Sync()
+	}
+	if err != nil {
+		// don't leave a partial record for the next commit to follow
+		if terr := j.truncate(j.size); terr != nil {
+			j.err = fmt.Errorf("wal: %v: can't repair log: %w", err, terr)
+		}
+		return err
+	}
+	j.seq, j.size, j.commits = j.seq+1, j.size+int64(len(buf)), j.commits+1
+	return nil
+}
+
+// Compact writes the snapshot and then empties the log.
+// The snapshot replaces the old one atomically, so a crash at any point
+// leaves either the old snapshot and the full log, or the new snapshot
+// and commits it already includes.
+func (j *journal) Compact(r *memory.Records) error {
+	if j.err != nil {
+		return j.err
+	} else if j.log == nil {
+		return os.ErrClosed
+	}
+	data, err := json.Marshal(snapshot{Version: snapshotVersion, Seq: j.seq, Records: r})
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(j.path, snapshotName)
+	if err = writeFileSync(name+".tmp", data); err != nil {
+		return err
+	} else if err = os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	syncDir(j.path)
+
+	if err = j.truncate(0); err != nil {
+		j.err = fmt.Errorf("wal: can't empty log: %w", err)
+		return err
+	}
+	j.commits = 0
+	return nil
+}
+
+// ShouldCompact returns true once the log holds enough commits.
+func (j *journal) ShouldCompact() bool {
+	return j.compactAfter > 0 && j.commits >= j.compactAfter
+}
+
+// open loads the snapshot and the commits in the log after it, in order.
+// A torn record at the end of the log is cut off.
+// The log is left open for appending.
+func (j *journal) open() ([]*memory.Records, error) {
+	var records []*memory.Records
+	if data, err := ioutil.ReadFile(filepath.Join(j.path, snapshotName)); err == nil {
+		var s snapshot
+		if err = json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("snapshot: %v: %w", err, ErrCorrupt)
+		} else if s.Version != snapshotVersion {
+			return nil, fmt.Errorf("snapshot: unknown version %d", s.Version)
+		} else if s.Records == nil {
+			return nil, fmt.Errorf("snapshot: no records: %w", ErrCorrupt)
+		}
+		records, j.seq = append(records, s.Records), s.Seq
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(j.path, logName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.log = log
+	entries, err := j.replay()
+	if err != nil {
+		_ = j.close()
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Seq <= j.seq { // already in the snapshot
+			continue
+		} else if e.Seq != j.seq+1 {
+			_ = j.close()
+			return nil, fmt.Errorf("wal: expected commit %d: got %d: %w", j.seq+1, e.Seq, ErrCorrupt)
+		}
+		records, j.seq = append(records, e.Records), e.Seq
+		j.commits++
+	}
+	return records, nil
+}
+
+// replay reads every record in the log.
+// If the last record is torn, the log is truncated to the end of the record before it.
+func (j *journal) replay() ([]entry, error) {
+	fi, err := j.log.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, fi.Size())
+	if _, err = io.ReadFull(j.log, data); err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	var offset int64
+	for offset < int64(len(data)) {
+		rest := data[offset:]
+		if len(rest) < headerSize {
+			break // torn header
+		}
+		length := int64(binary.BigEndian.Uint32(rest[0:4]))
+		if headerSize+length > int64(len(rest)) {
+			break // torn payload
+		}
+		payload := rest[headerSize : headerSize+length]
+		end := offset + headerSize + length
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(rest[4:8]) {
+			if end == int64(len(data)) {
+				break // torn payload that happens to fill the file
+			}
+			return nil, fmt.Errorf("wal: record at %d: bad checksum: %w", offset, ErrCorrupt)
+		}
+		var e entry
+		if err = json.Unmarshal(payload, &e); err != nil || e.Records == nil {
+			return nil, fmt.Errorf("wal: record at %d: %v: %w", offset, err, ErrCorrupt)
+		}
+		entries = append(entries, e)
+		offset = end
+	}
+
+	if err = j.truncate(offset); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// truncate cuts the log to the size and positions it for appending.
+func (j *journal) truncate(size int64) error {
+	if err := j.log.Truncate(size); err != nil {
+		return err
+	} else if _, err = j.log.Seek(size, io.SeekStart); err != nil {
+		return err
+	} else if err = j.log.Sync(); err != nil {
+		return err
+	}
+	j.size = size
+	return nil
+}
+
+func (j *journal) close() error {
+	if j.log == nil {
+		return nil
+	}
+	err := j.log.Close()
+	j.log = nil
+	return err
+}
+
+// writeFileSync writes the file and syncs it before closing it.
+func writeFileSync(name string, data []byte) error {
+	fp, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = fp.Write(data); err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncDir makes a rename in the directory durable.
+// Not every platform can sync a directory, so errors are ignored.
+func syncDir(path string) {
+	if dir, err := os.Open(path); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/mdhender/conduit/internal/store/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChangePassword replaces the user's password if the current one matches.
+// It clears any required reset and revokes all of the user's sessions.
+func (db *Store) ChangePassword(email, password, newPassword string) (*model.User, map[string][]string, error) {
+	errs := make(map[string][]string)
+	if newPassword = strings.TrimSpace(newPassword); newPassword == "" {
+		errs["newPassword"] = append(errs["newPassword"], "can't be blank")
+		return nil, errs, nil
+	}
+	user, hash, _ := db.authenticate(email, password)
+	if user == nil {
+		errs["email or password"] = append(errs["email or password"], "is invalid")
+		return nil, errs, nil
+	}
+	h, err := db.passwords.Hash(newPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, nil, db.failed
+	}
+	if db.users.id[user.Id] != user || user.PasswordHash != hash { // changed while we were verifying
+		errs["email or password"] = append(errs["email or password"], "is invalid")
+		return nil, errs, nil
+	} else if user.Suspended {
+		errs["email"] = append(errs["email"], "is suspended")
+		return nil, errs, nil
+	}
+	user.PasswordHash = h
+	user.PasswordResetRequired = false
+	db.revokeSessions(user)
+	user.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z")
+	user.Version++
+	if err := db.commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return user.AsModelUser(), nil, nil
+}
+
+// DeleteUser deletes the user along with their articles, comments,
+// favorites, follows and sessions.
+func (db *Store) DeleteUser(id int) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+	if id == 0 {
+		return ErrNotAuthorized
+	}
+	user, ok := db.users.id[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	for _, a := range db.articles.author[id] {
+		db.removeArticle(a)
+	}
+	for _, a := range user.Favorites {
+		delete(a.FavoritedBy, id)
+		db.touchArticle(a.Id)
+	}
+	for _, a := range db.articles.id {
+		for commentId, c := range a.Comments {
+			if c.Author == user {
+				delete(a.Comments, commentId)
+				db.touchArticle(a.Id)
+			}
+		}
+	}
+	for _, other := range db.users.id {
+		if other.Following[id] != nil {
+			delete(other.Following, id)
+			db.touchUser(other.Id)
+		}
+	}
+	db.revokeSessions(user)
+	delete(db.users.email, user.Email)
+	delete(db.users.name, user.Username)
+	delete(db.users.id, user.Id)
+
+	return db.commit()
+}
+
+// ListUsers returns the users that match the filter, oldest first,
+// along with the number of users that matched.
+func (db *Store) ListUsers(filter model.UserFilter) ([]*model.User, int, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	search := strings.ToLower(strings.TrimSpace(filter.Search))
+	var users []*User
+	for _, u := range db.users.id {
+		if search == "" || strings.Contains(strings.ToLower(u.Username), search) || strings.Contains(strings.ToLower(u.Email), search) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Id < users[j].Id
+	})
+
+	count := len(users)
+	if filter.Offset >= len(users) {
+		return nil, count, nil
+	}
+	users = users[filter.Offset:]
+	if filter.Limit < len(users) {
+		users = users[:filter.Limit]
+	}
+	var list []*model.User
+	for _, u := range users {
+		list = append(list, u.AsModelUser())
+	}
+	return list, count, nil
+}
+
+// RequirePasswordReset stops the user from logging in until they change
+// their password, and revokes all of their sessions.
+func (db *Store) RequirePasswordReset(id int) (*model.User, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
+	if id == 0 {
+		return nil, ErrNotAuthorized
+	}
+	user, ok := db.users.id[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user.PasswordResetRequired = true
+	db.revokeSessions(user)
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
+	return user.AsModelUser(), nil
+}
+
+// SuspendUser suspends or restores the user.
+// While suspended, the user can't log in or refresh their sessions.
+func (db *Store) SuspendUser(id int, suspended bool) (*model.User, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
+	if id == 0 {
+		return nil, ErrNotAuthorized
+	}
+	user, ok := db.users.id[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user.Suspended = suspended
+	db.touchUser(user.Id)
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
+	return user.AsModelUser(), nil
+}
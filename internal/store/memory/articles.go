@@ -0,0 +1,446 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/mdhender/conduit/internal/slug"
+	"github.com/mdhender/conduit/internal/store/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (db *Store) CreateArticle(id int, title, description, body string, tagList []string) (*model.Article, map[string][]string, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, nil, db.failed
+	}
+	errs := make(map[string][]string)
+
+	author := db.users.id[id]
+	if id == 0 || author == nil {
+		errs["author"] = append(errs["author"], "must be a registered user")
+	}
+	if title = strings.TrimSpace(title); title == "" {
+		errs["title"] = append(errs["title"], "can't be blank")
+	}
+	if description = strings.TrimSpace(description); description == "" {
+		errs["description"] = append(errs["description"], "can't be blank")
+	}
+	if body = strings.TrimSpace(body); body == "" {
+		errs["body"] = append(errs["body"], "can't be blank")
+	}
+	if len(errs) != 0 {
+		return nil, errs, nil
+	}
+
+	db.articles.seq++
+	a := &Article{
+		Id:          db.articles.seq,
+		Title:       title,
+		Description: description,
+		Body:        body,
+		CreatedAt:   time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
+		UpdatedAt:   time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
+		Version:     1,
+		Author:      author,
+		FavoritedBy: make(map[int]*User),
+		Comments:    make(map[int]*Comment),
+	}
+	db.articles.id[a.Id] = a
+	db.setSlug(a)
+	if db.articles.author[author.Id] == nil {
+		db.articles.author[author.Id] = make(map[int]*Article)
+	}
+	db.articles.author[author.Id][a.Id] = a
+	db.setTags(a, tagList)
+	db.touchArticle(a.Id)
+	if err := db.commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return a.AsModelArticle(author), nil, nil
+}
+
+// DeleteArticle deletes the article along with its comments, favorites, and slugs.
+// Only the author of the article is allowed to delete it.
+func (db *Store) DeleteArticle(id int, slug string) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+
+	user := db.users.id[id]
+	if id == 0 || user == nil {
+		return ErrNotAuthorized
+	}
+	a := db.articles.slug[slug]
+	if a == nil {
+		return ErrNotFound
+	} else if a.Author != user {
+		return ErrForbidden
+	}
+
+	db.removeArticle(a)
+
+	return db.commit()
+}
+
+// FavoriteArticle adds the article to the favorites of the user with the given id.
+// Favoriting an article more than once has no effect.
+func (db *Store) FavoriteArticle(id int, slug string) (*model.Article, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
+
+	user := db.users.id[id]
+	if id == 0 || user == nil {
+		return nil, ErrNotAuthorized
+	}
+	a := db.articles.slug[slug]
+	if a == nil {
+		return nil, ErrNotFound
+	}
+	a.FavoritedBy[user.Id] = user
+	user.Favorites[a.Id] = a
+	db.touchArticle(a.Id)
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
+
+	return a.AsModelArticle(user), nil
+}
+
+// FeedArticles returns the articles written by the users that the user with
+// the given id follows, newest first. It also returns the number of articles
+// in the feed before the limit and offset were applied.
+func (db *Store) FeedArticles(id, limit, offset int) ([]*model.Article, int, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	user := db.users.id[id]
+	if id == 0 || user == nil {
+		return nil, 0, ErrNotAuthorized
+	}
+
+	var matches []*Article
+	for authorId := range user.Following {
+		for _, a := range db.articles.author[authorId] {
+			matches = append(matches, a)
+		}
+	}
+
+	return db.page(matches, id, limit, offset), len(matches), nil
+}
+
+// GetArticleBySlug returns the article as seen by the user with the given id.
+// The slug may be the article's current slug or one it used before being renamed.
+func (db *Store) GetArticleBySlug(id int, slug string) (*model.Article, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	a := db.articles.slug[slug]
+	if a == nil {
+		return nil, ErrNotFound
+	}
+	return a.AsModelArticle(db.users.id[id]), nil
+}
+
+// ListArticles returns the articles that match the filter, newest first,
+// as seen by the user with the given id. It also returns the number of
+// articles that matched before the limit and offset were applied.
+func (db *Store) ListArticles(id int, filter model.ArticleFilter) ([]*model.Article, int, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	// start with the smallest index that applies to the filter
+	candidates := db.articles.id
+	if filter.Author != "" {
+		author := db.users.name[filter.Author]
+		if author == nil {
+			return nil, 0, nil
+		}
+		candidates = db.articles.author[author.Id]
+	}
+	filter.Tag = normalizeTag(filter.Tag)
+	if filter.Tag != "" {
+		if tagged := db.articles.tag[filter.Tag]; len(tagged) < len(candidates) {
+			candidates = tagged
+		}
+	}
+	if filter.Favorited != "" {
+		fan := db.users.name[filter.Favorited]
+		if fan == nil {
+			return nil, 0, nil
+		} else if len(fan.Favorites) < len(candidates) {
+			candidates = fan.Favorites
+		}
+	}
+
+	var matches []*Article
+	for _, a := range candidates {
+		if filter.Author != "" && a.Author.Username != filter.Author {
+			continue
+		} else if filter.Tag != "" && !a.HasTag(filter.Tag) {
+			continue
+		} else if filter.Favorited != "" && !a.IsFavoritedBy(filter.Favorited) {
+			continue
+		}
+		matches = append(matches, a)
+	}
+
+	return db.page(matches, id, filter.Limit, filter.Offset), len(matches), nil
+}
+
+// UnfavoriteArticle removes the article from the favorites of the user with the given id.
+// Unfavoriting an article that isn't a favorite has no effect.
+func (db *Store) UnfavoriteArticle(id int, slug string) (*model.Article, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
+
+	user := db.users.id[id]
+	if id == 0 || user == nil {
+		return nil, ErrNotAuthorized
+	}
+	a := db.articles.slug[slug]
+	if a == nil {
+		return nil, ErrNotFound
+	}
+	delete(a.FavoritedBy, user.Id)
+	delete(user.Favorites, a.Id)
+	db.touchArticle(a.Id)
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
+
+	return a.AsModelArticle(user), nil
+}
+
+// UpdateArticle updates the article with the given slug.
+// Only the author of the article is allowed to update it.
+// A nil tag list leaves the tags alone; an empty one removes them.
+// If the title changes, the article is given a new slug and the old
+// slug is kept as an alias so that existing links still resolve.
+func (db *Store) UpdateArticle(id int, slug string, title, description, body *string, tagList *[]string) (*model.Article, map[string][]string, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, nil, db.failed
+	}
+	errs := make(map[string][]string)
+
+	a := db.articles.slug[slug]
+	if a == nil {
+		return nil, nil, ErrNotFound
+	} else if id == 0 {
+		return nil, nil, ErrNotAuthorized
+	} else if a.Author.Id != id {
+		return nil, nil, ErrForbidden
+	}
+
+	cp, changes := *a, false
+	if title != nil {
+		if val := strings.TrimSpace(*title); val == "" {
+			errs["title"] = append(errs["title"], "must not be empty if provided")
+		} else if val != a.Title {
+			cp.Title = val
+			changes = true
+		}
+	}
+	if description != nil {
+		if val := strings.TrimSpace(*description); val == "" {
+			errs["description"] = append(errs["description"], "must not be empty if provided")
+		} else if val != a.Description {
+			cp.Description = val
+			changes = true
+		}
+	}
+	if body != nil {
+		if val := strings.TrimSpace(*body); val == "" {
+			errs["body"] = append(errs["body"], "must not be empty if provided")
+		} else if val != a.Body {
+			cp.Body = val
+			changes = true
+		}
+	}
+	if tagList != nil {
+		if tags := normalizeTags(*tagList); !equalTags(tags, a.TagList) {
+			cp.TagList = tags
+			changes = true
+		}
+	}
+	if len(errs) != 0 {
+		return nil, errs, nil
+	}
+
+	if changes {
+		a.Description, a.Body = cp.Description, cp.Body
+		if a.Title != cp.Title {
+			a.Title = cp.Title
+			db.setSlug(a)
+		}
+		if tagList != nil {
+			db.setTags(a, cp.TagList)
+		}
+		a.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z")
+		a.Version++
+		db.touchArticle(a.Id)
+		if err := db.commit(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return a.AsModelArticle(a.Author), nil, nil
+}
+
+// page sorts the articles newest first and returns the requested page
+// as seen by the user with the given id.
+func (db *Store) page(articles []*Article, id, limit, offset int) []*model.Article {
+	sort.Slice(articles, func(i, j int) bool {
+		return articles[i].Id > articles[j].Id
+	})
+	if offset >= len(articles) {
+		return nil
+	}
+	articles = articles[offset:]
+	if limit < len(articles) {
+		articles = articles[:limit]
+	}
+	user := db.users.id[id]
+	var list []*model.Article
+	for _, a := range articles {
+		list = append(list, a.AsModelArticle(user))
+	}
+	return list
+}
+
+// removeArticle deletes the article and its comments, favorites and tags.
+func (db *Store) removeArticle(a *Article) {
+	for _, fan := range a.FavoritedBy {
+		delete(fan.Favorites, a.Id)
+	}
+	db.setTags(a, nil)
+	delete(db.articles.author[a.Author.Id], a.Id)
+	if len(db.articles.author[a.Author.Id]) == 0 {
+		delete(db.articles.author, a.Author.Id)
+	}
+	for _, alias := range a.Aliases {
+		delete(db.articles.slug, alias)
+	}
+	delete(db.articles.slug, a.Slug)
+	delete(db.articles.id, a.Id)
+	db.touchArticle(a.Id)
+}
+
+// setSlug derives the slug for an article from its title.
+// If the slug is already used by another article, a numeric suffix is added.
+// Any prior slug is kept as an alias that continues to resolve to the article.
+// Slugs are deterministic: re-using a prior title restores the prior slug.
+func (db *Store) setSlug(a *Article) {
+	base := slug.Make(a.Title)
+	for n := 1; ; n++ {
+		candidate := slug.WithSuffix(base, n)
+		if owner := db.articles.slug[candidate]; owner == nil || owner == a {
+			if a.Slug != "" && a.Slug != candidate {
+				aliases := []string{a.Slug}
+				for _, alias := range a.Aliases {
+					if alias != candidate {
+						aliases = append(aliases, alias)
+					}
+				}
+				a.Aliases = aliases
+			}
+			a.Slug = candidate
+			db.articles.slug[a.Slug] = a
+			return
+		}
+	}
+}
+
+type Article struct {
+	Id          int
+	Slug        string
+	Title       string
+	Description string
+	Body        string
+	TagList     []string
+	CreatedAt   string // "2021-03-27T16:58:01.233Z"
+	UpdatedAt   string // "2021-03-27T16:58:01.245Z"
+	Version     int    // bumped along with UpdatedAt
+	Author      *User
+	Aliases     []string         // slugs used before the article was renamed
+	FavoritedBy map[int]*User    // map of Id of users that favorited the article
+	Comments    map[int]*Comment // map of Id of comments on the article; deleted with the article
+}
+
+// AsModelArticle returns a copy of the article as seen by the user p.
+// The user may be nil (for example, when the request isn't authenticated).
+func (a *Article) AsModelArticle(p *User) *model.Article {
+	if a == nil {
+		return &model.Article{}
+	}
+	article := &model.Article{
+		Id:          a.Id,
+		Slug:        a.Slug,
+		Title:       a.Title,
+		Description: a.Description,
+		Body:        a.Body,
+		CreatedAt:   a.CreatedAt,
+		UpdatedAt:   a.UpdatedAt,
+		Version:     a.Version,
+		Author:      *a.Author.AsModelProfile(p),
+	}
+	article.Favorited = p != nil && a.FavoritedBy[p.Id] != nil
+	article.FavoritesCount = len(a.FavoritedBy)
+	article.TagList = append(article.TagList, a.TagList...)
+	return article
+}
+
+// HasTag returns true if the article is tagged with the tag.
+func (a *Article) HasTag(tag string) bool {
+	for _, t := range a.TagList {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFavoritedBy returns true if the user with the given username favorited the article.
+func (a *Article) IsFavoritedBy(username string) bool {
+	for _, user := range a.FavoritedBy {
+		if user.Username == username {
+			return true
+		}
+	}
+	return false
+}
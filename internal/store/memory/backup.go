@@ -0,0 +1,270 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"fmt"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"io"
+	"sort"
+)
+
+// Export writes a copy of every user, follow, article, favorite and comment.
+// The records are copied under the lock and written after it is released,
+// so a slow writer doesn't hold up the rest of the server.
+func (db *Store) Export(w store.RecordWriter) error {
+	db.Lock()
+	records := db.exportRecords()
+	db.Unlock()
+
+	for _, rec := range records {
+		if err := w.WriteRecord(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import loads the records into an empty store.
+// Every record is read and checked before the lock is taken,
+// so a bad record leaves the store untouched.
+func (db *Store) Import(r store.RecordReader) error {
+	recs, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+	if len(db.users.id) != 0 || len(db.articles.id) != 0 {
+		return ErrNotEmpty
+	}
+	// keep the sequences ahead of the imported ids
+	recs.Sequences = db.sequences()
+	for _, u := range recs.Users {
+		if u.Id > recs.Sequences.Users {
+			recs.Sequences.Users = u.Id
+		}
+	}
+	for _, a := range recs.Articles {
+		if a.Id > recs.Sequences.Articles {
+			recs.Sequences.Articles = a.Id
+		}
+		for _, c := range a.Comments {
+			if c.Id > recs.Sequences.Comments {
+				recs.Sequences.Comments = c.Id
+			}
+		}
+	}
+	if err := db.apply(recs); err != nil {
+		return err
+	}
+	for _, u := range recs.Users {
+		db.touchUser(u.Id)
+	}
+	for _, a := range recs.Articles {
+		db.touchArticle(a.Id)
+	}
+	return db.commit()
+}
+
+// exportRecords returns the records in the order described by model.Record.
+// The caller must hold the lock.
+func (db *Store) exportRecords() []*model.Record {
+	var users []UserRecord
+	for _, u := range db.users.id {
+		users = append(users, u.asRecord())
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	var articles []ArticleRecord
+	for _, a := range db.articles.id {
+		articles = append(articles, a.asRecord())
+	}
+	sort.Slice(articles, func(i, j int) bool { return articles[i].Id < articles[j].Id })
+
+	var records, follows, favorites, comments []*model.Record
+	for _, u := range users {
+		rec := &model.UserRecord{
+			Id:                    u.Id,
+			Username:              u.Username,
+			Email:                 u.Email,
+			PasswordHash:          u.PasswordHash,
+			CreatedAt:             u.CreatedAt,
+			UpdatedAt:             u.UpdatedAt,
+			Version:               u.Version,
+			Bio:                   u.Bio,
+			Image:                 u.Image,
+			TokenGeneration:       u.TokenGeneration,
+			Roles:                 u.Roles,
+			Suspended:             u.Suspended,
+			PasswordResetRequired: u.PasswordResetRequired,
+		}
+		records = append(records, &model.Record{User: rec})
+		for _, target := range u.Following {
+			follows = append(follows, &model.Record{Follow: &model.FollowRecord{User: u.Id, Target: target}})
+		}
+	}
+	records = append(records, follows...)
+	for _, a := range articles {
+		rec := &model.ArticleRecord{
+			Id:          a.Id,
+			Slug:        a.Slug,
+			Aliases:     a.Aliases,
+			Title:       a.Title,
+			Description: a.Description,
+			Body:        a.Body,
+			TagList:     a.TagList,
+			Author:      a.Author,
+			CreatedAt:   a.CreatedAt,
+			UpdatedAt:   a.UpdatedAt,
+			Version:     a.Version,
+		}
+		records = append(records, &model.Record{Article: rec})
+		for _, fan := range a.FavoritedBy {
+			favorites = append(favorites, &model.Record{Favorite: &model.FavoriteRecord{User: fan, Article: a.Id}})
+		}
+		for _, c := range a.Comments {
+			rec := &model.CommentRecord{Id: c.Id, Article: a.Id, Author: c.Author, Body: c.Body, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt}
+			comments = append(comments, &model.Record{Comment: rec})
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].Comment.Id < comments[j].Comment.Id })
+	records = append(records, favorites...)
+	return append(records, comments...)
+}
+
+// readRecords reads the records from r and checks that they can be loaded:
+// ids and unique fields aren't repeated, and every reference is to a
+// record that was read earlier.
+func readRecords(r store.RecordReader) (*Records, error) {
+	recs := &Records{}
+	users := make(map[int]*UserRecord)
+	usernames, emails := make(map[string]bool), make(map[string]bool)
+	articles := make(map[int]*ArticleRecord)
+	slugs := make(map[string]bool)
+	comments := make(map[int]bool)
+
+	// the records are collected by id and copied out at the end,
+	// because appending to the slices would move them
+	var userIds, articleIds []int
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case rec.User != nil:
+			u := rec.User
+			if u.Id <= 0 || users[u.Id] != nil {
+				return nil, fmt.Errorf("import: user %d: invalid or duplicate id", u.Id)
+			} else if u.Username == "" || usernames[u.Username] {
+				return nil, fmt.Errorf("import: user %d: invalid or duplicate username %q", u.Id, u.Username)
+			} else if u.Email == "" || emails[u.Email] {
+				return nil, fmt.Errorf("import: user %d: invalid or duplicate email %q", u.Id, u.Email)
+			}
+			users[u.Id] = &UserRecord{
+				Id:                    u.Id,
+				Username:              u.Username,
+				Email:                 u.Email,
+				PasswordHash:          u.PasswordHash,
+				CreatedAt:             u.CreatedAt,
+				UpdatedAt:             u.UpdatedAt,
+				Version:               u.Version,
+				Bio:                   u.Bio,
+				Image:                 u.Image,
+				TokenGeneration:       u.TokenGeneration,
+				Roles:                 u.Roles,
+				Suspended:             u.Suspended,
+				PasswordResetRequired: u.PasswordResetRequired,
+			}
+			userIds, usernames[u.Username], emails[u.Email] = append(userIds, u.Id), true, true
+		case rec.Follow != nil:
+			f := rec.Follow
+			if users[f.User] == nil || users[f.Target] == nil {
+				return nil, fmt.Errorf("import: follow %d -> %d: unknown user", f.User, f.Target)
+			} else if f.User == f.Target {
+				return nil, fmt.Errorf("import: follow %d -> %d: user can't follow self", f.User, f.Target)
+			}
+			users[f.User].Following = append(users[f.User].Following, f.Target)
+		case rec.Article != nil:
+			a := rec.Article
+			if a.Id <= 0 || articles[a.Id] != nil {
+				return nil, fmt.Errorf("import: article %d: invalid or duplicate id", a.Id)
+			} else if users[a.Author] == nil {
+				return nil, fmt.Errorf("import: article %d: unknown author %d", a.Id, a.Author)
+			}
+			for _, slug := range append([]string{a.Slug}, a.Aliases...) {
+				if slug == "" || slugs[slug] {
+					return nil, fmt.Errorf("import: article %d: invalid or duplicate slug %q", a.Id, slug)
+				}
+				slugs[slug] = true
+			}
+			articles[a.Id] = &ArticleRecord{
+				Id:          a.Id,
+				Slug:        a.Slug,
+				Aliases:     a.Aliases,
+				Title:       a.Title,
+				Description: a.Description,
+				Body:        a.Body,
+				TagList:     a.TagList,
+				CreatedAt:   a.CreatedAt,
+				UpdatedAt:   a.UpdatedAt,
+				Version:     a.Version,
+				Author:      a.Author,
+			}
+			articleIds = append(articleIds, a.Id)
+		case rec.Favorite != nil:
+			f := rec.Favorite
+			if users[f.User] == nil || articles[f.Article] == nil {
+				return nil, fmt.Errorf("import: favorite %d -> %d: unknown user or article", f.User, f.Article)
+			}
+			articles[f.Article].FavoritedBy = append(articles[f.Article].FavoritedBy, f.User)
+		case rec.Comment != nil:
+			c := rec.Comment
+			if c.Id <= 0 || comments[c.Id] {
+				return nil, fmt.Errorf("import: comment %d: invalid or duplicate id", c.Id)
+			} else if users[c.Author] == nil || articles[c.Article] == nil {
+				return nil, fmt.Errorf("import: comment %d: unknown author or article", c.Id)
+			}
+			articles[c.Article].Comments = append(articles[c.Article].Comments, CommentRecord{Id: c.Id, Body: c.Body, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt, Author: c.Author})
+			comments[c.Id] = true
+		default:
+			return nil, fmt.Errorf("import: empty record")
+		}
+	}
+	for _, id := range userIds {
+		recs.Users = append(recs.Users, *users[id])
+	}
+	for _, id := range articleIds {
+		recs.Articles = append(recs.Articles, *articles[id])
+	}
+	return recs, nil
+}
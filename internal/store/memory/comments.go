@@ -0,0 +1,173 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/mdhender/conduit/internal/store/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AddComment adds a comment from the user with the given id to the article.
+func (db *Store) AddComment(id int, slug, body string) (*model.Comment, map[string][]string, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, nil, db.failed
+	}
+	errs := make(map[string][]string)
+
+	author := db.users.id[id]
+	if id == 0 || author == nil {
+		errs["author"] = append(errs["author"], "must be a registered user")
+	}
+	a := db.articles.slug[slug]
+	if a == nil {
+		errs["article"] = append(errs["article"], "not found")
+	}
+	if body = strings.TrimSpace(body); body == "" {
+		errs["body"] = append(errs["body"], "can't be blank")
+	}
+	if len(errs) != 0 {
+		return nil, errs, nil
+	}
+
+	db.comments.seq++
+	c := &Comment{
+		Id:        db.comments.seq,
+		Body:      body,
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
+		UpdatedAt: time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
+		Author:    author,
+	}
+	a.Comments[c.Id] = c
+	db.touchArticle(a.Id)
+	if err := db.commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return c.AsModelComment(author), nil, nil
+}
+
+// DeleteComment deletes a comment from the article.
+// Only the author of the comment is allowed to delete it.
+func (db *Store) DeleteComment(id int, slug string, commentId int) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+
+	user := db.users.id[id]
+	if id == 0 || user == nil {
+		return ErrNotAuthorized
+	}
+	a := db.articles.slug[slug]
+	if a == nil {
+		return ErrNotFound
+	}
+	c := a.Comments[commentId]
+	if c == nil {
+		return ErrNotFound
+	} else if c.Author != user {
+		return ErrForbidden
+	}
+	delete(a.Comments, c.Id)
+	db.touchArticle(a.Id)
+
+	return db.commit()
+}
+
+// GetComments returns the comments on the article, oldest first,
+// as seen by the user with the given id.
+func (db *Store) GetComments(id int, slug string) ([]*model.Comment, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	a := db.articles.slug[slug]
+	if a == nil {
+		return nil, ErrNotFound
+	}
+
+	var comments []*Comment
+	for _, c := range a.Comments {
+		comments = append(comments, c)
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].Id < comments[j].Id
+	})
+
+	user := db.users.id[id]
+	var list []*model.Comment
+	for _, c := range comments {
+		list = append(list, c.AsModelComment(user))
+	}
+	return list, nil
+}
+
+// RemoveComment deletes the comment whoever wrote it.
+func (db *Store) RemoveComment(slug string, commentId int) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+
+	a := db.articles.slug[slug]
+	if a == nil {
+		return ErrNotFound
+	}
+	if a.Comments[commentId] == nil {
+		return ErrNotFound
+	}
+	delete(a.Comments, commentId)
+	db.touchArticle(a.Id)
+
+	return db.commit()
+}
+
+type Comment struct {
+	Id        int
+	Body      string
+	CreatedAt string // "2021-03-27T16:58:01.233Z"
+	UpdatedAt string // "2021-03-27T16:58:01.245Z"
+	Author    *User
+}
+
+// AsModelComment returns a copy of the comment as seen by the user p.
+// The user may be nil (for example, when the request isn't authenticated).
+func (c *Comment) AsModelComment(p *User) *model.Comment {
+	if c == nil {
+		return &model.Comment{}
+	}
+	return &model.Comment{
+		Id:        c.Id,
+		Body:      c.Body,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		Author:    *c.Author.AsModelProfile(p),
+	}
+}
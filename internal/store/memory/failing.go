@@ -0,0 +1,56 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import "errors"
+
+// ErrDiskFull is the error returned by a FailingJournal once it is broken.
+var ErrDiskFull = errors.New("disk full")
+
+// FailingJournal is a Journal for tests. It keeps nothing, and once
+// Broken is set it fails every commit the way a full disk would.
+type FailingJournal struct {
+	Broken  bool
+	Commits int // the number of commits that succeeded
+}
+
+func (j *FailingJournal) Commit(r *Records) error {
+	if j.Broken {
+		return ErrDiskFull
+	}
+	j.Commits++
+	return nil
+}
+
+func (j *FailingJournal) Compact(snapshot *Records) error {
+	if j.Broken {
+		return ErrDiskFull
+	}
+	return nil
+}
+
+func (j *FailingJournal) ShouldCompact() bool {
+	return false
+}
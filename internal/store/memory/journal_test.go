@@ -0,0 +1,114 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"errors"
+	"github.com/mdhender/conduit/internal/password"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/storetest"
+	"testing"
+)
+
+func TestJournalFailure(t *testing.T) {
+	journal := &FailingJournal{}
+	db, err := New(WithJournal(journal), WithPasswordHasher(storetest.Hasher))
+	if err != nil {
+		t.Fatalf("memory: new: %+v\n", err)
+	}
+	jake, errs, err := db.CreateUser("Jacob", "jake@jake.jake", "jakejake")
+	if err != nil || errs != nil {
+		t.Fatalf("memory: create user: %v %v\n", errs, err)
+	}
+
+	// a unit of work that fails to commit is rolled back
+	// and leaves the store accepting changes
+	journal.Broken = true
+	bio := "I work at statefarm"
+	err = db.Transact(func(tx store.Store) error {
+		_, _, err := tx.UpdateUser(jake.Id, nil, &bio, nil)
+		return err
+	})
+	if !errors.Is(err, ErrDiskFull) {
+		t.Errorf("memory: transact: expected %v: got %v\n", ErrDiskFull, err)
+	}
+	if u, err := db.GetUser(jake.Id); err != nil || u.Bio != nil {
+		t.Errorf("memory: transact: expected the change to be rolled back: got %+v %v\n", u, err)
+	}
+	journal.Broken = false
+	if _, err := db.SuspendUser(jake.Id, false); err != nil {
+		t.Errorf("memory: transact: expected the store to accept changes: got %v\n", err)
+	}
+
+	// a single change that fails to commit fails the store,
+	// which then refuses every change without making it
+	journal.Broken = true
+	if _, errs, err := db.UpdateUser(jake.Id, nil, &bio, nil); !errors.Is(err, ErrDiskFull) || errs != nil {
+		t.Errorf("memory: update user: expected %v: got %v %v\n", ErrDiskFull, errs, err)
+	}
+	journal.Broken = false
+	if _, _, err := db.CreateUser("Anne", "anne@anne.anne", "anneanne"); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("memory: create user: expected %v: got %v\n", ErrDiskFull, err)
+	}
+	if _, err := db.GetProfileByUsername(0, "Anne"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("memory: create user: expected no user: got %v\n", err)
+	}
+	if err := db.Transact(func(tx store.Store) error { return nil }); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("memory: transact: expected %v: got %v\n", ErrDiskFull, err)
+	}
+	if journal.Commits != 2 {
+		t.Errorf("memory: expected 2 commits: got %d\n", journal.Commits)
+	}
+}
+
+// TestJournalFailureOnRehash checks that a login whose new password hash
+// can't be saved still succeeds but fails the store.
+func TestJournalFailureOnRehash(t *testing.T) {
+	journal := &FailingJournal{}
+	db, err := New(WithJournal(journal), WithPasswordHasher(storetest.Hasher))
+	if err != nil {
+		t.Fatalf("memory: new: %+v\n", err)
+	}
+	if _, errs, err := db.CreateUser("Jacob", "jake@jake.jake", "jakejake"); err != nil || errs != nil {
+		t.Fatalf("memory: create user: %v %v\n", errs, err)
+	}
+
+	// the hasher is made stronger, so the next login replaces the hash
+	db.passwords = &password.Hasher{Iterations: 2000, SaltLength: 16, KeyLength: 32}
+	journal.Broken = true
+	if _, err := db.Login("jake@jake.jake", "jakejake"); err != nil {
+		t.Errorf("memory: login: expected no error: got %v\n", err)
+	}
+	journal.Broken = false
+	if _, _, err := db.CreateUser("Anne", "anne@anne.anne", "anneanne"); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("memory: create user: expected %v: got %v\n", ErrDiskFull, err)
+	}
+	if _, err := db.Login("jake@jake.jake", "jakejake"); err != nil {
+		t.Errorf("memory: login: expected the old hash to still work: got %v\n", err)
+	}
+	if journal.Commits != 1 {
+		t.Errorf("memory: expected 1 commit: got %d\n", journal.Commits)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/mdhender/conduit/internal/password"
+	"strings"
+	"testing"
+)
+
+func TestLoginRehash(t *testing.T) {
+	weak := &password.Hasher{Iterations: 1000, SaltLength: 16, KeyLength: 32}
+	strong := &password.Hasher{Iterations: 2000, SaltLength: 16, KeyLength: 32}
+
+	db, err := New(WithPasswordHasher(weak))
+	if err != nil {
+		t.Fatalf("memory: new: %+v\n", err)
+	}
+	if _, errs, err := db.CreateUser("Jacob", "jake@jake.jake", "jakejake"); err != nil || len(errs) != 0 {
+		t.Fatalf("memory: create user: %v %v\n", errs, err)
+	}
+	stored := db.users.email["jake@jake.jake"].PasswordHash
+	if stored == "" || strings.Contains(stored, "jakejake") {
+		t.Errorf("memory: create user: password stored as %q\n", stored)
+	}
+	if !strings.Contains(stored, "$i=1000$") {
+		t.Errorf("memory: create user: expected 1000 iterations: got %q\n", stored)
+	}
+
+	// upgrading the hasher must not lock anyone out
+	db.passwords = strong
+	if _, err := db.Login("jake@jake.jake", "fakefake"); err != ErrNotAuthorized {
+		t.Errorf("memory: login with wrong password: expected %v: got %v\n", ErrNotAuthorized, err)
+	}
+	if got := db.users.email["jake@jake.jake"].PasswordHash; got != stored {
+		t.Errorf("memory: login with wrong password: hash must not change\n")
+	}
+	if _, err := db.Login("jake@jake.jake", "jakejake"); err != nil {
+		t.Fatalf("memory: login after upgrade: %+v\n", err)
+	}
+	rehashed := db.users.email["jake@jake.jake"].PasswordHash
+	if !strings.Contains(rehashed, "$i=2000$") {
+		t.Errorf("memory: login after upgrade: expected rehash with 2000 iterations: got %q\n", rehashed)
+	}
+	if _, err := db.Login("jake@jake.jake", "jakejake"); err != nil {
+		t.Fatalf("memory: login after rehash: %+v\n", err)
+	}
+	if got := db.users.email["jake@jake.jake"].PasswordHash; got != rehashed {
+		t.Errorf("memory: login after rehash: hash must not change again\n")
+	}
+
+	if _, err := db.Login("anne@anne.anne", "jakejake"); err != ErrNotAuthorized {
+		t.Errorf("memory: login with unknown email: expected %v: got %v\n", ErrNotAuthorized, err)
+	}
+}
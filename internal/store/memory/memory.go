@@ -26,37 +26,87 @@
 package memory
 
 import (
-	"errors"
+	"github.com/mdhender/conduit/internal/password"
+	"github.com/mdhender/conduit/internal/store"
 	"github.com/mdhender/conduit/internal/store/model"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
-var ErrNotAuthorized = errors.New("not authorized")
-var ErrNotFound = errors.New("not found")
+var ErrForbidden = store.ErrForbidden
+var ErrNotAuthorized = store.ErrNotAuthorized
+var ErrNotEmpty = store.ErrNotEmpty
+var ErrNotFound = store.ErrNotFound
+var ErrPasswordReset = store.ErrPasswordReset
+var ErrSuspended = store.ErrSuspended
 
-func New() (*Store, error) {
-	db := &Store{}
+// Store implements the store.Store interface.
+var _ store.Store = (*Store)(nil)
+
+// Option configures a Store.
+type Option func(*Store) error
+
+// WithPasswordHasher sets the hasher used to store and verify passwords.
+func WithPasswordHasher(h *password.Hasher) Option {
+	return func(db *Store) error {
+		db.passwords = h
+		return nil
+	}
+}
+
+func New(options ...Option) (*Store, error) {
+	db := newStore(password.NewHasher(""))
+	for _, option := range options {
+		if err := option(db); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// newStore returns an empty store with its indexes allocated.
+func newStore(passwords *password.Hasher) *Store {
+	db := &Store{passwords: passwords}
+	db.articles.author = make(map[int]map[int]*Article)
+	db.articles.id = make(map[int]*Article)
+	db.articles.slug = make(map[string]*Article)
+	db.articles.tag = make(map[string]map[int]*Article)
+	db.sessions.family = make(map[int]map[string]*RefreshToken)
+	db.sessions.hash = make(map[string]*RefreshToken)
+	db.sessions.revoked = make(map[string]time.Time)
+	db.sessions.user = make(map[int]map[int]bool)
 	db.users.email = make(map[string]*User)
 	db.users.id = make(map[int]*User)
 	db.users.name = make(map[string]*User)
-	return db, nil
+	return db
 }
 
-func (db *Store) CreateUser(username, email, password string) (*model.User, map[string][]string) {
+func (db *Store) CreateUser(username, email, password string) (*model.User, map[string][]string, error) {
+	errs := make(map[string][]string)
+
+	// hashing is slow by design, so do it before taking the lock
+	var hash string
+	if password = strings.TrimSpace(password); password == "" {
+		errs["password"] = append(errs["password"], "can't be blank")
+	} else if h, err := db.passwords.Hash(password); err != nil {
+		return nil, nil, err
+	} else {
+		hash = h
+	}
+
 	db.Lock()
 	defer db.Unlock()
-	errs := make(map[string][]string)
+	if db.failed != nil {
+		return nil, nil, db.failed
+	}
 	if username = strings.TrimSpace(username); username == "" {
 		errs["username"] = append(errs["username"], "can't be blank")
 	}
 	if email = strings.TrimSpace(email); email == "" {
 		errs["email"] = append(errs["email"], "can't be blank")
 	}
-	if password = strings.TrimSpace(password); password == "" {
-		errs["password"] = append(errs["password"], "can't be blank")
-	}
 	if _, ok := db.users.name[username]; ok {
 		errs["username"] = append(errs["username"], "has already been taken")
 	}
@@ -64,29 +114,38 @@ func (db *Store) CreateUser(username, email, password string) (*model.User, map[
 		errs["email"] = append(errs["email"], "has already been taken")
 	}
 	if len(errs) != 0 {
-		return nil, errs
+		return nil, errs, nil
 	}
 
 	db.seq++
 	u := &User{
-		Id:        db.seq,
-		Username:  username,
-		Email:     email,
-		Password:  password,
-		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
-		UpdatedAt: time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
-		Following: make(map[int]*User),
+		Id:           db.seq,
+		Username:     username,
+		Email:        email,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
+		UpdatedAt:    time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z"),
+		Version:      1,
+		Following:    make(map[int]*User),
+		Favorites:    make(map[int]*Article),
 	}
 	db.users.id[u.Id] = u
 	db.users.name[u.Username] = u
 	db.users.email[u.Email] = u
+	db.touchUser(u.Id)
+	if err := db.commit(); err != nil {
+		return nil, nil, err
+	}
 
-	return u.AsModelUser(), nil
+	return u.AsModelUser(), nil, nil
 }
 
 func (db *Store) FollowUserByUsername(id int, username string) (*model.Profile, error) {
 	db.Lock()
 	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
 
 	user := db.users.id[id]
 	if id == 0 || user == nil {
@@ -100,6 +159,10 @@ func (db *Store) FollowUserByUsername(id int, username string) (*model.Profile,
 		return nil, ErrNotFound
 	}
 	user.Following[target.Id] = target
+	db.touchUser(user.Id)
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
 
 	return target.AsModelProfile(user), nil
 }
@@ -131,25 +194,79 @@ func (db *Store) GetUser(id int) (*model.User, error) {
 	return user.AsModelUser(), nil
 }
 
+// Login returns the user if the password matches the one stored for the e-mail.
+// If the stored hash was made with weaker parameters than the store's hasher
+// currently uses, it is replaced with a new hash.
+// Suspended users and users who must reset their password are rejected.
 func (db *Store) Login(email, password string) (*model.User, error) {
+	user, hash, rehash := db.authenticate(email, password)
+	if user == nil {
+		return nil, ErrNotAuthorized
+	}
+	if rehash {
+		if h, err := db.passwords.Hash(password); err == nil {
+			db.Lock()
+			if user.PasswordHash == hash && db.failed == nil { // don't overwrite a concurrent change
+				user.PasswordHash = h
+				db.touchUser(user.Id)
+				if err := db.commit(); err != nil {
+					// the old hash still works, so put it back and let the login
+					// succeed, but the store stays failed like any other change
+					user.PasswordHash = hash
+				}
+			}
+			db.Unlock()
+		}
+	}
+
 	db.Lock()
 	defer db.Unlock()
-	user := db.users.email[email]
-	if user == nil || user.Password != password { // yeah, we know, timing attack
+	if db.users.id[user.Id] != user { // deleted while we were verifying
 		return nil, ErrNotAuthorized
+	} else if user.Suspended {
+		return nil, ErrSuspended
+	} else if user.PasswordResetRequired {
+		return nil, ErrPasswordReset
 	}
 	return user.AsModelUser(), nil
 }
 
-func (db *Store) UpdateUser(id int, email, bio, image *string) (*model.User, map[string][]string) {
+// authenticate returns the user if the password matches the one stored for the e-mail.
+// It also returns the hash that was verified and whether it should be replaced.
+// Hashing is slow by design, so the lock isn't held while verifying.
+func (db *Store) authenticate(email, password string) (user *User, hash string, rehash bool) {
+	db.Lock()
+	user, hash = db.users.email[email], db.dummyHash
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	db.Unlock()
+
+	if hash == "" { // only happens for an unknown e-mail on the first call
+		hash, _ = db.passwords.Hash("not a password")
+		db.Lock()
+		db.dummyHash = hash
+		db.Unlock()
+	}
+	// always verify so that unknown e-mails take as long as wrong passwords
+	ok, rehash, err := db.passwords.Verify(password, hash)
+	if user == nil || err != nil || !ok {
+		return nil, "", false
+	}
+	return user, hash, rehash
+}
+
+func (db *Store) UpdateUser(id int, email, bio, image *string) (*model.User, map[string][]string, error) {
 	db.Lock()
 	defer db.Unlock()
+	if db.failed != nil {
+		return nil, nil, db.failed
+	}
 	errs := make(map[string][]string)
 
 	user := db.users.id[id]
 	if user == nil {
-		errs["email"] = append(errs["email"], "no such email")
-		return nil, errs
+		return nil, nil, ErrNotFound
 	}
 
 	cp, changes := user.Copy(), false
@@ -164,6 +281,8 @@ func (db *Store) UpdateUser(id int, email, bio, image *string) (*model.User, map
 			errs["email"] = append(errs["email"], "can't have leading or trailing spaces")
 		} else if val == "" {
 			errs["email"] = append(errs["email"], "must not be empty if provided")
+		} else if other := db.users.email[val]; other != nil && other != user {
+			errs["email"] = append(errs["email"], "has already been taken")
 		} else {
 			cp.Email = val
 			changes = true
@@ -175,29 +294,41 @@ func (db *Store) UpdateUser(id int, email, bio, image *string) (*model.User, map
 		changes = true
 	}
 	if len(errs) != 0 {
-		return nil, errs
+		return nil, errs, nil
 	}
 
 	if !changes {
-		return user.AsModelUser(), nil
+		return user.AsModelUser(), nil, nil
 	}
 
-	// clear out pointers to the old record
-	delete(db.users.id, user.Id)
-	delete(db.users.name, user.Username)
+	// update the record in place so that the follows, articles, and
+	// favorites that point to it see the changes.
 	delete(db.users.email, user.Email)
+	user.Email, user.bio, user.image = cp.Email, cp.bio, cp.image
+	user.Bio, user.Image = nil, nil
+	if cp.Bio != nil {
+		user.Bio = &user.bio
+	}
+	if cp.Image != nil {
+		user.Image = &user.image
+	}
+	user.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z")
+	user.Version++
+	db.users.email[user.Email] = user
+	db.touchUser(user.Id)
+	if err := db.commit(); err != nil {
+		return nil, nil, err
+	}
 
-	// and then insert the new record
-	db.users.id[id] = cp
-	db.users.name[user.Username] = cp
-	db.users.email[user.Email] = cp
-
-	return cp.AsModelUser(), nil
+	return user.AsModelUser(), nil, nil
 }
 
 func (db *Store) UnfollowUserByUsername(id int, username string) (*model.Profile, error) {
 	db.Lock()
 	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
 
 	user := db.users.id[id]
 	if id == 0 || user == nil {
@@ -211,13 +342,45 @@ func (db *Store) UnfollowUserByUsername(id int, username string) (*model.Profile
 		return nil, ErrNotFound
 	}
 	delete(user.Following, target.Id)
+	db.touchUser(user.Id)
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
 
 	return target.AsModelProfile(user), nil
 }
 
 type Store struct {
 	sync.RWMutex
-	seq   int
+	seq       int
+	passwords *password.Hasher
+	dummyHash string // verified against when logging in with an unknown e-mail
+	journal   Journal
+	failed    error    // set when a commit fails; the store refuses changes after that
+	dirty     struct { // records changed since the last commit
+		users    map[int]bool
+		articles map[int]bool
+		tokens   map[string]bool
+		revoked  map[string]bool
+	}
+	articles struct {
+		seq    int
+		author map[int]map[int]*Article // articles indexed by author id and then article id
+		id     map[int]*Article
+		slug   map[string]*Article         // includes aliases
+		tag    map[string]map[int]*Article // articles indexed by tag and then article id
+	}
+	comments struct {
+		seq int // comments are stored on their article
+	}
+	sessions struct {
+		seq     int // refresh token families
+		family  map[int]map[string]*RefreshToken
+		hash    map[string]*RefreshToken
+		user    map[int]map[int]bool // families indexed by user id
+		revoked map[string]time.Time // expiry of revoked access tokens indexed by jti
+		gcAt    int                  // number of entries that triggers the next sweep
+	}
 	users struct {
 		id    map[int]*User
 		name  map[string]*User
@@ -226,16 +389,23 @@ type Store struct {
 }
 
 type User struct {
-	Id         int
-	Username   string
-	Email      string
-	Password   string
-	CreatedAt  string // "2021-03-27T16:58:01.233Z"
-	UpdatedAt  string // "2021-03-27T16:58:01.245Z"
-	Bio        *string
-	Image      *string
-	Following  map[int]*User // map of Id of users being followed
-	bio, image string
+	Id           int
+	Username     string
+	Email        string
+	PasswordHash string // never leaves the store
+	CreatedAt    string // "2021-03-27T16:58:01.233Z"
+	UpdatedAt    string // "2021-03-27T16:58:01.245Z"
+	Version      int    // bumped along with UpdatedAt
+	Bio          *string
+	Image        *string
+	Following    map[int]*User    // map of Id of users being followed
+	Favorites    map[int]*Article // map of Id of articles favorited
+	bio, image   string
+
+	TokenGeneration       int             // bumped by RevokeSessions, RevokeRole and ChangePassword
+	Roles                 map[string]bool // roles granted to the user
+	Suspended             bool
+	PasswordResetRequired bool
 }
 
 func (u *User) AsModelProfile(p *User) *model.Profile {
@@ -246,6 +416,7 @@ func (u *User) AsModelProfile(p *User) *model.Profile {
 		Id:        u.Id,
 		Username:  u.Username,
 		Following: p != nil && p.Following[u.Id] != nil,
+		Version:   u.Version,
 	}
 	if u.Bio != nil {
 		tmp := *u.Bio
@@ -266,10 +437,19 @@ func (u *User) AsModelUser() *model.User {
 		Id:        u.Id,
 		Username:  u.Username,
 		Email:     u.Email,
-		Password:  u.Password,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
+		Version:   u.Version,
+
+		TokenGeneration: u.TokenGeneration,
+
+		Suspended:             u.Suspended,
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+	for role := range u.Roles {
+		cp.Roles = append(cp.Roles, role)
 	}
+	sort.Strings(cp.Roles)
 	if u.Bio != nil {
 		tmp := *u.Bio
 		cp.Bio = &tmp
@@ -289,15 +469,25 @@ func (u *User) Copy() *User {
 		return &User{}
 	}
 	cp := &User{
-		Id:        u.Id,
-		Username:  u.Username,
-		Email:     u.Email,
-		Password:  u.Password,
-		CreatedAt: u.CreatedAt,
-		UpdatedAt: u.UpdatedAt,
-		Following: make(map[int]*User),
-		bio:       u.bio,
-		image:     u.image,
+		Id:           u.Id,
+		Username:     u.Username,
+		Email:        u.Email,
+		PasswordHash: u.PasswordHash,
+		CreatedAt:    u.CreatedAt,
+		UpdatedAt:    u.UpdatedAt,
+		Version:      u.Version,
+		Following:    make(map[int]*User),
+		Favorites:    make(map[int]*Article),
+		bio:          u.bio,
+		image:        u.image,
+
+		TokenGeneration:       u.TokenGeneration,
+		Roles:                 make(map[string]bool),
+		Suspended:             u.Suspended,
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+	for role := range u.Roles {
+		cp.Roles[role] = true
 	}
 	if u.Bio != nil {
 		cp.Bio = &cp.bio
@@ -308,5 +498,8 @@ func (u *User) Copy() *User {
 	for id, user := range u.Following {
 		cp.Following[id] = user
 	}
+	for id, article := range u.Favorites {
+		cp.Favorites[id] = article
+	}
 	return cp
 }
@@ -0,0 +1,518 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Journal makes the changes to a Store durable.
+// The Store calls it with the lock held, so commits arrive in order.
+type Journal interface {
+	// Commit saves the records changed by a mutation.
+	// It must not return until the records are durable.
+	Commit(r *Records) error
+	// Compact replaces everything committed so far with a snapshot
+	// of the whole store. It is called after a commit whenever
+	// ShouldCompact returns true.
+	Compact(snapshot *Records) error
+	ShouldCompact() bool
+}
+
+// WithJournal sends every change to the journal.
+func WithJournal(j Journal) Option {
+	return func(db *Store) error {
+		db.journal = j
+		return nil
+	}
+}
+
+// WithRecords loads the records into the store.
+// The records are usually a snapshot followed by the changes committed since.
+func WithRecords(r *Records) Option {
+	return func(db *Store) error {
+		return db.apply(r)
+	}
+}
+
+// Records are the contents of a Store that must survive a restart.
+// A snapshot holds every record; a commit holds only the records that
+// changed and the keys of the ones that were deleted.
+type Records struct {
+	Sequences Sequences `json:"sequences"`
+
+	Users           []UserRecord         `json:"users,omitempty"`
+	Articles        []ArticleRecord      `json:"articles,omitempty"`
+	RefreshTokens   []RefreshTokenRecord `json:"refreshTokens,omitempty"`
+	RevokedTokens   []RevokedTokenRecord `json:"revokedTokens,omitempty"`
+	DeletedUsers    []int                `json:"deletedUsers,omitempty"`
+	DeletedArticles []int                `json:"deletedArticles,omitempty"`
+	DeletedTokens   []string             `json:"deletedTokens,omitempty"`   // hashes of refresh tokens
+	UnrevokedTokens []string             `json:"unrevokedTokens,omitempty"` // jti of revoked access tokens that expired
+}
+
+// Sequences are the last ids handed out.
+type Sequences struct {
+	Users    int `json:"users"`
+	Articles int `json:"articles"`
+	Comments int `json:"comments"`
+	Sessions int `json:"sessions"`
+}
+
+type UserRecord struct {
+	Id                    int      `json:"id"`
+	Username              string   `json:"username"`
+	Email                 string   `json:"email"`
+	PasswordHash          string   `json:"passwordHash"`
+	CreatedAt             string   `json:"createdAt"`
+	UpdatedAt             string   `json:"updatedAt"`
+	Version               int      `json:"version,omitempty"`
+	Bio                   *string  `json:"bio,omitempty"`
+	Image                 *string  `json:"image,omitempty"`
+	Following             []int    `json:"following,omitempty"`
+	TokenGeneration       int      `json:"tokenGeneration,omitempty"`
+	Roles                 []string `json:"roles,omitempty"`
+	Suspended             bool     `json:"suspended,omitempty"`
+	PasswordResetRequired bool     `json:"passwordResetRequired,omitempty"`
+}
+
+// ArticleRecord includes the comments on the article and the users who favorited it.
+type ArticleRecord struct {
+	Id          int             `json:"id"`
+	Slug        string          `json:"slug"`
+	Aliases     []string        `json:"aliases,omitempty"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Body        string          `json:"body"`
+	TagList     []string        `json:"tagList,omitempty"`
+	CreatedAt   string          `json:"createdAt"`
+	UpdatedAt   string          `json:"updatedAt"`
+	Version     int             `json:"version,omitempty"`
+	Author      int             `json:"author"`
+	FavoritedBy []int           `json:"favoritedBy,omitempty"`
+	Comments    []CommentRecord `json:"comments,omitempty"`
+}
+
+type CommentRecord struct {
+	Id        int    `json:"id"`
+	Body      string `json:"body"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+	Author    int    `json:"author"`
+}
+
+type RefreshTokenRecord struct {
+	Hash      string    `json:"hash"`
+	Family    int       `json:"family"`
+	User      int       `json:"user"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Used      bool      `json:"used,omitempty"`
+}
+
+type RevokedTokenRecord struct {
+	Jti       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// IsEmpty returns true if there are no records or deleted keys.
+func (r *Records) IsEmpty() bool {
+	return len(r.Users) == 0 && len(r.Articles) == 0 && len(r.RefreshTokens) == 0 && len(r.RevokedTokens) == 0 &&
+		len(r.DeletedUsers) == 0 && len(r.DeletedArticles) == 0 && len(r.DeletedTokens) == 0 && len(r.UnrevokedTokens) == 0
+}
+
+// Compact writes a snapshot of the store to the journal.
+// It does nothing if the store doesn't have a journal.
+func (db *Store) Compact() error {
+	db.Lock()
+	defer db.Unlock()
+	if db.journal == nil {
+		return nil
+	}
+	return db.journal.Compact(db.snapshot())
+}
+
+// Snapshot returns every record in the store.
+func (db *Store) Snapshot() *Records {
+	db.RLock()
+	defer db.RUnlock()
+	return db.snapshot()
+}
+
+func (db *Store) snapshot() *Records {
+	r := &Records{Sequences: db.sequences()}
+	for _, u := range db.users.id {
+		r.Users = append(r.Users, u.asRecord())
+	}
+	for _, a := range db.articles.id {
+		r.Articles = append(r.Articles, a.asRecord())
+	}
+	for _, rt := range db.sessions.hash {
+		r.RefreshTokens = append(r.RefreshTokens, rt.asRecord())
+	}
+	for jti, expiresAt := range db.sessions.revoked {
+		r.RevokedTokens = append(r.RevokedTokens, RevokedTokenRecord{Jti: jti, ExpiresAt: expiresAt.UTC()})
+	}
+	r.sort()
+	return r
+}
+
+func (db *Store) sequences() Sequences {
+	return Sequences{Users: db.seq, Articles: db.articles.seq, Comments: db.comments.seq, Sessions: db.sessions.seq}
+}
+
+// commit sends the records changed since the last commit to the journal.
+// If the journal fails, the change has already been made in memory but
+// isn't durable, and there's no record of what it replaced. The store is
+// marked as failed so that it refuses further changes rather than drift
+// further from the journal; reopening it from the journal brings back the
+// last durable state. The caller must hold the lock.
+func (db *Store) commit() error {
+	if db.journal == nil {
+		return nil
+	}
+	r := &Records{Sequences: db.sequences()}
+	for id := range db.dirty.users {
+		if u := db.users.id[id]; u != nil {
+			r.Users = append(r.Users, u.asRecord())
+		} else {
+			r.DeletedUsers = append(r.DeletedUsers, id)
+		}
+	}
+	for id := range db.dirty.articles {
+		if a := db.articles.id[id]; a != nil {
+			r.Articles = append(r.Articles, a.asRecord())
+		} else {
+			r.DeletedArticles = append(r.DeletedArticles, id)
+		}
+	}
+	for hash := range db.dirty.tokens {
+		if rt := db.sessions.hash[hash]; rt != nil {
+			r.RefreshTokens = append(r.RefreshTokens, rt.asRecord())
+		} else {
+			r.DeletedTokens = append(r.DeletedTokens, hash)
+		}
+	}
+	for jti := range db.dirty.revoked {
+		if expiresAt, ok := db.sessions.revoked[jti]; ok {
+			r.RevokedTokens = append(r.RevokedTokens, RevokedTokenRecord{Jti: jti, ExpiresAt: expiresAt.UTC()})
+		} else {
+			r.UnrevokedTokens = append(r.UnrevokedTokens, jti)
+		}
+	}
+	if r.IsEmpty() {
+		return nil
+	}
+	r.sort()
+	if err := db.journal.Commit(r); err != nil {
+		db.failed = fmt.Errorf("memory: journal: %w", err)
+		return db.failed
+	}
+	db.dirty.users, db.dirty.articles, db.dirty.tokens, db.dirty.revoked = nil, nil, nil, nil
+
+	if db.journal.ShouldCompact() {
+		// the commit is already durable, so a failed compaction loses
+		// nothing and is tried again after the next commit
+		_ = db.journal.Compact(db.snapshot())
+	}
+	return nil
+}
+
+// touchUser marks the user as changed (or deleted) so that the next commit saves it.
+func (db *Store) touchUser(id int) {
+	if db.journal != nil {
+		if db.dirty.users == nil {
+			db.dirty.users = make(map[int]bool)
+		}
+		db.dirty.users[id] = true
+	}
+}
+
+// touchArticle marks the article as changed (or deleted) so that the next commit saves it.
+func (db *Store) touchArticle(id int) {
+	if db.journal != nil {
+		if db.dirty.articles == nil {
+			db.dirty.articles = make(map[int]bool)
+		}
+		db.dirty.articles[id] = true
+	}
+}
+
+// touchToken marks the refresh token as changed (or deleted) so that the next commit saves it.
+func (db *Store) touchToken(hash string) {
+	if db.journal != nil {
+		if db.dirty.tokens == nil {
+			db.dirty.tokens = make(map[string]bool)
+		}
+		db.dirty.tokens[hash] = true
+	}
+}
+
+// touchRevoked marks the revoked access token as changed (or forgotten) so that the next commit saves it.
+func (db *Store) touchRevoked(jti string) {
+	if db.journal != nil {
+		if db.dirty.revoked == nil {
+			db.dirty.revoked = make(map[string]bool)
+		}
+		db.dirty.revoked[jti] = true
+	}
+}
+
+// apply loads the records into the store, replacing any records with the same keys.
+// The caller must hold the lock or be the only user of the store.
+func (db *Store) apply(r *Records) error {
+	db.seq, db.articles.seq, db.comments.seq, db.sessions.seq = r.Sequences.Users, r.Sequences.Articles, r.Sequences.Comments, r.Sequences.Sessions
+
+	for _, id := range r.DeletedArticles {
+		if a := db.articles.id[id]; a != nil {
+			db.removeArticle(a)
+		}
+	}
+	for _, hash := range r.DeletedTokens {
+		db.removeRefreshToken(hash)
+	}
+	for _, jti := range r.UnrevokedTokens {
+		delete(db.sessions.revoked, jti)
+	}
+	for _, id := range r.DeletedUsers {
+		if u := db.users.id[id]; u != nil {
+			delete(db.users.email, u.Email)
+			delete(db.users.name, u.Username)
+			delete(db.users.id, u.Id)
+		}
+	}
+
+	// users are loaded before their follows so that they can follow users later in the list
+	for _, rec := range r.Users {
+		db.putUser(rec)
+	}
+	for _, rec := range r.Users {
+		u := db.users.id[rec.Id]
+		u.Following = make(map[int]*User)
+		for _, id := range rec.Following {
+			target := db.users.id[id]
+			if target == nil {
+				return fmt.Errorf("records: user %d: follows unknown user %d", rec.Id, id)
+			}
+			u.Following[id] = target
+		}
+	}
+	for _, rec := range r.Articles {
+		if err := db.putArticle(rec); err != nil {
+			return err
+		}
+	}
+	for _, rec := range r.RefreshTokens {
+		user := db.users.id[rec.User]
+		if user == nil {
+			return fmt.Errorf("records: refresh token: unknown user %d", rec.User)
+		}
+		if rt := db.sessions.hash[rec.Hash]; rt != nil {
+			rt.ExpiresAt, rt.Used = rec.ExpiresAt, rec.Used
+		} else {
+			db.addRefreshToken(&RefreshToken{Hash: rec.Hash, Family: rec.Family, User: user, ExpiresAt: rec.ExpiresAt, Used: rec.Used})
+		}
+	}
+	for _, rec := range r.RevokedTokens {
+		db.sessions.revoked[rec.Jti] = rec.ExpiresAt
+	}
+	return nil
+}
+
+// putUser adds or replaces the user, updating the record in place so that
+// the follows, articles, and favorites that point to it see the changes.
+// The caller must load the follows.
+func (db *Store) putUser(rec UserRecord) {
+	u := db.users.id[rec.Id]
+	if u == nil {
+		u = &User{Id: rec.Id, Following: make(map[int]*User), Favorites: make(map[int]*Article)}
+		db.users.id[u.Id] = u
+	} else {
+		delete(db.users.email, u.Email)
+		delete(db.users.name, u.Username)
+	}
+	u.Username, u.Email, u.PasswordHash = rec.Username, rec.Email, rec.PasswordHash
+	u.CreatedAt, u.UpdatedAt, u.Version = rec.CreatedAt, rec.UpdatedAt, recordVersion(rec.Version)
+	u.Bio, u.bio = nil, ""
+	if rec.Bio != nil {
+		u.bio = *rec.Bio
+		u.Bio = &u.bio
+	}
+	u.Image, u.image = nil, ""
+	if rec.Image != nil {
+		u.image = *rec.Image
+		u.Image = &u.image
+	}
+	u.TokenGeneration = rec.TokenGeneration
+	u.Roles = make(map[string]bool)
+	for _, role := range rec.Roles {
+		u.Roles[role] = true
+	}
+	u.Suspended, u.PasswordResetRequired = rec.Suspended, rec.PasswordResetRequired
+	db.users.email[u.Email] = u
+	db.users.name[u.Username] = u
+}
+
+// putArticle adds or replaces the article along with its comments and favorites.
+func (db *Store) putArticle(rec ArticleRecord) error {
+	author := db.users.id[rec.Author]
+	if author == nil {
+		return fmt.Errorf("records: article %d: unknown author %d", rec.Id, rec.Author)
+	}
+	a := db.articles.id[rec.Id]
+	if a == nil {
+		a = &Article{Id: rec.Id}
+		db.articles.id[a.Id] = a
+	} else {
+		delete(db.articles.author[a.Author.Id], a.Id)
+		if len(db.articles.author[a.Author.Id]) == 0 {
+			delete(db.articles.author, a.Author.Id)
+		}
+		for _, alias := range a.Aliases {
+			delete(db.articles.slug, alias)
+		}
+		delete(db.articles.slug, a.Slug)
+		for _, fan := range a.FavoritedBy {
+			delete(fan.Favorites, a.Id)
+		}
+	}
+	a.Slug, a.Aliases = rec.Slug, append([]string(nil), rec.Aliases...)
+	a.Title, a.Description, a.Body = rec.Title, rec.Description, rec.Body
+	a.CreatedAt, a.UpdatedAt, a.Version = rec.CreatedAt, rec.UpdatedAt, recordVersion(rec.Version)
+	a.Author = author
+	if db.articles.author[author.Id] == nil {
+		db.articles.author[author.Id] = make(map[int]*Article)
+	}
+	db.articles.author[author.Id][a.Id] = a
+	db.articles.slug[a.Slug] = a
+	for _, alias := range a.Aliases {
+		db.articles.slug[alias] = a
+	}
+	db.setTags(a, rec.TagList)
+	a.FavoritedBy = make(map[int]*User)
+	for _, id := range rec.FavoritedBy {
+		fan := db.users.id[id]
+		if fan == nil {
+			return fmt.Errorf("records: article %d: favorited by unknown user %d", rec.Id, id)
+		}
+		a.FavoritedBy[id] = fan
+		fan.Favorites[a.Id] = a
+	}
+	a.Comments = make(map[int]*Comment)
+	for _, c := range rec.Comments {
+		commenter := db.users.id[c.Author]
+		if commenter == nil {
+			return fmt.Errorf("records: comment %d: unknown author %d", c.Id, c.Author)
+		}
+		a.Comments[c.Id] = &Comment{Id: c.Id, Body: c.Body, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt, Author: commenter}
+	}
+	return nil
+}
+
+// recordVersion returns the version from a record.
+// Records written before versions were kept start at version 1.
+func recordVersion(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
+
+func (u *User) asRecord() UserRecord {
+	rec := UserRecord{
+		Id:                    u.Id,
+		Username:              u.Username,
+		Email:                 u.Email,
+		PasswordHash:          u.PasswordHash,
+		CreatedAt:             u.CreatedAt,
+		UpdatedAt:             u.UpdatedAt,
+		Version:               u.Version,
+		TokenGeneration:       u.TokenGeneration,
+		Suspended:             u.Suspended,
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+	if u.Bio != nil {
+		tmp := *u.Bio
+		rec.Bio = &tmp
+	}
+	if u.Image != nil {
+		tmp := *u.Image
+		rec.Image = &tmp
+	}
+	for id := range u.Following {
+		rec.Following = append(rec.Following, id)
+	}
+	sort.Ints(rec.Following)
+	for role := range u.Roles {
+		rec.Roles = append(rec.Roles, role)
+	}
+	sort.Strings(rec.Roles)
+	return rec
+}
+
+func (a *Article) asRecord() ArticleRecord {
+	rec := ArticleRecord{
+		Id:          a.Id,
+		Slug:        a.Slug,
+		Aliases:     append([]string(nil), a.Aliases...),
+		Title:       a.Title,
+		Description: a.Description,
+		Body:        a.Body,
+		TagList:     append([]string(nil), a.TagList...),
+		CreatedAt:   a.CreatedAt,
+		UpdatedAt:   a.UpdatedAt,
+		Version:     a.Version,
+		Author:      a.Author.Id,
+	}
+	for id := range a.FavoritedBy {
+		rec.FavoritedBy = append(rec.FavoritedBy, id)
+	}
+	sort.Ints(rec.FavoritedBy)
+	for _, c := range a.Comments {
+		rec.Comments = append(rec.Comments, CommentRecord{Id: c.Id, Body: c.Body, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt, Author: c.Author.Id})
+	}
+	sort.Slice(rec.Comments, func(i, j int) bool {
+		return rec.Comments[i].Id < rec.Comments[j].Id
+	})
+	return rec
+}
+
+func (rt *RefreshToken) asRecord() RefreshTokenRecord {
+	return RefreshTokenRecord{Hash: rt.Hash, Family: rt.Family, User: rt.User.Id, ExpiresAt: rt.ExpiresAt.UTC(), Used: rt.Used}
+}
+
+// sort orders the records by key so that the same contents always
+// produce the same records.
+func (r *Records) sort() {
+	sort.Slice(r.Users, func(i, j int) bool { return r.Users[i].Id < r.Users[j].Id })
+	sort.Slice(r.Articles, func(i, j int) bool { return r.Articles[i].Id < r.Articles[j].Id })
+	sort.Slice(r.RefreshTokens, func(i, j int) bool { return r.RefreshTokens[i].Hash < r.RefreshTokens[j].Hash })
+	sort.Slice(r.RevokedTokens, func(i, j int) bool { return r.RevokedTokens[i].Jti < r.RevokedTokens[j].Jti })
+	sort.Ints(r.DeletedUsers)
+	sort.Ints(r.DeletedArticles)
+	sort.Strings(r.DeletedTokens)
+	sort.Strings(r.UnrevokedTokens)
+}
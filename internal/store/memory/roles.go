@@ -0,0 +1,83 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/mdhender/conduit/internal/store/model"
+)
+
+// GrantRole adds the role to the user.
+// Granting a role the user already has is not an error.
+func (db *Store) GrantRole(id int, role string) (*model.User, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
+	if id == 0 {
+		return nil, ErrNotAuthorized
+	}
+	user, ok := db.users.id[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if user.Roles == nil {
+		user.Roles = make(map[string]bool)
+	}
+	user.Roles[role] = true
+	db.touchUser(user.Id)
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
+	return user.AsModelUser(), nil
+}
+
+// RevokeRole removes the role from the user.
+// If the user had the role, the token generation is bumped so that
+// tokens claiming it are rejected. Revoking a role the user doesn't
+// have is not an error.
+func (db *Store) RevokeRole(id int, role string) (*model.User, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
+	if id == 0 {
+		return nil, ErrNotAuthorized
+	}
+	user, ok := db.users.id[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if user.Roles[role] {
+		delete(user.Roles, role)
+		user.TokenGeneration++
+		db.touchUser(user.Id)
+		if err := db.commit(); err != nil {
+			return nil, err
+		}
+	}
+	return user.AsModelUser(), nil
+}
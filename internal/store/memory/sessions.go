@@ -0,0 +1,240 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/mdhender/conduit/internal/store/model"
+	"time"
+)
+
+// CreateRefreshToken starts a new token family for the user.
+func (db *Store) CreateRefreshToken(id int, hash string, expiresAt time.Time) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+	if id == 0 {
+		return ErrNotAuthorized
+	}
+	user, ok := db.users.id[id]
+	if !ok {
+		return ErrNotFound
+	}
+	db.gc(time.Now())
+	db.sessions.seq++
+	db.addRefreshToken(&RefreshToken{Hash: hash, Family: db.sessions.seq, User: user, ExpiresAt: expiresAt})
+	return db.commit()
+}
+
+// IsTokenRevoked returns true if the access token with the jti was revoked.
+func (db *Store) IsTokenRevoked(jti string) (bool, error) {
+	db.RLock()
+	defer db.RUnlock()
+	_, ok := db.sessions.revoked[jti]
+	return ok, nil
+}
+
+// RevokeRefreshToken revokes the token and every other token in its family.
+// Unknown tokens are ignored, so logging out twice is not an error.
+func (db *Store) RevokeRefreshToken(hash string) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+	if rt, ok := db.sessions.hash[hash]; ok {
+		db.revokeFamily(rt.Family)
+	}
+	return db.commit()
+}
+
+// RevokeSessions revokes all of the user's refresh tokens and bumps the
+// user's token generation so that every access token already issued is rejected.
+func (db *Store) RevokeSessions(id int) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+	if id == 0 {
+		return ErrNotAuthorized
+	}
+	user, ok := db.users.id[id]
+	if !ok {
+		return ErrNotFound
+	}
+	db.revokeSessions(user)
+	return db.commit()
+}
+
+// RevokeToken rejects the access token with the jti until it expires.
+func (db *Store) RevokeToken(jti string, expiresAt time.Time) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+	if jti == "" {
+		return ErrNotFound
+	}
+	db.gc(time.Now())
+	db.sessions.revoked[jti] = expiresAt
+	db.touchRevoked(jti)
+	return db.commit()
+}
+
+// RotateRefreshToken uses up the token and replaces it with newHash in the same family.
+// Replaying a token that was already used revokes every token in its family.
+// Tokens belonging to suspended users are rejected but not used up.
+func (db *Store) RotateRefreshToken(hash, newHash string, expiresAt time.Time) (*model.User, error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return nil, db.failed
+	}
+	rt, ok := db.sessions.hash[hash]
+	if !ok {
+		return nil, ErrNotAuthorized
+	} else if rt.User.Suspended {
+		return nil, ErrNotAuthorized
+	} else if rt.Used || !time.Now().Before(rt.ExpiresAt) {
+		db.revokeFamily(rt.Family)
+		if err := db.commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotAuthorized
+	}
+	rt.Used = true
+	db.touchToken(rt.Hash)
+	db.addRefreshToken(&RefreshToken{Hash: newHash, Family: rt.Family, User: rt.User, ExpiresAt: expiresAt})
+	if err := db.commit(); err != nil {
+		return nil, err
+	}
+	return rt.User.AsModelUser(), nil
+}
+
+// addRefreshToken adds the token to the indexes.
+// Used tokens are kept until the family is revoked so that replays can be detected.
+func (db *Store) addRefreshToken(rt *RefreshToken) {
+	db.sessions.hash[rt.Hash] = rt
+	family, ok := db.sessions.family[rt.Family]
+	if !ok {
+		family = make(map[string]*RefreshToken)
+		db.sessions.family[rt.Family] = family
+	}
+	family[rt.Hash] = rt
+	families, ok := db.sessions.user[rt.User.Id]
+	if !ok {
+		families = make(map[int]bool)
+		db.sessions.user[rt.User.Id] = families
+	}
+	families[rt.Family] = true
+	db.touchToken(rt.Hash)
+}
+
+// gc forgets revoked access tokens that have expired and families whose
+// tokens have all expired. It only sweeps once the number of entries has
+// doubled since the last sweep, so the cost is spread over the inserts.
+func (db *Store) gc(now time.Time) {
+	if len(db.sessions.revoked)+len(db.sessions.hash) < db.sessions.gcAt {
+		return
+	}
+	for jti, expiresAt := range db.sessions.revoked {
+		if !now.Before(expiresAt) {
+			delete(db.sessions.revoked, jti)
+			db.touchRevoked(jti)
+		}
+	}
+	for family, tokens := range db.sessions.family {
+		live := false
+		for _, rt := range tokens {
+			if now.Before(rt.ExpiresAt) {
+				live = true
+				break
+			}
+		}
+		if !live {
+			db.revokeFamily(family)
+		}
+	}
+	db.sessions.gcAt = 2*(len(db.sessions.revoked)+len(db.sessions.hash)) + 64
+}
+
+// revokeSessions revokes every refresh token family belonging to the user
+// and bumps the user's token generation so that access tokens are rejected.
+func (db *Store) revokeSessions(user *User) {
+	for family := range db.sessions.user[user.Id] {
+		db.revokeFamily(family)
+	}
+	user.TokenGeneration++
+	db.touchUser(user.Id)
+}
+
+// revokeFamily removes every token in the family.
+func (db *Store) revokeFamily(family int) {
+	for hash, rt := range db.sessions.family[family] {
+		delete(db.sessions.hash, hash)
+		db.touchToken(hash)
+		if families := db.sessions.user[rt.User.Id]; families != nil {
+			delete(families, family)
+			if len(families) == 0 {
+				delete(db.sessions.user, rt.User.Id)
+			}
+		}
+	}
+	delete(db.sessions.family, family)
+}
+
+// removeRefreshToken removes the token, and its family once the family is empty.
+func (db *Store) removeRefreshToken(hash string) {
+	rt, ok := db.sessions.hash[hash]
+	if !ok {
+		return
+	}
+	delete(db.sessions.hash, hash)
+	db.touchToken(hash)
+	if family := db.sessions.family[rt.Family]; family != nil {
+		delete(family, hash)
+		if len(family) != 0 {
+			return
+		}
+	}
+	delete(db.sessions.family, rt.Family)
+	if families := db.sessions.user[rt.User.Id]; families != nil {
+		delete(families, rt.Family)
+		if len(families) == 0 {
+			delete(db.sessions.user, rt.User.Id)
+		}
+	}
+}
+
+type RefreshToken struct {
+	Hash      string // hash of the token; the token itself is never stored
+	Family    int    // all the tokens rotated from the same login
+	User      *User
+	ExpiresAt time.Time
+	Used      bool // true once the token has been rotated
+}
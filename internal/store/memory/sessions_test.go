@@ -0,0 +1,69 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"fmt"
+	"github.com/mdhender/conduit/internal/store/storetest"
+	"testing"
+	"time"
+)
+
+func TestSessionsGC(t *testing.T) {
+	db, err := New(WithPasswordHasher(storetest.Hasher))
+	if err != nil {
+		t.Fatalf("memory: new: %+v\n", err)
+	}
+	if _, errs, err := db.CreateUser("Jacob", "jake@jake.jake", "jakejake"); err != nil || len(errs) != 0 {
+		t.Fatalf("memory: create user: %v %v\n", errs, err)
+	}
+
+	// revoke lots of tokens that have already expired, and one that hasn't
+	if err := db.RevokeToken("live", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("memory: revoke token: %+v\n", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := db.RevokeToken(fmt.Sprintf("expired-%d", i), time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("memory: revoke token: %+v\n", err)
+		}
+		if err := db.CreateRefreshToken(1, fmt.Sprintf("expired-%d", i), time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("memory: create refresh token: %+v\n", err)
+		}
+	}
+
+	// the sweeps should have kept the indexes from growing with the expired entries
+	if n := len(db.sessions.revoked); n > 200 {
+		t.Errorf("memory: gc: expected expired revocations to be swept: got %d\n", n)
+	}
+	if n := len(db.sessions.hash); n > 200 {
+		t.Errorf("memory: gc: expected expired refresh tokens to be swept: got %d\n", n)
+	}
+	if n, m := len(db.sessions.family), len(db.sessions.user[1]); n != m {
+		t.Errorf("memory: gc: expected user index to match families: got %d and %d\n", n, m)
+	}
+	if revoked, _ := db.IsTokenRevoked("live"); !revoked {
+		t.Errorf("memory: gc: expected unexpired revocation to be kept\n")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import (
+	"sort"
+	"strings"
+)
+
+// GetTags returns all the tags in use, most popular first.
+// Tags that are used by the same number of articles are sorted by name.
+func (db *Store) GetTags() ([]string, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	var tags []string
+	for tag := range db.articles.tag {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if ni, nj := len(db.articles.tag[tags[i]]), len(db.articles.tag[tags[j]]); ni != nj {
+			return ni > nj
+		}
+		return tags[i] < tags[j]
+	})
+	return tags, nil
+}
+
+// setTags replaces the tags on the article and updates the tag index.
+// Tags that are no longer used by any article are removed from the index.
+func (db *Store) setTags(a *Article, tagList []string) {
+	for _, tag := range a.TagList {
+		delete(db.articles.tag[tag], a.Id)
+		if len(db.articles.tag[tag]) == 0 {
+			delete(db.articles.tag, tag)
+		}
+	}
+	a.TagList = normalizeTags(tagList)
+	for _, tag := range a.TagList {
+		if db.articles.tag[tag] == nil {
+			db.articles.tag[tag] = make(map[int]*Article)
+		}
+		db.articles.tag[tag][a.Id] = a
+	}
+}
+
+// equalTags returns true if both lists contain the same tags in the same order.
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeTag trims and case-folds a tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// normalizeTags returns the normalized tags, dropping empty and duplicate tags.
+// The order of the first occurrence of each tag is preserved.
+func normalizeTags(tagList []string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range tagList {
+		if tag = normalizeTag(tag); tag != "" && !seen[tag] {
+			tags, seen[tag] = append(tags, tag), true
+		}
+	}
+	return tags
+}
@@ -0,0 +1,138 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package memory
+
+import "github.com/mdhender/conduit/internal/store"
+
+// Transact implements the store.TxStore interface.
+//
+// fn works on a copy of the store, so rolling back is just a matter of
+// dropping the copy. If fn succeeds, the copy's contents replace the
+// store's and its changes are committed to the journal; if that commit
+// fails, the old contents are put back, so unlike a single change, a
+// unit of work that fails to commit doesn't leave the store refusing
+// changes. The lock is held throughout so that no other change can slip
+// in between the copy and the swap.
+// Copying costs time in proportion to the size of the store, which is
+// fine for the data sets this store is meant for.
+func (db *Store) Transact(fn func(tx store.Store) error) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.failed != nil {
+		return db.failed
+	}
+
+	tx := db.copy()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	db.swap(tx)
+	if j, ok := tx.journal.(*txJournal); ok {
+		for _, r := range j.commits {
+			db.touchRecords(r)
+		}
+	}
+	if err := db.commit(); err != nil {
+		// the records stay marked, which is harmless: the next commit
+		// writes their current contents, which are the old ones again
+		db.swap(tx)
+		db.failed = nil
+		return err
+	}
+	return nil
+}
+
+// copy returns a copy of the store that shares no records with it.
+// If the store has a journal, the copy collects its commits so that
+// they can be replayed into the store's journal.
+// The caller must hold the lock.
+func (db *Store) copy() *Store {
+	cp := newStore(db.passwords)
+	cp.dummyHash = db.dummyHash
+	// the snapshot comes from a consistent store, so it always applies
+	_ = cp.apply(db.snapshot())
+	cp.sessions.gcAt = db.sessions.gcAt
+	if db.journal != nil {
+		cp.journal = &txJournal{}
+	}
+	return cp
+}
+
+// swap exchanges the contents of the two stores.
+// The caller must hold the locks on both.
+func (db *Store) swap(other *Store) {
+	db.seq, other.seq = other.seq, db.seq
+	db.articles, other.articles = other.articles, db.articles
+	db.comments, other.comments = other.comments, db.comments
+	db.sessions, other.sessions = other.sessions, db.sessions
+	db.users, other.users = other.users, db.users
+}
+
+// touchRecords marks every record in r as changed.
+func (db *Store) touchRecords(r *Records) {
+	for _, rec := range r.Users {
+		db.touchUser(rec.Id)
+	}
+	for _, id := range r.DeletedUsers {
+		db.touchUser(id)
+	}
+	for _, rec := range r.Articles {
+		db.touchArticle(rec.Id)
+	}
+	for _, id := range r.DeletedArticles {
+		db.touchArticle(id)
+	}
+	for _, rec := range r.RefreshTokens {
+		db.touchToken(rec.Hash)
+	}
+	for _, hash := range r.DeletedTokens {
+		db.touchToken(hash)
+	}
+	for _, rec := range r.RevokedTokens {
+		db.touchRevoked(rec.Jti)
+	}
+	for _, jti := range r.UnrevokedTokens {
+		db.touchRevoked(jti)
+	}
+}
+
+// txJournal is the journal of a copy made for a transaction.
+// It keeps the commits until the transaction ends.
+type txJournal struct {
+	commits []*Records
+}
+
+func (j *txJournal) Commit(r *Records) error {
+	j.commits = append(j.commits, r)
+	return nil
+}
+
+func (j *txJournal) Compact(snapshot *Records) error {
+	return nil
+}
+
+func (j *txJournal) ShouldCompact() bool {
+	return false
+}
@@ -26,12 +26,46 @@
 // We don't care about their internal details; only what this model needs.
 package model
 
+type Article struct {
+	Id             int
+	Slug           string
+	Title          string
+	Description    string
+	Body           string
+	TagList        []string
+	CreatedAt      string // "2021-03-27T16:58:01.233Z"
+	UpdatedAt      string // "2021-03-27T16:58:01.245Z"
+	Version        int    // see User.Version
+	Favorited      bool
+	FavoritesCount int
+	Author         Profile
+}
+
+// ArticleFilter selects the articles returned by a listing.
+// Empty fields are not used to filter.
+type ArticleFilter struct {
+	Tag       string // only articles with this tag
+	Author    string // only articles written by this username
+	Favorited string // only articles favorited by this username
+	Limit     int    // maximum number of articles to return
+	Offset    int    // number of articles to skip
+}
+
+type Comment struct {
+	Id        int
+	CreatedAt string // "2021-03-27T16:58:01.233Z"
+	UpdatedAt string // "2021-03-27T16:58:01.245Z"
+	Body      string
+	Author    Profile
+}
+
 type Profile struct {
 	Id         int
 	Username   string
 	Bio        *string
 	Image      *string
 	Following  bool
+	Version    int // the version of the user
 	bio, image string
 }
 
@@ -39,10 +73,98 @@ type User struct {
 	Id        int
 	Username  string
 	Email     string
-	Password  string
 	CreatedAt string // "2021-03-27T16:58:01.233Z"
 	UpdatedAt string // "2021-03-27T16:58:01.245Z"
 	Bio       *string
 	Image     *string
 	Following []string // list of usernames being followed
+
+	// Version starts at 1 and is bumped along with UpdatedAt.
+	// Clients use it to detect that the record changed since they read it.
+	Version int
+
+	// TokenGeneration is bumped when all of the user's sessions are revoked.
+	// Tokens minted for an earlier generation are no longer accepted.
+	TokenGeneration int
+
+	// Roles granted to the user, sorted by name.
+	// Every user implicitly has the "authenticated" role, which isn't listed.
+	Roles []string
+
+	Suspended             bool // set by an admin; the user can't log in or use their tokens
+	PasswordResetRequired bool // set by an admin; the user must change their password to log in
+}
+
+// UserFilter selects the users returned by a listing.
+// Empty fields are not used to filter.
+type UserFilter struct {
+	Search string // only users whose username or e-mail contains this, ignoring case
+	Limit  int    // maximum number of users to return
+	Offset int    // number of users to skip
+}
+
+// Record is one entry in a backup of a store.
+// Exactly one of the fields is set.
+//
+// Records refer to each other by id. A backup lists the users first,
+// then the follows, articles, favorites and comments, so that every
+// record comes after the records it refers to.
+type Record struct {
+	User     *UserRecord
+	Follow   *FollowRecord
+	Article  *ArticleRecord
+	Favorite *FavoriteRecord
+	Comment  *CommentRecord
+}
+
+// UserRecord is a user with the fields that the store otherwise keeps to itself.
+type UserRecord struct {
+	Id                    int
+	Username              string
+	Email                 string
+	PasswordHash          string
+	CreatedAt             string
+	UpdatedAt             string
+	Version               int
+	Bio                   *string
+	Image                 *string
+	TokenGeneration       int
+	Roles                 []string
+	Suspended             bool
+	PasswordResetRequired bool
+}
+
+// FollowRecord means that the user follows the target.
+type FollowRecord struct {
+	User   int
+	Target int
+}
+
+type ArticleRecord struct {
+	Id          int
+	Slug        string
+	Aliases     []string // slugs used before the article was renamed
+	Title       string
+	Description string
+	Body        string
+	TagList     []string
+	Author      int
+	CreatedAt   string
+	UpdatedAt   string
+	Version     int
+}
+
+// FavoriteRecord means that the user favorited the article.
+type FavoriteRecord struct {
+	User    int
+	Article int
+}
+
+type CommentRecord struct {
+	Id        int
+	Article   int
+	Author    int
+	Body      string
+	CreatedAt string
+	UpdatedAt string
 }
@@ -0,0 +1,202 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/mdhender/conduit/internal/store/model"
+	"strings"
+)
+
+// ChangePassword replaces the user's password if the current one matches.
+// It clears any required reset and revokes all of the user's sessions.
+func (db *Store) ChangePassword(email, password, newPassword string) (*model.User, map[string][]string, error) {
+	errs := make(map[string][]string)
+	if newPassword = strings.TrimSpace(newPassword); newPassword == "" {
+		errs["newPassword"] = append(errs["newPassword"], "can't be blank")
+		return nil, errs, nil
+	}
+	u, _, err := db.authenticate(email, password)
+	if err != nil {
+		return nil, nil, err
+	} else if u == nil {
+		errs["email or password"] = append(errs["email or password"], "is invalid")
+		return nil, errs, nil
+	}
+	h, err := db.passwords.Hash(newPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var user *model.User
+	err = db.transact(func(tx *sql.Tx) error {
+		current, err := getUser(tx, u.id)
+		if err == ErrNotFound || (err == nil && current.passwordHash != u.passwordHash) { // changed while we were verifying
+			errs["email or password"] = append(errs["email or password"], "is invalid")
+			return nil
+		} else if err != nil {
+			return err
+		} else if current.suspended {
+			errs["email"] = append(errs["email"], "is suspended")
+			return nil
+		}
+		if _, err = tx.Exec(`UPDATE users SET password_hash = $1, password_reset_required = FALSE, updated_at = $2, version = version + 1 WHERE id = $3`, h, now(), u.id); err != nil {
+			return err
+		} else if err = revokeSessions(tx, u.id); err != nil {
+			return err
+		}
+		if current, err = getUser(tx, u.id); err != nil {
+			return err
+		}
+		user, err = current.asModelUser(tx)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	} else if len(errs) != 0 {
+		return nil, errs, nil
+	}
+	return user, nil, nil
+}
+
+// DeleteUser deletes the user along with their articles, comments,
+// favorites, follows and sessions.
+func (db *Store) DeleteUser(id int) error {
+	if id == 0 {
+		return ErrNotAuthorized
+	}
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); err != nil {
+			return err
+		}
+		articles, err := queryInts(tx, `SELECT id FROM articles WHERE author_id = $1`, id)
+		if err != nil {
+			return err
+		}
+		for _, articleId := range articles {
+			if err = removeArticle(tx, articleId); err != nil {
+				return err
+			}
+		}
+		for _, stmt := range []string{
+			`DELETE FROM favorites WHERE user_id = $1`,
+			`DELETE FROM comments WHERE author_id = $1`,
+			`DELETE FROM follows WHERE user_id = $1 OR target_id = $1`,
+			`DELETE FROM refresh_tokens WHERE user_id = $1`,
+			`DELETE FROM user_roles WHERE user_id = $1`,
+			`DELETE FROM users WHERE id = $1`,
+		} {
+			if _, err = tx.Exec(stmt, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListUsers returns the users that match the filter, oldest first,
+// along with the number of users that matched.
+func (db *Store) ListUsers(filter model.UserFilter) ([]*model.User, int, error) {
+	where, args := "", []interface{}{}
+	if search := strings.ToLower(strings.TrimSpace(filter.Search)); search != "" {
+		// escape the wildcards so that they match themselves
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(search) + "%"
+		where, args = ` WHERE LOWER(username) LIKE $1 ESCAPE '\' OR LOWER(email) LIKE $1 ESCAPE '\'`, append(args, pattern)
+	}
+
+	var list []*model.User
+	var count int
+	err := db.transact(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM users`+where, args...).Scan(&count); err != nil {
+			return err
+		}
+		ids, err := queryInts(tx, fmt.Sprintf(`SELECT id FROM users%s ORDER BY id LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2), append(args, filter.Limit, filter.Offset)...)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			u, err := getUser(tx, id)
+			if err != nil {
+				return err
+			}
+			user, err := u.asModelUser(tx)
+			if err != nil {
+				return err
+			}
+			list = append(list, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, count, nil
+}
+
+// RequirePasswordReset stops the user from logging in until they change
+// their password, and revokes all of their sessions.
+func (db *Store) RequirePasswordReset(id int) (*model.User, error) {
+	return db.updateAccount(id, true, `UPDATE users SET password_reset_required = TRUE WHERE id = $1`)
+}
+
+// SuspendUser suspends or restores the user.
+// While suspended, the user can't log in or refresh their sessions.
+func (db *Store) SuspendUser(id int, suspended bool) (*model.User, error) {
+	return db.updateAccount(id, false, `UPDATE users SET suspended = $2 WHERE id = $1`, suspended)
+}
+
+// updateAccount runs the statement to update the user with the given id,
+// revoking their sessions if asked to, and returns the updated user.
+// The id is always the first argument to the statement.
+func (db *Store) updateAccount(id int, revoke bool, stmt string, args ...interface{}) (*model.User, error) {
+	if id == 0 {
+		return nil, ErrNotAuthorized
+	}
+	var user *model.User
+	err := db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stmt, append([]interface{}{id}, args...)...); err != nil {
+			return err
+		}
+		if revoke {
+			if err := revokeSessions(tx, id); err != nil {
+				return err
+			}
+		}
+		u, err := getUser(tx, id)
+		if err != nil {
+			return err
+		}
+		user, err = u.asModelUser(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
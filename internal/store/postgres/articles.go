@@ -0,0 +1,510 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/mdhender/conduit/internal/slug"
+	"github.com/mdhender/conduit/internal/store/model"
+	"strings"
+)
+
+func (db *Store) CreateArticle(id int, title, description, body string, tagList []string) (*model.Article, map[string][]string, error) {
+	errs := make(map[string][]string)
+
+	var article *model.Article
+	err := db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); id == 0 || err == ErrNotFound {
+			errs["author"] = append(errs["author"], "must be a registered user")
+		} else if err != nil {
+			return err
+		}
+		if title = strings.TrimSpace(title); title == "" {
+			errs["title"] = append(errs["title"], "can't be blank")
+		}
+		if description = strings.TrimSpace(description); description == "" {
+			errs["description"] = append(errs["description"], "can't be blank")
+		}
+		if body = strings.TrimSpace(body); body == "" {
+			errs["body"] = append(errs["body"], "can't be blank")
+		}
+		if len(errs) != 0 {
+			return nil
+		}
+
+		articleId, err := nextId(tx, "articles")
+		if err != nil {
+			return err
+		}
+		a := &articleRow{
+			id:          articleId,
+			title:       title,
+			description: description,
+			body:        body,
+			authorId:    id,
+			createdAt:   now(),
+		}
+		a.updatedAt = a.createdAt
+		if a.slug, err = findSlug(tx, a.id, a.title); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`INSERT INTO articles (id, slug, title, description, body, author_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			a.id, a.slug, a.title, a.description, a.body, a.authorId, a.createdAt, a.updatedAt); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`INSERT INTO article_slugs (slug, article_id) VALUES ($1, $2)`, a.slug, a.id); err != nil {
+			return err
+		}
+		if err = setTags(tx, a.id, normalizeTags(tagList)); err != nil {
+			return err
+		}
+		article, err = a.asModelArticle(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	} else if len(errs) != 0 {
+		return nil, errs, nil
+	}
+	return article, nil, nil
+}
+
+// DeleteArticle deletes the article along with its comments, favorites, and slugs.
+// Only the author of the article is allowed to delete it.
+func (db *Store) DeleteArticle(id int, slug string) error {
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); id == 0 || err == ErrNotFound {
+			return ErrNotAuthorized
+		} else if err != nil {
+			return err
+		}
+		a, err := getArticleBySlug(tx, slug)
+		if err != nil {
+			return err
+		} else if a.authorId != id {
+			return ErrForbidden
+		}
+		return removeArticle(tx, a.id)
+	})
+}
+
+// FavoriteArticle adds the article to the favorites of the user with the given id.
+// Favoriting an article more than once has no effect.
+func (db *Store) FavoriteArticle(id int, slug string) (*model.Article, error) {
+	return db.setFavorite(id, slug, `INSERT INTO favorites (user_id, article_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`)
+}
+
+// FeedArticles returns the articles written by the users that the user with
+// the given id follows, newest first. It also returns the number of articles
+// in the feed before the limit and offset were applied.
+func (db *Store) FeedArticles(id, limit, offset int) ([]*model.Article, int, error) {
+	if _, err := getUser(db.q, id); id == 0 || err == ErrNotFound {
+		return nil, 0, ErrNotAuthorized
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return db.page(id, []string{`a.author_id IN (SELECT target_id FROM follows WHERE user_id = $1)`}, []interface{}{id}, limit, offset)
+}
+
+// GetArticleBySlug returns the article as seen by the user with the given id.
+// The slug may be the article's current slug or one it used before being renamed.
+func (db *Store) GetArticleBySlug(id int, slug string) (*model.Article, error) {
+	a, err := getArticleBySlug(db.q, slug)
+	if err != nil {
+		return nil, err
+	}
+	return a.asModelArticle(db.q, id)
+}
+
+// ListArticles returns the articles that match the filter, newest first,
+// as seen by the user with the given id. It also returns the number of
+// articles that matched before the limit and offset were applied.
+func (db *Store) ListArticles(id int, filter model.ArticleFilter) ([]*model.Article, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Author != "" {
+		args = append(args, filter.Author)
+		conditions = append(conditions, fmt.Sprintf(`a.author_id IN (SELECT id FROM users WHERE username = $%d)`, len(args)))
+	}
+	if filter.Tag = normalizeTag(filter.Tag); filter.Tag != "" {
+		args = append(args, filter.Tag)
+		conditions = append(conditions, fmt.Sprintf(`a.id IN (SELECT article_id FROM article_tags WHERE tag = $%d)`, len(args)))
+	}
+	if filter.Favorited != "" {
+		args = append(args, filter.Favorited)
+		conditions = append(conditions, fmt.Sprintf(`a.id IN (SELECT f.article_id FROM favorites f JOIN users u ON u.id = f.user_id WHERE u.username = $%d)`, len(args)))
+	}
+	return db.page(id, conditions, args, filter.Limit, filter.Offset)
+}
+
+// UnfavoriteArticle removes the article from the favorites of the user with the given id.
+// Unfavoriting an article that isn't a favorite has no effect.
+func (db *Store) UnfavoriteArticle(id int, slug string) (*model.Article, error) {
+	return db.setFavorite(id, slug, `DELETE FROM favorites WHERE user_id = $1 AND article_id = $2`)
+}
+
+// UpdateArticle updates the article with the given slug.
+// Only the author of the article is allowed to update it.
+// A nil tag list leaves the tags alone; an empty one removes them.
+// If the title changes, the article is given a new slug and the old
+// slug is kept as an alias so that existing links still resolve.
+func (db *Store) UpdateArticle(id int, slug string, title, description, body *string, tagList *[]string) (*model.Article, map[string][]string, error) {
+	errs := make(map[string][]string)
+
+	var article *model.Article
+	err := db.transact(func(tx *sql.Tx) error {
+		a, err := getArticleBySlug(tx, slug)
+		if err != nil {
+			return err
+		} else if id == 0 {
+			return ErrNotAuthorized
+		} else if a.authorId != id {
+			return ErrForbidden
+		}
+		tags, err := getTags(tx, a.id)
+		if err != nil {
+			return err
+		}
+
+		cp, changes := *a, false
+		if title != nil {
+			if val := strings.TrimSpace(*title); val == "" {
+				errs["title"] = append(errs["title"], "must not be empty if provided")
+			} else if val != a.title {
+				cp.title = val
+				changes = true
+			}
+		}
+		if description != nil {
+			if val := strings.TrimSpace(*description); val == "" {
+				errs["description"] = append(errs["description"], "must not be empty if provided")
+			} else if val != a.description {
+				cp.description = val
+				changes = true
+			}
+		}
+		if body != nil {
+			if val := strings.TrimSpace(*body); val == "" {
+				errs["body"] = append(errs["body"], "must not be empty if provided")
+			} else if val != a.body {
+				cp.body = val
+				changes = true
+			}
+		}
+		var newTags []string
+		if tagList != nil {
+			if newTags = normalizeTags(*tagList); !equalTags(newTags, tags) {
+				changes = true
+			}
+		}
+		if len(errs) != 0 {
+			return nil
+		}
+
+		if changes {
+			if cp.title != a.title {
+				if cp.slug, err = findSlug(tx, a.id, cp.title); err != nil {
+					return err
+				} else if _, err = tx.Exec(`INSERT INTO article_slugs (slug, article_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, cp.slug, a.id); err != nil {
+					return err
+				}
+			}
+			if tagList != nil {
+				if err = setTags(tx, a.id, newTags); err != nil {
+					return err
+				}
+			}
+			cp.updatedAt, cp.version = now(), cp.version+1
+			if _, err = tx.Exec(`UPDATE articles SET slug = $1, title = $2, description = $3, body = $4, updated_at = $5, version = version + 1 WHERE id = $6`,
+				cp.slug, cp.title, cp.description, cp.body, cp.updatedAt, a.id); err != nil {
+				return err
+			}
+		}
+		article, err = cp.asModelArticle(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	} else if len(errs) != 0 {
+		return nil, errs, nil
+	}
+	return article, nil, nil
+}
+
+// page returns the requested page of the articles that match all of the
+// conditions, newest first, as seen by the user with the given id.
+// It also returns the number of articles that matched.
+// The count and the page are read from the same snapshot, so they agree,
+// and the page is loaded with a fixed number of queries however long it is.
+func (db *Store) page(id int, conditions []string, args []interface{}, limit, offset int) ([]*model.Article, int, error) {
+	where := ""
+	if len(conditions) != 0 {
+		where = ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	var list []*model.Article
+	var count int
+	err := db.snapshot(func(q querier) error {
+		if err := q.QueryRow(`SELECT COUNT(*) FROM articles a`+where, args...).Scan(&count); err != nil {
+			return err
+		}
+
+		// the favorites are joined to count them and to see if the user is among them
+		args := append(append([]interface{}{}, args...), id, limit, offset)
+		rows, err := q.Query(fmt.Sprintf(`SELECT %s, COUNT(fc.user_id), MAX(CASE WHEN fc.user_id = $%d THEN 1 ELSE 0 END)
+			FROM articles a LEFT JOIN favorites fc ON fc.article_id = a.id%s
+			GROUP BY a.id ORDER BY a.id DESC LIMIT $%d OFFSET $%d`, articleColumns, len(args)-2, where, len(args)-1, len(args)), args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a articleRow
+			var favorited int
+			article := &model.Article{}
+			if err := rows.Scan(&a.id, &a.slug, &a.title, &a.description, &a.body, &a.authorId, &a.createdAt, &a.updatedAt, &a.version, &article.FavoritesCount, &favorited); err != nil {
+				return err
+			}
+			article.Id, article.Slug, article.Title, article.Description, article.Body = a.id, a.slug, a.title, a.description, a.body
+			article.CreatedAt, article.UpdatedAt, article.Version = a.createdAt, a.updatedAt, a.version
+			article.Favorited = favorited != 0
+			article.Author.Id = a.authorId
+			list = append(list, article)
+		}
+		if err = rows.Err(); err != nil || len(list) == 0 {
+			return err
+		}
+		if err = loadTags(q, list); err != nil {
+			return err
+		}
+		return loadAuthors(q, id, list)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, count, nil
+}
+
+// loadTags sets the tags on the articles.
+func loadTags(q querier, articles []*model.Article) error {
+	var articleIds []interface{}
+	byId := make(map[int]*model.Article)
+	for _, article := range articles {
+		articleIds = append(articleIds, article.Id)
+		byId[article.Id] = article
+	}
+	rows, err := q.Query(`SELECT article_id, tag FROM article_tags WHERE article_id IN (`+placeholders(1, len(articleIds))+`) ORDER BY article_id, position`, articleIds...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var articleId int
+		var tag string
+		if err := rows.Scan(&articleId, &tag); err != nil {
+			return err
+		}
+		byId[articleId].TagList = append(byId[articleId].TagList, tag)
+	}
+	return rows.Err()
+}
+
+// loadAuthors replaces the author of each article, which only has its id set,
+// with the author's profile as seen by the user with the given id.
+func loadAuthors(q querier, id int, articles []*model.Article) error {
+	var authorIds []interface{}
+	seen := make(map[int]bool)
+	for _, article := range articles {
+		if !seen[article.Author.Id] {
+			seen[article.Author.Id] = true
+			authorIds = append(authorIds, article.Author.Id)
+		}
+	}
+	following := make(map[int]bool)
+	if id != 0 {
+		ids, err := queryInts(q, `SELECT target_id FROM follows WHERE user_id = $1 AND target_id IN (`+placeholders(2, len(authorIds))+`)`, append([]interface{}{id}, authorIds...)...)
+		if err != nil {
+			return err
+		}
+		for _, targetId := range ids {
+			following[targetId] = true
+		}
+	}
+	rows, err := q.Query(`SELECT `+userColumns+` FROM users WHERE id IN (`+placeholders(1, len(authorIds))+`)`, authorIds...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	profiles := make(map[int]*model.Profile)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		profiles[u.id] = u.asProfile(following[u.id])
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, article := range articles {
+		profile, ok := profiles[article.Author.Id]
+		if !ok {
+			return ErrNotFound
+		}
+		article.Author = *profile
+	}
+	return nil
+}
+
+// setFavorite runs the statement to favorite or unfavorite the article
+// and returns the article as seen by the user with the given id.
+func (db *Store) setFavorite(id int, slug, stmt string) (*model.Article, error) {
+	var article *model.Article
+	err := db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); id == 0 || err == ErrNotFound {
+			return ErrNotAuthorized
+		} else if err != nil {
+			return err
+		}
+		a, err := getArticleBySlug(tx, slug)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(stmt, id, a.id); err != nil {
+			return err
+		}
+		article, err = a.asModelArticle(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return article, nil
+}
+
+// findSlug derives the slug for an article from its title.
+// If the slug is already used by another article, a numeric suffix is added.
+// Prior slugs stay in the article_slugs table as aliases that continue to
+// resolve to the article, so re-using a prior title restores the prior slug.
+func findSlug(q querier, articleId int, title string) (string, error) {
+	base := slug.Make(title)
+	for n := 1; ; n++ {
+		candidate := slug.WithSuffix(base, n)
+		var owner int
+		if err := q.QueryRow(`SELECT article_id FROM article_slugs WHERE slug = $1`, candidate).Scan(&owner); err == sql.ErrNoRows || (err == nil && owner == articleId) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// removeArticle deletes the article and its comments, favorites, tags and slugs.
+func removeArticle(q querier, articleId int) error {
+	for _, stmt := range []string{
+		`DELETE FROM comments WHERE article_id = $1`,
+		`DELETE FROM favorites WHERE article_id = $1`,
+		`DELETE FROM article_tags WHERE article_id = $1`,
+		`DELETE FROM article_slugs WHERE article_id = $1`,
+		`DELETE FROM articles WHERE id = $1`,
+	} {
+		if _, err := q.Exec(stmt, articleId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// articleRow is a row from the articles table.
+type articleRow struct {
+	id          int
+	slug        string
+	title       string
+	description string
+	body        string
+	authorId    int
+	createdAt   string
+	updatedAt   string
+	version     int
+}
+
+const articleColumns = `a.id, a.slug, a.title, a.description, a.body, a.author_id, a.created_at, a.updated_at, a.version`
+
+func scanArticle(row scanner) (*articleRow, error) {
+	var a articleRow
+	err := row.Scan(&a.id, &a.slug, &a.title, &a.description, &a.body, &a.authorId, &a.createdAt, &a.updatedAt, &a.version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func getArticle(q querier, id int) (*articleRow, error) {
+	return scanArticle(q.QueryRow(`SELECT `+articleColumns+` FROM articles a WHERE a.id = $1`, id))
+}
+
+// getArticleBySlug returns the article with the slug or one of its aliases.
+func getArticleBySlug(q querier, slug string) (*articleRow, error) {
+	return scanArticle(q.QueryRow(`SELECT `+articleColumns+` FROM articles a JOIN article_slugs s ON s.article_id = a.id WHERE s.slug = $1`, slug))
+}
+
+// asModelArticle returns a copy of the article as seen by the user with the given id.
+// The id may be zero (for example, when the request isn't authenticated).
+func (a *articleRow) asModelArticle(q querier, id int) (*model.Article, error) {
+	article := &model.Article{
+		Id:          a.id,
+		Slug:        a.slug,
+		Title:       a.title,
+		Description: a.description,
+		Body:        a.body,
+		CreatedAt:   a.createdAt,
+		UpdatedAt:   a.updatedAt,
+		Version:     a.version,
+	}
+	author, err := getUser(q, a.authorId)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := author.asModelProfile(q, id)
+	if err != nil {
+		return nil, err
+	}
+	article.Author = *profile
+	if article.TagList, err = getTags(q, a.id); err != nil {
+		return nil, err
+	}
+	if err = q.QueryRow(`SELECT COUNT(*) FROM favorites WHERE article_id = $1`, a.id).Scan(&article.FavoritesCount); err != nil {
+		return nil, err
+	}
+	if id != 0 {
+		if article.Favorited, err = exists(q, `SELECT 1 FROM favorites WHERE user_id = $1 AND article_id = $2`, id, a.id); err != nil {
+			return nil, err
+		}
+	}
+	return article, nil
+}
@@ -0,0 +1,349 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"io"
+)
+
+// Export writes a copy of every user, follow, article, favorite and comment.
+// The rows are read in a read-only transaction (or the Transact transaction)
+// so that the copy is consistent, and each record is written as soon as it is read.
+func (db *Store) Export(w store.RecordWriter) error {
+	var tx querier = db.tx
+	if db.tx == nil {
+		t, err := db.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer t.Rollback()
+		tx = t
+	}
+
+	// roles, tags and aliases are small, so load them first rather than
+	// running a query per row while another query is still open
+	roles := make(map[int][]string)
+	err := forEach(tx, `SELECT user_id, role FROM user_roles ORDER BY user_id, role`, func(rows *sql.Rows) error {
+		var id int
+		var role string
+		if err := rows.Scan(&id, &role); err != nil {
+			return err
+		}
+		roles[id] = append(roles[id], role)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	tags := make(map[int][]string)
+	err = forEach(tx, `SELECT article_id, tag FROM article_tags ORDER BY article_id, position`, func(rows *sql.Rows) error {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		tags[id] = append(tags[id], tag)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	aliases := make(map[int][]string)
+	err = forEach(tx, `SELECT s.article_id, s.slug FROM article_slugs s JOIN articles a ON a.id = s.article_id WHERE s.slug <> a.slug ORDER BY s.article_id, s.slug`, func(rows *sql.Rows) error {
+		var id int
+		var slug string
+		if err := rows.Scan(&id, &slug); err != nil {
+			return err
+		}
+		aliases[id] = append(aliases[id], slug)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = forEach(tx, `SELECT `+userColumns+` FROM users ORDER BY id`, func(rows *sql.Rows) error {
+		u, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		rec := &model.UserRecord{
+			Id:                    u.id,
+			Username:              u.username,
+			Email:                 u.email,
+			PasswordHash:          u.passwordHash,
+			CreatedAt:             u.createdAt,
+			UpdatedAt:             u.updatedAt,
+			Version:               u.version,
+			TokenGeneration:       u.tokenGeneration,
+			Roles:                 roles[u.id],
+			Suspended:             u.suspended,
+			PasswordResetRequired: u.passwordResetRequired,
+		}
+		if u.bio.Valid {
+			rec.Bio = &u.bio.String
+		}
+		if u.image.Valid {
+			rec.Image = &u.image.String
+		}
+		return w.WriteRecord(&model.Record{User: rec})
+	})
+	if err != nil {
+		return err
+	}
+	err = forEach(tx, `SELECT user_id, target_id FROM follows ORDER BY user_id, target_id`, func(rows *sql.Rows) error {
+		var rec model.FollowRecord
+		if err := rows.Scan(&rec.User, &rec.Target); err != nil {
+			return err
+		}
+		return w.WriteRecord(&model.Record{Follow: &rec})
+	})
+	if err != nil {
+		return err
+	}
+	err = forEach(tx, `SELECT `+articleColumns+` FROM articles a ORDER BY a.id`, func(rows *sql.Rows) error {
+		a, err := scanArticle(rows)
+		if err != nil {
+			return err
+		}
+		rec := &model.ArticleRecord{
+			Id:          a.id,
+			Slug:        a.slug,
+			Aliases:     aliases[a.id],
+			Title:       a.title,
+			Description: a.description,
+			Body:        a.body,
+			TagList:     tags[a.id],
+			Author:      a.authorId,
+			CreatedAt:   a.createdAt,
+			UpdatedAt:   a.updatedAt,
+			Version:     a.version,
+		}
+		return w.WriteRecord(&model.Record{Article: rec})
+	})
+	if err != nil {
+		return err
+	}
+	err = forEach(tx, `SELECT user_id, article_id FROM favorites ORDER BY article_id, user_id`, func(rows *sql.Rows) error {
+		var rec model.FavoriteRecord
+		if err := rows.Scan(&rec.User, &rec.Article); err != nil {
+			return err
+		}
+		return w.WriteRecord(&model.Record{Favorite: &rec})
+	})
+	if err != nil {
+		return err
+	}
+	return forEach(tx, `SELECT id, article_id, author_id, body, created_at, updated_at FROM comments ORDER BY id`, func(rows *sql.Rows) error {
+		var rec model.CommentRecord
+		if err := rows.Scan(&rec.Id, &rec.Article, &rec.Author, &rec.Body, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+			return err
+		}
+		return w.WriteRecord(&model.Record{Comment: &rec})
+	})
+}
+
+// Import loads the records into an empty store in a single transaction,
+// so a bad record leaves the store untouched.
+func (db *Store) Import(r store.RecordReader) error {
+	return db.transact(func(tx *sql.Tx) error {
+		if found, err := exists(tx, `SELECT 1 FROM users LIMIT 1`); err != nil {
+			return err
+		} else if found {
+			return ErrNotEmpty
+		}
+		sequences := map[string]int{"users": 0, "articles": 0, "comments": 0}
+		for {
+			rec, err := r.ReadRecord()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			switch {
+			case rec.User != nil:
+				err = importUser(tx, rec.User)
+				if rec.User.Id > sequences["users"] {
+					sequences["users"] = rec.User.Id
+				}
+			case rec.Follow != nil:
+				err = importFollow(tx, rec.Follow)
+			case rec.Article != nil:
+				err = importArticle(tx, rec.Article)
+				if rec.Article.Id > sequences["articles"] {
+					sequences["articles"] = rec.Article.Id
+				}
+			case rec.Favorite != nil:
+				err = importFavorite(tx, rec.Favorite)
+			case rec.Comment != nil:
+				err = importComment(tx, rec.Comment)
+				if rec.Comment.Id > sequences["comments"] {
+					sequences["comments"] = rec.Comment.Id
+				}
+			default:
+				err = fmt.Errorf("import: empty record")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		// keep the sequences ahead of the imported ids
+		for name, value := range sequences {
+			if _, err := tx.Exec(`UPDATE sequences SET value = $1 WHERE name = $2 AND value < $1`, value, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// The import functions check references themselves because SQLite
+// doesn't enforce foreign keys unless asked to.
+
+func importUser(q querier, u *model.UserRecord) error {
+	if u.Id <= 0 || u.Username == "" || u.Email == "" {
+		return fmt.Errorf("import: user %d: missing id, username or email", u.Id)
+	}
+	if _, err := q.Exec(`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		u.Id, u.Username, u.Email, u.PasswordHash, nullString(u.Bio), nullString(u.Image), u.CreatedAt, u.UpdatedAt, u.TokenGeneration, u.Suspended, u.PasswordResetRequired, recordVersion(u.Version)); err != nil {
+		return fmt.Errorf("import: user %d: %w", u.Id, err)
+	}
+	for _, role := range u.Roles {
+		if _, err := q.Exec(`INSERT INTO user_roles (user_id, role) VALUES ($1, $2)`, u.Id, role); err != nil {
+			return fmt.Errorf("import: user %d: %w", u.Id, err)
+		}
+	}
+	return nil
+}
+
+func importFollow(q querier, f *model.FollowRecord) error {
+	if f.User == f.Target {
+		return fmt.Errorf("import: follow %d -> %d: user can't follow self", f.User, f.Target)
+	} else if err := requireRows(q, `SELECT COUNT(*) FROM users WHERE id IN ($1, $2)`, 2, f.User, f.Target); err != nil {
+		return fmt.Errorf("import: follow %d -> %d: unknown user", f.User, f.Target)
+	}
+	if _, err := q.Exec(`INSERT INTO follows (user_id, target_id) VALUES ($1, $2)`, f.User, f.Target); err != nil {
+		return fmt.Errorf("import: follow %d -> %d: %w", f.User, f.Target, err)
+	}
+	return nil
+}
+
+func importArticle(q querier, a *model.ArticleRecord) error {
+	if a.Id <= 0 || a.Slug == "" {
+		return fmt.Errorf("import: article %d: missing id or slug", a.Id)
+	} else if err := requireRows(q, `SELECT COUNT(*) FROM users WHERE id = $1`, 1, a.Author); err != nil {
+		return fmt.Errorf("import: article %d: unknown author %d", a.Id, a.Author)
+	}
+	if _, err := q.Exec(`INSERT INTO articles (id, slug, title, description, body, author_id, created_at, updated_at, version) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		a.Id, a.Slug, a.Title, a.Description, a.Body, a.Author, a.CreatedAt, a.UpdatedAt, recordVersion(a.Version)); err != nil {
+		return fmt.Errorf("import: article %d: %w", a.Id, err)
+	}
+	for _, slug := range append([]string{a.Slug}, a.Aliases...) {
+		if slug == "" {
+			return fmt.Errorf("import: article %d: empty alias", a.Id)
+		} else if _, err := q.Exec(`INSERT INTO article_slugs (slug, article_id) VALUES ($1, $2)`, slug, a.Id); err != nil {
+			return fmt.Errorf("import: article %d: slug %q: %w", a.Id, slug, err)
+		}
+	}
+	if err := setTags(q, a.Id, normalizeTags(a.TagList)); err != nil {
+		return fmt.Errorf("import: article %d: %w", a.Id, err)
+	}
+	return nil
+}
+
+func importFavorite(q querier, f *model.FavoriteRecord) error {
+	if err := requireRows(q, `SELECT COUNT(*) FROM users WHERE id = $1`, 1, f.User); err != nil {
+		return fmt.Errorf("import: favorite %d -> %d: unknown user or article", f.User, f.Article)
+	} else if err = requireRows(q, `SELECT COUNT(*) FROM articles WHERE id = $1`, 1, f.Article); err != nil {
+		return fmt.Errorf("import: favorite %d -> %d: unknown user or article", f.User, f.Article)
+	}
+	if _, err := q.Exec(`INSERT INTO favorites (user_id, article_id) VALUES ($1, $2)`, f.User, f.Article); err != nil {
+		return fmt.Errorf("import: favorite %d -> %d: %w", f.User, f.Article, err)
+	}
+	return nil
+}
+
+func importComment(q querier, c *model.CommentRecord) error {
+	if c.Id <= 0 {
+		return fmt.Errorf("import: comment %d: missing id", c.Id)
+	} else if err := requireRows(q, `SELECT COUNT(*) FROM users WHERE id = $1`, 1, c.Author); err != nil {
+		return fmt.Errorf("import: comment %d: unknown author or article", c.Id)
+	} else if err = requireRows(q, `SELECT COUNT(*) FROM articles WHERE id = $1`, 1, c.Article); err != nil {
+		return fmt.Errorf("import: comment %d: unknown author or article", c.Id)
+	}
+	if _, err := q.Exec(`INSERT INTO comments (id, article_id, author_id, body, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		c.Id, c.Article, c.Author, c.Body, c.CreatedAt, c.UpdatedAt); err != nil {
+		return fmt.Errorf("import: comment %d: %w", c.Id, err)
+	}
+	return nil
+}
+
+// forEach calls fn for every row returned by the query.
+func forEach(q querier, query string, fn func(rows *sql.Rows) error, args ...interface{}) error {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// requireRows returns ErrNotFound unless the count returned by the query is n.
+func requireRows(q querier, query string, n int, args ...interface{}) error {
+	var count int
+	if err := q.QueryRow(query, args...).Scan(&count); err != nil {
+		return err
+	} else if count != n {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// nullString returns the value to store for an optional string.
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+// recordVersion returns the version from a record.
+// Records made before versions were kept start at version 1.
+func recordVersion(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
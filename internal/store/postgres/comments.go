@@ -0,0 +1,201 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"github.com/mdhender/conduit/internal/store/model"
+	"strings"
+)
+
+// AddComment adds a comment from the user with the given id to the article.
+func (db *Store) AddComment(id int, slug, body string) (*model.Comment, map[string][]string, error) {
+	errs := make(map[string][]string)
+
+	var comment *model.Comment
+	err := db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); id == 0 || err == ErrNotFound {
+			errs["author"] = append(errs["author"], "must be a registered user")
+		} else if err != nil {
+			return err
+		}
+		a, err := getArticleBySlug(tx, slug)
+		if err == ErrNotFound {
+			errs["article"] = append(errs["article"], "not found")
+		} else if err != nil {
+			return err
+		}
+		if body = strings.TrimSpace(body); body == "" {
+			errs["body"] = append(errs["body"], "can't be blank")
+		}
+		if len(errs) != 0 {
+			return nil
+		}
+
+		c := &commentRow{articleId: a.id, authorId: id, body: body, createdAt: now()}
+		c.updatedAt = c.createdAt
+		if c.id, err = nextId(tx, "comments"); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`INSERT INTO comments (id, article_id, author_id, body, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			c.id, c.articleId, c.authorId, c.body, c.createdAt, c.updatedAt); err != nil {
+			return err
+		}
+		comment, err = c.asModelComment(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	} else if len(errs) != 0 {
+		return nil, errs, nil
+	}
+	return comment, nil, nil
+}
+
+// DeleteComment deletes a comment from the article.
+// Only the author of the comment is allowed to delete it.
+func (db *Store) DeleteComment(id int, slug string, commentId int) error {
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); id == 0 || err == ErrNotFound {
+			return ErrNotAuthorized
+		} else if err != nil {
+			return err
+		}
+		c, err := getComment(tx, slug, commentId)
+		if err != nil {
+			return err
+		} else if c.authorId != id {
+			return ErrForbidden
+		}
+		_, err = tx.Exec(`DELETE FROM comments WHERE id = $1`, c.id)
+		return err
+	})
+}
+
+// GetComments returns the comments on the article, oldest first,
+// as seen by the user with the given id.
+func (db *Store) GetComments(id int, slug string) ([]*model.Comment, error) {
+	var list []*model.Comment
+	err := db.transact(func(tx *sql.Tx) error {
+		a, err := getArticleBySlug(tx, slug)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT `+commentColumns+` FROM comments c WHERE c.article_id = $1 ORDER BY c.id`, a.id)
+		if err != nil {
+			return err
+		}
+		var comments []*commentRow
+		for rows.Next() {
+			c, err := scanComment(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			comments = append(comments, c)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		// the rows must be closed before loading the authors
+		for _, c := range comments {
+			comment, err := c.asModelComment(tx, id)
+			if err != nil {
+				return err
+			}
+			list = append(list, comment)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// RemoveComment deletes the comment whoever wrote it.
+func (db *Store) RemoveComment(slug string, commentId int) error {
+	return db.transact(func(tx *sql.Tx) error {
+		c, err := getComment(tx, slug, commentId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM comments WHERE id = $1`, c.id)
+		return err
+	})
+}
+
+// commentRow is a row from the comments table.
+type commentRow struct {
+	id        int
+	articleId int
+	authorId  int
+	body      string
+	createdAt string
+	updatedAt string
+}
+
+const commentColumns = `c.id, c.article_id, c.author_id, c.body, c.created_at, c.updated_at`
+
+func scanComment(row scanner) (*commentRow, error) {
+	var c commentRow
+	err := row.Scan(&c.id, &c.articleId, &c.authorId, &c.body, &c.createdAt, &c.updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// getComment returns the comment if it belongs to the article with the slug.
+func getComment(q querier, slug string, commentId int) (*commentRow, error) {
+	a, err := getArticleBySlug(q, slug)
+	if err != nil {
+		return nil, err
+	}
+	return scanComment(q.QueryRow(`SELECT `+commentColumns+` FROM comments c WHERE c.id = $1 AND c.article_id = $2`, commentId, a.id))
+}
+
+// asModelComment returns a copy of the comment as seen by the user with the given id.
+// The id may be zero (for example, when the request isn't authenticated).
+func (c *commentRow) asModelComment(q querier, id int) (*model.Comment, error) {
+	author, err := getUser(q, c.authorId)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := author.asModelProfile(q, id)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Comment{
+		Id:        c.id,
+		Body:      c.body,
+		CreatedAt: c.createdAt,
+		UpdatedAt: c.updatedAt,
+		Author:    *profile,
+	}, nil
+}
@@ -0,0 +1,82 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// sqlError is a driver error that reports an SQLSTATE, like *pq.Error does.
+type sqlError struct {
+	state, message string
+}
+
+func (e *sqlError) Error() string {
+	return e.message
+}
+
+func (e *sqlError) SQLState() string {
+	return e.state
+}
+
+func TestUniqueViolation(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		field string
+	}{
+		{&sqlError{"23505", `pq: duplicate key value violates unique constraint "users_email_key"`}, "email"},
+		{fmt.Errorf("insert: %w", &sqlError{"23505", `pq: duplicate key value violates unique constraint "users_username_key"`}), "username"},
+		{&sqlError{"23505", `pq: duplicate key value violates unique constraint "articles_slug_key"`}, ""},
+		{&sqlError{"40001", "pq: could not serialize access due to concurrent update"}, ""},
+		{errors.New(`duplicate key value violates unique constraint "users_email_key"`), ""},
+		{nil, ""},
+	} {
+		if got := uniqueViolation(tc.err); got != tc.field {
+			t.Errorf("uniqueViolation: %v: expected %q: got %q\n", tc.err, tc.field, got)
+		}
+	}
+}
+
+// TestIsConflict checks the SQLSTATE that Transact turns into store.ErrConflict
+// without a PostgreSQL server; TestTransactConflict checks it against one.
+func TestIsConflict(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		conflict bool
+	}{
+		{&sqlError{"40001", "pq: could not serialize access due to concurrent update"}, true},
+		{fmt.Errorf("update: %w", &sqlError{"40001", "pq: could not serialize access due to concurrent update"}), true},
+		{&sqlError{"40P01", "pq: deadlock detected"}, false},
+		{&sqlError{"23505", `pq: duplicate key value violates unique constraint "users_email_key"`}, false},
+		{errors.New("could not serialize access due to concurrent update"), false},
+		{nil, false},
+	} {
+		if got := isConflict(tc.err); got != tc.conflict {
+			t.Errorf("isConflict: %v: expected %v: got %v\n", tc.err, tc.conflict, got)
+		}
+	}
+}
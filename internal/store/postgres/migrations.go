@@ -0,0 +1,193 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration is one version of the schema.
+// The statements are run in order, in a single transaction.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// migrations are compiled into the binary so that the schema always
+// matches the code. Never change a migration once it has been released;
+// add a new one instead.
+var migrations = []migration{
+	{1, "users", []string{
+		`CREATE TABLE sequences (
+			name  TEXT PRIMARY KEY,
+			value INTEGER NOT NULL
+		)`,
+		`INSERT INTO sequences (name, value) VALUES ('users', 0)`,
+		`CREATE TABLE users (
+			id                      INTEGER PRIMARY KEY,
+			username                TEXT    NOT NULL UNIQUE,
+			email                   TEXT    NOT NULL UNIQUE,
+			password_hash           TEXT    NOT NULL,
+			bio                     TEXT,
+			image                   TEXT,
+			created_at              TEXT    NOT NULL,
+			updated_at              TEXT    NOT NULL,
+			token_generation        INTEGER NOT NULL DEFAULT 0,
+			suspended               BOOLEAN NOT NULL DEFAULT FALSE,
+			password_reset_required BOOLEAN NOT NULL DEFAULT FALSE
+		)`,
+		`CREATE TABLE follows (
+			user_id   INTEGER NOT NULL REFERENCES users (id),
+			target_id INTEGER NOT NULL REFERENCES users (id),
+			PRIMARY KEY (user_id, target_id)
+		)`,
+		`CREATE INDEX follows_target_id ON follows (target_id)`,
+		`CREATE TABLE user_roles (
+			user_id INTEGER NOT NULL REFERENCES users (id),
+			role    TEXT    NOT NULL,
+			PRIMARY KEY (user_id, role)
+		)`,
+	}},
+	{2, "articles", []string{
+		`INSERT INTO sequences (name, value) VALUES ('articles', 0), ('comments', 0)`,
+		`CREATE TABLE articles (
+			id          INTEGER PRIMARY KEY,
+			slug        TEXT    NOT NULL UNIQUE,
+			title       TEXT    NOT NULL,
+			description TEXT    NOT NULL,
+			body        TEXT    NOT NULL,
+			author_id   INTEGER NOT NULL REFERENCES users (id),
+			created_at  TEXT    NOT NULL,
+			updated_at  TEXT    NOT NULL
+		)`,
+		`CREATE INDEX articles_author_id ON articles (author_id)`,
+		`CREATE TABLE article_slugs (
+			slug       TEXT    PRIMARY KEY,
+			article_id INTEGER NOT NULL REFERENCES articles (id)
+		)`,
+		`CREATE INDEX article_slugs_article_id ON article_slugs (article_id)`,
+		`CREATE TABLE article_tags (
+			article_id INTEGER NOT NULL REFERENCES articles (id),
+			tag        TEXT    NOT NULL,
+			position   INTEGER NOT NULL,
+			PRIMARY KEY (article_id, tag)
+		)`,
+		`CREATE INDEX article_tags_tag ON article_tags (tag)`,
+		`CREATE TABLE favorites (
+			user_id    INTEGER NOT NULL REFERENCES users (id),
+			article_id INTEGER NOT NULL REFERENCES articles (id),
+			PRIMARY KEY (user_id, article_id)
+		)`,
+		`CREATE INDEX favorites_article_id ON favorites (article_id)`,
+		`CREATE TABLE comments (
+			id         INTEGER PRIMARY KEY,
+			article_id INTEGER NOT NULL REFERENCES articles (id),
+			author_id  INTEGER NOT NULL REFERENCES users (id),
+			body       TEXT    NOT NULL,
+			created_at TEXT    NOT NULL,
+			updated_at TEXT    NOT NULL
+		)`,
+		`CREATE INDEX comments_article_id ON comments (article_id)`,
+		`CREATE INDEX comments_author_id ON comments (author_id)`,
+	}},
+	{3, "sessions", []string{
+		`INSERT INTO sequences (name, value) VALUES ('sessions', 0)`,
+		`CREATE TABLE refresh_tokens (
+			hash       TEXT    PRIMARY KEY,
+			family     INTEGER NOT NULL,
+			user_id    INTEGER NOT NULL REFERENCES users (id),
+			expires_at BIGINT  NOT NULL,
+			used       BOOLEAN NOT NULL DEFAULT FALSE
+		)`,
+		`CREATE INDEX refresh_tokens_family ON refresh_tokens (family)`,
+		`CREATE INDEX refresh_tokens_user_id ON refresh_tokens (user_id)`,
+		`CREATE TABLE revoked_tokens (
+			jti        TEXT   PRIMARY KEY,
+			expires_at BIGINT NOT NULL
+		)`,
+	}},
+	{4, "versions", []string{
+		`ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE articles ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	}},
+}
+
+// Migrate brings the schema up to date by applying every migration newer
+// than the version recorded in the database. Each migration is applied in
+// its own transaction, so a failure leaves the schema at the last good version.
+// It returns the version of the schema.
+func Migrate(db *sql.DB) (int, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT    NOT NULL,
+		applied_at TEXT    NOT NULL
+	)`); err != nil {
+		return 0, fmt.Errorf("migrate: %w", err)
+	}
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return version, fmt.Errorf("migrate: %d %s: %w", m.version, m.name, err)
+		}
+		version = m.version
+	}
+	return version, nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the database.
+// It returns zero if no migrations have been applied.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("migrate: version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range m.statements {
+		if _, err = tx.Exec(stmt); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if _, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`, m.version, m.name, now()); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
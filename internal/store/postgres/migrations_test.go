@@ -0,0 +1,86 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestMigrate(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("migrate: open: %+v\n", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	testMigrate(t, db)
+}
+
+// TestMigrateServer runs the migrations on a PostgreSQL server.
+// It is skipped unless TestServerEnv is set.
+func TestMigrateServer(t *testing.T) {
+	testMigrate(t, OpenTestServer(t))
+}
+
+func testMigrate(t *testing.T, db *sql.DB) {
+	latest := migrations[len(migrations)-1].version
+
+	// a new database should be brought up to the latest version
+	// and migrating again should not change anything
+	for i := 0; i < 2; i++ {
+		if version, err := Migrate(db); err != nil {
+			t.Fatalf("migrate: %d: expected no error: got %+v\n", i, err)
+		} else if version != latest {
+			t.Errorf("migrate: %d: expected version %d: got %d\n", i, latest, version)
+		}
+	}
+	var applied int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		t.Fatalf("migrate: count: %+v\n", err)
+	} else if applied != len(migrations) {
+		t.Errorf("migrate: expected %d migrations applied: got %d\n", len(migrations), applied)
+	}
+
+	// a migration that fails should be rolled back entirely
+	defer func(saved []migration) { migrations = saved }(migrations)
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{latest + 1, "broken", []string{
+		`CREATE TABLE broken (id INTEGER PRIMARY KEY)`,
+		`INSERT INTO no_such_table (id) VALUES (1)`,
+	}})
+	if version, err := Migrate(db); err == nil {
+		t.Errorf("migrate: broken: expected error: got nil\n")
+	} else if version != latest {
+		t.Errorf("migrate: broken: expected version %d: got %d\n", latest, version)
+	}
+	if version, err := SchemaVersion(db); err != nil || version != latest {
+		t.Errorf("migrate: broken: expected schema version %d: got %d %v\n", latest, version, err)
+	}
+	if ok, err := exists(db, `SELECT 1 FROM broken`); err == nil || ok {
+		t.Errorf("migrate: broken: expected table to be rolled back: got %v %v\n", ok, err)
+	}
+}
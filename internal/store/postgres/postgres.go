@@ -22,48 +22,224 @@
  * SOFTWARE.
  */
 
-// Package postgres implements a make-believe connection to an external database
+// Package postgres implements the store on a PostgreSQL-compatible database.
+//
+// The store is written against database/sql and sticks to SQL that both
+// PostgreSQL and SQLite accept, so the tests run against an in-process
+// SQLite database and production can use a real server. Callers open
+// the database with the driver of their choice and pass it to New,
+// which brings the schema up to date before returning.
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/mdhender/conduit/internal/store/model"
+	"github.com/mdhender/conduit/internal/password"
+	"github.com/mdhender/conduit/internal/store"
+	"strings"
+	"sync"
+	"time"
 )
 
-var ErrNotFound = errors.New("not found")
+var ErrForbidden = store.ErrForbidden
+var ErrNotAuthorized = store.ErrNotAuthorized
+var ErrNotEmpty = store.ErrNotEmpty
+var ErrNotFound = store.ErrNotFound
+var ErrPasswordReset = store.ErrPasswordReset
+var ErrSuspended = store.ErrSuspended
+
+// Store implements the store.Store interface.
+var _ store.Store = (*Store)(nil)
 
 type Store struct {
-	pg *sql.DB
+	db        *sql.DB
+	tx        *sql.Tx // set on the store passed to a Transact function
+	q         querier // the transaction if there is one, otherwise the database
+	passwords *password.Hasher
+
+	sync.Mutex
+	dummyHash string    // verified against when logging in with an unknown e-mail
+	gcAt      time.Time // time of the next sweep for expired tokens
 }
 
-func New(user, password, host string) (*Store, error) {
-	db := &Store{}
-	var err error
-	db.pg, err = sql.Open("postgres", fmt.Sprintf("user=%s password=%s dbname=%s sslmode=verify-full", user, password, host))
-	if err != nil {
+// Option configures a Store.
+type Option func(*Store) error
+
+// WithPasswordHasher sets the hasher used to store and verify passwords.
+func WithPasswordHasher(h *password.Hasher) Option {
+	return func(db *Store) error {
+		db.passwords = h
+		return nil
+	}
+}
+
+// New returns a store that keeps its data in the database.
+// It applies any migrations that the database is missing.
+// The caller owns the database and must close it when done with the store.
+func New(db *sql.DB, options ...Option) (*Store, error) {
+	s := &Store{db: db, q: db, passwords: password.NewHasher("")}
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := Migrate(db); err != nil {
 		return nil, err
 	}
+	return s, nil
+}
 
-	return db, nil
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
-func (db *Store) GetUser(id int) (*model.User, error) {
-	row := db.pg.QueryRow(`SELECT USERNAME, EMAIL FROM USERS WHERE ID = $1`, id)
-	var username, email string
-	err := row.Scan(&username, email)
+// Transact implements the store.TxStore interface with a database transaction.
+// Nested calls use savepoints, so an inner failure only undoes its own changes.
+//
+// The transaction is repeatable read, so everything fn reads comes from one
+// snapshot, and PostgreSQL fails any change to a row that another transaction
+// changed after the snapshot was taken. Transact returns store.ErrConflict
+// for that failure. (SQLite only ever runs one writer, so it never conflicts.)
+func (db *Store) Transact(fn func(tx store.Store) error) error {
+	if db.tx != nil {
+		return savepoint(db.tx, func() error { return fn(db) })
+	}
+	tx, err := db.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrNotFound
-		} else {
-			return nil, err
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	db.Lock()
+	txdb := &Store{db: db.db, tx: tx, q: tx, passwords: db.passwords, dummyHash: db.dummyHash, gcAt: db.gcAt}
+	db.Unlock()
+	if err = fn(txdb); err != nil {
+		_ = tx.Rollback()
+		if isConflict(err) {
+			return store.ErrConflict
+		}
+		return err
+	}
+	if err = tx.Commit(); isConflict(err) {
+		return store.ErrConflict
+	}
+	return err
+}
+
+// isConflict returns true if err is the serialization failure (SQLSTATE 40001)
+// that PostgreSQL reports when a repeatable read transaction tries to change
+// a row that was changed after its snapshot was taken.
+func isConflict(err error) bool {
+	var e interface{ SQLState() string }
+	return errors.As(err, &e) && e.SQLState() == "40001"
+}
+
+// uniqueFields maps the UNIQUE constraints that PostgreSQL names
+// for the columns of the users table to the fields they protect.
+var uniqueFields = map[string]string{
+	"users_email_key":    "email",
+	"users_username_key": "username",
+}
+
+// uniqueViolation returns the field whose UNIQUE constraint err violated
+// (SQLSTATE 23505) or an empty string if err isn't a violation of one of them.
+// Checking for a duplicate doesn't see rows that concurrent transactions
+// haven't committed yet, so two requests can both pass the check; the
+// constraint stops the second one. The constraint is looked for in the
+// message since drivers don't agree on where else to report it.
+func uniqueViolation(err error) string {
+	var e interface{ SQLState() string }
+	if !errors.As(err, &e) || e.SQLState() != "23505" {
+		return ""
+	}
+	for constraint, field := range uniqueFields {
+		if strings.Contains(err.Error(), constraint) {
+			return field
 		}
 	}
+	return ""
+}
+
+// transact runs fn in a transaction.
+// The transaction is committed if fn returns nil and rolled back otherwise.
+// Within a Transact function, fn runs in a savepoint of that transaction instead.
+func (db *Store) transact(fn func(tx *sql.Tx) error) error {
+	if db.tx != nil {
+		return savepoint(db.tx, func() error { return fn(db.tx) })
+	}
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// snapshot runs fn in a read only, repeatable read transaction, so that
+// statements that must agree with each other (say, a count and the page it
+// counts) all read from the same snapshot.
+// Within a Transact function, fn runs in that transaction, which already does.
+func (db *Store) snapshot(fn func(q querier) error) error {
+	if db.tx != nil {
+		return fn(db.tx)
+	}
+	tx, err := db.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback() // nothing to commit
+	}()
+	return fn(tx)
+}
+
+// placeholders returns n numbered placeholders for a list of values,
+// starting with $first.
+func placeholders(first, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", first+i)
+	}
+	return strings.Join(list, ", ")
+}
+
+// savepoint runs fn in a savepoint of the transaction.
+// If fn fails, its changes are undone and the rest of the transaction can carry on.
+func savepoint(tx *sql.Tx, fn func() error) error {
+	if _, err := tx.Exec(`SAVEPOINT store`); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		_, _ = tx.Exec(`ROLLBACK TO SAVEPOINT store`)
+		_, _ = tx.Exec(`RELEASE SAVEPOINT store`)
+		return err
+	}
+	_, err := tx.Exec(`RELEASE SAVEPOINT store`)
+	return err
+}
+
+// nextId returns the next value from the named sequence.
+// Sequences are kept in a table because the two dialects don't agree
+// on how to generate keys.
+func nextId(q querier, name string) (int, error) {
+	var id int
+	err := q.QueryRow(`UPDATE sequences SET value = value + 1 WHERE name = $1 RETURNING value`, name).Scan(&id)
+	return id, err
+}
 
-	return &model.User{
-		Id:       id,
-		Username: username,
-		Email:    email,
-	}, nil
+// now returns the current time in the format used for timestamps.
+func now() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.99999999Z")
 }
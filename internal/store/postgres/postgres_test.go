@@ -0,0 +1,113 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres_test
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/postgres"
+	"github.com/mdhender/conduit/internal/store/storetest"
+	"os"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Suite(newStore(t, openDB), t)
+}
+
+// TestStoreServer runs the suite against a PostgreSQL server.
+// It is skipped unless postgres.TestServerEnv is set.
+func TestStoreServer(t *testing.T) {
+	if os.Getenv(postgres.TestServerEnv) == "" {
+		t.Skipf("postgres: set %s to run against a PostgreSQL server\n", postgres.TestServerEnv)
+	}
+	storetest.Suite(newStore(t, postgres.OpenTestServer), t)
+}
+
+// newStore returns a function that creates a store on a new database from open.
+func newStore(t *testing.T, open func(t *testing.T) *sql.DB) storetest.NewStore {
+	return func() store.Store {
+		// keep the hashing cost low so the suite stays fast
+		db, err := postgres.New(open(t), postgres.WithPasswordHasher(storetest.Hasher))
+		if err != nil {
+			t.Fatalf("postgres: new: %+v\n", err)
+		}
+		return db
+	}
+}
+
+// openDB returns a new, empty in-process database that is closed when the test ends.
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("postgres: open: %+v\n", err)
+	}
+	// every connection to ":memory:" gets its own database, so only allow one
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// TestTransactConflict checks that a unit of work can't overwrite a change
+// that another one made after it read the record. It needs a server, because
+// SQLite never lets two writers overlap. It is skipped unless
+// postgres.TestServerEnv is set.
+func TestTransactConflict(t *testing.T) {
+	db := newStore(t, postgres.OpenTestServer)()
+	jake, errs, err := db.CreateUser("Jacob", "jake@jake.jake", "jakejake")
+	if err != nil || errs != nil {
+		t.Fatalf("conflict: createUser: %v %v\n", errs, err)
+	}
+
+	read, written, done := make(chan bool), make(chan bool), make(chan error)
+	go func() {
+		done <- db.Transact(func(tx store.Store) error {
+			if _, err := tx.GetUser(jake.Id); err != nil {
+				return err
+			}
+			read <- true
+			<-written
+			bio := "I work at statefarm"
+			_, _, err := tx.UpdateUser(jake.Id, nil, &bio, nil)
+			return err
+		})
+	}()
+	<-read
+	bio := "I like to skateboard"
+	if _, errs, err := db.UpdateUser(jake.Id, nil, &bio, nil); err != nil || errs != nil {
+		t.Errorf("conflict: updateUser: expected no errors: got %v %v\n", errs, err)
+	}
+	written <- true
+	if err := <-done; !errors.Is(err, store.ErrConflict) {
+		t.Errorf("conflict: transact: expected %v: got %v\n", store.ErrConflict, err)
+	}
+	if u, err := db.GetUser(jake.Id); err != nil || u.Bio == nil || *u.Bio != bio {
+		t.Errorf("conflict: getUser: expected bio %q: got %+v %v\n", bio, u, err)
+	}
+}
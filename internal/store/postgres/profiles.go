@@ -0,0 +1,77 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"github.com/mdhender/conduit/internal/store/model"
+)
+
+func (db *Store) FollowUserByUsername(id int, username string) (*model.Profile, error) {
+	return db.setFollowing(id, username, `INSERT INTO follows (user_id, target_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`)
+}
+
+func (db *Store) GetProfileByUsername(id int, username string) (*model.Profile, error) {
+	target, err := getUserByUsername(db.q, username)
+	if err != nil {
+		return nil, err
+	}
+	return target.asModelProfile(db.q, id)
+}
+
+func (db *Store) UnfollowUserByUsername(id int, username string) (*model.Profile, error) {
+	return db.setFollowing(id, username, `DELETE FROM follows WHERE user_id = $1 AND target_id = $2`)
+}
+
+// setFollowing runs the statement to follow or unfollow the user with the
+// given username and returns the profile as seen by the user with the id.
+// Users can't follow or unfollow themselves.
+func (db *Store) setFollowing(id int, username, stmt string) (*model.Profile, error) {
+	var profile *model.Profile
+	err := db.transact(func(tx *sql.Tx) error {
+		user, err := getUser(tx, id)
+		if id == 0 || err == ErrNotFound {
+			return ErrNotAuthorized
+		} else if err != nil {
+			return err
+		} else if user.username == username { // wants to follow self
+			return ErrNotAuthorized
+		}
+
+		target, err := getUserByUsername(tx, username)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(stmt, user.id, target.id); err != nil {
+			return err
+		}
+		profile, err = target.asModelProfile(tx, user.id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
@@ -0,0 +1,91 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"github.com/mdhender/conduit/internal/store/model"
+)
+
+// GrantRole adds the role to the user.
+// Granting a role the user already has is not an error.
+func (db *Store) GrantRole(id int, role string) (*model.User, error) {
+	if id == 0 {
+		return nil, ErrNotAuthorized
+	}
+	var user *model.User
+	err := db.transact(func(tx *sql.Tx) error {
+		u, err := getUser(tx, id)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, role); err != nil {
+			return err
+		}
+		user, err = u.asModelUser(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// RevokeRole removes the role from the user.
+// If the user had the role, the token generation is bumped so that
+// tokens claiming it are rejected. Revoking a role the user doesn't
+// have is not an error.
+func (db *Store) RevokeRole(id int, role string) (*model.User, error) {
+	if id == 0 {
+		return nil, ErrNotAuthorized
+	}
+	var user *model.User
+	err := db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); err != nil {
+			return err
+		}
+		result, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, id, role)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n != 0 {
+			if _, err = tx.Exec(`UPDATE users SET token_generation = token_generation + 1 WHERE id = $1`, id); err != nil {
+				return err
+			}
+		}
+		u, err := getUser(tx, id)
+		if err != nil {
+			return err
+		}
+		user, err = u.asModelUser(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
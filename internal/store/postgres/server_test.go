@@ -0,0 +1,83 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// TestServerEnv names the environment variable that holds the connection
+// string for a PostgreSQL server. The tests that need a server are skipped
+// when it isn't set, which leaves only the SQLite runs.
+const TestServerEnv = "CONDUIT_TEST_POSTGRES"
+
+var testSchemas int32
+
+// OpenTestServer returns a connection to the PostgreSQL server named by
+// TestServerEnv that sees a new, empty schema of its own. The schema is
+// dropped and the connection closed when the test ends.
+func OpenTestServer(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv(TestServerEnv)
+	if dsn == "" {
+		t.Skipf("postgres: set %s to run against a PostgreSQL server\n", TestServerEnv)
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("postgres: open: %+v\n", err)
+	}
+	schema := fmt.Sprintf("conduit_test_%d_%d", os.Getpid(), atomic.AddInt32(&testSchemas, 1))
+	if _, err = admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		_ = admin.Close()
+		t.Fatalf("postgres: create schema: %+v\n", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		_ = admin.Close()
+	})
+
+	// the driver passes unknown settings on to the server, so every
+	// connection in the pool starts out with the schema on its path
+	sep := " "
+	if strings.Contains(dsn, "://") {
+		sep = "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+	}
+	db, err := sql.Open("postgres", dsn+sep+"search_path="+schema)
+	if err != nil {
+		t.Fatalf("postgres: open: %+v\n", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
@@ -0,0 +1,164 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"github.com/mdhender/conduit/internal/store/model"
+	"time"
+)
+
+// gcInterval is how often expired tokens are swept from the database.
+const gcInterval = time.Minute
+
+// CreateRefreshToken starts a new token family for the user.
+func (db *Store) CreateRefreshToken(id int, hash string, expiresAt time.Time) error {
+	if id == 0 {
+		return ErrNotAuthorized
+	}
+	db.gc(time.Now())
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); err != nil {
+			return err
+		}
+		family, err := nextId(tx, "sessions")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO refresh_tokens (hash, family, user_id, expires_at) VALUES ($1, $2, $3, $4)`, hash, family, id, expiresAt.UnixNano())
+		return err
+	})
+}
+
+// IsTokenRevoked returns true if the access token with the jti was revoked.
+func (db *Store) IsTokenRevoked(jti string) (bool, error) {
+	return exists(db.q, `SELECT 1 FROM revoked_tokens WHERE jti = $1`, jti)
+}
+
+// RevokeRefreshToken revokes the token and every other token in its family.
+// Unknown tokens are ignored, so logging out twice is not an error.
+func (db *Store) RevokeRefreshToken(hash string) error {
+	_, err := db.q.Exec(`DELETE FROM refresh_tokens WHERE family IN (SELECT family FROM refresh_tokens WHERE hash = $1)`, hash)
+	return err
+}
+
+// RevokeSessions revokes all of the user's refresh tokens and bumps the
+// user's token generation so that every access token already issued is rejected.
+func (db *Store) RevokeSessions(id int) error {
+	if id == 0 {
+		return ErrNotAuthorized
+	}
+	return db.transact(func(tx *sql.Tx) error {
+		if _, err := getUser(tx, id); err != nil {
+			return err
+		}
+		return revokeSessions(tx, id)
+	})
+}
+
+// RevokeToken rejects the access token with the jti until it expires.
+func (db *Store) RevokeToken(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return ErrNotFound
+	}
+	db.gc(time.Now())
+	_, err := db.q.Exec(`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO UPDATE SET expires_at = excluded.expires_at`, jti, expiresAt.UnixNano())
+	return err
+}
+
+// RotateRefreshToken uses up the token and replaces it with newHash in the same family.
+// Replaying a token that was already used revokes every token in its family.
+// Tokens belonging to suspended users are rejected but not used up.
+func (db *Store) RotateRefreshToken(hash, newHash string, expiresAt time.Time) (*model.User, error) {
+	var user *model.User
+	var rejected bool
+	err := db.transact(func(tx *sql.Tx) error {
+		var family, userId int
+		var tokenExpiresAt int64
+		if err := tx.QueryRow(`SELECT family, user_id, expires_at FROM refresh_tokens WHERE hash = $1`, hash).Scan(&family, &userId, &tokenExpiresAt); err == sql.ErrNoRows {
+			rejected = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		u, err := getUser(tx, userId)
+		if err != nil {
+			return err
+		} else if u.suspended {
+			rejected = true
+			return nil
+		}
+		// mark the token as used only if it wasn't already, so that of two
+		// concurrent rotations only one succeeds and the other is a replay
+		result, err := tx.Exec(`UPDATE refresh_tokens SET used = TRUE WHERE hash = $1 AND used = FALSE AND expires_at > $2`, hash, time.Now().UnixNano())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 { // replayed or expired
+			rejected = true
+			_, err = tx.Exec(`DELETE FROM refresh_tokens WHERE family = $1`, family)
+			return err // commit the revocation
+		}
+		if _, err = tx.Exec(`INSERT INTO refresh_tokens (hash, family, user_id, expires_at) VALUES ($1, $2, $3, $4)`, newHash, family, userId, expiresAt.UnixNano()); err != nil {
+			return err
+		}
+		user, err = u.asModelUser(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	} else if rejected {
+		return nil, ErrNotAuthorized
+	}
+	return user, nil
+}
+
+// gc forgets revoked access tokens that have expired and families whose
+// tokens have all expired. It sweeps at most once every gcInterval.
+// Failing to sweep is not an error; the next sweep will try again.
+func (db *Store) gc(now time.Time) {
+	db.Lock()
+	if now.Before(db.gcAt) {
+		db.Unlock()
+		return
+	}
+	db.gcAt = now.Add(gcInterval)
+	db.Unlock()
+
+	_, _ = db.q.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= $1`, now.UnixNano())
+	_, _ = db.q.Exec(`DELETE FROM refresh_tokens WHERE family IN (SELECT family FROM refresh_tokens GROUP BY family HAVING MAX(expires_at) <= $1)`, now.UnixNano())
+}
+
+// revokeSessions revokes every refresh token family belonging to the user
+// and bumps the user's token generation so that access tokens are rejected.
+func revokeSessions(q querier, id int) error {
+	if _, err := q.Exec(`DELETE FROM refresh_tokens WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+	_, err := q.Exec(`UPDATE users SET token_generation = token_generation + 1 WHERE id = $1`, id)
+	return err
+}
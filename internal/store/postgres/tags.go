@@ -0,0 +1,111 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"sort"
+	"strings"
+)
+
+// GetTags returns all the tags in use, most popular first.
+// Tags that are used by the same number of articles are sorted by name.
+func (db *Store) GetTags() ([]string, error) {
+	rows, err := db.q.Query(`SELECT tag, COUNT(*) FROM article_tags GROUP BY tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []string
+	counts := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var count int
+		if err := rows.Scan(&tag, &count); err != nil {
+			return nil, err
+		}
+		tags, counts[tag] = append(tags, tag), count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// sort here rather than in the query because collations differ between databases
+	sort.Slice(tags, func(i, j int) bool {
+		if ni, nj := counts[tags[i]], counts[tags[j]]; ni != nj {
+			return ni > nj
+		}
+		return tags[i] < tags[j]
+	})
+	return tags, nil
+}
+
+// getTags returns the tags on the article in the order they were given.
+func getTags(q querier, articleId int) ([]string, error) {
+	return queryStrings(q, `SELECT tag FROM article_tags WHERE article_id = $1 ORDER BY position`, articleId)
+}
+
+// setTags replaces the tags on the article.
+// The tags must already be normalized.
+func setTags(q querier, articleId int, tags []string) error {
+	if _, err := q.Exec(`DELETE FROM article_tags WHERE article_id = $1`, articleId); err != nil {
+		return err
+	}
+	for position, tag := range tags {
+		if _, err := q.Exec(`INSERT INTO article_tags (article_id, tag, position) VALUES ($1, $2, $3)`, articleId, tag, position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// equalTags returns true if both lists contain the same tags in the same order.
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeTag trims and case-folds a tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
+
+// normalizeTags returns the normalized tags, dropping empty and duplicate tags.
+// The order of the first occurrence of each tag is preserved.
+func normalizeTags(tagList []string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range tagList {
+		if tag = normalizeTag(tag); tag != "" && !seen[tag] {
+			tags, seen[tag] = append(tags, tag), true
+		}
+	}
+	return tags
+}
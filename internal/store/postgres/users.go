@@ -0,0 +1,384 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package postgres
+
+import (
+	"database/sql"
+	"github.com/mdhender/conduit/internal/store/model"
+	"sort"
+	"strings"
+)
+
+func (db *Store) CreateUser(username, email, password string) (*model.User, map[string][]string, error) {
+	errs := make(map[string][]string)
+
+	// hashing is slow by design, so do it before starting the transaction
+	var hash string
+	if password = strings.TrimSpace(password); password == "" {
+		errs["password"] = append(errs["password"], "can't be blank")
+	} else if h, err := db.passwords.Hash(password); err != nil {
+		return nil, nil, err
+	} else {
+		hash = h
+	}
+
+	var user *model.User
+	err := db.transact(func(tx *sql.Tx) error {
+		if username = strings.TrimSpace(username); username == "" {
+			errs["username"] = append(errs["username"], "can't be blank")
+		} else if other, err := getUserByUsername(tx, username); err == nil && other != nil {
+			errs["username"] = append(errs["username"], "has already been taken")
+		} else if err != nil && err != ErrNotFound {
+			return err
+		}
+		if email = strings.TrimSpace(email); email == "" {
+			errs["email"] = append(errs["email"], "can't be blank")
+		} else if other, err := getUserByEmail(tx, email); err == nil && other != nil {
+			errs["email"] = append(errs["email"], "has already been taken")
+		} else if err != nil && err != ErrNotFound {
+			return err
+		}
+		if len(errs) != 0 {
+			return nil
+		}
+
+		id, err := nextId(tx, "users")
+		if err != nil {
+			return err
+		}
+		createdAt := now()
+		if _, err = tx.Exec(`INSERT INTO users (id, username, email, password_hash, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			id, username, email, hash, createdAt, createdAt); err != nil {
+			return err
+		}
+		u, err := getUser(tx, id)
+		if err != nil {
+			return err
+		}
+		user, err = u.asModelUser(tx)
+		return err
+	})
+	if field := uniqueViolation(err); field != "" {
+		// a concurrent request took the name or e-mail after we checked
+		return nil, map[string][]string{field: {"has already been taken"}}, nil
+	} else if err != nil {
+		return nil, nil, err
+	} else if len(errs) != 0 {
+		return nil, errs, nil
+	}
+	return user, nil, nil
+}
+
+func (db *Store) GetUser(id int) (*model.User, error) {
+	u, err := getUser(db.q, id)
+	if err != nil {
+		return nil, err
+	}
+	return u.asModelUser(db.q)
+}
+
+// Login returns the user if the password matches the one stored for the e-mail.
+// If the stored hash was made with weaker parameters than the store's hasher
+// currently uses, it is replaced with a new hash.
+// Suspended users and users who must reset their password are rejected.
+func (db *Store) Login(email, password string) (*model.User, error) {
+	u, rehash, err := db.authenticate(email, password)
+	if err != nil {
+		return nil, err
+	} else if u == nil {
+		return nil, ErrNotAuthorized
+	}
+	if rehash {
+		if h, err := db.passwords.Hash(password); err == nil {
+			// don't overwrite a concurrent change
+			_, _ = db.q.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`, h, u.id, u.passwordHash)
+		}
+	}
+
+	// reload the user in case it changed while we were verifying
+	if u, err = getUser(db.q, u.id); err == ErrNotFound {
+		return nil, ErrNotAuthorized
+	} else if err != nil {
+		return nil, err
+	} else if u.suspended {
+		return nil, ErrSuspended
+	} else if u.passwordResetRequired {
+		return nil, ErrPasswordReset
+	}
+	return u.asModelUser(db.q)
+}
+
+// authenticate returns the user if the password matches the one stored for the e-mail.
+// It also returns whether the hash should be replaced.
+// It returns a nil user, not an error, if the e-mail or password is wrong.
+func (db *Store) authenticate(email, password string) (*userRow, bool, error) {
+	u, err := getUserByEmail(db.q, email)
+	if err != nil && err != ErrNotFound {
+		return nil, false, err
+	}
+	hash := db.getDummyHash()
+	if u != nil {
+		hash = u.passwordHash
+	}
+	// always verify so that unknown e-mails take as long as wrong passwords
+	ok, rehash, err := db.passwords.Verify(password, hash)
+	if u == nil || err != nil || !ok {
+		return nil, false, nil
+	}
+	return u, rehash, nil
+}
+
+// getDummyHash returns the hash verified against for unknown e-mails,
+// creating it on the first call.
+func (db *Store) getDummyHash() string {
+	db.Lock()
+	defer db.Unlock()
+	if db.dummyHash == "" {
+		db.dummyHash, _ = db.passwords.Hash("not a password")
+	}
+	return db.dummyHash
+}
+
+func (db *Store) UpdateUser(id int, email, bio, image *string) (*model.User, map[string][]string, error) {
+	errs := make(map[string][]string)
+
+	var updated *model.User
+	err := db.transact(func(tx *sql.Tx) error {
+		u, err := getUser(tx, id)
+		if err != nil {
+			return err
+		}
+
+		changes := false
+		if bio != nil {
+			u.bio = sql.NullString{String: strings.TrimSpace(*bio), Valid: true}
+			changes = true
+		}
+		if email != nil {
+			val := strings.TrimSpace(*email)
+			if *email != val {
+				errs["email"] = append(errs["email"], "can't have leading or trailing spaces")
+			} else if val == "" {
+				errs["email"] = append(errs["email"], "must not be empty if provided")
+			} else if other, err := getUserByEmail(tx, val); err != nil && err != ErrNotFound {
+				return err
+			} else if other != nil && other.id != u.id {
+				errs["email"] = append(errs["email"], "has already been taken")
+			} else {
+				u.email = val
+				changes = true
+			}
+		}
+		if image != nil {
+			u.image = sql.NullString{String: strings.TrimSpace(*image), Valid: true}
+			changes = true
+		}
+		if len(errs) != 0 {
+			return nil
+		}
+
+		if changes {
+			u.updatedAt, u.version = now(), u.version+1
+			if _, err = tx.Exec(`UPDATE users SET email = $1, bio = $2, image = $3, updated_at = $4, version = version + 1 WHERE id = $5`,
+				u.email, u.bio, u.image, u.updatedAt, u.id); err != nil {
+				return err
+			}
+		}
+		updated, err = u.asModelUser(tx)
+		return err
+	})
+	if field := uniqueViolation(err); field != "" {
+		// a concurrent request took the e-mail after we checked
+		return nil, map[string][]string{field: {"has already been taken"}}, nil
+	} else if err != nil {
+		return nil, nil, err
+	} else if len(errs) != 0 {
+		return nil, errs, nil
+	}
+	return updated, nil, nil
+}
+
+// userRow is a row from the users table.
+type userRow struct {
+	id                    int
+	username              string
+	email                 string
+	passwordHash          string // never leaves the store
+	bio, image            sql.NullString
+	createdAt             string
+	updatedAt             string
+	version               int
+	tokenGeneration       int
+	suspended             bool
+	passwordResetRequired bool
+}
+
+const userColumns = `id, username, email, password_hash, bio, image, created_at, updated_at, token_generation, suspended, password_reset_required, version`
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*userRow, error) {
+	var u userRow
+	err := row.Scan(&u.id, &u.username, &u.email, &u.passwordHash, &u.bio, &u.image, &u.createdAt, &u.updatedAt, &u.tokenGeneration, &u.suspended, &u.passwordResetRequired, &u.version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func getUser(q querier, id int) (*userRow, error) {
+	return scanUser(q.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+func getUserByEmail(q querier, email string) (*userRow, error) {
+	return scanUser(q.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = $1`, email))
+}
+
+func getUserByUsername(q querier, username string) (*userRow, error) {
+	return scanUser(q.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+}
+
+// asModelUser returns the user along with the users they follow and their roles.
+func (u *userRow) asModelUser(q querier) (*model.User, error) {
+	cp := &model.User{
+		Id:        u.id,
+		Username:  u.username,
+		Email:     u.email,
+		CreatedAt: u.createdAt,
+		UpdatedAt: u.updatedAt,
+		Version:   u.version,
+
+		TokenGeneration: u.tokenGeneration,
+
+		Suspended:             u.suspended,
+		PasswordResetRequired: u.passwordResetRequired,
+	}
+	if u.bio.Valid {
+		tmp := u.bio.String
+		cp.Bio = &tmp
+	}
+	if u.image.Valid {
+		tmp := u.image.String
+		cp.Image = &tmp
+	}
+	var err error
+	if cp.Following, err = queryStrings(q, `SELECT users.username FROM follows JOIN users ON users.id = follows.target_id WHERE follows.user_id = $1`, u.id); err != nil {
+		return nil, err
+	}
+	if cp.Roles, err = queryStrings(q, `SELECT role FROM user_roles WHERE user_id = $1`, u.id); err != nil {
+		return nil, err
+	}
+	sort.Strings(cp.Roles)
+	return cp, nil
+}
+
+// asModelProfile returns the user as seen by the user with the given id.
+func (u *userRow) asModelProfile(q querier, id int) (*model.Profile, error) {
+	following, err := isFollowing(q, id, u.id)
+	if err != nil {
+		return nil, err
+	}
+	return u.asProfile(following), nil
+}
+
+// asProfile returns the user's profile with the following flag already looked up.
+func (u *userRow) asProfile(following bool) *model.Profile {
+	profile := &model.Profile{
+		Id:        u.id,
+		Username:  u.username,
+		Following: following,
+		Version:   u.version,
+	}
+	if u.bio.Valid {
+		tmp := u.bio.String
+		profile.Bio = &tmp
+	}
+	if u.image.Valid {
+		tmp := u.image.String
+		profile.Image = &tmp
+	}
+	return profile
+}
+
+// isFollowing returns true if the user with the given id follows the target.
+func isFollowing(q querier, id, targetId int) (bool, error) {
+	if id == 0 {
+		return false, nil
+	}
+	return exists(q, `SELECT 1 FROM follows WHERE user_id = $1 AND target_id = $2`, id, targetId)
+}
+
+// exists returns true if the query returns any rows.
+func exists(q querier, query string, args ...interface{}) (bool, error) {
+	var one int
+	err := q.QueryRow(query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// queryInts returns the first column of every row returned by the query.
+func queryInts(q querier, query string, args ...interface{}) ([]int, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+// queryStrings returns the first column of every row returned by the query.
+func queryStrings(q querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,192 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package store defines the port that servers use to reach a data store.
+// Servers should depend only on the Store interface and the model types;
+// the implementations live in the sub-packages (memory, postgres, etc).
+//
+// By convention, the id parameter is the id of the user making the request,
+// or 0 if the request isn't authenticated. Values such as the following flag
+// on a profile are computed relative to that user.
+//
+// Methods that validate client data return a map of field names to error
+// messages, which the servers return to the client as a 422.
+package store
+
+import (
+	"errors"
+	"github.com/mdhender/conduit/internal/store/model"
+	"time"
+)
+
+// Implementations must return these errors (or wrap them) so that servers
+// can map them to the correct response.
+var ErrForbidden = errors.New("forbidden")          // the user isn't allowed to change the entity
+var ErrNotAuthorized = errors.New("not authorized") // the user isn't known or isn't allowed to do that
+var ErrNotFound = errors.New("not found")           // the entity doesn't exist
+var ErrConflict = errors.New("conflict")            // another unit of work changed the entity first
+
+// Login returns these errors only after the password has been verified,
+// so they don't tell a stranger anything about the account.
+var ErrPasswordReset = errors.New("password must be reset") // an admin requires the user to choose a new password
+var ErrSuspended = errors.New("suspended")                  // an admin has suspended the user
+
+// Import returns this error if the store already holds data.
+var ErrNotEmpty = errors.New("not empty")
+
+// The operations that validate their input return the problems they find
+// as a map from field name to messages, which servers show to the client.
+// They return an error only when the store itself fails (the database or
+// the journal couldn't be written, say), in which case the map is nil.
+
+// Store is the complete set of operations a data store must support.
+type Store interface {
+	UserStore
+	ProfileStore
+	ArticleStore
+	CommentStore
+	SessionStore
+	BackupStore
+	TxStore
+}
+
+// UserStore manages accounts.
+// Roles are stored as given; servers decide which role names are valid.
+// Revoking a role bumps the user's TokenGeneration so that tokens
+// claiming the role stop working.
+//
+// Suspended users can't log in or refresh their sessions. Users who
+// must reset their password can't log in until they call ChangePassword,
+// which also revokes all of their sessions. Deleting a user deletes
+// their articles, comments, favorites, follows and sessions.
+type UserStore interface {
+	ChangePassword(email, password, newPassword string) (*model.User, map[string][]string, error)
+	CreateUser(username, email, password string) (*model.User, map[string][]string, error)
+	DeleteUser(id int) error
+	GetUser(id int) (*model.User, error)
+	GrantRole(id int, role string) (*model.User, error)
+	ListUsers(filter model.UserFilter) ([]*model.User, int, error)
+	Login(email, password string) (*model.User, error)
+	RequirePasswordReset(id int) (*model.User, error)
+	RevokeRole(id int, role string) (*model.User, error)
+	SuspendUser(id int, suspended bool) (*model.User, error)
+	UpdateUser(id int, email, bio, image *string) (*model.User, map[string][]string, error)
+}
+
+type ProfileStore interface {
+	FollowUserByUsername(id int, username string) (*model.Profile, error)
+	GetProfileByUsername(id int, username string) (*model.Profile, error)
+	UnfollowUserByUsername(id int, username string) (*model.Profile, error)
+}
+
+type ArticleStore interface {
+	CreateArticle(id int, title, description, body string, tagList []string) (*model.Article, map[string][]string, error)
+	DeleteArticle(id int, slug string) error
+	FavoriteArticle(id int, slug string) (*model.Article, error)
+	FeedArticles(id, limit, offset int) ([]*model.Article, int, error)
+	GetArticleBySlug(id int, slug string) (*model.Article, error)
+	GetTags() ([]string, error)
+	ListArticles(id int, filter model.ArticleFilter) ([]*model.Article, int, error)
+	UnfavoriteArticle(id int, slug string) (*model.Article, error)
+	UpdateArticle(id int, slug string, title, description, body *string, tagList *[]string) (*model.Article, map[string][]string, error)
+}
+
+// CommentStore manages the comments on articles.
+// DeleteComment only lets authors delete their own comments.
+// RemoveComment deletes any comment; servers must check that
+// the user is allowed to moderate comments before calling it.
+type CommentStore interface {
+	AddComment(id int, slug, body string) (*model.Comment, map[string][]string, error)
+	DeleteComment(id int, slug string, commentId int) error
+	GetComments(id int, slug string) ([]*model.Comment, error)
+	RemoveComment(slug string, commentId int) error
+}
+
+// SessionStore persists refresh tokens.
+// Servers pass a hash of each token; the store never sees the token itself.
+// Every token belongs to a family that starts when the user logs in.
+// Rotating a token uses it up and adds its replacement to the same family.
+// Presenting a used token means it was stolen, so the whole family is revoked.
+//
+// Access tokens can be revoked one at a time by their jti (until they
+// expire, after which the store may forget them) or all at once by
+// RevokeSessions, which also bumps the user's TokenGeneration.
+type SessionStore interface {
+	CreateRefreshToken(id int, hash string, expiresAt time.Time) error
+	IsTokenRevoked(jti string) (bool, error)
+	RevokeRefreshToken(hash string) error
+	RevokeSessions(id int) error
+	RevokeToken(jti string, expiresAt time.Time) error
+	RotateRefreshToken(hash, newHash string, expiresAt time.Time) (*model.User, error)
+}
+
+// BackupStore copies the contents of a store in and out in bulk, for
+// backups and for moving data from one store to another.
+//
+// Export writes every user, follow, article, favorite and comment as
+// they were at a single moment, in the order described by model.Record.
+// Records keep their ids, timestamps and password hashes, so an export
+// imported into another store gives the same results. Sessions and
+// revoked tokens are not copied; users must log in again after a move.
+//
+// Import loads the records read from r into an empty store. It returns
+// ErrNotEmpty if the store already has users. If any record is invalid
+// or refers to a record that hasn't been read yet, Import returns an
+// error and loads nothing.
+type BackupStore interface {
+	Export(w RecordWriter) error
+	Import(r RecordReader) error
+}
+
+// RecordReader is the source of records for Import.
+// ReadRecord returns io.EOF after the last record.
+type RecordReader interface {
+	ReadRecord() (*model.Record, error)
+}
+
+// RecordWriter is the destination of records for Export.
+type RecordWriter interface {
+	WriteRecord(rec *model.Record) error
+}
+
+// TxStore runs a unit of work: several operations that succeed or fail together.
+//
+// Transact calls fn with a store that sees the changes made so far in the
+// unit of work. If fn returns nil, the changes are committed. If fn returns
+// an error or panics, every change is rolled back and Transact returns the
+// error (or panics again). Each operation on the store is still atomic on its
+// own, so an operation that fails inside fn leaves nothing behind even if fn
+// carries on.
+//
+// fn must only use the store it is given. The outer store may be locked
+// until the unit of work ends, so calling it from fn can deadlock.
+// Calling Transact on the store given to fn starts a nested unit of work
+// that can fail without rolling back the outer one.
+//
+// What fn reads stays true until the unit of work ends: a store that lets
+// units of work run side by side returns ErrConflict (and rolls back)
+// rather than let fn change an entity that was changed after fn read it.
+type TxStore interface {
+	Transact(fn func(tx Store) error) error
+}
@@ -0,0 +1,219 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"github.com/mdhender/conduit/internal/store/model"
+	"testing"
+	"time"
+)
+
+// Specification: Store Account Management API
+func Accounts(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob," "Anne" and "Bob" have been added
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+	bob := mustCreateUser(t, db, "Bob", "bob@example.com", "bobbob")
+
+	// When users are listed
+	// Then they should be returned oldest first, filtered and paged
+	for _, tc := range []struct {
+		filter   model.UserFilter
+		expected []int
+		count    int
+	}{
+		{model.UserFilter{Limit: 20}, []int{jake, anne, bob}, 3},
+		{model.UserFilter{Limit: 2}, []int{jake, anne}, 3},
+		{model.UserFilter{Limit: 2, Offset: 2}, []int{bob}, 3},
+		{model.UserFilter{Limit: 2, Offset: 3}, nil, 3},
+		{model.UserFilter{Search: "ANNE", Limit: 20}, []int{anne}, 1},
+		{model.UserFilter{Search: "example.com", Limit: 20}, []int{bob}, 1},
+		{model.UserFilter{Search: "nobody", Limit: 20}, nil, 0},
+	} {
+		list, count, err := db.ListUsers(tc.filter)
+		if err != nil {
+			t.Errorf("accounts: list %+v: expected no error: got %v\n", tc.filter, err)
+			continue
+		} else if count != tc.count {
+			t.Errorf("accounts: list %+v: expected count %d: got %d\n", tc.filter, tc.count, count)
+		}
+		var ids []int
+		for _, u := range list {
+			ids = append(ids, u.Id)
+		}
+		if len(ids) != len(tc.expected) {
+			t.Errorf("accounts: list %+v: expected %v: got %v\n", tc.filter, tc.expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tc.expected[i] {
+				t.Errorf("accounts: list %+v: expected %v: got %v\n", tc.filter, tc.expected, ids)
+				break
+			}
+		}
+	}
+
+	// When account changes are made for user id 0 or an unknown user
+	// Then we should get the expected errors
+	_, err := db.SuspendUser(0, true)
+	isError(t, "accounts: suspend: user id 0", err, ErrNotAuthorized)
+	_, err = db.SuspendUser(bob+1000, true)
+	isError(t, "accounts: suspend: unknown user id", err, ErrNotFound)
+	_, err = db.RequirePasswordReset(0)
+	isError(t, "accounts: reset: user id 0", err, ErrNotAuthorized)
+	_, err = db.RequirePasswordReset(bob + 1000)
+	isError(t, "accounts: reset: unknown user id", err, ErrNotFound)
+	isError(t, "accounts: delete: user id 0", db.DeleteUser(0), ErrNotAuthorized)
+	isError(t, "accounts: delete: unknown user id", db.DeleteUser(bob+1000), ErrNotFound)
+
+	// When "Anne" is suspended
+	// Then "Anne" should not be able to log in or refresh a session
+	// And "Anne" should be able to do both once restored
+	later := time.Now().Add(time.Hour)
+	if err := db.CreateRefreshToken(anne, "anne-1", later); err != nil {
+		t.Fatalf("accounts: createRefreshToken: expected no error: got %v\n", err)
+	}
+	if u, err := db.SuspendUser(anne, true); err != nil || !u.Suspended {
+		t.Errorf("accounts: suspend: expected suspended user: got %v %v\n", u, err)
+	}
+	_, err = db.Login("anne@anne.anne", "anneanne")
+	isError(t, "accounts: login: suspended", err, ErrSuspended)
+	_, err = db.Login("anne@anne.anne", "wrong")
+	isError(t, "accounts: login: suspended, wrong password", err, ErrNotAuthorized)
+	_, err = db.RotateRefreshToken("anne-1", "anne-2", later)
+	isError(t, "accounts: rotate: suspended", err, ErrNotAuthorized)
+	if u, err := db.SuspendUser(anne, false); err != nil || u.Suspended {
+		t.Errorf("accounts: unsuspend: expected active user: got %v %v\n", u, err)
+	}
+	if _, err := db.Login("anne@anne.anne", "anneanne"); err != nil {
+		t.Errorf("accounts: login: unsuspended: expected no error: got %v\n", err)
+	}
+	if _, err := db.RotateRefreshToken("anne-1", "anne-2", later); err != nil {
+		t.Errorf("accounts: rotate: unsuspended: expected no error: got %v\n", err)
+	}
+
+	// When "Anne" is required to reset the password
+	// Then the sessions of "Anne" should be revoked
+	// And "Anne" should not be able to log in until the password is changed
+	u, err := db.RequirePasswordReset(anne)
+	if err != nil || !u.PasswordResetRequired {
+		t.Fatalf("accounts: reset: expected reset required: got %v %v\n", u, err)
+	}
+	generation := u.TokenGeneration
+	_, err = db.RotateRefreshToken("anne-2", "anne-3", later)
+	isError(t, "accounts: rotate: after reset", err, ErrNotAuthorized)
+	_, err = db.Login("anne@anne.anne", "anneanne")
+	isError(t, "accounts: login: reset required", err, ErrPasswordReset)
+	for _, tc := range []struct {
+		why                          string
+		email, password, newPassword string
+		field                        string
+	}{
+		{"blank new password", "anne@anne.anne", "anneanne", " ", "newPassword"},
+		{"wrong password", "anne@anne.anne", "wrong", "annieannie", "email or password"},
+		{"unknown e-mail", "nobody@anne.anne", "anneanne", "annieannie", "email or password"},
+	} {
+		if _, errs, _ := db.ChangePassword(tc.email, tc.password, tc.newPassword); len(errs[tc.field]) == 0 {
+			t.Errorf("accounts: changePassword: %s: expected errors for %q: got %v\n", tc.why, tc.field, errs)
+		}
+	}
+	if u, errs, err := db.ChangePassword("anne@anne.anne", "anneanne", "annieannie"); err != nil || errs != nil {
+		t.Errorf("accounts: changePassword: expected no errors: got %v %v\n", errs, err)
+	} else if u.PasswordResetRequired || u.TokenGeneration == generation {
+		t.Errorf("accounts: changePassword: expected reset cleared and new generation: got %+v\n", u)
+	}
+	_, err = db.Login("anne@anne.anne", "anneanne")
+	isError(t, "accounts: login: old password", err, ErrNotAuthorized)
+	if _, err := db.Login("anne@anne.anne", "annieannie"); err != nil {
+		t.Errorf("accounts: login: new password: expected no error: got %v\n", err)
+	}
+
+	// Given "Jacob" has written an article that "Bob" favorited and "Anne" commented on
+	// And "Jacob" has favorited and commented on an article by "Bob"
+	// And "Jacob" follows "Bob" and "Bob" follows "Jacob"
+	jakeArticle, errs, err := db.CreateArticle(jake, "How to train your dragon", "Ever wonder how?", "You have to believe", []string{"dragons"})
+	if err != nil || errs != nil {
+		t.Fatalf("accounts: createArticle: expected no errors: got %v %v\n", errs, err)
+	}
+	bobArticle, errs, err := db.CreateArticle(bob, "How to sell your dragon", "Ever wonder where?", "Online", []string{"dragons", "sales"})
+	if err != nil || errs != nil {
+		t.Fatalf("accounts: createArticle: expected no errors: got %v %v\n", errs, err)
+	}
+	if _, err := db.FavoriteArticle(bob, jakeArticle.Slug); err != nil {
+		t.Fatalf("accounts: favorite: expected no error: got %v\n", err)
+	}
+	if _, err := db.FavoriteArticle(jake, bobArticle.Slug); err != nil {
+		t.Fatalf("accounts: favorite: expected no error: got %v\n", err)
+	}
+	if _, errs, err := db.AddComment(anne, jakeArticle.Slug, "Nice."); err != nil || errs != nil {
+		t.Fatalf("accounts: addComment: expected no errors: got %v %v\n", errs, err)
+	}
+	if _, errs, err := db.AddComment(jake, bobArticle.Slug, "Thanks!"); err != nil || errs != nil {
+		t.Fatalf("accounts: addComment: expected no errors: got %v %v\n", errs, err)
+	}
+	if _, err := db.FollowUserByUsername(jake, "Bob"); err != nil {
+		t.Fatalf("accounts: follow: expected no error: got %v\n", err)
+	}
+	if _, err := db.FollowUserByUsername(bob, "Jacob"); err != nil {
+		t.Fatalf("accounts: follow: expected no error: got %v\n", err)
+	}
+
+	// When "Jacob" is deleted
+	// Then "Jacob" and the article by "Jacob" should be gone
+	// And "Bob's" article should have no favorites or comments from "Jacob"
+	// And "Bob" should no longer follow "Jacob"
+	if err := db.DeleteUser(jake); err != nil {
+		t.Fatalf("accounts: delete: expected no error: got %v\n", err)
+	}
+	_, err = db.GetUser(jake)
+	isError(t, "accounts: getUser: deleted", err, ErrNotFound)
+	_, err = db.GetProfileByUsername(0, "Jacob")
+	isError(t, "accounts: getProfile: deleted", err, ErrNotFound)
+	_, err = db.Login("jake@jake.jake", "jakejake")
+	isError(t, "accounts: login: deleted", err, ErrNotAuthorized)
+	_, err = db.GetArticleBySlug(0, jakeArticle.Slug)
+	isError(t, "accounts: getArticle: deleted author", err, ErrNotFound)
+	if a, err := db.GetArticleBySlug(0, bobArticle.Slug); err != nil || a.FavoritesCount != 0 {
+		t.Errorf("accounts: getArticle: expected no favorites: got %v %v\n", a, err)
+	}
+	if list, err := db.GetComments(0, bobArticle.Slug); err != nil || len(list) != 0 {
+		t.Errorf("accounts: getComments: expected none: got %v %v\n", list, err)
+	}
+	if u, err := db.GetUser(bob); err != nil || len(u.Following) != 0 {
+		t.Errorf("accounts: getUser: expected Bob to follow no one: got %v %v\n", u, err)
+	}
+	if tags, err := db.GetTags(); err != nil || len(tags) != 2 {
+		t.Errorf("accounts: getTags: expected [dragons sales]: got %v %v\n", tags, err)
+	}
+	if _, count, err := db.ListUsers(model.UserFilter{Limit: 20}); err != nil || count != 2 {
+		t.Errorf("accounts: list: expected 2 users: got %d %v\n", count, err)
+	}
+
+	// When a new user registers with the deleted user's name and e-mail
+	// Then it should succeed
+	mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+}
@@ -0,0 +1,144 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"github.com/mdhender/conduit/internal/store/model"
+	"testing"
+)
+
+// Specification: Store Article API
+func Articles(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob" and "Anne" have been added
+	// And "Jacob" has created the article "How to train your dragon" with the tags "dragons" and "training"
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+	a, errs, err := db.CreateArticle(jake, "How to train your dragon", "Ever wonder how?", "You have to believe", []string{"dragons", "training"})
+	if err != nil || errs != nil {
+		t.Fatalf("articles: createArticle: expected no errors: got %v %v\n", errs, err)
+	} else if a.Slug == "" || a.Author.Username != "Jacob" || a.Author.Id != jake {
+		t.Errorf("articles: createArticle: expected slug and author Jacob: got %+v\n", a)
+	}
+	slug := a.Slug
+
+	// When articles are created with blank fields or by unknown users
+	// Then we should get errors for exactly those fields
+	for _, tc := range []struct {
+		name   string
+		id     int
+		fields []string
+	}{
+		{"user id 0", 0, []string{"author", "title", "description", "body"}},
+		{"unknown user", anne + 1000, []string{"author", "title", "description", "body"}},
+		{"blank fields", anne, []string{"title", "description", "body"}},
+	} {
+		if _, errs, _ := db.CreateArticle(tc.id, " ", "", "", nil); len(errs) != len(tc.fields) {
+			t.Errorf("articles: createArticle: %s: expected errors for %v: got %v\n", tc.name, tc.fields, errs)
+		}
+	}
+
+	// When "Anne" favorites the article
+	// Then the article should be favorited by "Anne" but not "Jacob"
+	// And the favorites count should be 1
+	if a, err := db.FavoriteArticle(anne, slug); err != nil || !a.Favorited || a.FavoritesCount != 1 {
+		t.Errorf("articles: favorite: expected favorited with count 1: got %+v %v\n", a, err)
+	}
+	if a, err := db.GetArticleBySlug(jake, slug); err != nil || a.Favorited || a.FavoritesCount != 1 {
+		t.Errorf("articles: getArticle: expected not favorited with count 1: got %+v %v\n", a, err)
+	}
+	list, count, err := db.ListArticles(0, model.ArticleFilter{Favorited: "Anne", Limit: 20})
+	if err != nil || count != 1 || len(list) != 1 {
+		t.Errorf("articles: listArticles: favorited: expected 1 article: got %d %v\n", count, err)
+	}
+
+	// When "Anne" follows "Jacob" and reads her feed
+	// Then the article should be listed as she sees it
+	if _, err = db.FollowUserByUsername(anne, "Jacob"); err != nil {
+		t.Fatalf("articles: follow: %+v\n", err)
+	}
+	list, count, err = db.FeedArticles(anne, 20, 0)
+	if err != nil || count != 1 || len(list) != 1 {
+		t.Fatalf("articles: feed: expected 1 article: got %d %v\n", count, err)
+	} else if a := list[0]; a.Slug != slug || !a.Favorited || a.FavoritesCount != 1 || !a.Author.Following || a.Author.Username != "Jacob" {
+		t.Errorf("articles: feed: expected favorited with count 1 by followed Jacob: got %+v\n", a)
+	} else if len(a.TagList) != 2 || a.TagList[0] != "dragons" || a.TagList[1] != "training" {
+		t.Errorf("articles: feed: expected tags [dragons training]: got %v\n", a.TagList)
+	}
+
+	// When the article is fetched, changed, or deleted with bad slugs or users
+	// Then we should get the expected errors
+	_, err = db.GetArticleBySlug(jake, "no-such-article")
+	isError(t, "articles: getArticle: unknown slug", err, ErrNotFound)
+	_, err = db.FavoriteArticle(0, slug)
+	isError(t, "articles: favorite: user id 0", err, ErrNotAuthorized)
+	_, err = db.FavoriteArticle(anne, "no-such-article")
+	isError(t, "articles: favorite: unknown slug", err, ErrNotFound)
+	_, err = db.UnfavoriteArticle(0, slug)
+	isError(t, "articles: unfavorite: user id 0", err, ErrNotAuthorized)
+	_, _, err = db.FeedArticles(0, 20, 0)
+	isError(t, "articles: feed: user id 0", err, ErrNotAuthorized)
+	err = db.DeleteArticle(0, slug)
+	isError(t, "articles: delete: user id 0", err, ErrNotAuthorized)
+	err = db.DeleteArticle(jake, "no-such-article")
+	isError(t, "articles: delete: unknown slug", err, ErrNotFound)
+	err = db.DeleteArticle(anne, slug)
+	isError(t, "articles: delete: not author", err, ErrForbidden)
+	title := "Something else"
+	_, _, err = db.UpdateArticle(0, slug, &title, nil, nil, nil)
+	isError(t, "articles: updateArticle: user id 0", err, ErrNotAuthorized)
+	_, _, err = db.UpdateArticle(jake, "no-such-article", &title, nil, nil, nil)
+	isError(t, "articles: updateArticle: unknown slug", err, ErrNotFound)
+	_, _, err = db.UpdateArticle(anne, slug, &title, nil, nil, nil)
+	isError(t, "articles: updateArticle: not author", err, ErrForbidden)
+
+	// When "Jacob" renames the article
+	// Then the slug should change
+	// And the old slug should still find the article
+	title = "How to tame your dragon"
+	if a, errs, err := db.UpdateArticle(jake, slug, &title, nil, nil, nil); err != nil || errs != nil {
+		t.Errorf("articles: updateArticle: expected no errors: got %v %v\n", errs, err)
+	} else if a.Slug == slug || a.Title != title {
+		t.Errorf("articles: updateArticle: expected new slug and title: got %q %q\n", a.Slug, a.Title)
+	} else if b, err := db.GetArticleBySlug(0, slug); err != nil || b.Slug != a.Slug {
+		t.Errorf("articles: getArticle: old slug: expected %q: got %+v %v\n", a.Slug, b, err)
+	}
+
+	// When "Jacob" deletes the article
+	// Then the article should not be found
+	// And the tags and favorites should be gone
+	if err := db.DeleteArticle(jake, slug); err != nil {
+		t.Errorf("articles: delete: expected no error: got %v\n", err)
+	}
+	_, err = db.GetArticleBySlug(jake, slug)
+	isError(t, "articles: getArticle: deleted", err, ErrNotFound)
+	if tags, err := db.GetTags(); err != nil || len(tags) != 0 {
+		t.Errorf("articles: getTags: expected no tags: got %v %v\n", tags, err)
+	}
+	if _, count, err := db.ListArticles(0, model.ArticleFilter{Favorited: "Anne", Limit: 20}); err != nil || count != 0 {
+		t.Errorf("articles: listArticles: favorited: expected no articles: got %d %v\n", count, err)
+	}
+}
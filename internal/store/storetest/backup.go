@@ -0,0 +1,246 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/conduit/internal/store/model"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Specification: Store Backup API
+func Backup(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob," "Anne," "Bob" and "Carol" have been added
+	// And "Carol" has been deleted
+	// And "Jacob" follows "Anne" and has been granted the "moderator" role
+	// And "Bob" has been suspended
+	// And "Anne" has written an article and renamed it
+	// And "Jacob" has favorited it and "Bob" has commented on it
+	src := newStore()
+	jake := mustCreateUser(t, src, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, src, "Anne", "anne@anne.anne", "anneanne")
+	bob := mustCreateUser(t, src, "Bob", "bob@example.com", "bobbob")
+	carol := mustCreateUser(t, src, "Carol", "carol@example.com", "carolcarol")
+	if err := src.DeleteUser(carol); err != nil {
+		t.Fatalf("backup: deleteUser: expected no error: got %v\n", err)
+	}
+	bio := "I work at statefarm"
+	if _, errs, err := src.UpdateUser(jake, nil, &bio, nil); err != nil || errs != nil {
+		t.Fatalf("backup: updateUser: expected no errors: got %v %v\n", errs, err)
+	}
+	if _, err := src.FollowUserByUsername(jake, "Anne"); err != nil {
+		t.Fatalf("backup: follow: expected no error: got %v\n", err)
+	}
+	if _, err := src.GrantRole(jake, "moderator"); err != nil {
+		t.Fatalf("backup: grantRole: expected no error: got %v\n", err)
+	}
+	if _, err := src.SuspendUser(bob, true); err != nil {
+		t.Fatalf("backup: suspend: expected no error: got %v\n", err)
+	}
+	a, errs, err := src.CreateArticle(anne, "How to train your dragon", "Ever wonder how?", "You have to believe", []string{"dragons", "training"})
+	if err != nil || errs != nil {
+		t.Fatalf("backup: createArticle: expected no errors: got %v %v\n", errs, err)
+	}
+	oldSlug, title := a.Slug, "How to tame your dragon"
+	if a, errs, err = src.UpdateArticle(anne, a.Slug, &title, nil, nil, nil); err != nil || errs != nil {
+		t.Fatalf("backup: updateArticle: expected no errors: got %v %v\n", errs, err)
+	}
+	if _, err := src.FavoriteArticle(jake, a.Slug); err != nil {
+		t.Fatalf("backup: favorite: expected no error: got %v\n", err)
+	}
+	if _, errs, err := src.AddComment(bob, a.Slug, "His name was my name too."); err != nil || errs != nil {
+		t.Fatalf("backup: addComment: expected no errors: got %v %v\n", errs, err)
+	}
+
+	// When the store is exported
+	// Then the records should be listed in order without the deleted user
+	// And the users should carry their password hashes
+	exported := &records{}
+	if err := src.Export(exported); err != nil {
+		t.Fatalf("backup: export: expected no error: got %v\n", err)
+	}
+	if expected, got := "uuufavc", exported.kinds(); got != expected {
+		t.Errorf("backup: export: expected kinds %q: got %q\n", expected, got)
+	}
+	for _, rec := range exported.list {
+		if rec.User != nil && rec.User.PasswordHash == "" {
+			t.Errorf("backup: export: user %d: expected password hash: got none\n", rec.User.Id)
+		}
+	}
+
+	// When the records are imported into a new store
+	// Then exporting the new store should give the same records
+	dst := newStore()
+	if err := dst.Import(exported.reader()); err != nil {
+		t.Fatalf("backup: import: expected no error: got %v\n", err)
+	}
+	again := &records{}
+	if err := dst.Export(again); err != nil {
+		t.Fatalf("backup: export: expected no error: got %v\n", err)
+	}
+	if !reflect.DeepEqual(exported.list, again.list) {
+		t.Errorf("backup: import: expected\n%s\ngot\n%s\n", exported, again)
+	}
+
+	// And the users should be able to log in with their passwords
+	// And "Bob" should still be suspended
+	if u, err := dst.Login("jake@jake.jake", "jakejake"); err != nil || len(u.Roles) != 1 || u.Roles[0] != "moderator" {
+		t.Errorf("backup: login: expected moderator: got %v %v\n", u, err)
+	}
+	_, err = dst.Login("bob@example.com", "bobbob")
+	isError(t, "backup: login: suspended", err, ErrSuspended)
+
+	// And the article should be found by its old and new slugs with its favorite, tags and comment
+	// And "Jacob" should see it in the feed
+	for _, slug := range []string{oldSlug, a.Slug} {
+		got, err := dst.GetArticleBySlug(jake, slug)
+		if err != nil || got.Slug != a.Slug || !got.Favorited || got.FavoritesCount != 1 || !got.Author.Following || !reflect.DeepEqual(got.TagList, a.TagList) {
+			t.Errorf("backup: getArticle: %q: expected %+v: got %+v %v\n", slug, a, got, err)
+		}
+	}
+	if list, err := dst.GetComments(0, a.Slug); err != nil || len(list) != 1 || list[0].Author.Username != "Bob" {
+		t.Errorf("backup: getComments: expected comment by Bob: got %v %v\n", list, err)
+	}
+	if list, count, err := dst.FeedArticles(jake, 20, 0); err != nil || count != 1 || list[0].Id != a.Id {
+		t.Errorf("backup: feed: expected article %d: got %v %d %v\n", a.Id, list, count, err)
+	}
+	if tags, err := dst.GetTags(); err != nil || len(tags) != 2 {
+		t.Errorf("backup: getTags: expected 2 tags: got %v %v\n", tags, err)
+	}
+
+	// And new records should not reuse the imported ids
+	if id := mustCreateUser(t, dst, "Dave", "dave@example.com", "davedave"); id <= bob {
+		t.Errorf("backup: createUser: expected id after %d: got %d\n", bob, id)
+	}
+	if got, errs, err := dst.CreateArticle(anne, "Another dragon", "Still?", "Yes", nil); err != nil || errs != nil || got.Id <= a.Id {
+		t.Errorf("backup: createArticle: expected id after %d: got %+v %v %v\n", a.Id, got, errs, err)
+	}
+
+	// When the records are imported into a store that has data
+	// Then we should get ErrNotEmpty
+	isError(t, "backup: import: not empty", dst.Import(exported.reader()), ErrNotEmpty)
+
+	// When the records refer to a record that isn't there
+	// Or the reader fails part way through
+	// Then the import should fail and leave the store empty
+	readErr := errors.New("read failed")
+	for _, tc := range []struct {
+		why     string
+		records []*model.Record
+		err     error
+	}{
+		{"unknown follow target", []*model.Record{exported.list[0], {Follow: &model.FollowRecord{User: jake, Target: carol}}}, nil},
+		{"unknown author", []*model.Record{exported.list[0], {Article: &model.ArticleRecord{Id: 1, Slug: "a", Author: anne}}}, nil},
+		{"duplicate username", []*model.Record{exported.list[0], exported.list[0]}, nil},
+		{"reader error", exported.list, readErr},
+	} {
+		db := newStore()
+		r := (&records{list: tc.records}).reader()
+		r.err = tc.err
+		if err := db.Import(r); err == nil {
+			t.Errorf("backup: import: %s: expected error: got nil\n", tc.why)
+		} else if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("backup: import: %s: expected error %v: got %v\n", tc.why, tc.err, err)
+		}
+		if _, count, err := db.ListUsers(model.UserFilter{Limit: 20}); err != nil || count != 0 {
+			t.Errorf("backup: import: %s: expected empty store: got %d users %v\n", tc.why, count, err)
+		}
+	}
+}
+
+// records collects the records of an export and plays them back for an import.
+type records struct {
+	list []*model.Record
+}
+
+func (r *records) WriteRecord(rec *model.Record) error {
+	r.list = append(r.list, rec)
+	return nil
+}
+
+// kinds returns the first letter of the kind of each record.
+func (r *records) kinds() string {
+	var s []byte
+	for _, rec := range r.list {
+		switch {
+		case rec.User != nil:
+			s = append(s, 'u')
+		case rec.Follow != nil:
+			s = append(s, 'f')
+		case rec.Article != nil:
+			s = append(s, 'a')
+		case rec.Favorite != nil:
+			s = append(s, 'v')
+		case rec.Comment != nil:
+			s = append(s, 'c')
+		}
+	}
+	return string(s)
+}
+
+func (r *records) String() string {
+	var s string
+	for _, rec := range r.list {
+		switch {
+		case rec.User != nil:
+			s += fmt.Sprintf("%+v\n", *rec.User)
+		case rec.Follow != nil:
+			s += fmt.Sprintf("%+v\n", *rec.Follow)
+		case rec.Article != nil:
+			s += fmt.Sprintf("%+v\n", *rec.Article)
+		case rec.Favorite != nil:
+			s += fmt.Sprintf("%+v\n", *rec.Favorite)
+		case rec.Comment != nil:
+			s += fmt.Sprintf("%+v\n", *rec.Comment)
+		}
+	}
+	return s
+}
+
+// reader returns a reader that plays back the records.
+func (r *records) reader() *recordReader {
+	return &recordReader{list: r.list}
+}
+
+// recordReader returns err, if set, in place of the last record.
+type recordReader struct {
+	list []*model.Record
+	err  error
+}
+
+func (r *recordReader) ReadRecord() (*model.Record, error) {
+	if len(r.list) == 0 {
+		return nil, io.EOF
+	} else if r.err != nil && len(r.list) == 1 {
+		return nil, r.err
+	}
+	rec := r.list[0]
+	r.list = r.list[1:]
+	return rec, nil
+}
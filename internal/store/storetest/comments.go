@@ -0,0 +1,109 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"testing"
+)
+
+// Specification: Store Comment API
+func Comments(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob" and "Anne" have been added
+	// And "Jacob" has created the article "How to train your dragon"
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+	a, errs, err := db.CreateArticle(jake, "How to train your dragon", "Ever wonder how?", "You have to believe", nil)
+	if err != nil || errs != nil {
+		t.Fatalf("comments: createArticle: expected no errors: got %v %v\n", errs, err)
+	}
+
+	// When "Anne" and then "Jacob" comment on the article
+	// Then the comments should be returned oldest first
+	first, errs, err := db.AddComment(anne, a.Slug, "His name was my name too.")
+	if err != nil || errs != nil {
+		t.Fatalf("comments: addComment: expected no errors: got %v %v\n", errs, err)
+	}
+	second, errs, err := db.AddComment(jake, a.Slug, "Thank you!")
+	if err != nil || errs != nil {
+		t.Fatalf("comments: addComment: expected no errors: got %v %v\n", errs, err)
+	}
+	if list, err := db.GetComments(0, a.Slug); err != nil || len(list) != 2 || list[0].Id != first.Id || list[1].Id != second.Id {
+		t.Errorf("comments: getComments: expected [%d %d]: got %v %v\n", first.Id, second.Id, list, err)
+	}
+
+	// When comments are added or deleted with bad data
+	// Then we should get the expected errors
+	if _, errs, _ := db.AddComment(anne, a.Slug, " "); len(errs["body"]) == 0 {
+		t.Errorf("comments: addComment: blank body: expected errors for body: got %v\n", errs)
+	}
+	if _, errs, _ := db.AddComment(0, a.Slug, "Hello"); errs == nil {
+		t.Errorf("comments: addComment: user id 0: expected errors: got none\n")
+	}
+	if _, errs, _ := db.AddComment(anne, "no-such-article", "Hello"); errs == nil {
+		t.Errorf("comments: addComment: unknown slug: expected errors: got none\n")
+	}
+	_, err = db.GetComments(0, "no-such-article")
+	isError(t, "comments: getComments: unknown slug", err, ErrNotFound)
+	isError(t, "comments: delete: user id 0", db.DeleteComment(0, a.Slug, first.Id), ErrNotAuthorized)
+	isError(t, "comments: delete: unknown slug", db.DeleteComment(anne, "no-such-article", first.Id), ErrNotFound)
+	isError(t, "comments: delete: unknown id", db.DeleteComment(anne, a.Slug, second.Id+1000), ErrNotFound)
+	isError(t, "comments: delete: not author", db.DeleteComment(jake, a.Slug, first.Id), ErrForbidden)
+
+	// When "Anne" deletes the comment from "Anne"
+	// Then only the comment from "Jacob" should remain
+	if err := db.DeleteComment(anne, a.Slug, first.Id); err != nil {
+		t.Errorf("comments: delete: expected no error: got %v\n", err)
+	}
+	if list, err := db.GetComments(0, a.Slug); err != nil || len(list) != 1 || list[0].Id != second.Id {
+		t.Errorf("comments: getComments: expected [%d]: got %v %v\n", second.Id, list, err)
+	}
+
+	// When a moderator removes the comment from "Jacob"
+	// Then no comments should remain
+	isError(t, "comments: remove: unknown slug", db.RemoveComment("no-such-article", second.Id), ErrNotFound)
+	isError(t, "comments: remove: unknown id", db.RemoveComment(a.Slug, second.Id+1000), ErrNotFound)
+	if err := db.RemoveComment(a.Slug, second.Id); err != nil {
+		t.Errorf("comments: remove: expected no error: got %v\n", err)
+	}
+	if list, err := db.GetComments(0, a.Slug); err != nil || len(list) != 0 {
+		t.Errorf("comments: getComments: expected []: got %v %v\n", list, err)
+	}
+	third, errs, err := db.AddComment(jake, a.Slug, "Anyone?")
+	if err != nil || errs != nil {
+		t.Fatalf("comments: addComment: expected no errors: got %v %v\n", errs, err)
+	}
+
+	// When "Jacob" deletes the article
+	// Then the comments should be gone with it
+	if err := db.DeleteArticle(jake, a.Slug); err != nil {
+		t.Errorf("comments: deleteArticle: expected no error: got %v\n", err)
+	}
+	_, err = db.GetComments(0, a.Slug)
+	isError(t, "comments: getComments: deleted article", err, ErrNotFound)
+	isError(t, "comments: delete: deleted article", db.DeleteComment(jake, a.Slug, third.Id), ErrNotFound)
+	isError(t, "comments: remove: deleted article", db.RemoveComment(a.Slug, third.Id), ErrNotFound)
+}
@@ -0,0 +1,95 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"testing"
+)
+
+// Specification: Store Profile API
+func Profiles(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob" and "Anne" have been added
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+
+	// When "Jacob" follows "Anne," twice
+	// Then the profile should say "Jacob" is following "Anne"
+	// And the profile fetched by "Anne" and by anonymous users should not
+	for i := 0; i < 2; i++ {
+		if p, err := db.FollowUserByUsername(jake, "Anne"); err != nil {
+			t.Errorf("profiles: follow: expected no error: got %v\n", err)
+		} else if p.Username != "Anne" || !p.Following {
+			t.Errorf("profiles: follow: expected Anne following: got %+v\n", p)
+		}
+	}
+	if p, err := db.GetProfileByUsername(jake, "Anne"); err != nil || !p.Following {
+		t.Errorf("profiles: getProfile: expected following: got %+v %v\n", p, err)
+	}
+	if p, err := db.GetProfileByUsername(anne, "Jacob"); err != nil || p.Following {
+		t.Errorf("profiles: getProfile: expected not following: got %+v %v\n", p, err)
+	}
+	if p, err := db.GetProfileByUsername(0, "Anne"); err != nil || p.Following {
+		t.Errorf("profiles: getProfile: anonymous: expected not following: got %+v %v\n", p, err)
+	}
+	if u, err := db.GetUser(jake); err != nil || len(u.Following) != 1 || u.Following[0] != "Anne" {
+		t.Errorf("profiles: getUser: expected following [Anne]: got %+v %v\n", u, err)
+	}
+
+	// When users follow or unfollow themselves, unknown users, or as user 0
+	// Then we should get the expected errors
+	for _, tc := range []struct {
+		name     string
+		id       int
+		username string
+		target   error
+	}{
+		{"self", jake, "Jacob", ErrNotAuthorized},
+		{"unknown user", jake, "Bob", ErrNotFound},
+		{"user id 0", 0, "Anne", ErrNotAuthorized},
+		{"unknown user id", anne + 1000, "Anne", ErrNotAuthorized},
+	} {
+		_, err := db.FollowUserByUsername(tc.id, tc.username)
+		isError(t, "profiles: follow: "+tc.name, err, tc.target)
+		_, err = db.UnfollowUserByUsername(tc.id, tc.username)
+		isError(t, "profiles: unfollow: "+tc.name, err, tc.target)
+	}
+	_, err := db.GetProfileByUsername(jake, "Bob")
+	isError(t, "profiles: getProfile: unknown user", err, ErrNotFound)
+
+	// When "Jacob" unfollows "Anne," twice
+	// Then the profile should say "Jacob" is not following "Anne"
+	// When "Anne" unfollows "Jacob," who was never followed
+	// Then the profile should say "Anne" is not following "Jacob"
+	for i := 0; i < 2; i++ {
+		if p, err := db.UnfollowUserByUsername(jake, "Anne"); err != nil || p.Following {
+			t.Errorf("profiles: unfollow: expected not following: got %+v %v\n", p, err)
+		}
+	}
+	if p, err := db.UnfollowUserByUsername(anne, "Jacob"); err != nil || p.Following {
+		t.Errorf("profiles: unfollow: never followed: expected not following: got %+v %v\n", p, err)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"testing"
+)
+
+// Specification: Store Role API
+func Roles(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob" and "Anne" have been added
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+
+	// When roles are granted to or revoked from user id 0 or an unknown user
+	// Then we should get the expected errors
+	_, err := db.GrantRole(0, "moderator")
+	isError(t, "roles: grant: user id 0", err, ErrNotAuthorized)
+	_, err = db.GrantRole(anne+1000, "moderator")
+	isError(t, "roles: grant: unknown user id", err, ErrNotFound)
+	_, err = db.RevokeRole(0, "moderator")
+	isError(t, "roles: revoke: user id 0", err, ErrNotAuthorized)
+	_, err = db.RevokeRole(anne+1000, "moderator")
+	isError(t, "roles: revoke: unknown user id", err, ErrNotFound)
+
+	// When "Jacob" is granted "moderator" and "admin" (twice)
+	// Then "Jacob" should have both roles, sorted by name
+	// And "Anne" should have none
+	for _, role := range []string{"moderator", "admin", "admin"} {
+		if _, err := db.GrantRole(jake, role); err != nil {
+			t.Errorf("roles: grant %q: expected no error: got %v\n", role, err)
+		}
+	}
+	u, err := db.GetUser(jake)
+	if err != nil {
+		t.Fatalf("roles: getUser: expected no error: got %v\n", err)
+	} else if len(u.Roles) != 2 || u.Roles[0] != "admin" || u.Roles[1] != "moderator" {
+		t.Errorf("roles: getUser: expected [admin moderator]: got %v\n", u.Roles)
+	}
+	if u, err := db.GetUser(anne); err != nil || len(u.Roles) != 0 {
+		t.Errorf("roles: getUser: expected no roles for Anne: got %v %v\n", u, err)
+	}
+	generation := u.TokenGeneration
+
+	// When "Jacob" logs in
+	// Then the roles should be returned with the user
+	if u, err := db.Login("jake@jake.jake", "jakejake"); err != nil || len(u.Roles) != 2 {
+		t.Errorf("roles: login: expected 2 roles: got %v %v\n", u, err)
+	}
+
+	// When "Jacob" has "admin" revoked
+	// Then only "moderator" should remain
+	// And the token generation should be bumped
+	if u, err = db.RevokeRole(jake, "admin"); err != nil {
+		t.Fatalf("roles: revoke: expected no error: got %v\n", err)
+	} else if len(u.Roles) != 1 || u.Roles[0] != "moderator" {
+		t.Errorf("roles: revoke: expected [moderator]: got %v\n", u.Roles)
+	} else if u.TokenGeneration == generation {
+		t.Errorf("roles: revoke: expected token generation to change from %d\n", generation)
+	}
+	generation = u.TokenGeneration
+
+	// When "Anne" has a role revoked that was never granted
+	// Then the token generation should not change
+	if u, err := db.RevokeRole(anne, "admin"); err != nil || u.TokenGeneration != 0 {
+		t.Errorf("roles: revoke: role not granted: expected generation 0: got %v %v\n", u, err)
+	}
+	if u, err := db.GetUser(jake); err != nil || u.TokenGeneration != generation {
+		t.Errorf("roles: getUser: expected generation %d: got %v %v\n", generation, u, err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"testing"
+	"time"
+)
+
+// Specification: Store Session API
+func Sessions(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob" and "Anne" have been added
+	// And each of them has logged in and been given a refresh token
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+	later := time.Now().Add(time.Hour)
+	if err := db.CreateRefreshToken(jake, "jake-1", later); err != nil {
+		t.Fatalf("sessions: create: expected no error: got %v\n", err)
+	}
+	if err := db.CreateRefreshToken(anne, "anne-1", later); err != nil {
+		t.Fatalf("sessions: create: expected no error: got %v\n", err)
+	}
+
+	// When tokens are created for user id 0 or an unknown user
+	// Then we should get the expected errors
+	isError(t, "sessions: create: user id 0", db.CreateRefreshToken(0, "nobody", later), ErrNotAuthorized)
+	isError(t, "sessions: create: unknown user id", db.CreateRefreshToken(anne+1000, "nobody", later), ErrNotFound)
+
+	// When "Jacob" rotates the token twice
+	// Then each rotation should return "Jacob"
+	for _, tc := range []struct{ hash, newHash string }{
+		{"jake-1", "jake-2"},
+		{"jake-2", "jake-3"},
+	} {
+		if u, err := db.RotateRefreshToken(tc.hash, tc.newHash, later); err != nil {
+			t.Errorf("sessions: rotate %q: expected no error: got %v\n", tc.hash, err)
+		} else if u.Id != jake || u.Username != "Jacob" {
+			t.Errorf("sessions: rotate %q: expected Jacob: got %+v\n", tc.hash, u)
+		}
+	}
+
+	// When an unknown token is rotated
+	// Then we should get ErrNotAuthorized
+	_, err := db.RotateRefreshToken("unknown", "unknown-2", later)
+	isError(t, "sessions: rotate: unknown token", err, ErrNotAuthorized)
+
+	// When a used token is replayed
+	// Then it should be rejected
+	// And the newest token in its family should be revoked too
+	// And other families should not be affected
+	_, err = db.RotateRefreshToken("jake-1", "jake-stolen", later)
+	isError(t, "sessions: rotate: replayed token", err, ErrNotAuthorized)
+	_, err = db.RotateRefreshToken("jake-3", "jake-4", later)
+	isError(t, "sessions: rotate: revoked family", err, ErrNotAuthorized)
+	_, err = db.RotateRefreshToken("jake-stolen", "jake-5", later)
+	isError(t, "sessions: rotate: token from replay", err, ErrNotAuthorized)
+	if u, err := db.RotateRefreshToken("anne-1", "anne-2", later); err != nil || u.Id != anne {
+		t.Errorf("sessions: rotate: other family: expected Anne: got %+v %v\n", u, err)
+	}
+
+	// When an expired token is rotated
+	// Then it should be rejected
+	if err := db.CreateRefreshToken(jake, "jake-expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("sessions: create: expected no error: got %v\n", err)
+	}
+	_, err = db.RotateRefreshToken("jake-expired", "jake-6", later)
+	isError(t, "sessions: rotate: expired token", err, ErrNotAuthorized)
+
+	// When "Anne" logs out of the session holding "anne-2"
+	// Then the token should no longer rotate
+	// And logging out again should not be an error
+	if err := db.RevokeRefreshToken("anne-2"); err != nil {
+		t.Errorf("sessions: revokeRefreshToken: expected no error: got %v\n", err)
+	}
+	_, err = db.RotateRefreshToken("anne-2", "anne-3", later)
+	isError(t, "sessions: rotate: logged out", err, ErrNotAuthorized)
+	if err := db.RevokeRefreshToken("anne-2"); err != nil {
+		t.Errorf("sessions: revokeRefreshToken: twice: expected no error: got %v\n", err)
+	}
+
+	// When an access token is revoked
+	// Then it should be reported as revoked
+	// And other tokens should not be
+	if err := db.RevokeToken("jti-1", later); err != nil {
+		t.Errorf("sessions: revokeToken: expected no error: got %v\n", err)
+	}
+	if revoked, err := db.IsTokenRevoked("jti-1"); err != nil || !revoked {
+		t.Errorf("sessions: isTokenRevoked: expected revoked: got %v %v\n", revoked, err)
+	}
+	if revoked, err := db.IsTokenRevoked("jti-2"); err != nil || revoked {
+		t.Errorf("sessions: isTokenRevoked: expected not revoked: got %v %v\n", revoked, err)
+	}
+
+	// When all of "Jacob's" sessions are revoked
+	// Then "Jacob's" token generation should be bumped
+	// And none of "Jacob's" refresh tokens should rotate
+	// And "Anne's" sessions should not be affected
+	if err := db.CreateRefreshToken(jake, "jake-7", later); err != nil {
+		t.Fatalf("sessions: create: expected no error: got %v\n", err)
+	}
+	if err := db.CreateRefreshToken(jake, "jake-8", later); err != nil {
+		t.Fatalf("sessions: create: expected no error: got %v\n", err)
+	}
+	if err := db.CreateRefreshToken(anne, "anne-4", later); err != nil {
+		t.Fatalf("sessions: create: expected no error: got %v\n", err)
+	}
+	before, err := db.GetUser(jake)
+	if err != nil {
+		t.Fatalf("sessions: getUser: expected no error: got %v\n", err)
+	}
+	if err := db.RevokeSessions(jake); err != nil {
+		t.Errorf("sessions: revokeSessions: expected no error: got %v\n", err)
+	}
+	if after, err := db.GetUser(jake); err != nil || after.TokenGeneration == before.TokenGeneration {
+		t.Errorf("sessions: revokeSessions: expected new token generation: got %+v %v\n", after, err)
+	}
+	for _, hash := range []string{"jake-7", "jake-8"} {
+		_, err = db.RotateRefreshToken(hash, hash+"-next", later)
+		isError(t, "sessions: rotate: revoked sessions", err, ErrNotAuthorized)
+	}
+	if u, err := db.RotateRefreshToken("anne-4", "anne-5", later); err != nil || u.Id != anne {
+		t.Errorf("sessions: rotate: other user: expected Anne: got %+v %v\n", u, err)
+	}
+	isError(t, "sessions: revokeSessions: user id 0", db.RevokeSessions(0), ErrNotAuthorized)
+	isError(t, "sessions: revokeSessions: unknown user id", db.RevokeSessions(anne+1000), ErrNotFound)
+}
@@ -0,0 +1,87 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package storetest implements a conformance test suite for Store implementations.
+//
+// To use this package, arrange for your store's test file to call Suite
+// with a function that returns a new, empty store each time it is called.
+package storetest
+
+import (
+	"errors"
+	"github.com/mdhender/conduit/internal/password"
+	"github.com/mdhender/conduit/internal/store"
+	"testing"
+)
+
+// Hasher is a password hasher for tests. The default hasher is slow by design,
+// which adds up over a suite; this one is far too weak for real passwords.
+var Hasher = &password.Hasher{Iterations: 1000, SaltLength: 16, KeyLength: 32}
+
+// NewStore must return a new, empty store.
+type NewStore func() store.Store
+
+// The error identities that implementations must return.
+var (
+	ErrForbidden     = store.ErrForbidden
+	ErrNotAuthorized = store.ErrNotAuthorized
+	ErrNotEmpty      = store.ErrNotEmpty
+	ErrNotFound      = store.ErrNotFound
+	ErrPasswordReset = store.ErrPasswordReset
+	ErrSuspended     = store.ErrSuspended
+)
+
+func Suite(newStore NewStore, t *testing.T) {
+	Users(newStore, t)
+	Profiles(newStore, t)
+	Articles(newStore, t)
+	Comments(newStore, t)
+	Sessions(newStore, t)
+	Roles(newStore, t)
+	Accounts(newStore, t)
+	Backup(newStore, t)
+	Transactions(newStore, t)
+	Versions(newStore, t)
+}
+
+// mustCreateUser creates a user or fails the test.
+// It returns the id of the new user.
+func mustCreateUser(t *testing.T, db store.Store, username, email, password string) int {
+	t.Helper()
+	u, errs, err := db.CreateUser(username, email, password)
+	if err != nil || errs != nil {
+		t.Fatalf("createUser: %q: expected no errors: got %v %v\n", username, errs, err)
+	} else if u == nil || u.Id == 0 {
+		t.Fatalf("createUser: %q: expected user with id: got %+v\n", username, u)
+	}
+	return u.Id
+}
+
+// isError reports an error if err is not target.
+func isError(t *testing.T, what string, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("%s: expected error %v: got %v\n", what, target, err)
+	}
+}
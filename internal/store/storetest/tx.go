@@ -0,0 +1,166 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdhender/conduit/internal/store"
+	"github.com/mdhender/conduit/internal/store/model"
+	"testing"
+)
+
+// Specification: Store Transaction API
+func Transactions(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob" and "Anne" have been added
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+	usernames := func() (list []string) {
+		t.Helper()
+		users, _, err := db.ListUsers(model.UserFilter{Limit: 20})
+		if err != nil {
+			t.Fatalf("transactions: listUsers: expected no error: got %v\n", err)
+		}
+		for _, u := range users {
+			list = append(list, u.Username)
+		}
+		return list
+	}
+
+	// When a unit of work adds a user and an article, a follow and a favorite
+	// Then its changes should be visible inside the unit of work as they are made
+	// And all of them should be visible once it succeeds
+	var slug string
+	err := db.Transact(func(tx store.Store) error {
+		if _, errs, err := tx.CreateUser("Bob", "bob@example.com", "bobbob"); err != nil || errs != nil {
+			return fmt.Errorf("createUser: %v %v", errs, err)
+		}
+		a, errs, err := tx.CreateArticle(jake, "How to train your dragon", "Ever wonder how?", "You have to believe", []string{"dragons"})
+		if err != nil || errs != nil {
+			return fmt.Errorf("createArticle: %v %v", errs, err)
+		}
+		slug = a.Slug
+		if _, err := tx.GetArticleBySlug(0, slug); err != nil {
+			return fmt.Errorf("getArticle: %w", err)
+		}
+		if _, err := tx.FollowUserByUsername(anne, "Jacob"); err != nil {
+			return fmt.Errorf("follow: %w", err)
+		}
+		if _, err := tx.FavoriteArticle(anne, slug); err != nil {
+			return fmt.Errorf("favorite: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transactions: commit: expected no error: got %v\n", err)
+	}
+	if got := fmt.Sprint(usernames()); got != "[Jacob Anne Bob]" {
+		t.Errorf("transactions: commit: expected [Jacob Anne Bob]: got %s\n", got)
+	}
+	if a, err := db.GetArticleBySlug(anne, slug); err != nil || !a.Favorited || a.FavoritesCount != 1 || !a.Author.Following {
+		t.Errorf("transactions: commit: expected favorited article by followed author: got %+v %v\n", a, err)
+	}
+
+	// When a unit of work adds a user, changes an e-mail and deletes the article
+	// And then fails
+	// Then Transact should return its error
+	// And none of its changes should be visible
+	failed := errors.New("failed")
+	err = db.Transact(func(tx store.Store) error {
+		email := "jacob@jake.jake"
+		if _, errs, err := tx.CreateUser("Carol", "carol@example.com", "carolcarol"); err != nil || errs != nil {
+			return fmt.Errorf("createUser: %v %v", errs, err)
+		} else if _, errs, err := tx.UpdateUser(jake, &email, nil, nil); err != nil || errs != nil {
+			return fmt.Errorf("updateUser: %v %v", errs, err)
+		} else if err := tx.DeleteArticle(jake, slug); err != nil {
+			return fmt.Errorf("deleteArticle: %w", err)
+		}
+		return failed
+	})
+	isError(t, "transactions: rollback", err, failed)
+	if got := fmt.Sprint(usernames()); got != "[Jacob Anne Bob]" {
+		t.Errorf("transactions: rollback: expected [Jacob Anne Bob]: got %s\n", got)
+	}
+	if u, err := db.GetUser(jake); err != nil || u.Email != "jake@jake.jake" {
+		t.Errorf("transactions: rollback: expected e-mail %q: got %+v %v\n", "jake@jake.jake", u, err)
+	}
+	if a, err := db.GetArticleBySlug(anne, slug); err != nil || !a.Favorited {
+		t.Errorf("transactions: rollback: expected favorited article: got %+v %v\n", a, err)
+	}
+	if tags, err := db.GetTags(); err != nil || len(tags) != 1 {
+		t.Errorf("transactions: rollback: expected [dragons]: got %v %v\n", tags, err)
+	}
+
+	// When a unit of work panics
+	// Then the panic should reach the caller
+	// And none of its changes should be visible
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("transactions: panic: expected %q: got %v\n", "boom", p)
+			}
+		}()
+		_ = db.Transact(func(tx store.Store) error {
+			_, _, _ = tx.CreateUser("Dave", "dave@example.com", "davedave")
+			panic("boom")
+		})
+	}()
+	if got := fmt.Sprint(usernames()); got != "[Jacob Anne Bob]" {
+		t.Errorf("transactions: panic: expected [Jacob Anne Bob]: got %s\n", got)
+	}
+
+	// When a nested unit of work fails but the outer one carries on and succeeds
+	// Then only the changes of the outer unit of work should be kept
+	// And an operation that fails should leave nothing behind
+	err = db.Transact(func(tx store.Store) error {
+		if _, errs, err := tx.CreateUser("Erin", "erin@example.com", "erinerin"); err != nil || errs != nil {
+			return fmt.Errorf("createUser: %v %v", errs, err)
+		}
+		err := tx.Transact(func(inner store.Store) error {
+			if _, errs, err := inner.CreateUser("Frank", "frank@example.com", "frankfrank"); err != nil || errs != nil {
+				return fmt.Errorf("createUser: %v %v", errs, err)
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			return fmt.Errorf("nested: expected %v: got %v", failed, err)
+		}
+		if _, errs, _ := tx.CreateUser("Gina", "jake@jake.jake", "ginagina"); errs == nil {
+			return fmt.Errorf("createUser: expected duplicate e-mail to fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("transactions: nested: expected no error: got %v\n", err)
+	}
+	if got := fmt.Sprint(usernames()); got != "[Jacob Anne Bob Erin]" {
+		t.Errorf("transactions: nested: expected [Jacob Anne Bob Erin]: got %s\n", got)
+	}
+
+	// And the store should still be usable after all of that
+	mustCreateUser(t, db, "Hank", "hank@example.com", "hankhank")
+}
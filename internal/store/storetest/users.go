@@ -0,0 +1,144 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"testing"
+)
+
+// Specification: Store User API
+func Users(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// When we create the user "Jacob"
+	// Then the user should have a non-zero id
+	// And the username and e-mail should match
+	// And the password should not be returned by GetUser
+	db := newStore()
+	u, errs, err := db.CreateUser("Jacob", "jake@jake.jake", "jakejake")
+	if err != nil || errs != nil {
+		t.Fatalf("users: createUser: expected no errors: got %v %v\n", errs, err)
+	} else if u.Id == 0 {
+		t.Errorf("users: createUser: expected non-zero id: got 0\n")
+	} else if u.Username != "Jacob" || u.Email != "jake@jake.jake" {
+		t.Errorf("users: createUser: expected Jacob jake@jake.jake: got %s %s\n", u.Username, u.Email)
+	}
+	jake := u.Id
+
+	// When we create users with blank or duplicate fields
+	// Then we should get errors for exactly those fields
+	for _, tc := range []struct {
+		name                      string
+		username, email, password string
+		fields                    []string
+	}{
+		{"blank fields", "", " ", "", []string{"username", "email", "password"}},
+		{"duplicate username", "Jacob", "jacob@jake.jake", "jakejake", []string{"username"}},
+		{"duplicate username after trimming", " Jacob ", "jacob@jake.jake", "jakejake", []string{"username"}},
+		{"duplicate email", "Jake", "jake@jake.jake", "jakejake", []string{"email"}},
+		{"duplicate username and email", "Jacob", "jake@jake.jake", "jakejake", []string{"username", "email"}},
+	} {
+		u, errs, err := db.CreateUser(tc.username, tc.email, tc.password)
+		if err != nil {
+			t.Errorf("users: createUser: %s: %+v\n", tc.name, err)
+		}
+		if u != nil {
+			t.Errorf("users: createUser: %s: expected no user: got %+v\n", tc.name, u)
+		}
+		for _, field := range tc.fields {
+			if len(errs[field]) == 0 {
+				t.Errorf("users: createUser: %s: expected errors for %q: got %v\n", tc.name, field, errs)
+			}
+		}
+		if len(errs) != len(tc.fields) {
+			t.Errorf("users: createUser: %s: expected errors for %v: got %v\n", tc.name, tc.fields, errs)
+		}
+	}
+
+	// When we log in with the right and wrong passwords and an unknown e-mail
+	// Then only the right password should succeed
+	if u, err := db.Login("jake@jake.jake", "jakejake"); err != nil {
+		t.Errorf("users: login: expected no error: got %v\n", err)
+	} else if u.Id != jake {
+		t.Errorf("users: login: expected id %d: got %d\n", jake, u.Id)
+	}
+	_, err = db.Login("jake@jake.jake", "fakefake")
+	isError(t, "users: login: wrong password", err, ErrNotAuthorized)
+	_, err = db.Login("anne@anne.anne", "jakejake")
+	isError(t, "users: login: unknown email", err, ErrNotAuthorized)
+
+	// When we fetch unknown users
+	// Then we should get ErrNotFound
+	_, err = db.GetUser(0)
+	isError(t, "users: getUser: id 0", err, ErrNotFound)
+	_, err = db.GetUser(jake + 1000)
+	isError(t, "users: getUser: unknown id", err, ErrNotFound)
+
+	// Given the user "Anne" has been added
+	// When "Jacob" changes e-mail to "jacob@jake.jake" and sets a bio
+	// Then GetUser, Login, and the profile should reflect the change
+	// And the old e-mail should no longer log in
+	// And the old e-mail should be available to new users
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+	email, bio := "jacob@jake.jake", "I like to skateboard"
+	if u, errs, err := db.UpdateUser(jake, &email, &bio, nil); err != nil || errs != nil {
+		t.Errorf("users: updateUser: expected no errors: got %v %v\n", errs, err)
+	} else if u.Email != email || u.Bio == nil || *u.Bio != bio || u.Image != nil {
+		t.Errorf("users: updateUser: expected %q %q nil: got %+v\n", email, bio, u)
+	}
+	if u, err := db.GetUser(jake); err != nil {
+		t.Errorf("users: getUser: expected no error: got %v\n", err)
+	} else if u.Email != email || u.Username != "Jacob" {
+		t.Errorf("users: getUser: expected Jacob %q: got %s %q\n", email, u.Username, u.Email)
+	}
+	if _, err := db.Login(email, "jakejake"); err != nil {
+		t.Errorf("users: login: new email: expected no error: got %v\n", err)
+	}
+	_, err = db.Login("jake@jake.jake", "jakejake")
+	isError(t, "users: login: old email", err, ErrNotAuthorized)
+	if p, err := db.GetProfileByUsername(anne, "Jacob"); err != nil {
+		t.Errorf("users: getProfileByUsername: expected no error: got %v\n", err)
+	} else if p.Bio == nil || *p.Bio != bio {
+		t.Errorf("users: getProfileByUsername: expected bio %q: got %v\n", bio, p.Bio)
+	}
+	mustCreateUser(t, db, "Jake", "jake@jake.jake", "jakejake")
+
+	// When "Jacob" tries to change e-mail to Anne's, to blank, or with spaces
+	// Then we should get errors for the e-mail
+	// And the e-mail should not change
+	for _, val := range []string{"anne@anne.anne", "", " jacob@jake.jake"} {
+		email := val
+		if _, errs, _ := db.UpdateUser(jake, &email, nil, nil); len(errs["email"]) == 0 {
+			t.Errorf("users: updateUser: %q: expected errors for email: got %v\n", val, errs)
+		}
+	}
+	if u, err := db.GetUser(jake); err != nil || u.Email != "jacob@jake.jake" {
+		t.Errorf("users: getUser: expected email %q: got %+v %v\n", "jacob@jake.jake", u, err)
+	}
+
+	// When an unknown user is updated
+	// Then we should get ErrNotFound
+	_, _, err = db.UpdateUser(0, &email, nil, nil)
+	isError(t, "users: updateUser: id 0", err, ErrNotFound)
+}
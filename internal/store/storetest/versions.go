@@ -0,0 +1,188 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package storetest
+
+import (
+	"errors"
+	"github.com/mdhender/conduit/internal/store"
+	"testing"
+)
+
+// Specification: Record Versions
+func Versions(newStore NewStore, t *testing.T) {
+	// Given a new store
+	// And the users "Jacob" and "Anne" have been added
+	db := newStore()
+	jake := mustCreateUser(t, db, "Jacob", "jake@jake.jake", "jakejake")
+	anne := mustCreateUser(t, db, "Anne", "anne@anne.anne", "anneanne")
+	userVersion := func(what string, expected int) {
+		t.Helper()
+		if u, err := db.GetUser(jake); err != nil {
+			t.Fatalf("versions: %s: getUser: expected no error: got %v\n", what, err)
+		} else if u.Version != expected {
+			t.Errorf("versions: %s: expected user version %d: got %d\n", what, expected, u.Version)
+		}
+		if p, err := db.GetProfileByUsername(anne, "Jacob"); err != nil {
+			t.Fatalf("versions: %s: getProfile: expected no error: got %v\n", what, err)
+		} else if p.Version != expected {
+			t.Errorf("versions: %s: expected profile version %d: got %d\n", what, expected, p.Version)
+		}
+	}
+
+	// Then a new user should be at version 1
+	userVersion("create", 1)
+
+	// When Jacob updates his bio
+	// Then the version should be bumped
+	bio := "I work at statefarm"
+	if u, errs, err := db.UpdateUser(jake, nil, &bio, nil); err != nil || errs != nil {
+		t.Fatalf("versions: updateUser: expected no errors: got %v %v\n", errs, err)
+	} else if u.Version != 2 {
+		t.Errorf("versions: updateUser: expected version 2: got %d\n", u.Version)
+	}
+	userVersion("updateUser", 2)
+
+	// When Jacob sends an update without any changes
+	// Then the version should not change
+	if _, errs, err := db.UpdateUser(jake, nil, nil, nil); err != nil || errs != nil {
+		t.Fatalf("versions: updateUser: no changes: expected no errors: got %v %v\n", errs, err)
+	}
+	userVersion("updateUser: no changes", 2)
+
+	// When an update is rejected
+	// Then the version should not change
+	taken := "anne@anne.anne"
+	if _, errs, _ := db.UpdateUser(jake, &taken, nil, nil); errs == nil {
+		t.Errorf("versions: updateUser: duplicate email: expected errors: got none\n")
+	}
+	userVersion("updateUser: rejected", 2)
+
+	// When Anne follows Jacob
+	// Then Jacob's version should not change
+	if _, err := db.FollowUserByUsername(anne, "Jacob"); err != nil {
+		t.Fatalf("versions: follow: expected no error: got %v\n", err)
+	}
+	userVersion("follow", 2)
+
+	// When Jacob changes his password
+	// Then the version should be bumped
+	if _, errs, err := db.ChangePassword("jake@jake.jake", "jakejake", "jakejakejake"); err != nil || errs != nil {
+		t.Fatalf("versions: changePassword: expected no errors: got %v %v\n", errs, err)
+	}
+	userVersion("changePassword", 3)
+
+	// When an update is rolled back
+	// Then the version should not change
+	errRollback := errors.New("rollback")
+	err := db.Transact(func(tx store.Store) error {
+		if _, errs, err := tx.UpdateUser(jake, nil, nil, &bio); err != nil || errs != nil {
+			t.Errorf("versions: transact: updateUser: expected no errors: got %v %v\n", errs, err)
+		}
+		return errRollback
+	})
+	isError(t, "versions: transact", err, errRollback)
+	userVersion("rollback", 3)
+
+	// Given Jacob has written an article
+	a, errs, err := db.CreateArticle(jake, "How to train your dragon", "Ever wonder how?", "You have to believe", []string{"dragons"})
+	if err != nil || errs != nil {
+		t.Fatalf("versions: createArticle: expected no errors: got %v %v\n", errs, err)
+	}
+	slug := a.Slug
+	articleVersion := func(what string, expected int) {
+		t.Helper()
+		if a, err := db.GetArticleBySlug(anne, slug); err != nil {
+			t.Fatalf("versions: %s: getArticle: expected no error: got %v\n", what, err)
+		} else if a.Version != expected {
+			t.Errorf("versions: %s: expected article version %d: got %d\n", what, expected, a.Version)
+		} else if a.Author.Version != 3 {
+			t.Errorf("versions: %s: expected author version 3: got %d\n", what, a.Author.Version)
+		}
+	}
+
+	// Then a new article should be at version 1
+	articleVersion("createArticle", 1)
+
+	// When Anne favorites and comments on the article
+	// Then the version should not change
+	if _, err := db.FavoriteArticle(anne, slug); err != nil {
+		t.Fatalf("versions: favorite: expected no error: got %v\n", err)
+	} else if _, errs, err := db.AddComment(anne, slug, "Thank you so much!"); err != nil || errs != nil {
+		t.Fatalf("versions: addComment: expected no errors: got %v %v\n", errs, err)
+	}
+	articleVersion("favorite and comment", 1)
+
+	// When Jacob renames the article
+	// Then the version should be bumped
+	title := "How to train your dragon, part 2"
+	if a, errs, err := db.UpdateArticle(jake, slug, &title, nil, nil, nil); err != nil || errs != nil {
+		t.Fatalf("versions: updateArticle: expected no errors: got %v %v\n", errs, err)
+	} else if a.Version != 2 {
+		t.Errorf("versions: updateArticle: expected version 2: got %d\n", a.Version)
+	} else {
+		slug = a.Slug
+	}
+	articleVersion("updateArticle", 2)
+
+	// When Jacob sends an update with the same title
+	// Then the version should not change
+	if _, errs, err := db.UpdateArticle(jake, slug, &title, nil, nil, nil); err != nil || errs != nil {
+		t.Fatalf("versions: updateArticle: no changes: expected no errors: got %v %v\n", errs, err)
+	}
+	articleVersion("updateArticle: no changes", 2)
+
+	// When the store is backed up and restored
+	// Then the versions should be kept
+	exported := &records{}
+	if err := db.Export(exported); err != nil {
+		t.Fatalf("versions: export: expected no error: got %v\n", err)
+	}
+	db = newStore()
+	if err := db.Import(exported.reader()); err != nil {
+		t.Fatalf("versions: import: expected no error: got %v\n", err)
+	}
+	userVersion("import", 3)
+	articleVersion("import", 2)
+
+	// When a backup made before versions were kept is restored
+	// Then the records should be at version 1
+	for _, rec := range exported.list {
+		if rec.User != nil {
+			rec.User.Version = 0
+		} else if rec.Article != nil {
+			rec.Article.Version = 0
+		}
+	}
+	db = newStore()
+	if err := db.Import(exported.reader()); err != nil {
+		t.Fatalf("versions: import: no versions: expected no error: got %v\n", err)
+	}
+	if u, err := db.GetUser(jake); err != nil || u.Version != 1 {
+		t.Errorf("versions: import: no versions: expected user version 1: got %+v %v\n", u, err)
+	}
+	if a, err := db.GetArticleBySlug(0, slug); err != nil || a.Version != 1 {
+		t.Errorf("versions: import: no versions: expected article version 1: got %+v %v\n", a, err)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: Admin API
+func Admin(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	adminBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(time.Minute, 4, "Root", "root@conduit", "admin")}
+
+	// login returns the response code, token and refresh token for POST /api/users/login.
+	login := func(email, password string) (int, string, string) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request("POST", "/api/users/login", conduit.LoginUserRequest{User: conduit.LoginUser{Email: email, Password: password}}, contentType))
+		if w.Code != http.StatusOK {
+			return w.Code, "", ""
+		}
+		var userResponse conduit.UserResponse
+		if err := fetch(w.Result().Body, &userResponse); err != nil {
+			t.Fatalf("admin: login: response did not contain valid UserResponse: %+v\n", err)
+		}
+		return w.Code, userResponse.User.Token, userResponse.User.RefreshToken
+	}
+	// currentUser returns the response code for GET /api/user.
+	currentUser := func(token string) int {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request("GET", "/api/user", nil, keyValue{key: "Authorization", value: "Bearer " + token}))
+		return w.Code
+	}
+	// admin sends the request with the admin token and returns the response.
+	// The user in the response is only set for an AdminUserResponse.
+	admin := func(method, target string, expected int) (*httptest.ResponseRecorder, conduit.AdminUser) {
+		req := request(method, target, nil, adminBearerToken)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("admin: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.String(), expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+		}
+		var userResponse conduit.AdminUserResponse
+		if w.Code == http.StatusOK && method != "GET" {
+			if err := fetch(w.Result().Body, &userResponse); err != nil {
+				t.Errorf("admin: %s %s response did not contain valid AdminUserResponse: %+v\n", req.Method, req.URL.Path, err)
+			}
+		}
+		return w, userResponse.User
+	}
+
+	// Given a new server
+	// And the users "Jacob," "Anne" and "Bob" have been added
+	// And "Jacob" and "Anne" follow each other
+	// And "Anne" has created the article "How to train your dragon"
+	// And "Jacob" has commented on it
+	for _, u := range []conduit.NewUser{
+		{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"},
+		{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"},
+		{Username: "Bob", Email: "bob@example.com", Password: "bobbob"},
+		{Username: "Root", Email: "root@conduit", Password: "rootroot"},
+	} {
+		srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: u}, contentType))
+	}
+	_, jakeToken, _ := login("jake@jake.jake", "jakejake")
+	_, anneToken, anneRefresh := login("anne@anne.anne", "anneanne")
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + jakeToken}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + anneToken}
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/profiles/Anne/follow", nil, jakeBearerToken))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/profiles/Jacob/follow", nil, anneBearerToken))
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Description = "Ever wonder how?"
+	createArticle.Article.Body = "You have to believe"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, anneBearerToken))
+	var addComment conduit.CommentAddRequest
+	addComment.Comment.Body = "Thank you!"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles/how-to-train-your-dragon/comments", addComment, contentType, jakeBearerToken))
+
+	// When a user who is not an admin, or no user at all, uses the admin API
+	// Then the response should have a status of 404 (not found)
+	for _, keys := range [][]keyValue{{jakeBearerToken}, nil} {
+		req := request("GET", "/api/admin/users", nil, keys...)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusNotFound; w.Code != expected {
+			t.Errorf("admin: %s %s without admin expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+		}
+	}
+
+	// When an admin fetches the admin index
+	// Then the roles that can be granted and the number of users should be returned
+	if w, _ := admin("GET", "/api/admin", http.StatusOK); w.Code == http.StatusOK {
+		var indexResponse conduit.AdminIndexResponse
+		if err := fetch(w.Result().Body, &indexResponse); err != nil {
+			t.Errorf("admin: GET /api/admin response did not contain valid AdminIndexResponse: %+v\n", err)
+		} else if !equalStrings(indexResponse.Roles, []string{"admin", "moderator"}) || indexResponse.UsersCount != 4 {
+			t.Errorf("admin: GET /api/admin expected [admin moderator] and 4 users: got %+v\n", indexResponse)
+		}
+	}
+
+	// When an admin lists the users
+	// Then the users should be returned oldest first, searched and paged
+	for _, tc := range []struct {
+		target   string
+		expected []string
+		count    int
+	}{
+		{"/api/admin/users", []string{"Jacob", "Anne", "Bob", "Root"}, 4},
+		{"/api/admin/users?limit=2&offset=1", []string{"Anne", "Bob"}, 4},
+		{"/api/admin/users?search=ANN", []string{"Anne"}, 1},
+		{"/api/admin/users?search=example.com", []string{"Bob"}, 1},
+	} {
+		w, _ := admin("GET", tc.target, http.StatusOK)
+		var usersResponse conduit.AdminUsersResponse
+		if err := fetch(w.Result().Body, &usersResponse); err != nil {
+			t.Errorf("admin: GET %s response did not contain valid AdminUsersResponse: %+v\n", tc.target, err)
+			continue
+		}
+		var usernames []string
+		for _, u := range usersResponse.Users {
+			usernames = append(usernames, u.Username)
+		}
+		if !equalStrings(usernames, tc.expected) || usersResponse.UsersCount != tc.count {
+			t.Errorf("admin: GET %s expected %v of %d: got %v of %d\n", tc.target, tc.expected, tc.count, usernames, usersResponse.UsersCount)
+		}
+	}
+	admin("GET", "/api/admin/users?limit=many", http.StatusUnprocessableEntity)
+
+	// When an admin fetches "Jacob"
+	// Then the details for "Jacob" should be returned
+	if w, _ := admin("GET", "/api/admin/users/Jacob", http.StatusOK); w.Code == http.StatusOK {
+		var userResponse conduit.AdminUserResponse
+		if err := fetch(w.Result().Body, &userResponse); err != nil {
+			t.Errorf("admin: GET /api/admin/users/Jacob response did not contain valid AdminUserResponse: %+v\n", err)
+		} else if u := userResponse.User; u.Email != "jake@jake.jake" || !equalStrings(u.Following, []string{"Anne"}) || len(u.Roles) != 0 || u.Suspended {
+			t.Errorf("admin: GET /api/admin/users/Jacob expected details for Jacob: got %+v\n", u)
+		}
+	}
+	admin("GET", "/api/admin/users/Nobody", http.StatusNotFound)
+
+	// When an admin suspends "Anne"
+	// Then "Anne" should not be able to use the token, log in, or refresh the session
+	// And all of that should work again once "Anne" is restored
+	if _, u := admin("POST", "/api/admin/users/Anne/suspend", http.StatusOK); !u.Suspended {
+		t.Errorf("admin: suspend: expected Anne to be suspended: got %+v\n", u)
+	}
+	if code := currentUser(anneToken); code != http.StatusUnauthorized {
+		t.Errorf("admin: suspended token expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+	if code, _, _ := login("anne@anne.anne", "anneanne"); code != http.StatusForbidden {
+		t.Errorf("admin: suspended login expected %d: got %d\n", http.StatusForbidden, code)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, request("POST", "/api/users/refresh", conduit.RefreshUserRequest{User: conduit.RefreshUser{RefreshToken: anneRefresh}}, contentType))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("admin: suspended refresh expected %d: got %d\n", http.StatusUnauthorized, w.Code)
+	}
+	if _, u := admin("DELETE", "/api/admin/users/Anne/suspend", http.StatusOK); u.Suspended {
+		t.Errorf("admin: unsuspend: expected Anne to be restored: got %+v\n", u)
+	}
+	if code := currentUser(anneToken); code != http.StatusOK {
+		t.Errorf("admin: restored token expected %d: got %d\n", http.StatusOK, code)
+	}
+	if code, _, _ := login("anne@anne.anne", "anneanne"); code != http.StatusOK {
+		t.Errorf("admin: restored login expected %d: got %d\n", http.StatusOK, code)
+	}
+
+	// When an admin forces "Jacob" to reset the password
+	// Then the token for "Jacob" should be rejected and "Jacob" should not be able to log in
+	// And "Jacob" should be able to log in once the password is changed
+	if _, u := admin("POST", "/api/admin/users/Jacob/password-reset", http.StatusOK); !u.PasswordResetRequired {
+		t.Errorf("admin: password reset: expected reset required: got %+v\n", u)
+	}
+	if code := currentUser(jakeToken); code != http.StatusUnauthorized {
+		t.Errorf("admin: password reset token expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+	if code, _, _ := login("jake@jake.jake", "jakejake"); code != http.StatusForbidden {
+		t.Errorf("admin: password reset login expected %d: got %d\n", http.StatusForbidden, code)
+	}
+	for _, tc := range []struct {
+		password, newPassword string
+		expected              int
+	}{
+		{"wrong", "jakejakejake", http.StatusUnauthorized},
+		{"jakejake", " ", http.StatusUnprocessableEntity},
+		{"jakejake", "jakejakejake", http.StatusOK},
+	} {
+		req := request("POST", "/api/users/password", conduit.ChangePasswordRequest{User: conduit.ChangePassword{Email: "jake@jake.jake", Password: tc.password, NewPassword: tc.newPassword}}, contentType)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("admin: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, tc.expected, http.StatusText(tc.expected), w.Code, http.StatusText(w.Code))
+		}
+	}
+	if code, _, _ := login("jake@jake.jake", "jakejake"); code != http.StatusUnauthorized {
+		t.Errorf("admin: old password login expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+	if code, _, _ := login("jake@jake.jake", "jakejakejake"); code != http.StatusOK {
+		t.Errorf("admin: new password login expected %d: got %d\n", http.StatusOK, code)
+	}
+
+	// When an admin grants and revokes roles
+	// Then only roles in the policy should be accepted
+	// And the granted roles should be returned with the user
+	if _, u := admin("POST", "/api/admin/users/Jacob/roles/moderator", http.StatusOK); !equalStrings(u.Roles, []string{"moderator"}) {
+		t.Errorf("admin: grant: expected [moderator]: got %v\n", u.Roles)
+	}
+	admin("POST", "/api/admin/users/Jacob/roles/wizard", http.StatusUnprocessableEntity)
+	admin("POST", "/api/admin/users/Jacob/roles/authenticated", http.StatusUnprocessableEntity)
+	admin("POST", "/api/admin/users/Nobody/roles/moderator", http.StatusNotFound)
+	if _, u := admin("DELETE", "/api/admin/users/Jacob/roles/moderator", http.StatusOK); len(u.Roles) != 0 {
+		t.Errorf("admin: revoke: expected no roles: got %v\n", u.Roles)
+	}
+
+	// When an admin deletes "Anne"
+	// Then "Anne" and the article by "Anne" should be gone
+	// And "Jacob" should no longer follow "Anne"
+	_, bobToken, _ := login("bob@example.com", "bobbob")
+	admin("DELETE", "/api/admin/users/Anne", http.StatusNoContent)
+	admin("DELETE", "/api/admin/users/Anne", http.StatusNotFound)
+	for _, target := range []string{"/api/profiles/Anne", "/api/articles/how-to-train-your-dragon"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request("GET", target, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("admin: deleted user: GET %s expected %d: got %d\n", target, http.StatusNotFound, w.Code)
+		}
+	}
+	if code, _, _ := login("anne@anne.anne", "anneanne"); code != http.StatusUnauthorized {
+		t.Errorf("admin: deleted user login expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+	if w, _ := admin("GET", "/api/admin/users/Jacob", http.StatusOK); w.Code == http.StatusOK {
+		var userResponse conduit.AdminUserResponse
+		if err := fetch(w.Result().Body, &userResponse); err != nil || len(userResponse.User.Following) != 0 {
+			t.Errorf("admin: deleted user: expected Jacob to follow no one: got %+v %v\n", userResponse.User, err)
+		}
+	}
+	if code := currentUser(bobToken); code != http.StatusOK {
+		t.Errorf("admin: other users' tokens expected %d: got %d\n", http.StatusOK, code)
+	}
+
+	// Given "Bob" has a token that claims the admin role
+	// When an admin deletes "Bob"
+	// Then the token for "Bob" should not be accepted by the admin API or anywhere else
+	bobAdminToken := srv.NewJWT(time.Minute, 3, "Bob", "bob@example.com", "authenticated", "admin")
+	for _, expected := range []int{http.StatusOK, http.StatusNotFound} {
+		if expected == http.StatusNotFound {
+			admin("DELETE", "/api/admin/users/Bob", http.StatusNoContent)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request("GET", "/api/admin/users", nil, keyValue{key: "Authorization", value: "Bearer " + bobAdminToken}))
+		if w.Code != expected {
+			t.Errorf("admin: deleted admin: GET /api/admin/users expected %d: got %d\n", expected, w.Code)
+		}
+	}
+	if code := currentUser(bobAdminToken); code != http.StatusUnauthorized {
+		t.Errorf("admin: deleted admin token expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+}
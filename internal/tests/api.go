@@ -57,4 +57,17 @@ func Suite(newServer TestServer, t *testing.T) {
 	Authentication(newServer, t)
 	User(newServer, t)
 	Profile(newServer, t)
+	Articles(newServer, t)
+	ListArticles(newServer, t)
+	Feed(newServer, t)
+	Favorites(newServer, t)
+	Comments(newServer, t)
+	Tags(newServer, t)
+	EditArticles(newServer, t)
+	JWKS(newServer, t)
+	Refresh(newServer, t)
+	Logout(newServer, t)
+	Roles(newServer, t)
+	Admin(newServer, t)
+	ConditionalRequests(newServer, t)
 }
@@ -0,0 +1,185 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: Articles API
+func Articles(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	validBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+
+	// Given a new server
+	// And the user with username "Jacob," e-mail "jake@jake.jake," and password "jakejake" has been added
+	// And the request is POST /api/articles
+	// And the request content type header is "application/json; charset=utf-8"
+	// And the request includes a valid bearer token for the user "jake@jake.jake"
+	// And the request body is an ArticleCreateRequest with the values
+	//   { "article": { "title": "How to train your dragon", "description": "Ever wonder how?", "body": "You have to believe", "tagList": ["dragons", "training"] } }
+	// When we execute the request
+	// Then the response should have a status of 201 (created)
+	// And contain a valid ArticleResponse with a valid Article
+	// And the Article title should be "How to train your dragon"
+	// And the Article slug should be "how-to-train-your-dragon"
+	// And the Article author should be "Jacob"
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Description = "Ever wonder how?"
+	createArticle.Article.Body = "You have to believe"
+	createArticle.Article.TagList = []string{"dragons", "training"}
+	req := request("POST", "/api/articles", createArticle, contentType, validBearerToken)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusCreated; w.Code != expected {
+		t.Errorf("articles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else {
+		var articleResponse conduit.ArticleResponse
+		if err := fetch(w.Result().Body, &articleResponse); err != nil {
+			t.Errorf("articles: %s %s response did not contain valid ArticleResponse: %+v\n", req.Method, req.URL.Path, err)
+		} else {
+			if expected := createArticle.Article.Title; articleResponse.Article.Title != expected {
+				t.Errorf("articles: %s %s title expected %q: got %q\n", req.Method, req.URL.Path, expected, articleResponse.Article.Title)
+			}
+			if expected := "how-to-train-your-dragon"; articleResponse.Article.Slug != expected {
+				t.Errorf("articles: %s %s slug expected %q: got %q\n", req.Method, req.URL.Path, expected, articleResponse.Article.Slug)
+			}
+			if expected := "Jacob"; articleResponse.Article.Author.Username != expected {
+				t.Errorf("articles: %s %s author expected %q: got %q\n", req.Method, req.URL.Path, expected, articleResponse.Article.Author.Username)
+			}
+		}
+	}
+
+	// Given the prior server
+	// And the request is POST /api/articles
+	// And the request content type header is "application/json; charset=utf-8"
+	// And the request includes a valid bearer token for the user "jake@jake.jake"
+	// And the request body is an ArticleCreateRequest with the values
+	//   { "article": { "title": "", "description": "Ever wonder how?", "body": "" } }
+	// When we execute the request
+	// Then the response should have a status of 422 (unprocessable entity)
+	// And the errors should include "title" and "body"
+	createArticle = conduit.ArticleCreateRequest{}
+	createArticle.Article.Description = "Ever wonder how?"
+	req = request("POST", "/api/articles", createArticle, contentType, validBearerToken)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnprocessableEntity; w.Code != expected {
+		t.Errorf("articles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else {
+		var errorsResponse struct {
+			Errors map[string][]string `json:"errors"`
+		}
+		if err := fetch(w.Result().Body, &errorsResponse); err != nil {
+			t.Errorf("articles: %s %s response did not contain valid errors: %+v\n", req.Method, req.URL.Path, err)
+		} else {
+			for _, field := range []string{"title", "body"} {
+				if len(errorsResponse.Errors[field]) == 0 {
+					t.Errorf("articles: %s %s expected errors for %q: got none\n", req.Method, req.URL.Path, field)
+				}
+			}
+			if len(errorsResponse.Errors["description"]) != 0 {
+				t.Errorf("articles: %s %s expected no errors for %q: got %v\n", req.Method, req.URL.Path, "description", errorsResponse.Errors["description"])
+			}
+		}
+	}
+
+	// Given the prior server
+	// And the request is POST /api/articles
+	// And the request content type header is "application/json; charset=utf-8"
+	// And the request does not include a bearer token
+	// When we execute the request
+	// Then the response should have a status of 401 (not authorized)
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Body = "You have to believe"
+	req = request("POST", "/api/articles", createArticle, contentType)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnauthorized; w.Code != expected {
+		t.Errorf("articles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+
+	// Given the prior server
+	// And the request is POST /api/articles
+	// And the request includes a valid bearer token for the user "jake@jake.jake"
+	// And the request body is an ArticleCreateRequest with the title "How to Train Your Dragon!"
+	// When we execute the request
+	// Then the response should have a status of 201 (created)
+	// And the Article slug should be "how-to-train-your-dragon-2"
+	createArticle.Article.Title = "How to Train Your Dragon!"
+	createArticle.Article.Description = "Ever wonder how?"
+	req = request("POST", "/api/articles", createArticle, contentType, validBearerToken)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusCreated; w.Code != expected {
+		t.Errorf("articles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else {
+		var articleResponse conduit.ArticleResponse
+		if err := fetch(w.Result().Body, &articleResponse); err != nil {
+			t.Errorf("articles: %s %s response did not contain valid ArticleResponse: %+v\n", req.Method, req.URL.Path, err)
+		} else if expected := "how-to-train-your-dragon-2"; articleResponse.Article.Slug != expected {
+			t.Errorf("articles: %s %s slug expected %q: got %q\n", req.Method, req.URL.Path, expected, articleResponse.Article.Slug)
+		}
+	}
+
+	// Given the prior server
+	// And the request is GET /api/articles/how-to-train-your-dragon-2
+	// And the request does not include a bearer token
+	// When we execute the request
+	// Then the response should have a status of 200 (ok)
+	// And contain a valid ArticleResponse with a valid Article
+	// And the Article title should be "How to Train Your Dragon!"
+	req = request("GET", "/api/articles/how-to-train-your-dragon-2", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusOK; w.Code != expected {
+		t.Errorf("articles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else {
+		var articleResponse conduit.ArticleResponse
+		if err := fetch(w.Result().Body, &articleResponse); err != nil {
+			t.Errorf("articles: %s %s response did not contain valid ArticleResponse: %+v\n", req.Method, req.URL.Path, err)
+		} else if expected := "How to Train Your Dragon!"; articleResponse.Article.Title != expected {
+			t.Errorf("articles: %s %s title expected %q: got %q\n", req.Method, req.URL.Path, expected, articleResponse.Article.Title)
+		}
+	}
+
+	// Given the prior server
+	// And the request is GET /api/articles/how-to-tame-your-dragon
+	// When we execute the request
+	// Then the response should have a status of 404 (not found)
+	req = request("GET", "/api/articles/how-to-tame-your-dragon", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusNotFound; w.Code != expected {
+		t.Errorf("articles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+}
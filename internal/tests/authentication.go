@@ -28,7 +28,9 @@ import (
 	"github.com/mdhender/conduit/internal/conduit"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Authentication(newServer TestServer, t *testing.T) {
@@ -77,4 +79,35 @@ func Authentication(newServer TestServer, t *testing.T) {
 	if expected := http.StatusUnauthorized; w.Code != expected {
 		t.Errorf("authentication: %q %q expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
 	}
+
+	// Given the prior server
+	// And the request is GET /api/user
+	// And the request has a bearer token for "jake@jake.jake" that expired a minute ago
+	// When we execute the request
+	// Then the response should have a status of 401 (not authorized)
+	// And the WWW-Authenticate header should say the token is invalid because it expired
+	expiredBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(-time.Minute, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	req = request("GET", "/api/user", nil, expiredBearerToken)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnauthorized; w.Code != expected {
+		t.Errorf("authentication: %q %q expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else if challenge := w.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, `error="invalid_token"`) || !strings.Contains(challenge, "expired") {
+		t.Errorf("authentication: %q %q expected WWW-Authenticate to report an expired token: got %q\n", req.Method, req.URL.Path, challenge)
+	}
+
+	// Given the prior server
+	// And the request is GET /api/user
+	// And the request has no bearer token
+	// When we execute the request
+	// Then the response should have a status of 401 (not authorized)
+	// And the WWW-Authenticate header should be a plain Bearer challenge
+	req = request("GET", "/api/user", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnauthorized; w.Code != expected {
+		t.Errorf("authentication: %q %q expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else if challenge := w.Header().Get("WWW-Authenticate"); challenge != "Bearer" {
+		t.Errorf("authentication: %q %q expected WWW-Authenticate %q: got %q\n", req.Method, req.URL.Path, "Bearer", challenge)
+	}
 }
@@ -0,0 +1,178 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"fmt"
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: Comments API
+func Comments(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 2, "Anne", "anne@anne.anne", "authenticated")}
+
+	// comments fetches the comments on an article.
+	comments := func(target string, keys ...keyValue) ([]conduit.Comment, bool) {
+		req := request("GET", target, nil, keys...)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("comments: %s %s expected %d(%s): got %d(%s)\n", req.Method, target, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+			return nil, false
+		}
+		var commentsResponse conduit.CommentsResponse
+		if err := fetch(w.Result().Body, &commentsResponse); err != nil {
+			t.Errorf("comments: %s %s response did not contain valid CommentsResponse: %+v\n", req.Method, target, err)
+			return nil, false
+		}
+		return commentsResponse.Comments, true
+	}
+
+	// Given a new server
+	// And the user with username "Jacob," e-mail "jake@jake.jake," and password "jakejake" has been added
+	// And the user with username "Anne," e-mail "anne@anne.anne," and password "anneanne" has been added
+	// And "Jacob" has created the article "How to train your dragon"
+	// And "Jacob" follows "Anne"
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"}}, contentType))
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Description = "Ever wonder how?"
+	createArticle.Article.Body = "You have to believe"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, jakeBearerToken))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/profiles/Anne/follow", nil, jakeBearerToken))
+
+	// When "Anne" comments "His name was my name too." on the article
+	// And then "Jacob" comments "Thank you!" on the article
+	// Then the responses should have a status of 200 (ok)
+	// And contain a valid CommentResponse with a valid Comment
+	// And the Comment bodies and authors should match the requests
+	var commentIds []int
+	for _, tc := range []struct {
+		body   string
+		author string
+		token  keyValue
+	}{
+		{"His name was my name too.", "Anne", anneBearerToken},
+		{"Thank you!", "Jacob", jakeBearerToken},
+	} {
+		var addComment conduit.CommentAddRequest
+		addComment.Comment.Body = tc.body
+		req := request("POST", "/api/articles/how-to-train-your-dragon/comments", addComment, contentType, tc.token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("comments: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+			continue
+		}
+		var commentResponse conduit.CommentResponse
+		if err := fetch(w.Result().Body, &commentResponse); err != nil {
+			t.Errorf("comments: %s %s response did not contain valid CommentResponse: %+v\n", req.Method, req.URL.Path, err)
+			continue
+		}
+		if commentResponse.Comment.Body != tc.body {
+			t.Errorf("comments: %s %s body expected %q: got %q\n", req.Method, req.URL.Path, tc.body, commentResponse.Comment.Body)
+		}
+		if commentResponse.Comment.Author.Username != tc.author {
+			t.Errorf("comments: %s %s author expected %q: got %q\n", req.Method, req.URL.Path, tc.author, commentResponse.Comment.Author.Username)
+		}
+		commentIds = append(commentIds, commentResponse.Comment.Id)
+	}
+	if len(commentIds) != 2 {
+		t.Fatalf("comments: expected 2 comments: got %d\n", len(commentIds))
+	}
+
+	// When "Jacob" fetches the comments on the article
+	// Then the comments should be from "Anne" and then "Jacob"
+	// And the author following flag should be true for "Anne"
+	if list, ok := comments("/api/articles/how-to-train-your-dragon/comments", jakeBearerToken); ok {
+		if len(list) != 2 {
+			t.Errorf("comments: expected 2 comments: got %d\n", len(list))
+		} else {
+			if list[0].Author.Username != "Anne" || list[1].Author.Username != "Jacob" {
+				t.Errorf("comments: authors expected [Anne Jacob]: got [%s %s]\n", list[0].Author.Username, list[1].Author.Username)
+			}
+			if expected := true; list[0].Author.Following != expected {
+				t.Errorf("comments: following expected %v: got %v\n", expected, list[0].Author.Following)
+			}
+		}
+	}
+
+	// When an unauthenticated client fetches the comments on the article
+	// Then the author following flags should be false
+	if list, ok := comments("/api/articles/how-to-train-your-dragon/comments"); ok {
+		for _, c := range list {
+			if expected := false; c.Author.Following != expected {
+				t.Errorf("comments: following expected %v: got %v\n", expected, c.Author.Following)
+			}
+		}
+	}
+
+	// When a comment is added or deleted
+	// Then the response should have the expected status
+	var emptyComment, orphanComment conduit.CommentAddRequest
+	orphanComment.Comment.Body = "Anyone there?"
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		body   interface{}
+		keys   []keyValue
+		status int
+	}{
+		{"add empty comment", "POST", "/api/articles/how-to-train-your-dragon/comments", emptyComment, []keyValue{contentType, anneBearerToken}, http.StatusUnprocessableEntity},
+		{"add to unknown article", "POST", "/api/articles/how-to-tame-your-dragon/comments", orphanComment, []keyValue{contentType, anneBearerToken}, http.StatusNotFound},
+		{"add unauthenticated", "POST", "/api/articles/how-to-train-your-dragon/comments", orphanComment, []keyValue{contentType}, http.StatusUnauthorized},
+		{"list unknown article", "GET", "/api/articles/how-to-tame-your-dragon/comments", nil, nil, http.StatusNotFound},
+		{"delete another's comment", "DELETE", fmt.Sprintf("/api/articles/how-to-train-your-dragon/comments/%d", commentIds[0]), nil, []keyValue{jakeBearerToken}, http.StatusForbidden},
+		{"delete unknown comment", "DELETE", "/api/articles/how-to-train-your-dragon/comments/999", nil, []keyValue{jakeBearerToken}, http.StatusNotFound},
+		{"delete malformed id", "DELETE", "/api/articles/how-to-train-your-dragon/comments/first", nil, []keyValue{jakeBearerToken}, http.StatusNotFound},
+		{"delete unauthenticated", "DELETE", fmt.Sprintf("/api/articles/how-to-train-your-dragon/comments/%d", commentIds[0]), nil, nil, http.StatusUnauthorized},
+		{"delete own comment", "DELETE", fmt.Sprintf("/api/articles/how-to-train-your-dragon/comments/%d", commentIds[0]), nil, []keyValue{anneBearerToken}, http.StatusOK},
+		{"delete deleted comment", "DELETE", fmt.Sprintf("/api/articles/how-to-train-your-dragon/comments/%d", commentIds[0]), nil, []keyValue{anneBearerToken}, http.StatusNotFound},
+	} {
+		req := request(tc.method, tc.target, tc.body, tc.keys...)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("comments: %s: %s %s expected %d(%s): got %d(%s)\n", tc.name, req.Method, req.URL.Path, tc.status, http.StatusText(tc.status), w.Code, http.StatusText(w.Code))
+		}
+	}
+
+	// When "Jacob" fetches the comments on the article
+	// Then the only comment should be from "Jacob"
+	if list, ok := comments("/api/articles/how-to-train-your-dragon/comments", jakeBearerToken); ok {
+		if len(list) != 1 || list[0].Id != commentIds[1] {
+			t.Errorf("comments: expected only comment %d: got %v\n", commentIds[1], list)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Specification: Conditional Requests
+//
+// The current user, profiles and articles are returned with an ETag header.
+// A GET with an If-None-Match header that matches returns 304 (not modified),
+// except for the current user, which carries a new token every time.
+// An update or delete with an If-Match header that doesn't match returns
+// 412 (precondition failed) and changes nothing. Requests without those
+// headers are not affected.
+func ConditionalRequests(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 2, "Anne", "anne@anne.anne", "authenticated")}
+
+	// do executes the request, checks the status, and returns the response.
+	do := func(name, method, target string, body interface{}, expected int, keys ...keyValue) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request(method, target, body, keys...))
+		if w.Code != expected {
+			t.Errorf("conditional: %s: %s %s expected %d(%s): got %d(%s)\n", name, method, target, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+		}
+		return w
+	}
+	// etag returns the entity tag from the response, which must be strong.
+	etag := func(name string, w *httptest.ResponseRecorder) string {
+		t.Helper()
+		tag := w.Header().Get("ETag")
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 3 {
+			t.Errorf("conditional: %s: expected strong ETag: got %q\n", name, tag)
+		}
+		return tag
+	}
+	ifMatch := func(tag string) keyValue { return keyValue{"If-Match", tag} }
+	ifNoneMatch := func(tag string) keyValue { return keyValue{"If-None-Match", tag} }
+
+	// Given a new server
+	// And the user with username "Jacob," e-mail "jake@jake.jake," and password "jakejake" has been added
+	// And the user with username "Anne," e-mail "anne@anne.anne," and password "anneanne" has been added
+	// And "Jacob" has created the article "How to train your dragon"
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"}}, contentType))
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Description = "Ever wonder how?"
+	createArticle.Article.Body = "You have to believe"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, jakeBearerToken))
+
+	// When "Jacob" fetches the current user
+	// Then the response should have an ETag
+	// And fetching it again with If-None-Match should still return 200 with a token
+	// And the response should not be stored
+	userTag := etag("get user", do("get user", "GET", "/api/user", nil, http.StatusOK, jakeBearerToken))
+	for _, tag := range []string{userTag, `"x", W/` + userTag} {
+		w := do("get user: if-none-match", "GET", "/api/user", nil, http.StatusOK, jakeBearerToken, ifNoneMatch(tag))
+		var userResponse conduit.UserResponse
+		if err := fetch(w.Result().Body, &userResponse); err != nil || userResponse.User.Token == "" {
+			t.Errorf("conditional: get user: if-none-match %s: expected a token: got %+v %v\n", tag, userResponse.User, err)
+		} else if got := w.Header().Get("ETag"); got != userTag {
+			t.Errorf("conditional: get user: if-none-match %s: expected ETag %q: got %q\n", tag, userTag, got)
+		} else if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("conditional: get user: expected Cache-Control %q: got %q\n", "no-store", got)
+		}
+	}
+
+	// When "Jacob" updates his bio with the tag he fetched
+	// Then the response should have a new ETag
+	bio := func(s string) conduit.UpdateUserRequest {
+		var req conduit.UpdateUserRequest
+		req.User.Bio = &s
+		return req
+	}
+	w := do("update user", "PUT", "/api/user", bio("I work at statefarm"), http.StatusOK, contentType, jakeBearerToken, ifMatch(userTag))
+	newUserTag := etag("update user", w)
+	if newUserTag == userTag {
+		t.Errorf("conditional: update user: expected ETag to change from %q\n", userTag)
+	}
+
+	// When a second client updates the bio with the tag from before the first update
+	// Then the response should be 412 (precondition failed)
+	// And the first update should not be overwritten
+	do("update user: stale", "PUT", "/api/user", bio("I clobber things"), http.StatusPreconditionFailed, contentType, jakeBearerToken, ifMatch(userTag))
+	do("update user: weak", "PUT", "/api/user", bio("I clobber things"), http.StatusPreconditionFailed, contentType, jakeBearerToken, ifMatch("W/"+newUserTag))
+	w = do("get user: modified", "GET", "/api/user", nil, http.StatusOK, jakeBearerToken, ifNoneMatch(userTag))
+	var userResponse conduit.UserResponse
+	if err := fetch(w.Result().Body, &userResponse); err != nil {
+		t.Errorf("conditional: get user: response did not contain valid UserResponse: %+v\n", err)
+	} else if userResponse.User.Bio == nil || *userResponse.User.Bio != "I work at statefarm" {
+		t.Errorf("conditional: get user: expected bio %q: got %v\n", "I work at statefarm", userResponse.User.Bio)
+	}
+
+	// When "Jacob" updates with If-Match "*" or without If-Match
+	// Then the update should be accepted
+	do("update user: any", "PUT", "/api/user", bio("I work at statefarm"), http.StatusOK, contentType, jakeBearerToken, ifMatch("*"))
+	do("update user: unconditional", "PUT", "/api/user", bio("I work at statefarm"), http.StatusOK, contentType, jakeBearerToken)
+
+	// When "Anne" fetches Jacob's profile
+	// Then the response should have an ETag
+	// And the tag should change when "Anne" follows "Jacob"
+	profileTag := etag("get profile", do("get profile", "GET", "/api/profiles/Jacob", nil, http.StatusOK, anneBearerToken))
+	do("get profile: not modified", "GET", "/api/profiles/Jacob", nil, http.StatusNotModified, anneBearerToken, ifNoneMatch(profileTag))
+	do("follow", "POST", "/api/profiles/Jacob/follow", nil, http.StatusOK, anneBearerToken)
+	do("get profile: following", "GET", "/api/profiles/Jacob", nil, http.StatusOK, anneBearerToken, ifNoneMatch(profileTag))
+
+	// Given two editors have fetched the article
+	target := "/api/articles/how-to-train-your-dragon"
+	articleTag := etag("get article", do("get article", "GET", target, nil, http.StatusOK, jakeBearerToken))
+	do("get article: not modified", "GET", target, nil, http.StatusNotModified, jakeBearerToken, ifNoneMatch(articleTag))
+	articleBody := func(s string) conduit.ArticleUpdateRequest {
+		var req conduit.ArticleUpdateRequest
+		req.Article.Body = &s
+		return req
+	}
+
+	// When the first editor saves a change with the tag they fetched
+	// Then the update should be accepted
+	// When the second editor saves a change with the same tag
+	// Then the response should be 412 (precondition failed)
+	// And the first editor's change should be kept
+	w = do("update article", "PUT", target, articleBody("You have to believe, really"), http.StatusOK, contentType, jakeBearerToken, ifMatch(articleTag))
+	newArticleTag := etag("update article", w)
+	if newArticleTag == articleTag {
+		t.Errorf("conditional: update article: expected ETag to change from %q\n", articleTag)
+	}
+	do("update article: stale", "PUT", target, articleBody("I clobber things"), http.StatusPreconditionFailed, contentType, jakeBearerToken, ifMatch(articleTag))
+	w = do("get article: modified", "GET", target, nil, http.StatusOK, jakeBearerToken, ifNoneMatch(articleTag))
+	var articleResponse conduit.ArticleResponse
+	if err := fetch(w.Result().Body, &articleResponse); err != nil {
+		t.Errorf("conditional: get article: response did not contain valid ArticleResponse: %+v\n", err)
+	} else if articleResponse.Article.Body != "You have to believe, really" {
+		t.Errorf("conditional: get article: expected body %q: got %q\n", "You have to believe, really", articleResponse.Article.Body)
+	}
+
+	// When "Anne" favorites the article
+	// Then the tag should change since the favorites count changed
+	do("get article: unchanged", "GET", target, nil, http.StatusNotModified, jakeBearerToken, ifNoneMatch(newArticleTag))
+	do("favorite", "POST", target+"/favorite", nil, http.StatusOK, anneBearerToken)
+	do("get article: favorited", "GET", target, nil, http.StatusOK, jakeBearerToken, ifNoneMatch(newArticleTag))
+
+	// When "Jacob" deletes the article with a stale tag
+	// Then the response should be 412 (precondition failed)
+	// And the article should not be deleted
+	do("delete article: stale", "DELETE", target, nil, http.StatusPreconditionFailed, jakeBearerToken, ifMatch(newArticleTag))
+	articleTag = etag("get article", do("get article", "GET", target, nil, http.StatusOK, jakeBearerToken))
+
+	// When "Jacob" deletes the article with the current tag
+	// Then the article should be deleted
+	// And updating it with any tag should fail
+	do("delete article", "DELETE", target, nil, http.StatusOK, jakeBearerToken, ifMatch(articleTag))
+	do("get article: deleted", "GET", target, nil, http.StatusNotFound)
+	do("update article: deleted", "PUT", target, articleBody("Too late"), http.StatusNotFound, contentType, jakeBearerToken, ifMatch("*"))
+}
@@ -0,0 +1,225 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: Update and Delete Article API
+//
+// Clients must be authenticated (401) to update or delete an article.
+// If the article doesn't exist, the response is 404 even when the client
+// wouldn't be allowed to change it. If the article exists but the client
+// isn't its author, the response is 403.
+func EditArticles(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 2, "Anne", "anne@anne.anne", "authenticated")}
+
+	// article executes the request and returns the article from the response.
+	article := func(method, target string, body interface{}, keys ...keyValue) (conduit.Article, bool) {
+		req := request(method, target, body, keys...)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("editArticles: %s %s expected %d(%s): got %d(%s)\n", req.Method, target, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+			return conduit.Article{}, false
+		}
+		var articleResponse conduit.ArticleResponse
+		if err := fetch(w.Result().Body, &articleResponse); err != nil {
+			t.Errorf("editArticles: %s %s response did not contain valid ArticleResponse: %+v\n", req.Method, target, err)
+			return conduit.Article{}, false
+		}
+		return articleResponse.Article, true
+	}
+	// status executes the request and returns the status code.
+	status := func(method, target string, body interface{}, keys ...keyValue) int {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request(method, target, body, keys...))
+		return w.Code
+	}
+
+	// Given a new server
+	// And the user with username "Jacob," e-mail "jake@jake.jake," and password "jakejake" has been added
+	// And the user with username "Anne," e-mail "anne@anne.anne," and password "anneanne" has been added
+	// And "Jacob" has created the article "How to train your dragon" with the tags "dragons" and "training"
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"}}, contentType))
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Description = "Ever wonder how?"
+	createArticle.Article.Body = "You have to believe"
+	createArticle.Article.TagList = []string{"dragons", "training"}
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, jakeBearerToken))
+	created, ok := article("GET", "/api/articles/how-to-train-your-dragon", nil)
+	if !ok {
+		t.Fatalf("editArticles: unable to create article\n")
+	}
+
+	// When an article is updated or deleted by someone other than its author
+	// Then the response should have the expected status
+	body := "I changed my mind"
+	var updateBody conduit.ArticleUpdateRequest
+	updateBody.Article.Body = &body
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		body   interface{}
+		keys   []keyValue
+		status int
+	}{
+		{"update unauthenticated", "PUT", "/api/articles/how-to-train-your-dragon", updateBody, []keyValue{contentType}, http.StatusUnauthorized},
+		{"update unknown article", "PUT", "/api/articles/how-to-tame-your-dragon", updateBody, []keyValue{contentType, anneBearerToken}, http.StatusNotFound},
+		{"update another's article", "PUT", "/api/articles/how-to-train-your-dragon", updateBody, []keyValue{contentType, anneBearerToken}, http.StatusForbidden},
+		{"delete unauthenticated", "DELETE", "/api/articles/how-to-train-your-dragon", nil, nil, http.StatusUnauthorized},
+		{"delete unknown article", "DELETE", "/api/articles/how-to-tame-your-dragon", nil, []keyValue{anneBearerToken}, http.StatusNotFound},
+		{"delete another's article", "DELETE", "/api/articles/how-to-train-your-dragon", nil, []keyValue{anneBearerToken}, http.StatusForbidden},
+	} {
+		if got := status(tc.method, tc.target, tc.body, tc.keys...); got != tc.status {
+			t.Errorf("editArticles: %s: %s %s expected %d(%s): got %d(%s)\n", tc.name, tc.method, tc.target, tc.status, http.StatusText(tc.status), got, http.StatusText(got))
+		}
+	}
+
+	// When "Jacob" updates only the body of the article
+	// Then the response should have a status of 200 (ok)
+	// And the body should be changed
+	// And the title, description, and tags should not be changed
+	// And the updatedAt timestamp should be later than before
+	time.Sleep(2 * time.Millisecond)
+	if a, ok := article("PUT", "/api/articles/how-to-train-your-dragon", updateBody, contentType, jakeBearerToken); ok {
+		if a.Body != body {
+			t.Errorf("editArticles: body expected %q: got %q\n", body, a.Body)
+		}
+		if a.Title != created.Title || a.Description != created.Description || !equalStrings(a.TagList, created.TagList) {
+			t.Errorf("editArticles: expected title, description, and tags to be unchanged: got %q %q %v\n", a.Title, a.Description, a.TagList)
+		}
+		before, err1 := time.Parse(time.RFC3339Nano, created.UpdatedAt)
+		after, err2 := time.Parse(time.RFC3339Nano, a.UpdatedAt)
+		if err1 != nil || err2 != nil || !after.After(before) {
+			t.Errorf("editArticles: updatedAt expected after %q: got %q\n", created.UpdatedAt, a.UpdatedAt)
+		}
+	}
+
+	// When "Jacob" updates the article with an empty title and description
+	// Then the response should have a status of 422 (unprocessable entity)
+	// And the errors should include "title" and "description"
+	empty := ""
+	var invalidUpdate conduit.ArticleUpdateRequest
+	invalidUpdate.Article.Title, invalidUpdate.Article.Description = &empty, &empty
+	req := request("PUT", "/api/articles/how-to-train-your-dragon", invalidUpdate, contentType, jakeBearerToken)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnprocessableEntity; w.Code != expected {
+		t.Errorf("editArticles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else {
+		var errorsResponse struct {
+			Errors map[string][]string `json:"errors"`
+		}
+		if err := fetch(w.Result().Body, &errorsResponse); err != nil {
+			t.Errorf("editArticles: %s %s response did not contain valid errors: %+v\n", req.Method, req.URL.Path, err)
+		} else if len(errorsResponse.Errors["title"]) == 0 || len(errorsResponse.Errors["description"]) == 0 {
+			t.Errorf("editArticles: %s %s expected errors for title and description: got %v\n", req.Method, req.URL.Path, errorsResponse.Errors)
+		}
+	}
+
+	// When "Jacob" changes the title to "How to tame your dragon" and the tags to "Tamed"
+	// Then the slug should be "how-to-tame-your-dragon"
+	// And the tags should be "tamed"
+	// And fetching the old slug should return the renamed article
+	// And the tags endpoint should only list "tamed"
+	title, tagList := "How to tame your dragon", []string{"Tamed"}
+	var renameUpdate conduit.ArticleUpdateRequest
+	renameUpdate.Article.Title, renameUpdate.Article.TagList = &title, &tagList
+	if a, ok := article("PUT", "/api/articles/how-to-train-your-dragon", renameUpdate, contentType, jakeBearerToken); ok {
+		if expected := "how-to-tame-your-dragon"; a.Slug != expected {
+			t.Errorf("editArticles: slug expected %q: got %q\n", expected, a.Slug)
+		}
+		if expected := []string{"tamed"}; !equalStrings(a.TagList, expected) {
+			t.Errorf("editArticles: tagList expected %v: got %v\n", expected, a.TagList)
+		}
+	}
+	if a, ok := article("GET", "/api/articles/how-to-train-your-dragon", nil); ok {
+		if expected := "how-to-tame-your-dragon"; a.Slug != expected {
+			t.Errorf("editArticles: alias slug expected %q: got %q\n", expected, a.Slug)
+		}
+	}
+	req = request("GET", "/api/tags", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var tagsResponse conduit.TagsResponse
+	if err := fetch(w.Result().Body, &tagsResponse); err != nil {
+		t.Errorf("editArticles: %s %s response did not contain valid TagsResponse: %+v\n", req.Method, req.URL.Path, err)
+	} else if expected := []string{"tamed"}; !equalStrings(tagsResponse.Tags, expected) {
+		t.Errorf("editArticles: tags expected %v: got %v\n", expected, tagsResponse.Tags)
+	}
+
+	// Given "Anne" favorites and comments on the article
+	// When "Jacob" deletes the article using its old slug
+	// Then the response should have a status of 200 (ok)
+	// And the article, its comments, its old and new slugs, its favorites, and its tags should be gone
+	var addComment conduit.CommentAddRequest
+	addComment.Comment.Body = "Nice dragon"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles/how-to-tame-your-dragon/favorite", nil, anneBearerToken))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles/how-to-tame-your-dragon/comments", addComment, contentType, anneBearerToken))
+	if got, expected := status("DELETE", "/api/articles/how-to-train-your-dragon", nil, jakeBearerToken), http.StatusOK; got != expected {
+		t.Errorf("editArticles: DELETE expected %d(%s): got %d(%s)\n", expected, http.StatusText(expected), got, http.StatusText(got))
+	}
+	for _, target := range []string{
+		"/api/articles/how-to-train-your-dragon",
+		"/api/articles/how-to-tame-your-dragon",
+		"/api/articles/how-to-tame-your-dragon/comments",
+	} {
+		if got, expected := status("GET", target, nil), http.StatusNotFound; got != expected {
+			t.Errorf("editArticles: GET %s expected %d(%s): got %d(%s)\n", target, expected, http.StatusText(expected), got, http.StatusText(got))
+		}
+	}
+	for _, target := range []string{"/api/articles", "/api/articles?favorited=Anne", "/api/articles?tag=tamed", "/api/articles?author=Jacob"} {
+		req = request("GET", target, nil)
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var articlesResponse conduit.MultipleArticlesResponse
+		if err := fetch(w.Result().Body, &articlesResponse); err != nil {
+			t.Errorf("editArticles: GET %s response did not contain valid MultipleArticlesResponse: %+v\n", target, err)
+		} else if articlesResponse.ArticlesCount != 0 {
+			t.Errorf("editArticles: GET %s articlesCount expected 0: got %d\n", target, articlesResponse.ArticlesCount)
+		}
+	}
+	req = request("GET", "/api/tags", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	tagsResponse = conduit.TagsResponse{}
+	if err := fetch(w.Result().Body, &tagsResponse); err != nil {
+		t.Errorf("editArticles: %s %s response did not contain valid TagsResponse: %+v\n", req.Method, req.URL.Path, err)
+	} else if len(tagsResponse.Tags) != 0 {
+		t.Errorf("editArticles: tags expected none: got %v\n", tagsResponse.Tags)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"fmt"
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Specification: Favorites API
+func Favorites(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 2, "Anne", "anne@anne.anne", "authenticated")}
+
+	// article executes the request and returns the article from the response.
+	article := func(method, target string, keys ...keyValue) (conduit.Article, bool) {
+		req := request(method, target, nil, keys...)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("favorites: %s %s expected %d(%s): got %d(%s)\n", req.Method, target, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+			return conduit.Article{}, false
+		}
+		var articleResponse conduit.ArticleResponse
+		if err := fetch(w.Result().Body, &articleResponse); err != nil {
+			t.Errorf("favorites: %s %s response did not contain valid ArticleResponse: %+v\n", req.Method, target, err)
+			return conduit.Article{}, false
+		}
+		return articleResponse.Article, true
+	}
+
+	// Given a new server
+	// And the user with username "Jacob," e-mail "jake@jake.jake," and password "jakejake" has been added
+	// And the user with username "Anne," e-mail "anne@anne.anne," and password "anneanne" has been added
+	// And "Jacob" has created the article "How to train your dragon"
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"}}, contentType))
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Description = "Ever wonder how?"
+	createArticle.Article.Body = "You have to believe"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, jakeBearerToken))
+
+	// When "Anne" favorites the article, twice
+	// Then the responses should have a status of 200 (ok)
+	// And the favorited flag should be true
+	// And the favorites count should be 1
+	for i := 0; i < 2; i++ {
+		if a, ok := article("POST", "/api/articles/how-to-train-your-dragon/favorite", anneBearerToken); ok {
+			if expected := true; a.Favorited != expected {
+				t.Errorf("favorites: favorited expected %v: got %v\n", expected, a.Favorited)
+			}
+			if expected := 1; a.FavoritesCount != expected {
+				t.Errorf("favorites: favoritesCount expected %d: got %d\n", expected, a.FavoritesCount)
+			}
+		}
+	}
+
+	// When "Jacob" fetches the article
+	// Then the favorited flag should be false
+	// And the favorites count should be 1
+	if a, ok := article("GET", "/api/articles/how-to-train-your-dragon", jakeBearerToken); ok {
+		if expected := false; a.Favorited != expected {
+			t.Errorf("favorites: favorited expected %v: got %v\n", expected, a.Favorited)
+		}
+		if expected := 1; a.FavoritesCount != expected {
+			t.Errorf("favorites: favoritesCount expected %d: got %d\n", expected, a.FavoritesCount)
+		}
+	}
+
+	// When the request is GET /api/articles?favorited=Anne
+	// Then the articles count should be 1
+	// When the request is GET /api/articles?favorited=Jacob
+	// Then the articles count should be 0
+	for _, tc := range []struct {
+		target string
+		count  int
+	}{
+		{"/api/articles?favorited=Anne", 1},
+		{"/api/articles?favorited=Jacob", 0},
+	} {
+		req := request("GET", tc.target, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var articlesResponse conduit.MultipleArticlesResponse
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("favorites: %s %s expected %d(%s): got %d(%s)\n", req.Method, tc.target, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+		} else if err := fetch(w.Result().Body, &articlesResponse); err != nil {
+			t.Errorf("favorites: %s %s response did not contain valid MultipleArticlesResponse: %+v\n", req.Method, tc.target, err)
+		} else if articlesResponse.ArticlesCount != tc.count || len(articlesResponse.Articles) != tc.count {
+			t.Errorf("favorites: %s %s articlesCount expected %d: got %d\n", req.Method, tc.target, tc.count, articlesResponse.ArticlesCount)
+		}
+	}
+
+	// When "Anne" unfavorites the article, twice
+	// Then the responses should have a status of 200 (ok)
+	// And the favorited flag should be false
+	// And the favorites count should be 0
+	for i := 0; i < 2; i++ {
+		if a, ok := article("DELETE", "/api/articles/how-to-train-your-dragon/favorite", anneBearerToken); ok {
+			if expected := false; a.Favorited != expected {
+				t.Errorf("favorites: favorited expected %v: got %v\n", expected, a.Favorited)
+			}
+			if expected := 0; a.FavoritesCount != expected {
+				t.Errorf("favorites: favoritesCount expected %d: got %d\n", expected, a.FavoritesCount)
+			}
+		}
+	}
+
+	// When "Anne" favorites an article that doesn't exist
+	// Then the response should have a status of 404 (not found)
+	// When the request to favorite the article does not include a bearer token
+	// Then the response should have a status of 401 (not authorized)
+	for _, tc := range []struct {
+		target string
+		keys   []keyValue
+		status int
+	}{
+		{"/api/articles/how-to-tame-your-dragon/favorite", []keyValue{anneBearerToken}, http.StatusNotFound},
+		{"/api/articles/how-to-train-your-dragon/favorite", nil, http.StatusUnauthorized},
+	} {
+		req := request("POST", tc.target, nil, tc.keys...)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("favorites: %s %s expected %d(%s): got %d(%s)\n", req.Method, tc.target, tc.status, http.StatusText(tc.status), w.Code, http.StatusText(w.Code))
+		}
+	}
+
+	// Given 25 more users have been added
+	// When they all favorite the article at the same time
+	// Then the favorites count should be 25
+	// When they all toggle the favorite on and then off at the same time
+	// Then the favorites count should be 0
+	var fans []keyValue
+	for i := 1; i <= 25; i++ {
+		username, email := fmt.Sprintf("fan%d", i), fmt.Sprintf("fan%d@fan.fan", i)
+		srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: username, Email: email, Password: "fanfan"}}, contentType))
+		fans = append(fans, keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 2+i, username, email, "authenticated")})
+	}
+	var wg sync.WaitGroup
+	for _, fan := range fans {
+		wg.Add(1)
+		go func(fan keyValue) {
+			defer wg.Done()
+			srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles/how-to-train-your-dragon/favorite", nil, fan))
+		}(fan)
+	}
+	wg.Wait()
+	if a, ok := article("GET", "/api/articles/how-to-train-your-dragon"); ok {
+		if expected := len(fans); a.FavoritesCount != expected {
+			t.Errorf("favorites: favoritesCount expected %d: got %d\n", expected, a.FavoritesCount)
+		}
+	}
+	for _, fan := range fans {
+		wg.Add(1)
+		go func(fan keyValue) {
+			defer wg.Done()
+			srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles/how-to-train-your-dragon/favorite", nil, fan))
+			srv.ServeHTTP(httptest.NewRecorder(), request("DELETE", "/api/articles/how-to-train-your-dragon/favorite", nil, fan))
+		}(fan)
+	}
+	wg.Wait()
+	if a, ok := article("GET", "/api/articles/how-to-train-your-dragon"); ok {
+		if expected := 0; a.FavoritesCount != expected {
+			t.Errorf("favorites: favoritesCount expected %d: got %d\n", expected, a.FavoritesCount)
+		}
+	}
+}
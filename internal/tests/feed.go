@@ -0,0 +1,171 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: Feed API
+func Feed(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 2, "Anne", "anne@anne.anne", "authenticated")}
+	bobBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 3, "Bob", "bob@bob.bob", "authenticated")}
+
+	// feed fetches a page of the feed and returns the titles, author following flags, and articles count.
+	feed := func(target string, token keyValue) (titles []string, following []bool, count int, ok bool) {
+		req := request("GET", target, nil, token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("feed: %s %s expected %d(%s): got %d(%s)\n", req.Method, target, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+			return nil, nil, 0, false
+		}
+		var articlesResponse conduit.MultipleArticlesResponse
+		if err := fetch(w.Result().Body, &articlesResponse); err != nil {
+			t.Errorf("feed: %s %s response did not contain valid MultipleArticlesResponse: %+v\n", req.Method, target, err)
+			return nil, nil, 0, false
+		}
+		for _, article := range articlesResponse.Articles {
+			titles = append(titles, article.Title)
+			following = append(following, article.Author.Following)
+		}
+		return titles, following, articlesResponse.ArticlesCount, true
+	}
+
+	// Given a new server
+	// And the users "Jacob," "Anne," and "Bob" have been added
+	// And "Anne" has created the article "Anne 1"
+	// And "Bob" has created the article "Bob 1"
+	// And "Anne" has created the article "Anne 2"
+	// And "Jacob" has created the article "Jacob 1"
+	// And "Jacob" follows "Anne"
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Bob", Email: "bob@bob.bob", Password: "bobbob"}}, contentType))
+	for _, article := range []struct {
+		title string
+		token keyValue
+	}{
+		{"Anne 1", anneBearerToken},
+		{"Bob 1", bobBearerToken},
+		{"Anne 2", anneBearerToken},
+		{"Jacob 1", jakeBearerToken},
+	} {
+		var createArticle conduit.ArticleCreateRequest
+		createArticle.Article.Title = article.title
+		createArticle.Article.Description = "About " + article.title
+		createArticle.Article.Body = "All about " + article.title
+		srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, article.token))
+	}
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/profiles/Anne/follow", nil, jakeBearerToken))
+
+	// When the request is GET /api/articles/feed
+	// And the request includes a valid bearer token for the user "jake@jake.jake"
+	// Then the response should have a status of 200 (ok)
+	// And the articles count should be 2
+	// And the articles should be "Anne 2" and "Anne 1"
+	// And the author following flags should be true
+	if titles, following, count, ok := feed("/api/articles/feed", jakeBearerToken); ok {
+		if expected := 2; count != expected {
+			t.Errorf("feed: articlesCount expected %d: got %d\n", expected, count)
+		}
+		if expected := []string{"Anne 2", "Anne 1"}; !equalStrings(titles, expected) {
+			t.Errorf("feed: articles expected %v: got %v\n", expected, titles)
+		}
+		for i := range following {
+			if !following[i] {
+				t.Errorf("feed: article %q following expected true: got false\n", titles[i])
+			}
+		}
+	}
+
+	// When the request is GET /api/articles/feed?limit=1&offset=1
+	// And the request includes a valid bearer token for the user "jake@jake.jake"
+	// Then the response should have a status of 200 (ok)
+	// And the articles count should be 2
+	// And the articles should be "Anne 1"
+	if titles, _, count, ok := feed("/api/articles/feed?limit=1&offset=1", jakeBearerToken); ok {
+		if expected := 2; count != expected {
+			t.Errorf("feed: articlesCount expected %d: got %d\n", expected, count)
+		}
+		if expected := []string{"Anne 1"}; !equalStrings(titles, expected) {
+			t.Errorf("feed: articles expected %v: got %v\n", expected, titles)
+		}
+	}
+
+	// Given "Jacob" follows "Bob"
+	// When the request is GET /api/articles/feed
+	// And the request includes a valid bearer token for the user "jake@jake.jake"
+	// Then the response should have a status of 200 (ok)
+	// And the articles should be "Anne 2," "Bob 1," and "Anne 1"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/profiles/Bob/follow", nil, jakeBearerToken))
+	if titles, _, count, ok := feed("/api/articles/feed", jakeBearerToken); ok {
+		if expected := 3; count != expected {
+			t.Errorf("feed: articlesCount expected %d: got %d\n", expected, count)
+		}
+		if expected := []string{"Anne 2", "Bob 1", "Anne 1"}; !equalStrings(titles, expected) {
+			t.Errorf("feed: articles expected %v: got %v\n", expected, titles)
+		}
+	}
+
+	// Given "Jacob" unfollows "Anne"
+	// When the request is GET /api/articles/feed
+	// And the request includes a valid bearer token for the user "jake@jake.jake"
+	// Then the response should have a status of 200 (ok)
+	// And the articles should be "Bob 1"
+	srv.ServeHTTP(httptest.NewRecorder(), request("DELETE", "/api/profiles/Anne/follow", nil, jakeBearerToken))
+	if titles, _, _, ok := feed("/api/articles/feed", jakeBearerToken); ok {
+		if expected := []string{"Bob 1"}; !equalStrings(titles, expected) {
+			t.Errorf("feed: articles expected %v: got %v\n", expected, titles)
+		}
+	}
+
+	// When the request is GET /api/articles/feed
+	// And the request includes a valid bearer token for the user "anne@anne.anne," who follows no one
+	// Then the response should have a status of 200 (ok)
+	// And the articles count should be 0
+	if titles, _, count, ok := feed("/api/articles/feed", anneBearerToken); ok {
+		if expected := 0; count != expected || len(titles) != expected {
+			t.Errorf("feed: articlesCount expected %d: got %d (%v)\n", expected, count, titles)
+		}
+	}
+
+	// When the request is GET /api/articles/feed
+	// And the request does not include a bearer token
+	// Then the response should have a status of 401 (not authorized)
+	req := request("GET", "/api/articles/feed", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnauthorized; w.Code != expected {
+		t.Errorf("feed: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+}
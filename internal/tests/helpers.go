@@ -45,6 +45,19 @@ func fetch(body io.Reader, data interface{}) error {
 	return nil
 }
 
+// equalStrings returns true if both slices contain the same strings in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func jsonReader(v interface{}) io.Reader {
 	if v == nil {
 		return nil
@@ -0,0 +1,74 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Specification: JWKS API
+func JWKS(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+
+	// Given a new server
+	// When the request is GET /.well-known/jwks.json
+	// Then the response should have a status of 200 (ok)
+	// And it should be cacheable
+	// And contain a key set with a list of keys
+	// And no key should expose private or symmetric key material
+	req := request("GET", "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusOK; w.Code != expected {
+		t.Errorf("jwks: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+		return
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age=") {
+		t.Errorf("jwks: %s %s expected Cache-Control with max-age: got %q\n", req.Method, req.URL.Path, cc)
+	}
+	var set struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.NewDecoder(w.Result().Body).Decode(&set); err != nil {
+		t.Errorf("jwks: %s %s response did not contain a valid key set: %+v\n", req.Method, req.URL.Path, err)
+		return
+	} else if set.Keys == nil {
+		t.Errorf("jwks: %s %s keys expected list: got null\n", req.Method, req.URL.Path)
+	}
+	for _, key := range set.Keys {
+		for _, member := range []string{"d", "p", "q", "dp", "dq", "qi", "k"} {
+			if _, ok := key[member]; ok {
+				t.Errorf("jwks: %s %s key %v must not contain %q\n", req.Method, req.URL.Path, key["kid"], member)
+			}
+		}
+		if kty := key["kty"]; kty != "RSA" && kty != "EC" && kty != "OKP" {
+			t.Errorf("jwks: %s %s key %v unexpected kty %v\n", req.Method, req.URL.Path, key["kid"], kty)
+		}
+	}
+}
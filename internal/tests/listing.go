@@ -0,0 +1,130 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: List Articles API
+func ListArticles(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 2, "Anne", "anne@anne.anne", "authenticated")}
+
+	// Given a new server
+	// And the user with username "Jacob," e-mail "jake@jake.jake," and password "jakejake" has been added
+	// And the user with username "Anne," e-mail "anne@anne.anne," and password "anneanne" has been added
+	// And "Jacob" has created the article "Alpha" with the tags "dragons"
+	// And "Jacob" has created the article "Bravo" with the tags "training"
+	// And "Jacob" has created the article "Charlie" with the tags "dragons" and "training"
+	// And "Anne" has created the article "Delta" with the tags "dragons"
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"}}, contentType))
+	for _, article := range []struct {
+		title   string
+		tagList []string
+		token   keyValue
+	}{
+		{"Alpha", []string{"dragons"}, jakeBearerToken},
+		{"Bravo", []string{"training"}, jakeBearerToken},
+		{"Charlie", []string{"dragons", "training"}, jakeBearerToken},
+		{"Delta", []string{"dragons"}, anneBearerToken},
+	} {
+		var createArticle conduit.ArticleCreateRequest
+		createArticle.Article.Title = article.title
+		createArticle.Article.Description = "About " + article.title
+		createArticle.Article.Body = "All about " + article.title
+		createArticle.Article.TagList = article.tagList
+		req := request("POST", "/api/articles", createArticle, contentType, article.token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusCreated; w.Code != expected {
+			t.Fatalf("listArticles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+		}
+	}
+
+	// When we execute the request GET /api/articles with the query parameters
+	// Then the response should have a status of 200 (ok)
+	// And contain a valid MultipleArticlesResponse
+	// And the articles count should be the number of articles matching the filters
+	// And the articles should be the requested page of matching articles, newest first
+	for _, tc := range []struct {
+		target string
+		count  int
+		titles []string
+	}{
+		{"/api/articles", 4, []string{"Delta", "Charlie", "Bravo", "Alpha"}},
+		{"/api/articles?tag=dragons", 3, []string{"Delta", "Charlie", "Alpha"}},
+		{"/api/articles?author=Jacob", 3, []string{"Charlie", "Bravo", "Alpha"}},
+		{"/api/articles?author=Jacob&tag=training", 2, []string{"Charlie", "Bravo"}},
+		{"/api/articles?author=Anne&tag=training", 0, []string{}},
+		{"/api/articles?author=Nobody", 0, []string{}},
+		{"/api/articles?tag=nothing", 0, []string{}},
+		{"/api/articles?limit=2", 4, []string{"Delta", "Charlie"}},
+		{"/api/articles?limit=1&offset=1", 4, []string{"Charlie"}},
+		{"/api/articles?tag=dragons&offset=2", 3, []string{"Alpha"}},
+		{"/api/articles?offset=10", 4, []string{}},
+	} {
+		req := request("GET", tc.target, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("listArticles: %s %s expected %d(%s): got %d(%s)\n", req.Method, tc.target, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+			continue
+		}
+		var articlesResponse conduit.MultipleArticlesResponse
+		if err := fetch(w.Result().Body, &articlesResponse); err != nil {
+			t.Errorf("listArticles: %s %s response did not contain valid MultipleArticlesResponse: %+v\n", req.Method, tc.target, err)
+			continue
+		}
+		if articlesResponse.ArticlesCount != tc.count {
+			t.Errorf("listArticles: %s %s articlesCount expected %d: got %d\n", req.Method, tc.target, tc.count, articlesResponse.ArticlesCount)
+		}
+		var titles []string
+		for _, article := range articlesResponse.Articles {
+			titles = append(titles, article.Title)
+		}
+		if !equalStrings(titles, tc.titles) {
+			t.Errorf("listArticles: %s %s articles expected %v: got %v\n", req.Method, tc.target, tc.titles, titles)
+		}
+	}
+
+	// Given the prior server
+	// And the request is GET /api/articles?limit=-1
+	// When we execute the request
+	// Then the response should have a status of 422 (unprocessable entity)
+	req := request("GET", "/api/articles?limit=-1", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnprocessableEntity; w.Code != expected {
+		t.Errorf("listArticles: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+}
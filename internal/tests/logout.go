@@ -0,0 +1,146 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Specification: Logout API
+func Logout(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+
+	// login starts a session for "Jacob" and returns the token and refresh token.
+	login := func() (string, string) {
+		req := request("POST", "/api/users/login", conduit.LoginUserRequest{User: conduit.LoginUser{Email: "jake@jake.jake", Password: "jakejake"}}, contentType)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var userResponse conduit.UserResponse
+		if err := fetch(w.Result().Body, &userResponse); err != nil {
+			t.Fatalf("logout: %s %s response did not contain valid UserResponse: %+v\n", req.Method, req.URL.Path, err)
+		}
+		return userResponse.User.Token, userResponse.User.RefreshToken
+	}
+	// currentUser returns the response code and WWW-Authenticate header for GET /api/user.
+	currentUser := func(token string) (int, string) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request("GET", "/api/user", nil, keyValue{key: "Authorization", value: "Bearer " + token}))
+		return w.Code, w.Header().Get("WWW-Authenticate")
+	}
+	// refresh returns the response code for POST /api/users/refresh.
+	refresh := func(refreshToken string) int {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request("POST", "/api/users/refresh", conduit.RefreshUserRequest{User: conduit.RefreshUser{RefreshToken: refreshToken}}, contentType))
+		return w.Code
+	}
+
+	// Given a new server
+	// And the user "Jacob" has been added
+	// And the admin "Root" has been added
+	// And "Jacob" has logged in twice
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Root", Email: "root@conduit", Password: "rootroot"}}, contentType))
+	token1, refresh1 := login()
+	token2, refresh2 := login()
+
+	// When the request is POST /api/users/logout with the first token and refresh token
+	// Then the response should have a status of 204 (no content)
+	// And the first token should be rejected as revoked
+	// And the first refresh token should be rejected
+	// And the second session should still work
+	req := request("POST", "/api/users/logout", conduit.RefreshUserRequest{User: conduit.RefreshUser{RefreshToken: refresh1}}, contentType, keyValue{key: "Authorization", value: "Bearer " + token1})
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusNoContent; w.Code != expected {
+		t.Errorf("logout: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+	if code, challenge := currentUser(token1); code != http.StatusUnauthorized || !strings.Contains(challenge, "revoked") {
+		t.Errorf("logout: logged out token expected %d and revoked: got %d %q\n", http.StatusUnauthorized, code, challenge)
+	}
+	if code := refresh(refresh1); code != http.StatusUnauthorized {
+		t.Errorf("logout: logged out refresh token expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+	if code, _ := currentUser(token2); code != http.StatusOK {
+		t.Errorf("logout: other session expected %d: got %d\n", http.StatusOK, code)
+	}
+
+	// When the request is POST /api/users/logout with no token
+	// Then the response should have a status of 401 (not authorized)
+	req = request("POST", "/api/users/logout", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusUnauthorized; w.Code != expected {
+		t.Errorf("logout: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+
+	// When a user who is not an admin revokes "Jacob's" sessions
+	// Then the response should have a status of 404 (not found)
+	// And the second session should still work
+	req = request("DELETE", "/api/admin/users/Jacob/sessions", nil, keyValue{key: "Authorization", value: "Bearer " + token2})
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusNotFound; w.Code != expected {
+		t.Errorf("logout: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+	if code, _ := currentUser(token2); code != http.StatusOK {
+		t.Errorf("logout: other session expected %d: got %d\n", http.StatusOK, code)
+	}
+
+	// When an admin revokes "Jacob's" sessions
+	// Then the response should have a status of 204 (no content)
+	// And the second token and refresh token should be rejected
+	// And "Jacob" should be able to log in again
+	adminBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(time.Minute, 2, "Root", "root@conduit", "admin")}
+	req = request("DELETE", "/api/admin/users/Jacob/sessions", nil, adminBearerToken)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusNoContent; w.Code != expected {
+		t.Errorf("logout: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+	if code, _ := currentUser(token2); code != http.StatusUnauthorized {
+		t.Errorf("logout: revoked sessions token expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+	if code := refresh(refresh2); code != http.StatusUnauthorized {
+		t.Errorf("logout: revoked sessions refresh token expected %d: got %d\n", http.StatusUnauthorized, code)
+	}
+	token3, _ := login()
+	if code, _ := currentUser(token3); code != http.StatusOK {
+		t.Errorf("logout: new session expected %d: got %d\n", http.StatusOK, code)
+	}
+
+	// When an admin revokes the sessions of an unknown user
+	// Then the response should have a status of 404 (not found)
+	req = request("DELETE", "/api/admin/users/Bob/sessions", nil, adminBearerToken)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusNotFound; w.Code != expected {
+		t.Errorf("logout: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Specification: Refresh Token API
+func Refresh(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+
+	// refresh trades a refresh token for a new session and returns the response code and user.
+	refresh := func(refreshToken string) (int, conduit.User) {
+		req := request("POST", "/api/users/refresh", conduit.RefreshUserRequest{User: conduit.RefreshUser{RefreshToken: refreshToken}}, contentType)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		var userResponse conduit.UserResponse
+		if w.Code == http.StatusOK {
+			if err := fetch(w.Result().Body, &userResponse); err != nil {
+				t.Errorf("refresh: %s %s response did not contain valid UserResponse: %+v\n", req.Method, req.URL.Path, err)
+			}
+		}
+		return w.Code, userResponse.User
+	}
+
+	// Given a new server
+	// And the user "Jacob" registers
+	// Then the response should contain a token and a refresh token
+	req := request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var userResponse conduit.UserResponse
+	if err := fetch(w.Result().Body, &userResponse); err != nil {
+		t.Fatalf("refresh: %s %s response did not contain valid UserResponse: %+v\n", req.Method, req.URL.Path, err)
+	} else if userResponse.User.Token == "" || userResponse.User.RefreshToken == "" {
+		t.Errorf("refresh: %s %s expected token and refresh token: got %+v\n", req.Method, req.URL.Path, userResponse.User)
+	}
+
+	// Given "Jacob" logs in
+	// Then the response should contain a refresh token
+	req = request("POST", "/api/users/login", conduit.LoginUserRequest{User: conduit.LoginUser{Email: "jake@jake.jake", Password: "jakejake"}}, contentType)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if err := fetch(w.Result().Body, &userResponse); err != nil {
+		t.Fatalf("refresh: %s %s response did not contain valid UserResponse: %+v\n", req.Method, req.URL.Path, err)
+	}
+	first := userResponse.User.RefreshToken
+	if first == "" {
+		t.Fatalf("refresh: %s %s expected refresh token: got none\n", req.Method, req.URL.Path)
+	}
+
+	// When the request is POST /api/users/refresh with that refresh token
+	// Then the response should have a status of 200 (ok)
+	// And contain a new token and a new refresh token for "Jacob"
+	// And the new token should be accepted by GET /api/user
+	code, user := refresh(first)
+	if expected := http.StatusOK; code != expected {
+		t.Fatalf("refresh: expected %d(%s): got %d(%s)\n", expected, http.StatusText(expected), code, http.StatusText(code))
+	} else if user.Username != "Jacob" || user.Token == "" || user.RefreshToken == "" || user.RefreshToken == first {
+		t.Errorf("refresh: expected new tokens for Jacob: got %+v\n", user)
+	}
+	second := user.RefreshToken
+	req = request("GET", "/api/user", nil, keyValue{key: "Authorization", value: "Bearer " + user.Token})
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusOK; w.Code != expected {
+		t.Errorf("refresh: %s %s with refreshed token expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	}
+
+	// When the first refresh token is replayed
+	// Then the response should have a status of 401 (not authorized)
+	// And the second refresh token should have been revoked with it
+	if code, _ := refresh(first); code != http.StatusUnauthorized {
+		t.Errorf("refresh: replay expected %d(%s): got %d(%s)\n", http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), code, http.StatusText(code))
+	}
+	if code, _ := refresh(second); code != http.StatusUnauthorized {
+		t.Errorf("refresh: after replay expected %d(%s): got %d(%s)\n", http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), code, http.StatusText(code))
+	}
+
+	// When the refresh token is unknown
+	// Then the response should have a status of 401 (not authorized)
+	// When the refresh token is blank
+	// Then the response should have a status of 422 (unprocessable entity)
+	if code, _ := refresh("not-a-refresh-token"); code != http.StatusUnauthorized {
+		t.Errorf("refresh: unknown expected %d(%s): got %d(%s)\n", http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), code, http.StatusText(code))
+	}
+	if code, _ := refresh(""); code != http.StatusUnprocessableEntity {
+		t.Errorf("refresh: blank expected %d(%s): got %d(%s)\n", http.StatusUnprocessableEntity, http.StatusText(http.StatusUnprocessableEntity), code, http.StatusText(code))
+	}
+}
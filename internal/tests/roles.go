@@ -0,0 +1,124 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"fmt"
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: Roles and Permissions
+func Roles(newServer TestServer, t *testing.T) {
+	// Given a new server
+	// And the users "Jacob," "Anne" and "Morgan" have been added
+	// And "Morgan" is a moderator
+	// And "Jacob" has created the article "How to train your dragon"
+	// And "Anne" and "Morgan" have commented on the article
+	srv := newServer(secret)
+	for _, u := range []conduit.NewUser{
+		{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"},
+		{Username: "Anne", Email: "anne@anne.anne", Password: "anneanne"},
+		{Username: "Morgan", Email: "morgan@morgan.morgan", Password: "morganmorgan"},
+		{Username: "Root", Email: "root@conduit", Password: "rootroot"},
+	} {
+		srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: u}, contentType))
+	}
+	jakeBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(time.Minute, 1, "Jacob", "jake@jake.jake", "authenticated")}
+	anneBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(time.Minute, 2, "Anne", "anne@anne.anne", "authenticated")}
+	moderatorBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(time.Minute, 3, "Morgan", "morgan@morgan.morgan", "authenticated", "moderator")}
+	adminBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(time.Minute, 4, "Root", "root@conduit", "admin")}
+	guestBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(time.Minute, 2, "Anne", "anne@anne.anne", "guest")}
+
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "How to train your dragon"
+	createArticle.Article.Description = "Ever wonder how?"
+	createArticle.Article.Body = "You have to believe"
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, jakeBearerToken))
+	comment := func(body string, token keyValue) int {
+		var addComment conduit.CommentAddRequest
+		addComment.Comment.Body = body
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, request("POST", "/api/articles/how-to-train-your-dragon/comments", addComment, contentType, token))
+		var commentResponse conduit.CommentResponse
+		if err := fetch(w.Result().Body, &commentResponse); err != nil {
+			t.Fatalf("roles: addComment: response did not contain valid CommentResponse: %+v\n", err)
+		}
+		return commentResponse.Comment.Id
+	}
+	anneComment := comment("Buy cheap dragons!", anneBearerToken)
+	morganComment := comment("Please stay on topic.", moderatorBearerToken)
+
+	var updateArticle conduit.ArticleUpdateRequest
+	title := "How to sell your dragon"
+	updateArticle.Article.Title = &title
+
+	// When each user tries something at the edge of their permissions
+	// Then the response should have the expected status
+	for _, tc := range []struct {
+		why      string
+		method   string
+		target   string
+		body     interface{}
+		token    keyValue
+		expected int
+	}{
+		{"guest role grants nothing", "GET", "/api/user", nil, guestBearerToken, http.StatusUnauthorized},
+		{"author can't delete other's comment", "DELETE", fmt.Sprintf("/api/articles/how-to-train-your-dragon/comments/%d", morganComment), nil, jakeBearerToken, http.StatusForbidden},
+		{"moderator can't edit other's article", "PUT", "/api/articles/how-to-train-your-dragon", updateArticle, moderatorBearerToken, http.StatusForbidden},
+		{"moderator can't delete other's article", "DELETE", "/api/articles/how-to-train-your-dragon", nil, moderatorBearerToken, http.StatusForbidden},
+		{"moderator can't manage users", "DELETE", "/api/admin/users/Anne/sessions", nil, moderatorBearerToken, http.StatusNotFound},
+		{"moderator can delete other's comment", "DELETE", fmt.Sprintf("/api/articles/how-to-train-your-dragon/comments/%d", anneComment), nil, moderatorBearerToken, http.StatusOK},
+		{"moderator gets 404 for deleted comment", "DELETE", fmt.Sprintf("/api/articles/how-to-train-your-dragon/comments/%d", anneComment), nil, moderatorBearerToken, http.StatusNotFound},
+		{"admin without authenticated can't write", "POST", "/api/articles", createArticle, adminBearerToken, http.StatusForbidden},
+		{"admin can manage users", "DELETE", "/api/admin/users/Anne/sessions", nil, adminBearerToken, http.StatusNoContent},
+	} {
+		keys := []keyValue{tc.token}
+		if tc.body != nil {
+			keys = append(keys, contentType)
+		}
+		req := request(tc.method, tc.target, tc.body, keys...)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if w.Code != tc.expected {
+			t.Errorf("roles: %s: %s %s expected %d(%s): got %d(%s)\n", tc.why, req.Method, req.URL.Path, tc.expected, http.StatusText(tc.expected), w.Code, http.StatusText(w.Code))
+		}
+	}
+
+	// When the comments on the article are fetched
+	// Then only the comment from "Morgan" should remain
+	req := request("GET", "/api/articles/how-to-train-your-dragon/comments", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	var commentsResponse conduit.CommentsResponse
+	if err := fetch(w.Result().Body, &commentsResponse); err != nil {
+		t.Errorf("roles: %s %s response did not contain valid CommentsResponse: %+v\n", req.Method, req.URL.Path, err)
+	} else if len(commentsResponse.Comments) != 1 || commentsResponse.Comments[0].Id != morganComment {
+		t.Errorf("roles: %s %s expected comment %d: got %+v\n", req.Method, req.URL.Path, morganComment, commentsResponse.Comments)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * conduit - current practices for Go web servers
+ *
+ * Copyright (c) 2021 Michael D Henderson
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tests
+
+import (
+	"github.com/mdhender/conduit/internal/conduit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Specification: Tags API
+func Tags(newServer TestServer, t *testing.T) {
+	srv := newServer(secret)
+	validBearerToken := keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 1, "Jacob", "jake@jake.jake", "authenticated")}
+
+	// tags fetches the tags.
+	tags := func() ([]string, bool) {
+		req := request("GET", "/api/tags", nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if expected := http.StatusOK; w.Code != expected {
+			t.Errorf("tags: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+			return nil, false
+		}
+		var tagsResponse conduit.TagsResponse
+		if err := fetch(w.Result().Body, &tagsResponse); err != nil {
+			t.Errorf("tags: %s %s response did not contain valid TagsResponse: %+v\n", req.Method, req.URL.Path, err)
+			return nil, false
+		} else if tagsResponse.Tags == nil {
+			t.Errorf("tags: %s %s tags expected list: got null\n", req.Method, req.URL.Path)
+		}
+		return tagsResponse.Tags, true
+	}
+
+	// Given a new server
+	// And the user with username "Jacob," e-mail "jake@jake.jake," and password "jakejake" has been added
+	// When the request is GET /api/tags
+	// Then the response should have a status of 200 (ok)
+	// And contain a valid TagsResponse with an empty list of tags
+	srv = newServer(secret)
+	srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/users", conduit.NewUserRequest{User: conduit.NewUser{Username: "Jacob", Email: "jake@jake.jake", Password: "jakejake"}}, contentType))
+	if list, ok := tags(); ok && len(list) != 0 {
+		t.Errorf("tags: expected no tags: got %v\n", list)
+	}
+
+	// Given "Jacob" creates the article "Alpha" with the tags " Dragons", "training", "dragons", and ""
+	// Then the Article tag list should be "dragons" and "training"
+	var createArticle conduit.ArticleCreateRequest
+	createArticle.Article.Title = "Alpha"
+	createArticle.Article.Description = "About Alpha"
+	createArticle.Article.Body = "All about Alpha"
+	createArticle.Article.TagList = []string{" Dragons", "training", "dragons", ""}
+	req := request("POST", "/api/articles", createArticle, contentType, validBearerToken)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusCreated; w.Code != expected {
+		t.Errorf("tags: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else {
+		var articleResponse conduit.ArticleResponse
+		if err := fetch(w.Result().Body, &articleResponse); err != nil {
+			t.Errorf("tags: %s %s response did not contain valid ArticleResponse: %+v\n", req.Method, req.URL.Path, err)
+		} else if expected := []string{"dragons", "training"}; !equalStrings(articleResponse.Article.TagList, expected) {
+			t.Errorf("tags: %s %s tagList expected %v: got %v\n", req.Method, req.URL.Path, expected, articleResponse.Article.TagList)
+		}
+	}
+
+	// Given "Jacob" creates the article "Bravo" with the tags "GO" and "Dragons"
+	// And "Jacob" creates the article "Charlie" with the tags "go"
+	// And "Jacob" creates the article "Delta" with no tags
+	// When the request is GET /api/tags
+	// Then the tags should be "dragons," "go," and "training," most popular first
+	for _, article := range []struct {
+		title   string
+		tagList []string
+	}{
+		{"Bravo", []string{"GO", "Dragons"}},
+		{"Charlie", []string{"go"}},
+		{"Delta", nil},
+	} {
+		createArticle.Article.Title = article.title
+		createArticle.Article.TagList = article.tagList
+		srv.ServeHTTP(httptest.NewRecorder(), request("POST", "/api/articles", createArticle, contentType, validBearerToken))
+	}
+	if list, ok := tags(); ok {
+		if expected := []string{"dragons", "go", "training"}; !equalStrings(list, expected) {
+			t.Errorf("tags: expected %v: got %v\n", expected, list)
+		}
+	}
+
+	// When the request is GET /api/articles?tag=DRAGONS
+	// Then the articles count should be 2
+	req = request("GET", "/api/articles?tag=DRAGONS", nil)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if expected := http.StatusOK; w.Code != expected {
+		t.Errorf("tags: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
+	} else {
+		var articlesResponse conduit.MultipleArticlesResponse
+		if err := fetch(w.Result().Body, &articlesResponse); err != nil {
+			t.Errorf("tags: %s %s response did not contain valid MultipleArticlesResponse: %+v\n", req.Method, req.URL, err)
+		} else if expected := 2; articlesResponse.ArticlesCount != expected {
+			t.Errorf("tags: %s %s articlesCount expected %d: got %d\n", req.Method, req.URL, expected, articlesResponse.ArticlesCount)
+		}
+	}
+}
@@ -93,27 +93,12 @@ func User(newServer TestServer, t *testing.T) {
 	// Given the prior server
 	// And the request is GET /api/user
 	// And the request includes a bearer token for a non-existent user
-	// Then the response should have a status of 200 (ok)
-	// And contain a valid UserResponse with a valid User
-	// And the User Id should be 0
-	// And the User email should be empty
+	// Then the response should have a status of 401 (not authorized)
 	req = request("GET", "/api/user", nil, keyValue{key: "Authorization", value: "Bearer " + srv.NewJWT(15*time.Second, 0, "Guest", "guest@guest.guest", "authenticated")})
 	w = httptest.NewRecorder()
 	srv.ServeHTTP(w, req)
-	if expected := http.StatusOK; w.Code != expected {
+	if expected := http.StatusUnauthorized; w.Code != expected {
 		t.Errorf("user: %s %s expected %d(%s): got %d(%s)\n", req.Method, req.URL.Path, expected, http.StatusText(expected), w.Code, http.StatusText(w.Code))
-	} else {
-		var userResponse conduit.UserResponse
-		if err := fetch(w.Result().Body, &userResponse); err != nil {
-			t.Errorf("user: %s %s response did not contain valid UserResponse: %+v\n", req.Method, req.URL.Path, err)
-		} else {
-			if expected := 0; userResponse.User.Id != expected {
-				t.Errorf("user: %s %s id expected %q: got %q\n", req.Method, req.URL.Path, expected, userResponse.User.Id)
-			}
-			if expected := ""; userResponse.User.Email != expected {
-				t.Errorf("user: %s %s email expected %q: got %q\n", req.Method, req.URL.Path, expected, userResponse.User.Email)
-			}
-		}
 	}
 
 	// Given the prior server